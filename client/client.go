@@ -10,13 +10,17 @@ import (
 	"log"
 	"math/big"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	pb "asignacionservidor/proto/keyval" 
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 // Variable global para el cliente gRPC, inicializada en main para ser usada por todos los comandos.
@@ -24,36 +28,300 @@ var grpcClient pb.KeyValueServiceClient
 
 // ---- Parte 1 ----
 
-func doSet(ctx context.Context, key, value string) {
+func doSet(ctx context.Context, key, value string, ttlSeconds uint64, durability pb.SetRequest_Durability, replication pb.SetRequest_Replication, syncReplicas uint32) {
 	// Realiza una llamada RPC (Remote Procedure Call) unaria al método 'Set' del servidor.
-	_, err := grpcClient.Set(ctx, &pb.SetRequest{
-		Pair: &pb.KeyValuePair{Key: key, Value: []byte(value)},
+	resp, err := grpcClient.Set(ctx, &pb.SetRequest{
+		Pair:         &pb.KeyValuePair{Key: key, Value: []byte(value)},
+		TtlSeconds:   ttlSeconds,
+		Durability:   durability,
+		Replication:  replication,
+		SyncReplicas: syncReplicas,
 	})
 	if err != nil {
 		log.Fatalf("Error en la operación Set: %v", err)
 	}
-	fmt.Printf("Éxito: Clave '%s' establecida.\n", key)
+	fmt.Printf("Éxito: Clave '%s' establecida (versión %d).\n", key, resp.Version)
+	if resp.Message != "" { fmt.Printf("Durabilidad: %s.\n", resp.Message) }
+	if resp.Replicas > 0 { fmt.Printf("Confirmada por %d réplicas.\n", resp.Replicas) }
 }
 
-func doGet(ctx context.Context, key string) {
-	resp, err := grpcClient.Get(ctx, &pb.GetRequest{Key: key})
+// parseDurability: Traduce la opción -durability del cliente ("" = política del servidor).
+func parseDurability(name string) pb.SetRequest_Durability {
+	switch name {
+	case "":
+		return pb.SetRequest_DEFAULT
+	case "always":
+		return pb.SetRequest_FSYNC_ALWAYS
+	case "interval":
+		return pb.SetRequest_FSYNC_INTERVAL
+	case "none":
+		return pb.SetRequest_NO_FSYNC
+	}
+	log.Fatalf("Durabilidad inválida '%s': use always, interval o none", name)
+	return pb.SetRequest_DEFAULT
+}
+
+// parseReplication: Traduce la opción -replication del cliente ("" = política del servidor).
+func parseReplication(name string) pb.SetRequest_Replication {
+	switch name {
+	case "":
+		return pb.SetRequest_REPLICATION_DEFAULT
+	case "async":
+		return pb.SetRequest_REPLICATION_ASYNC
+	case "sync":
+		return pb.SetRequest_REPLICATION_SYNC
+	}
+	log.Fatalf("Replicación inválida '%s': use async o sync", name)
+	return pb.SetRequest_REPLICATION_DEFAULT
+}
+
+// doCompareAndSet: Set condicional. Solo escribe si la versión actual de la clave es la esperada
+// (0 = la clave no debe existir). Si la condición falla, el servidor informa la versión actual.
+func doCompareAndSet(ctx context.Context, key string, expectedVersion uint64, value string) {
+	resp, err := grpcClient.CompareAndSet(ctx, &pb.CompareAndSetRequest{
+		Pair:            &pb.KeyValuePair{Key: key, Value: []byte(value)},
+		ExpectedVersion: expectedVersion,
+	})
+	if status.Code(err) == codes.FailedPrecondition {
+		for _, detail := range status.Convert(err).Details() {
+			if current, ok := detail.(*pb.CompareAndSetResponse); ok {
+				fmt.Printf("Conflicto: la clave '%s' está en la versión %d (se esperaba %d).\n", key, current.Version, expectedVersion)
+				os.Exit(1)
+			}
+		}
+	}
+	if err != nil {
+		log.Fatalf("Error en la operación CompareAndSet: %v", err)
+	}
+	fmt.Printf("Éxito: Clave '%s' establecida (versión %d).\n", key, resp.Version)
+}
+
+// readOptions: Consistencia de las lecturas de get y getprefix.
+type readOptions struct {
+	consistency  string
+	maxStaleness time.Duration
+	maxLag       uint64
+	minRevision  uint64
+}
+
+// register: Añade las opciones de consistencia al subcomando.
+func (o *readOptions) register(cmd *flag.FlagSet) {
+	cmd.StringVar(&o.consistency, "consistency", "", "Consistencia de la lectura: eventual, bounded o linearizable (por defecto eventual, o bounded si se indica un límite)")
+	cmd.DurationVar(&o.maxStaleness, "max-staleness", 0, "Con bounded, retraso máximo del nodo que responde (p. ej. 500ms)")
+	cmd.Uint64Var(&o.maxLag, "max-lag", 0, "Con bounded, revisiones máximas que puede ir atrasado el nodo que responde")
+	cmd.Uint64Var(&o.minRevision, "min-revision", 0, "Leer un estado que incluya al menos esta revisión (la informada por una lectura o escritura anterior)")
+}
+
+// level: Traduce la opción -consistency.
+func (o *readOptions) level() pb.GetRequest_Consistency {
+	switch o.consistency {
+	case "":
+		if o.maxStaleness > 0 || o.maxLag > 0 { return pb.GetRequest_BOUNDED }
+		return pb.GetRequest_EVENTUAL
+	case "eventual":
+		return pb.GetRequest_EVENTUAL
+	case "bounded":
+		return pb.GetRequest_BOUNDED
+	case "linearizable":
+		return pb.GetRequest_LINEARIZABLE
+	}
+	log.Fatalf("Consistencia inválida '%s': use eventual, bounded o linearizable", o.consistency)
+	return pb.GetRequest_EVENTUAL
+}
+
+func doGet(ctx context.Context, key string, opts readOptions) {
+	resp, err := grpcClient.Get(ctx, &pb.GetRequest{
+		Key:                   key,
+		Consistency:           opts.level(),
+		MaxStalenessMs:        uint64(opts.maxStaleness / time.Millisecond),
+		MaxStalenessRevisions: opts.maxLag,
+		MinRevision:           opts.minRevision,
+	})
 	if err != nil {
 		log.Fatalf("Error en la operación Get: %v", err)
 	}
 	if resp.Found {
-		fmt.Printf("Valor para '%s': %s\n", key, string(resp.Value))
+		fmt.Printf("Valor para '%s': %s (versión %d)\n", key, string(resp.Value), resp.Version)
+	} else {
+		fmt.Printf("Clave '%s' no encontrada.\n", key)
+	}
+	fmt.Printf("Leído en la revisión %d.\n", resp.Revision)
+}
+
+func doDelete(ctx context.Context, key string) {
+	resp, err := grpcClient.Delete(ctx, &pb.DeleteRequest{Key: key})
+	if err != nil {
+		log.Fatalf("Error en la operación Delete: %v", err)
+	}
+	if resp.Found {
+		fmt.Printf("Éxito: Clave '%s' borrada.\n", key)
 	} else {
 		fmt.Printf("Clave '%s' no encontrada.\n", key)
 	}
 }
 
-func doGetPrefix(ctx context.Context, prefix string) {
+// doBatch: Envía varias operaciones en una sola llamada atómica.
+// Argumentos: secuencia de "set <key> <value>" y "del <key>".
+func doBatch(ctx context.Context, args []string) {
+	req := &pb.BatchRequest{}
+	for i := 0; i < len(args); {
+		switch {
+		case args[i] == "set" && i+2 < len(args):
+			req.Operations = append(req.Operations, &pb.BatchOperation{
+				Op: &pb.BatchOperation_Put{Put: &pb.KeyValuePair{Key: args[i+1], Value: []byte(args[i+2])}},
+			})
+			i += 3
+		case args[i] == "del" && i+1 < len(args):
+			req.Operations = append(req.Operations, &pb.BatchOperation{
+				Op: &pb.BatchOperation_DeleteKey{DeleteKey: args[i+1]},
+			})
+			i += 2
+		default:
+			log.Fatalf("Uso: lbclient batch (set <key> <value> | del <key>)...")
+		}
+	}
+	resp, err := grpcClient.Batch(ctx, req)
+	if err != nil {
+		log.Fatalf("Error en la operación Batch: %v", err)
+	}
+	fmt.Printf("Éxito: %d operaciones aplicadas atómicamente (revisión %d).\n", resp.Applied, resp.Revision)
+}
+
+// multiFlag: Flag que puede repetirse varias veces (p. ej. -if ... -if ...).
+type multiFlag []string
+
+func (m *multiFlag) String() string     { return strings.Join(*m, "; ") }
+func (m *multiFlag) Set(v string) error { *m = append(*m, v); return nil }
+
+// parseCompare: Interpreta una condición "ver(<key>)<op><n>" o "val(<key>)<op><valor>",
+// con <op> entre =, !=, > y <.
+func parseCompare(expr string) (*pb.Compare, error) {
+	c := &pb.Compare{}
+	switch {
+	case strings.HasPrefix(expr, "ver("):
+		c.Target = pb.Compare_VERSION
+	case strings.HasPrefix(expr, "val("):
+		c.Target = pb.Compare_VALUE
+	default:
+		return nil, fmt.Errorf("condición inválida %q: debe empezar por ver( o val(", expr)
+	}
+	end := strings.Index(expr, ")")
+	if end < 0 { return nil, fmt.Errorf("condición inválida %q: falta ')'", expr) }
+	c.Key = expr[4:end]
+	rest := expr[end+1:]
+	for _, op := range []struct {
+		token  string
+		result pb.Compare_Result
+	}{{"!=", pb.Compare_NOT_EQUAL}, {"=", pb.Compare_EQUAL}, {">", pb.Compare_GREATER}, {"<", pb.Compare_LESS}} {
+		if !strings.HasPrefix(rest, op.token) { continue }
+		c.Result = op.result
+		operand := rest[len(op.token):]
+		if c.Target == pb.Compare_VALUE {
+			c.Value = []byte(operand)
+			return c, nil
+		}
+		version, err := strconv.ParseUint(operand, 10, 64)
+		if err != nil { return nil, fmt.Errorf("condición inválida %q: versión no numérica", expr) }
+		c.Version = version
+		return c, nil
+	}
+	return nil, fmt.Errorf("condición inválida %q: operador desconocido", expr)
+}
+
+// parseTxnOperation: Interpreta una operación "set <key> <value>", "del <key>" o "get <key>".
+func parseTxnOperation(expr string) (*pb.TxnOperation, error) {
+	fields := strings.SplitN(expr, " ", 3)
+	switch {
+	case fields[0] == "set" && len(fields) == 3:
+		return &pb.TxnOperation{Op: &pb.TxnOperation_Put{Put: &pb.KeyValuePair{Key: fields[1], Value: []byte(fields[2])}}}, nil
+	case fields[0] == "del" && len(fields) == 2:
+		return &pb.TxnOperation{Op: &pb.TxnOperation_DeleteKey{DeleteKey: fields[1]}}, nil
+	case fields[0] == "get" && len(fields) == 2:
+		return &pb.TxnOperation{Op: &pb.TxnOperation_GetKey{GetKey: fields[1]}}, nil
+	}
+	return nil, fmt.Errorf("operación inválida %q", expr)
+}
+
+// doTxn: Transacción compare/then/else. Ejemplo:
+//   lbclient txn -if 'ver(saldo)=7' -then 'set saldo 90' -then 'set log pago' -else 'get saldo'
+func doTxn(ctx context.Context) {
+	txnCmd := flag.NewFlagSet("txn", flag.ExitOnError)
+	var conditions, thenOps, elseOps multiFlag
+	txnCmd.Var(&conditions, "if", "Condición: 'ver(<key>)<op><n>' o 'val(<key>)<op><valor>' (op: =, !=, >, <)")
+	txnCmd.Var(&thenOps, "then", "Operación si se cumplen las condiciones: 'set <key> <value>', 'del <key>' o 'get <key>'")
+	txnCmd.Var(&elseOps, "else", "Operación si alguna condición falla (mismo formato que -then)")
+	txnCmd.Parse(flag.Args()[1:])
+
+	req := &pb.TxnRequest{}
+	for _, expr := range conditions {
+		c, err := parseCompare(expr)
+		if err != nil { log.Fatalf("%v", err) }
+		req.Compare = append(req.Compare, c)
+	}
+	for _, ops := range []struct {
+		exprs  multiFlag
+		target *[]*pb.TxnOperation
+	}{{thenOps, &req.Success}, {elseOps, &req.Failure}} {
+		for _, expr := range ops.exprs {
+			op, err := parseTxnOperation(expr)
+			if err != nil { log.Fatalf("%v", err) }
+			*ops.target = append(*ops.target, op)
+		}
+	}
+
+	resp, err := grpcClient.Txn(ctx, req)
+	if err != nil {
+		log.Fatalf("Error en la operación Txn: %v", err)
+	}
+	branch, ops := "then", req.Success
+	if !resp.Succeeded { branch, ops = "else", req.Failure }
+	fmt.Printf("Transacción ejecutada: rama '%s' (revisión %d).\n", branch, resp.Revision)
+	for i, r := range resp.Results {
+		switch ops[i].Op.(type) {
+		case *pb.TxnOperation_GetKey:
+			if r.Found {
+				fmt.Printf(" - get %s: %s (versión %d)\n", r.Key, string(r.Value), r.Version)
+			} else {
+				fmt.Printf(" - get %s: no encontrada\n", r.Key)
+			}
+		case *pb.TxnOperation_Put:
+			fmt.Printf(" - set %s (versión %d)\n", r.Key, r.Version)
+		case *pb.TxnOperation_DeleteKey:
+			fmt.Printf(" - del %s (existía: %t)\n", r.Key, r.Found)
+		}
+	}
+}
+
+func doGetPrefix(ctx context.Context) {
+	prefixCmd := flag.NewFlagSet("getprefix", flag.ExitOnError)
+	keysOnly := prefixCmd.Bool("keys-only", false, "Mostrar solo las claves, sin transferir los valores")
+	countOnly := prefixCmd.Bool("count", false, "Mostrar solo el número de claves con el prefijo")
+	limit := prefixCmd.Uint("limit", 0, "Máximo de pares a recibir (0 = sin límite)")
+	cursor := prefixCmd.String("cursor", "", "Cursor de continuación devuelto por una llamada anterior")
+	var opts readOptions
+	opts.register(prefixCmd)
+	prefixCmd.Parse(flag.Args()[1:])
+	if prefixCmd.NArg() != 1 { log.Fatalf("Uso: lbclient getprefix [-keys-only] [-count] [-limit n] [-cursor c] [-consistency c] [-max-staleness d] [-max-lag n] [-min-revision r] <prefix>") }
+	prefix := prefixCmd.Arg(0)
+
 	// Inicia una llamada de streaming; el cliente se prepara para recibir múltiples respuestas del servidor.
-	stream, err := grpcClient.GetPrefixStream(ctx, &pb.GetPrefixRequest{Prefix: prefix})
+	stream, err := grpcClient.GetPrefixStream(ctx, &pb.GetPrefixRequest{
+		Prefix:                prefix,
+		KeysOnly:              *keysOnly,
+		CountOnly:             *countOnly,
+		Limit:                 uint32(*limit),
+		Cursor:                *cursor,
+		Consistency:           opts.level(),
+		MaxStalenessMs:        uint64(opts.maxStaleness / time.Millisecond),
+		MaxStalenessRevisions: opts.maxLag,
+		MinRevision:           opts.minRevision,
+	})
 	if err != nil {
 		log.Fatalf("Error al iniciar el stream de GetPrefix: %v", err)
 	}
-	fmt.Printf("Valores para claves con prefijo '%s':\n", prefix)
+	if !*countOnly {
+		fmt.Printf("Valores para claves con prefijo '%s':\n", prefix)
+	}
 	count := 0
 	// Bucle para recibir cada una de las respuestas del stream.
 	for {
@@ -66,15 +334,96 @@ func doGetPrefix(ctx context.Context, prefix string) {
 			log.Fatalf("Error al recibir del stream: %v", err)
 		}
 		if pair := resp.GetPair(); pair != nil {
-			fmt.Printf(" - %s: %s\n", pair.Key, string(pair.Value))
+			if *keysOnly {
+				fmt.Printf(" - %s\n", pair.Key)
+			} else {
+				fmt.Printf(" - %s: %s\n", pair.Key, string(pair.Value))
+			}
 			count++
 		}
+		// El último mensaje del stream trae el total de coincidencias.
+		if total, ok := resp.Response.(*pb.GetPrefixStreamResponse_TotalMatches); ok {
+			if resp.Revision > 0 {
+				fmt.Printf("Total de coincidencias: %d (revisión %d)\n", total.TotalMatches, resp.Revision)
+			} else {
+				// Varias particiones: sus revisiones no son comparables.
+				fmt.Printf("Total de coincidencias: %d\n", total.TotalMatches)
+			}
+			if resp.NextCursor != "" {
+				fmt.Printf("Siguiente página: lbclient getprefix -cursor %s ...\n", resp.NextCursor)
+			}
+		}
 	}
-	if count == 0 {
+	if count == 0 && !*countOnly {
 		fmt.Println(" (Ninguna coincidencia encontrada)")
 	}
 }
 
+// doRange: Recorre las claves de [start, end) en orden, por páginas.
+// Sin -cursor empieza desde el principio; la respuesta indica el cursor de la página siguiente.
+func doRange(ctx context.Context) {
+	rangeCmd := flag.NewFlagSet("range", flag.ExitOnError)
+	limit := rangeCmd.Uint("limit", 0, "Máximo de pares por página (0 = sin límite)")
+	reverse := rangeCmd.Bool("reverse", false, "Recorrer en orden descendente")
+	cursor := rangeCmd.String("cursor", "", "Cursor de continuación devuelto por una llamada anterior")
+	rangeCmd.Parse(flag.Args()[1:])
+	if rangeCmd.NArg() != 2 { log.Fatalf("Uso: lbclient range [-limit n] [-reverse] [-cursor c] <start> <end> (\"\" = sin límite)") }
+
+	resp, err := grpcClient.Range(ctx, &pb.RangeRequest{
+		StartKey: rangeCmd.Arg(0),
+		EndKey:   rangeCmd.Arg(1),
+		Limit:    uint32(*limit),
+		Reverse:  *reverse,
+		Cursor:   *cursor,
+	})
+	if err != nil {
+		log.Fatalf("Error en la operación Range: %v", err)
+	}
+	for _, pair := range resp.Pairs {
+		fmt.Printf(" - %s: %s\n", pair.Key, string(pair.Value))
+	}
+	fmt.Printf("Mostrando %d de %d claves en el rango.\n", len(resp.Pairs), resp.TotalMatches)
+	if resp.NextCursor != "" {
+		fmt.Printf("Siguiente página: lbclient range -cursor %s ...\n", resp.NextCursor)
+	}
+}
+
+// doWatch: Muestra en tiempo real los cambios sobre las claves con el prefijo dado.
+// Si el servidor corta el stream por ir atrasado, se reanuda desde la última revisión recibida.
+func doWatch() {
+	watchCmd := flag.NewFlagSet("watch", flag.ExitOnError)
+	fromRevision := watchCmd.Uint64("from", 0, "Revisión desde la que reenviar eventos (0 = solo cambios nuevos)")
+	watchCmd.Parse(flag.Args()[1:])
+	if watchCmd.NArg() != 1 { log.Fatalf("Uso: lbclient watch [-from revision] <prefix>") }
+	prefix := watchCmd.Arg(0)
+
+	next := *fromRevision
+	for {
+		stream, err := grpcClient.Watch(context.Background(), &pb.WatchRequest{Prefix: prefix, StartRevision: next})
+		if err != nil {
+			log.Fatalf("Error al iniciar Watch: %v", err)
+		}
+		for {
+			resp, err := stream.Recv()
+			if status.Code(err) == codes.ResourceExhausted {
+				fmt.Fprintf(os.Stderr, "Watch atrasado, reanudando desde la revisión %d...\n", next)
+				break
+			}
+			if err != nil {
+				log.Fatalf("Error al recibir del stream de Watch: %v", err)
+			}
+			for _, e := range resp.Events {
+				if e.Type == pb.WatchEvent_DELETE {
+					fmt.Printf("[rev %d] DELETE %s\n", e.Revision, e.Pair.Key)
+				} else {
+					fmt.Printf("[rev %d] PUT %s = %s\n", e.Revision, e.Pair.Key, string(e.Pair.Value))
+				}
+				next = e.Revision + 1
+			}
+		}
+	}
+}
+
 func doStats(ctx context.Context) {
 	resp, err := grpcClient.Stat(ctx, &pb.StatRequest{})
 	if err != nil {
@@ -86,14 +435,90 @@ func doStats(ctx context.Context) {
 	fmt.Printf("Operaciones Set:       %d\n", resp.SetOperations)
 	fmt.Printf("Operaciones Get:       %d\n", resp.GetOperations)
 	fmt.Printf("Operaciones GetPrefix: %d\n", resp.PrefixOperations)
+	fmt.Printf("Operaciones Delete:    %d\n", resp.DeleteOperations)
+	fmt.Printf("Claves expiradas:      %d\n", resp.ExpiredKeys)
+	fmt.Printf("Registros del WAL:     %d (en %d fsyncs)\n", resp.WalRecords, resp.WalSyncs)
+	fmt.Printf("Segmentos del WAL:     %d (%d bytes)\n", resp.WalSegments, resp.WalSegmentBytes)
+	fmt.Printf("Segmentos archivados:  %d (%d bytes)\n", resp.ArchivedSegments, resp.ArchivedBytes)
+	if resp.ReplicaOf != "" { fmt.Printf("Réplica de:            %s\n", resp.ReplicaOf) }
+	fmt.Printf("Réplicas conectadas:   %d\n", resp.Replicas)
+	if resp.RaftRole != "" {
+		fmt.Printf("Raft:                  %s en el mandato %d (líder: %s)\n", resp.RaftRole, resp.RaftTerm, resp.RaftLeader)
+		fmt.Printf("Raft confirmado:       %d (aplicado %d)\n", resp.RaftCommit, resp.RaftApplied)
+	}
+	if len(resp.PartitionMembers) > 0 {
+		fmt.Printf("Partición:             %.1f%% del anillo de %d servidores (%s)\n", 100*resp.PartitionShare, len(resp.PartitionMembers), strings.Join(resp.PartitionMembers, ", "))
+	}
 	fmt.Println("-------------------------------")
 }
 
+// doBackup: Descarga una copia de seguridad en caliente del servidor y la guarda en un archivo.
+func doBackup() {
+	backupCmd := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := backupCmd.String("o", "", "Archivo donde guardar la imagen")
+	backupCmd.Parse(flag.Args()[1:])
+	if *out == "" || backupCmd.NArg() != 0 { log.Fatalf("Uso: lbclient backup -o <archivo>") }
+
+	stream, err := grpcClient.Backup(context.Background(), &pb.BackupRequest{})
+	if err != nil { log.Fatalf("Error al iniciar Backup: %v", err) }
+	// Se escribe en un temporal: un archivo a medias nunca queda con el nombre final.
+	file, err := os.Create(*out + ".tmp")
+	if err != nil { log.Fatalf("No se pudo crear el archivo: %v", err) }
+	var lsn uint64
+	var written int64
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF { break }
+		if err == nil { _, err = file.Write(chunk.Data) }
+		if err != nil {
+			file.Close()
+			os.Remove(*out + ".tmp")
+			log.Fatalf("Error durante la copia de seguridad: %v", err)
+		}
+		if chunk.Lsn != 0 { lsn = chunk.Lsn }
+		written += int64(len(chunk.Data))
+	}
+	if err := file.Sync(); err != nil { log.Fatalf("Error al guardar la copia: %v", err) }
+	file.Close()
+	if err := os.Rename(*out+".tmp", *out); err != nil { log.Fatalf("Error al guardar la copia: %v", err) }
+	fmt.Printf("Copia de seguridad guardada en %s: %d bytes, hasta la LSN %d.\n", *out, written, lsn)
+}
+
+// doRestore: Envía una imagen generada por 'backup' a un servidor con el almacén vacío.
+func doRestore() {
+	restoreCmd := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := restoreCmd.String("i", "", "Archivo con la imagen")
+	restoreCmd.Parse(flag.Args()[1:])
+	if *in == "" || restoreCmd.NArg() != 0 { log.Fatalf("Uso: lbclient restore -i <archivo>") }
+
+	file, err := os.Open(*in)
+	if err != nil { log.Fatalf("No se pudo abrir el archivo: %v", err) }
+	defer file.Close()
+	stream, err := grpcClient.Restore(context.Background())
+	if err != nil { log.Fatalf("Error al iniciar Restore: %v", err) }
+	buf := make([]byte, 1024*1024)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			if sendErr := stream.Send(&pb.BackupChunk{Data: buf[:n]}); sendErr != nil {
+				// El servidor cortó el stream; el motivo llega con CloseAndRecv.
+				break
+			}
+		}
+		if err == io.EOF { break }
+		if err != nil { log.Fatalf("Error al leer el archivo: %v", err) }
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil { log.Fatalf("Error en la operación Restore: %v", err) }
+	fmt.Printf("Copia de seguridad restaurada: %d claves, LSN %d.\n", resp.Keys, resp.Revision)
+}
+
 // doPopulate: Función para cargar datos masivamente en el servidor.
 func doPopulate() {
     popCmd := flag.NewFlagSet("populate", flag.ExitOnError)
     numKeys := popCmd.Int("n", 100000, "Número de claves a insertar")
     valueSize := popCmd.Int("valuesize", 4096, "Tamaño del valor en bytes")
+    batchSize := popCmd.Int("batch", 0, "Claves por llamada Batch (0 = una llamada Set por clave)")
     
     popCmd.Parse(os.Args[2:])
 
@@ -115,6 +540,21 @@ func doPopulate() {
             defer wg.Done()
             startKey := workerID * keysPerWorker
             endKey := startKey + keysPerWorker
+            // Con -batch, cada llamada agrupa varias claves en un único registro del WAL (un solo fsync).
+            if *batchSize > 0 {
+                for first := startKey; first < endKey; first += *batchSize {
+                    req := &pb.BatchRequest{}
+                    for k := first; k < endKey && k < first+*batchSize; k++ {
+                        req.Operations = append(req.Operations, &pb.BatchOperation{
+                            Op: &pb.BatchOperation_Put{Put: &pb.KeyValuePair{Key: fmt.Sprintf("key-%d", k), Value: value}},
+                        })
+                    }
+                    if _, err := grpcClient.Batch(context.Background(), req); err != nil {
+                        log.Printf("Error al poblar el lote que empieza en key-%d: %v", first, err)
+                    }
+                }
+                return
+            }
             for k := startKey; k < endKey; k++ {
                 key := fmt.Sprintf("key-%d", k)
                 // Cada goroutine realiza llamadas Set de forma independiente para maximizar el paralelismo.
@@ -138,7 +578,7 @@ func doPopulate() {
 
 // worker simula un cliente virtual que ejecuta operaciones para medir el rendimiento del servidor.
 // Recibe un canal de resultados para escribir sus mediciones.
-func worker(ctx context.Context, id int, wg *sync.WaitGroup, workload string, valueSize int, numOps int, resultsChan chan<- []string) {
+func worker(ctx context.Context, id int, wg *sync.WaitGroup, workload string, valueSize int, numOps int, durability pb.SetRequest_Durability, resultsChan chan<- []string) {
 	defer wg.Done()
 
 	value := make([]byte, valueSize)
@@ -170,7 +610,8 @@ func worker(ctx context.Context, id int, wg *sync.WaitGroup, workload string, va
 			_, err = grpcClient.Get(ctx, &pb.GetRequest{Key: opKey})
 		} else {
 			_, err = grpcClient.Set(ctx, &pb.SetRequest{
-				Pair: &pb.KeyValuePair{Key: opKey, Value: value},
+				Pair:       &pb.KeyValuePair{Key: opKey, Value: value},
+				Durability: durability,
 			})
 		}
 
@@ -200,6 +641,7 @@ func doBenchmark() {
 	numClients := benchCmd.Int("clients", 1, "Número de clientes concurrentes")
 	numOps := benchCmd.Int("ops", 1000, "Número de operaciones por cliente")
 	csvFile := benchCmd.String("out", "benchmark_results.csv", "Archivo CSV para guardar los resultados")
+	durability := benchCmd.String("durability", "", "Garantía de los Set: always, interval o none (por defecto, la del servidor)")
 
 	benchCmd.Parse(os.Args[2:])
 
@@ -228,16 +670,20 @@ func doBenchmark() {
 	resultsChan := make(chan []string, *numClients*(*numOps))
 	var wg sync.WaitGroup
 	ctx := context.Background()
+	// Las estadísticas del WAL antes y después permiten ver cuántas escrituras agrupa cada fsync.
+	statsBefore, _ := grpcClient.Stat(ctx, &pb.StatRequest{})
 	startTime := time.Now()
 	
 	// Lanza el número de clientes concurrentes (workers) especificado para simular carga real.
 	for i := 0; i < *numClients; i++ {
 		wg.Add(1)
-		go worker(ctx, i, &wg, *workload, *valueSize, *numOps, resultsChan)
+		go worker(ctx, i, &wg, *workload, *valueSize, *numOps, parseDurability(*durability), resultsChan)
 	}
 
 	// Una goroutine separada escribe los resultados para no ralentizar a los workers de la prueba.
+	written := make(chan struct{})
 	go func() {
+		defer close(written)
 		for result := range resultsChan {
 			if err := writer.Write(result); err != nil {
 				log.Printf("Error al escribir en CSV: %v", err)
@@ -250,6 +696,8 @@ func doBenchmark() {
 	close(resultsChan)
 
 	totalDuration := time.Since(startTime)
+	// El Flush diferido no debe correr mientras la goroutine del CSV aún escribe.
+	<-written
 	totalOps := *numClients * *numOps
 	// Al finalizar, calcula métricas clave como el rendimiento total (throughput).
 	throughput := float64(totalOps) / totalDuration.Seconds()
@@ -258,33 +706,197 @@ func doBenchmark() {
 	fmt.Printf("Tiempo total:          %v\n", totalDuration)
 	fmt.Printf("Operaciones totales:   %d\n", totalOps)
 	fmt.Printf("Rendimiento (ops/seg): %.2f\n", throughput)
+	if statsAfter, err := grpcClient.Stat(ctx, &pb.StatRequest{}); err == nil && statsBefore != nil {
+		syncs := statsAfter.WalSyncs - statsBefore.WalSyncs
+		if syncs > 0 {
+			fmt.Printf("Escrituras por fsync:  %.2f\n", float64(statsAfter.WalRecords-statsBefore.WalRecords)/float64(syncs))
+		}
+	}
 	fmt.Printf("Resultados guardados en: %s\n", *csvFile)
 	fmt.Println("------------------------------")
 }
 
 // ---- Parte 3: El Despachador Principal (nueva función main) ----
 
+// leaderRedirect: Sigue las redirecciones de un clúster de Raft o de una réplica. Un seguidor
+// rechaza las escrituras (y una réplica o un seguidor, las lecturas que no pueden servir con la
+// consistencia pedida) con FailedPrecondition y la dirección del líder o del primario en un
+// LeaderHint; la petición se repite allí, y esa conexión se usa desde entonces para todas las
+// peticiones. Durante una elección (Unavailable) se reintenta unas cuantas veces.
+// Con un anillo de particiones, un servidor con -partition-redirect rechaza las claves de otra
+// partición con un PartitionHint: solo esa petición se repite en el dueño.
+// Otras peticiones en curso pueden estar usando una conexión después de que se deje de
+// preferir, así que ninguna se cierra hasta close.
+type leaderRedirect struct {
+	mu       sync.Mutex
+	leader   *grpc.ClientConn
+	conns    map[string]*grpc.ClientConn // Conexiones con los líderes y los dueños de particiones, por dirección.
+	dialOpts []grpc.DialOption
+}
+
+const (
+	maxRedirects  = 10
+	redirectPause = 200 * time.Millisecond
+)
+
+func (l *leaderRedirect) intercept(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	var owner *grpc.ClientConn
+	for attempt := 0; ; attempt++ {
+		l.mu.Lock()
+		leader := l.leader
+		l.mu.Unlock()
+		var err error
+		switch {
+		case owner != nil:
+			err = owner.Invoke(ctx, method, req, reply, opts...)
+		case leader != nil:
+			err = leader.Invoke(ctx, method, req, reply, opts...)
+		default:
+			err = invoker(ctx, method, req, reply, cc, opts...)
+		}
+		if err == nil || attempt == maxRedirects { return err }
+		if conn := l.partitionOwner(err); conn != nil {
+			owner = conn
+			continue
+		}
+		if !l.follow(ctx, err) { return err }
+	}
+}
+
+// partitionOwner: Conexión con el dueño indicado en el PartitionHint de err (nil si no lo hay).
+func (l *leaderRedirect) partitionOwner(err error) *grpc.ClientConn {
+	var addr string
+	for _, d := range status.Convert(err).Details() {
+		if hint, ok := d.(*pb.PartitionHint); ok { addr = hint.Owner }
+	}
+	if addr == "" { return nil }
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	conn, _ := l.connLocked(addr)
+	return conn
+}
+
+// connLocked: Conexión con addr, reutilizando la que ya hubiera. El llamador tiene tomado mu.
+func (l *leaderRedirect) connLocked(addr string) (*grpc.ClientConn, error) {
+	if conn := l.conns[addr]; conn != nil { return conn, nil }
+	conn, err := grpc.Dial(addr, l.dialOpts...)
+	if err != nil { return nil, err }
+	if l.conns == nil { l.conns = make(map[string]*grpc.ClientConn) }
+	l.conns[addr] = conn
+	return conn, nil
+}
+
+// close: Cierra todas las conexiones abiertas por las redirecciones.
+func (l *leaderRedirect) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, conn := range l.conns { conn.Close() }
+	l.conns, l.leader = nil, nil
+}
+
+// follow: Indica si la petición que falló con err debe repetirse: tras conectar con el nodo
+// del LeaderHint, o tras una pausa durante una elección.
+func (l *leaderRedirect) follow(ctx context.Context, err error) bool {
+	st := status.Convert(err)
+	switch st.Code() {
+	case codes.FailedPrecondition:
+		var addr string
+		for _, d := range st.Details() {
+			if hint, ok := d.(*pb.LeaderHint); ok { addr = hint.Leader }
+		}
+		if addr == "" { return false }
+		l.mu.Lock()
+		conn, dialErr := l.connLocked(addr)
+		if dialErr == nil { l.leader = conn }
+		l.mu.Unlock()
+		if dialErr != nil { return false }
+		log.Printf("Se redirige a %s (%s).", addr, st.Message())
+		return true
+	case codes.Unavailable:
+		// El líder al que se seguía puede haber caído: se vuelve a preguntar al nodo original,
+		// que conoce al nuevo. Su conexión sigue en conns por si otra petición aún la usa.
+		l.mu.Lock()
+		l.leader = nil
+		l.mu.Unlock()
+		select {
+		case <-time.After(redirectPause):
+			return true
+		case <-ctx.Done():
+		}
+	}
+	return false
+}
+
+// interceptStream: Como intercept, para los streams del servidor (GetPrefixStream, Watch,
+// Backup). El rechazo llega con la primera respuesta, así que es redirectStream quien repite
+// la petición.
+func (l *leaderRedirect) interceptStream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	open := func() (grpc.ClientStream, error) {
+		l.mu.Lock()
+		leader := l.leader
+		l.mu.Unlock()
+		if leader != nil { return leader.NewStream(ctx, desc, method, opts...) }
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+	stream, err := open()
+	if err != nil || desc.ClientStreams { return stream, err }
+	return &redirectStream{ClientStream: stream, ctx: ctx, follow: l.follow, open: open}, nil
+}
+
+// redirectStream: Stream del servidor que, si la primera respuesta es una redirección, vuelve a
+// abrirlo en el nodo indicado con la misma petición.
+type redirectStream struct {
+	grpc.ClientStream
+	ctx      context.Context
+	follow   func(context.Context, error) bool
+	open     func() (grpc.ClientStream, error)
+	req      interface{}
+	received bool
+}
+
+func (s *redirectStream) SendMsg(m interface{}) error {
+	s.req = m
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *redirectStream) RecvMsg(m interface{}) error {
+	for attempt := 0; ; attempt++ {
+		err := s.ClientStream.RecvMsg(m)
+		if s.received || err == nil || attempt == maxRedirects || !s.follow(s.ctx, err) {
+			s.received = true
+			return err
+		}
+		stream, openErr := s.open()
+		if openErr != nil { return err }
+		if sendErr := stream.SendMsg(s.req); sendErr != nil { return err }
+		if closeErr := stream.CloseSend(); closeErr != nil { return err }
+		s.ClientStream = stream
+	}
+}
+
 func main() {
 	serverAddr := flag.String("addr", "localhost:50051", "Dirección del servidor gRPC (host:puerto)")
 	flag.Parse()
 
 	// Se conecta al servidor gRPC. 'insecure' se usa para pruebas locales sin cifrado TLS.
-	conn, err := grpc.Dial(*serverAddr,
+	redirect := &leaderRedirect{dialOpts: []grpc.DialOption{
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		// Aumenta el tamaño máximo de los mensajes para los benchmarks con valores grandes.
 		grpc.WithDefaultCallOptions(
 			grpc.MaxCallRecvMsgSize(10*1024*1024),
 			grpc.MaxCallSendMsgSize(10*1024*1024),
 		),
-	)
+	}}
+	conn, err := grpc.Dial(*serverAddr, append(redirect.dialOpts, grpc.WithUnaryInterceptor(redirect.intercept), grpc.WithStreamInterceptor(redirect.interceptStream))...)
 	if err != nil { log.Fatalf("La conexión falló: %v", err) }
 	defer conn.Close()
+	defer redirect.close()
 	grpcClient = pb.NewKeyValueServiceClient(conn)
 
 	// Determina el subcomando a ejecutar.
 	if flag.NArg() < 1 {
 		fmt.Println("Uso: lbclient [-addr host:port] <comando> [argumentos]")
-		fmt.Println("Comandos: set, get, getprefix, stats, benchmark")
+		fmt.Println("Comandos: set, cas, get, delete, batch, txn, getprefix, range, watch, stats, benchmark, backup, restore")
 		os.Exit(1)
 	}
 	
@@ -296,21 +908,55 @@ func main() {
 	// Este 'switch' actúa como un despachador que ejecuta la función correspondiente al comando.
 	switch command {
 	case "set":
-		if flag.NArg() != 3 { log.Fatalf("Uso: lbclient set <key> <value>") }
-		doSet(ctx, flag.Arg(1), flag.Arg(2))
+		setCmd := flag.NewFlagSet("set", flag.ExitOnError)
+		durability := setCmd.String("durability", "", "Garantía de la escritura: always, interval o none (por defecto, la del servidor)")
+		replication := setCmd.String("replication", "", "Esperar a las réplicas: async o sync (por defecto, lo que diga -sync-replicas del servidor)")
+		replicas := setCmd.Uint("replicas", 0, "Con -replication sync, réplicas que deben confirmar (0 = las del servidor, o 1)")
+		setCmd.Parse(flag.Args()[1:])
+		if setCmd.NArg() != 2 && setCmd.NArg() != 3 { log.Fatalf("Uso: lbclient set [-durability modo] [-replication async|sync] [-replicas N] <key> <value> [ttl_segundos]") }
+		var ttl uint64
+		if setCmd.NArg() == 3 {
+			var err error
+			if ttl, err = strconv.ParseUint(setCmd.Arg(2), 10, 64); err != nil { log.Fatalf("TTL inválido: %v", err) }
+		}
+		doSet(ctx, setCmd.Arg(0), setCmd.Arg(1), ttl, parseDurability(*durability), parseReplication(*replication), uint32(*replicas))
+	case "cas":
+		if flag.NArg() != 4 { log.Fatalf("Uso: lbclient cas <key> <expected_version> <value>") }
+		expectedVersion, err := strconv.ParseUint(flag.Arg(2), 10, 64)
+		if err != nil { log.Fatalf("Versión esperada inválida: %v", err) }
+		doCompareAndSet(ctx, flag.Arg(1), expectedVersion, flag.Arg(3))
 	case "get":
-		if flag.NArg() != 2 { log.Fatalf("Uso: lbclient get <key>") }
-		doGet(ctx, flag.Arg(1))
+		getCmd := flag.NewFlagSet("get", flag.ExitOnError)
+		var opts readOptions
+		opts.register(getCmd)
+		getCmd.Parse(flag.Args()[1:])
+		if getCmd.NArg() != 1 { log.Fatalf("Uso: lbclient get [-consistency c] [-max-staleness d] [-max-lag n] [-min-revision r] <key>") }
+		doGet(ctx, getCmd.Arg(0), opts)
+	case "delete":
+		if flag.NArg() != 2 { log.Fatalf("Uso: lbclient delete <key>") }
+		doDelete(ctx, flag.Arg(1))
+	case "batch":
+		if flag.NArg() < 3 { log.Fatalf("Uso: lbclient batch (set <key> <value> | del <key>)...") }
+		doBatch(ctx, flag.Args()[1:])
+	case "txn":
+		doTxn(ctx)
 	case "getprefix":
-		if flag.NArg() != 2 { log.Fatalf("Uso: lbclient getprefix <prefix>") }
-		doGetPrefix(ctx, flag.Arg(1))
+		doGetPrefix(ctx)
+	case "range":
+		doRange(ctx)
+	case "watch":
+		doWatch()
 	case "stats":
 		doStats(ctx)
 	case "populate":
     	doPopulate()
 	case "benchmark":
 		doBenchmark()
+	case "backup":
+		doBackup()
+	case "restore":
+		doRestore()
 	default:
-		log.Fatalf("Comando desconocido: '%s'. Válidos: set, get, getprefix, stats, benchmark", command)
+		log.Fatalf("Comando desconocido: '%s'. Válidos: set, cas, get, delete, batch, txn, getprefix, range, watch, stats, benchmark, backup, restore", command)
 	}
 }
\ No newline at end of file
@@ -3,6 +3,7 @@ module asignacionservidor
 go 1.23.4
 
 require (
+	github.com/klauspost/compress v1.18.0
 	google.golang.org/grpc v1.73.0
 	google.golang.org/protobuf v1.36.6
 )
@@ -21,11 +21,308 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// Durability: Cuándo se confirma la escritura. DEFAULT usa la política del servidor.
+type SetRequest_Durability int32
+
+const (
+	SetRequest_DEFAULT        SetRequest_Durability = 0
+	SetRequest_FSYNC_ALWAYS   SetRequest_Durability = 1 // fsync antes de responder
+	SetRequest_FSYNC_INTERVAL SetRequest_Durability = 2 // fsync en segundo plano cada N ms
+	SetRequest_NO_FSYNC       SetRequest_Durability = 3 // Solo escritura en el buffer del sistema operativo
+)
+
+// Enum value maps for SetRequest_Durability.
+var (
+	SetRequest_Durability_name = map[int32]string{
+		0: "DEFAULT",
+		1: "FSYNC_ALWAYS",
+		2: "FSYNC_INTERVAL",
+		3: "NO_FSYNC",
+	}
+	SetRequest_Durability_value = map[string]int32{
+		"DEFAULT":        0,
+		"FSYNC_ALWAYS":   1,
+		"FSYNC_INTERVAL": 2,
+		"NO_FSYNC":       3,
+	}
+)
+
+func (x SetRequest_Durability) Enum() *SetRequest_Durability {
+	p := new(SetRequest_Durability)
+	*p = x
+	return p
+}
+
+func (x SetRequest_Durability) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (SetRequest_Durability) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_keyval_keyval_proto_enumTypes[0].Descriptor()
+}
+
+func (SetRequest_Durability) Type() protoreflect.EnumType {
+	return &file_proto_keyval_keyval_proto_enumTypes[0]
+}
+
+func (x SetRequest_Durability) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use SetRequest_Durability.Descriptor instead.
+func (SetRequest_Durability) EnumDescriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{1, 0}
+}
+
+// Replication: Cuándo se responde respecto a las réplicas. REPLICATION_DEFAULT usa -sync-replicas del servidor.
+type SetRequest_Replication int32
+
+const (
+	SetRequest_REPLICATION_DEFAULT SetRequest_Replication = 0
+	SetRequest_REPLICATION_ASYNC   SetRequest_Replication = 1 // Se responde sin esperar a las réplicas
+	SetRequest_REPLICATION_SYNC    SetRequest_Replication = 2 // Se espera a que sync_replicas réplicas confirmen la escritura
+)
+
+// Enum value maps for SetRequest_Replication.
+var (
+	SetRequest_Replication_name = map[int32]string{
+		0: "REPLICATION_DEFAULT",
+		1: "REPLICATION_ASYNC",
+		2: "REPLICATION_SYNC",
+	}
+	SetRequest_Replication_value = map[string]int32{
+		"REPLICATION_DEFAULT": 0,
+		"REPLICATION_ASYNC":   1,
+		"REPLICATION_SYNC":    2,
+	}
+)
+
+func (x SetRequest_Replication) Enum() *SetRequest_Replication {
+	p := new(SetRequest_Replication)
+	*p = x
+	return p
+}
+
+func (x SetRequest_Replication) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (SetRequest_Replication) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_keyval_keyval_proto_enumTypes[1].Descriptor()
+}
+
+func (SetRequest_Replication) Type() protoreflect.EnumType {
+	return &file_proto_keyval_keyval_proto_enumTypes[1]
+}
+
+func (x SetRequest_Replication) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use SetRequest_Replication.Descriptor instead.
+func (SetRequest_Replication) EnumDescriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{1, 1}
+}
+
+// Consistency: Garantía de la lectura con réplicas o Raft. En un servidor único todas equivalen.
+type GetRequest_Consistency int32
+
+const (
+	GetRequest_EVENTUAL     GetRequest_Consistency = 0 // Lo que tenga el nodo que recibe la petición
+	GetRequest_BOUNDED      GetRequest_Consistency = 1 // Cualquier nodo que no vaya más atrasado que max_staleness_ms / max_staleness_revisions
+	GetRequest_LINEARIZABLE GetRequest_Consistency = 2 // Desde el líder (o el primario), tras confirmar que sigue siéndolo
+)
+
+// Enum value maps for GetRequest_Consistency.
+var (
+	GetRequest_Consistency_name = map[int32]string{
+		0: "EVENTUAL",
+		1: "BOUNDED",
+		2: "LINEARIZABLE",
+	}
+	GetRequest_Consistency_value = map[string]int32{
+		"EVENTUAL":     0,
+		"BOUNDED":      1,
+		"LINEARIZABLE": 2,
+	}
+)
+
+func (x GetRequest_Consistency) Enum() *GetRequest_Consistency {
+	p := new(GetRequest_Consistency)
+	*p = x
+	return p
+}
+
+func (x GetRequest_Consistency) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (GetRequest_Consistency) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_keyval_keyval_proto_enumTypes[2].Descriptor()
+}
+
+func (GetRequest_Consistency) Type() protoreflect.EnumType {
+	return &file_proto_keyval_keyval_proto_enumTypes[2]
+}
+
+func (x GetRequest_Consistency) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use GetRequest_Consistency.Descriptor instead.
+func (GetRequest_Consistency) EnumDescriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{3, 0}
+}
+
+type Compare_Target int32
+
+const (
+	Compare_VERSION Compare_Target = 0
+	Compare_VALUE   Compare_Target = 1
+)
+
+// Enum value maps for Compare_Target.
+var (
+	Compare_Target_name = map[int32]string{
+		0: "VERSION",
+		1: "VALUE",
+	}
+	Compare_Target_value = map[string]int32{
+		"VERSION": 0,
+		"VALUE":   1,
+	}
+)
+
+func (x Compare_Target) Enum() *Compare_Target {
+	p := new(Compare_Target)
+	*p = x
+	return p
+}
+
+func (x Compare_Target) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Compare_Target) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_keyval_keyval_proto_enumTypes[3].Descriptor()
+}
+
+func (Compare_Target) Type() protoreflect.EnumType {
+	return &file_proto_keyval_keyval_proto_enumTypes[3]
+}
+
+func (x Compare_Target) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Compare_Target.Descriptor instead.
+func (Compare_Target) EnumDescriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{12, 0}
+}
+
+type Compare_Result int32
+
+const (
+	Compare_EQUAL     Compare_Result = 0
+	Compare_NOT_EQUAL Compare_Result = 1
+	Compare_GREATER   Compare_Result = 2
+	Compare_LESS      Compare_Result = 3
+)
+
+// Enum value maps for Compare_Result.
+var (
+	Compare_Result_name = map[int32]string{
+		0: "EQUAL",
+		1: "NOT_EQUAL",
+		2: "GREATER",
+		3: "LESS",
+	}
+	Compare_Result_value = map[string]int32{
+		"EQUAL":     0,
+		"NOT_EQUAL": 1,
+		"GREATER":   2,
+		"LESS":      3,
+	}
+)
+
+func (x Compare_Result) Enum() *Compare_Result {
+	p := new(Compare_Result)
+	*p = x
+	return p
+}
+
+func (x Compare_Result) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Compare_Result) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_keyval_keyval_proto_enumTypes[4].Descriptor()
+}
+
+func (Compare_Result) Type() protoreflect.EnumType {
+	return &file_proto_keyval_keyval_proto_enumTypes[4]
+}
+
+func (x Compare_Result) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Compare_Result.Descriptor instead.
+func (Compare_Result) EnumDescriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{12, 1}
+}
+
+type WatchEvent_EventType int32
+
+const (
+	WatchEvent_PUT    WatchEvent_EventType = 0
+	WatchEvent_DELETE WatchEvent_EventType = 1
+)
+
+// Enum value maps for WatchEvent_EventType.
+var (
+	WatchEvent_EventType_name = map[int32]string{
+		0: "PUT",
+		1: "DELETE",
+	}
+	WatchEvent_EventType_value = map[string]int32{
+		"PUT":    0,
+		"DELETE": 1,
+	}
+)
+
+func (x WatchEvent_EventType) Enum() *WatchEvent_EventType {
+	p := new(WatchEvent_EventType)
+	*p = x
+	return p
+}
+
+func (x WatchEvent_EventType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (WatchEvent_EventType) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_keyval_keyval_proto_enumTypes[5].Descriptor()
+}
+
+func (WatchEvent_EventType) Type() protoreflect.EnumType {
+	return &file_proto_keyval_keyval_proto_enumTypes[5]
+}
+
+func (x WatchEvent_EventType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use WatchEvent_EventType.Descriptor instead.
+func (WatchEvent_EventType) EnumDescriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{22, 0}
+}
+
 // --- Mensajes principales --- //
 type KeyValuePair struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`     // Máximo 128 bytes (validado en servidor)
-	Value         []byte                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"` // Sin límite de tamaño
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"` // Máximo 128 bytes (validado en servidor)
+	Value         []byte                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -78,6 +375,10 @@ func (x *KeyValuePair) GetValue() []byte {
 type SetRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Pair          *KeyValuePair          `protobuf:"bytes,1,opt,name=pair,proto3" json:"pair,omitempty"`
+	TtlSeconds    uint64                 `protobuf:"varint,2,opt,name=ttl_seconds,json=ttlSeconds,proto3" json:"ttl_seconds,omitempty"` // Opcional: tiempo de vida de la clave (0 = no expira)
+	Durability    SetRequest_Durability  `protobuf:"varint,3,opt,name=durability,proto3,enum=kvstore.SetRequest_Durability" json:"durability,omitempty"`
+	Replication   SetRequest_Replication `protobuf:"varint,4,opt,name=replication,proto3,enum=kvstore.SetRequest_Replication" json:"replication,omitempty"`
+	SyncReplicas  uint32                 `protobuf:"varint,5,opt,name=sync_replicas,json=syncReplicas,proto3" json:"sync_replicas,omitempty"` // Con REPLICATION_SYNC (0 = las de -sync-replicas del servidor, o 1)
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -119,10 +420,40 @@ func (x *SetRequest) GetPair() *KeyValuePair {
 	return nil
 }
 
+func (x *SetRequest) GetTtlSeconds() uint64 {
+	if x != nil {
+		return x.TtlSeconds
+	}
+	return 0
+}
+
+func (x *SetRequest) GetDurability() SetRequest_Durability {
+	if x != nil {
+		return x.Durability
+	}
+	return SetRequest_DEFAULT
+}
+
+func (x *SetRequest) GetReplication() SetRequest_Replication {
+	if x != nil {
+		return x.Replication
+	}
+	return SetRequest_REPLICATION_DEFAULT
+}
+
+func (x *SetRequest) GetSyncReplicas() uint32 {
+	if x != nil {
+		return x.SyncReplicas
+	}
+	return 0
+}
+
 type SetResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
-	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"` // Ej: "key.abc (128B) set with value (512B)"
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`    // Garantía de durabilidad que obtuvo la escritura
+	Version       uint64                 `protobuf:"varint,3,opt,name=version,proto3" json:"version,omitempty"`   // Versión asignada al valor escrito
+	Replicas      uint32                 `protobuf:"varint,4,opt,name=replicas,proto3" json:"replicas,omitempty"` // Réplicas que confirmaron la escritura antes de responder
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -171,12 +502,30 @@ func (x *SetResponse) GetMessage() string {
 	return ""
 }
 
+func (x *SetResponse) GetVersion() uint64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *SetResponse) GetReplicas() uint32 {
+	if x != nil {
+		return x.Replicas
+	}
+	return 0
+}
+
 // --- Operación Get --- //
 type GetRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state                 protoimpl.MessageState `protogen:"open.v1"`
+	Key                   string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Consistency           GetRequest_Consistency `protobuf:"varint,2,opt,name=consistency,proto3,enum=kvstore.GetRequest_Consistency" json:"consistency,omitempty"`
+	MaxStalenessMs        uint64                 `protobuf:"varint,3,opt,name=max_staleness_ms,json=maxStalenessMs,proto3" json:"max_staleness_ms,omitempty"`                      // Con BOUNDED (0 = sin límite de tiempo)
+	MaxStalenessRevisions uint64                 `protobuf:"varint,4,opt,name=max_staleness_revisions,json=maxStalenessRevisions,proto3" json:"max_staleness_revisions,omitempty"` // Con BOUNDED (0 = sin límite de revisiones)
+	MinRevision           uint64                 `protobuf:"varint,5,opt,name=min_revision,json=minRevision,proto3" json:"min_revision,omitempty"`                                 // Esperar a que el nodo haya aplicado esta revisión (read-your-writes)
+	unknownFields         protoimpl.UnknownFields
+	sizeCache             protoimpl.SizeCache
 }
 
 func (x *GetRequest) Reset() {
@@ -216,10 +565,40 @@ func (x *GetRequest) GetKey() string {
 	return ""
 }
 
+func (x *GetRequest) GetConsistency() GetRequest_Consistency {
+	if x != nil {
+		return x.Consistency
+	}
+	return GetRequest_EVENTUAL
+}
+
+func (x *GetRequest) GetMaxStalenessMs() uint64 {
+	if x != nil {
+		return x.MaxStalenessMs
+	}
+	return 0
+}
+
+func (x *GetRequest) GetMaxStalenessRevisions() uint64 {
+	if x != nil {
+		return x.MaxStalenessRevisions
+	}
+	return 0
+}
+
+func (x *GetRequest) GetMinRevision() uint64 {
+	if x != nil {
+		return x.MinRevision
+	}
+	return 0
+}
+
 type GetResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Value         []byte                 `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"` // <-- CAMBIO CRÍTICO AQUÍ (de string a bytes)
+	Value         []byte                 `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
 	Found         bool                   `protobuf:"varint,2,opt,name=found,proto3" json:"found,omitempty"`
+	Version       uint64                 `protobuf:"varint,3,opt,name=version,proto3" json:"version,omitempty"`   // Versión del valor actual (0 si no existe)
+	Revision      uint64                 `protobuf:"varint,4,opt,name=revision,proto3" json:"revision,omitempty"` // Revisión del almacén con la que se sirvió la lectura
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -268,28 +647,43 @@ func (x *GetResponse) GetFound() bool {
 	return false
 }
 
-// --- Operación GetPrefix (Streaming) --- //
-type GetPrefixRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Prefix        string                 `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"` // Prefijo a buscar
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+func (x *GetResponse) GetVersion() uint64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
 }
 
-func (x *GetPrefixRequest) Reset() {
-	*x = GetPrefixRequest{}
+func (x *GetResponse) GetRevision() uint64 {
+	if x != nil {
+		return x.Revision
+	}
+	return 0
+}
+
+// --- Operación CompareAndSet (Set condicional) --- //
+type CompareAndSetRequest struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Pair            *KeyValuePair          `protobuf:"bytes,1,opt,name=pair,proto3" json:"pair,omitempty"`
+	ExpectedVersion uint64                 `protobuf:"varint,2,opt,name=expected_version,json=expectedVersion,proto3" json:"expected_version,omitempty"` // 0 indica que la clave no debe existir
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *CompareAndSetRequest) Reset() {
+	*x = CompareAndSetRequest{}
 	mi := &file_proto_keyval_keyval_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *GetPrefixRequest) String() string {
+func (x *CompareAndSetRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*GetPrefixRequest) ProtoMessage() {}
+func (*CompareAndSetRequest) ProtoMessage() {}
 
-func (x *GetPrefixRequest) ProtoReflect() protoreflect.Message {
+func (x *CompareAndSetRequest) ProtoReflect() protoreflect.Message {
 	mi := &file_proto_keyval_keyval_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -301,43 +695,47 @@ func (x *GetPrefixRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use GetPrefixRequest.ProtoReflect.Descriptor instead.
-func (*GetPrefixRequest) Descriptor() ([]byte, []int) {
+// Deprecated: Use CompareAndSetRequest.ProtoReflect.Descriptor instead.
+func (*CompareAndSetRequest) Descriptor() ([]byte, []int) {
 	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{5}
 }
 
-func (x *GetPrefixRequest) GetPrefix() string {
+func (x *CompareAndSetRequest) GetPair() *KeyValuePair {
 	if x != nil {
-		return x.Prefix
+		return x.Pair
 	}
-	return ""
+	return nil
 }
 
-type GetPrefixStreamResponse struct {
-	state protoimpl.MessageState `protogen:"open.v1"`
-	// Types that are valid to be assigned to Response:
-	//
-	//	*GetPrefixStreamResponse_Pair
-	//	*GetPrefixStreamResponse_TotalMatches
-	Response      isGetPrefixStreamResponse_Response `protobuf_oneof:"response"`
+func (x *CompareAndSetRequest) GetExpectedVersion() uint64 {
+	if x != nil {
+		return x.ExpectedVersion
+	}
+	return 0
+}
+
+type CompareAndSetResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Version       uint64                 `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"` // Nueva versión si tuvo éxito; versión actual si falló
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *GetPrefixStreamResponse) Reset() {
-	*x = GetPrefixStreamResponse{}
+func (x *CompareAndSetResponse) Reset() {
+	*x = CompareAndSetResponse{}
 	mi := &file_proto_keyval_keyval_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *GetPrefixStreamResponse) String() string {
+func (x *CompareAndSetResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*GetPrefixStreamResponse) ProtoMessage() {}
+func (*CompareAndSetResponse) ProtoMessage() {}
 
-func (x *GetPrefixStreamResponse) ProtoReflect() protoreflect.Message {
+func (x *CompareAndSetResponse) ProtoReflect() protoreflect.Message {
 	mi := &file_proto_keyval_keyval_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -349,74 +747,1832 @@ func (x *GetPrefixStreamResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use GetPrefixStreamResponse.ProtoReflect.Descriptor instead.
-func (*GetPrefixStreamResponse) Descriptor() ([]byte, []int) {
+// Deprecated: Use CompareAndSetResponse.ProtoReflect.Descriptor instead.
+func (*CompareAndSetResponse) Descriptor() ([]byte, []int) {
 	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{6}
 }
 
-func (x *GetPrefixStreamResponse) GetResponse() isGetPrefixStreamResponse_Response {
-	if x != nil {
-		return x.Response
-	}
-	return nil
-}
-
-func (x *GetPrefixStreamResponse) GetPair() *KeyValuePair {
+func (x *CompareAndSetResponse) GetSuccess() bool {
 	if x != nil {
-		if x, ok := x.Response.(*GetPrefixStreamResponse_Pair); ok {
-			return x.Pair
-		}
+		return x.Success
 	}
-	return nil
+	return false
 }
 
-func (x *GetPrefixStreamResponse) GetTotalMatches() uint32 {
+func (x *CompareAndSetResponse) GetVersion() uint64 {
 	if x != nil {
-		if x, ok := x.Response.(*GetPrefixStreamResponse_TotalMatches); ok {
-			return x.TotalMatches
-		}
+		return x.Version
 	}
 	return 0
 }
 
-type isGetPrefixStreamResponse_Response interface {
-	isGetPrefixStreamResponse_Response()
+// --- Operación Delete --- //
+type DeleteRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
-type GetPrefixStreamResponse_Pair struct {
-	Pair *KeyValuePair `protobuf:"bytes,1,opt,name=pair,proto3,oneof"` // Pares enviados uno a uno
+func (x *DeleteRequest) Reset() {
+	*x = DeleteRequest{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
-type GetPrefixStreamResponse_TotalMatches struct {
-	TotalMatches uint32 `protobuf:"varint,2,opt,name=total_matches,json=totalMatches,proto3,oneof"` // Opcional: Total de coincidencias (último mensaje)
+func (x *DeleteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*GetPrefixStreamResponse_Pair) isGetPrefixStreamResponse_Response() {}
+func (*DeleteRequest) ProtoMessage() {}
+
+func (x *DeleteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteRequest.ProtoReflect.Descriptor instead.
+func (*DeleteRequest) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *DeleteRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+type DeleteResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Found         bool                   `protobuf:"varint,1,opt,name=found,proto3" json:"found,omitempty"` // Indica si la clave existía antes de borrarse
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteResponse) Reset() {
+	*x = DeleteResponse{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteResponse) ProtoMessage() {}
+
+func (x *DeleteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteResponse.ProtoReflect.Descriptor instead.
+func (*DeleteResponse) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *DeleteResponse) GetFound() bool {
+	if x != nil {
+		return x.Found
+	}
+	return false
+}
+
+// --- Operación Batch (escritura atómica de varias claves) --- //
+type BatchOperation struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Op:
+	//
+	//	*BatchOperation_Put
+	//	*BatchOperation_DeleteKey
+	Op            isBatchOperation_Op `protobuf_oneof:"op"`
+	TtlSeconds    uint64              `protobuf:"varint,3,opt,name=ttl_seconds,json=ttlSeconds,proto3" json:"ttl_seconds,omitempty"` // Solo para put (0 = no expira)
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchOperation) Reset() {
+	*x = BatchOperation{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchOperation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchOperation) ProtoMessage() {}
+
+func (x *BatchOperation) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchOperation.ProtoReflect.Descriptor instead.
+func (*BatchOperation) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *BatchOperation) GetOp() isBatchOperation_Op {
+	if x != nil {
+		return x.Op
+	}
+	return nil
+}
+
+func (x *BatchOperation) GetPut() *KeyValuePair {
+	if x != nil {
+		if x, ok := x.Op.(*BatchOperation_Put); ok {
+			return x.Put
+		}
+	}
+	return nil
+}
+
+func (x *BatchOperation) GetDeleteKey() string {
+	if x != nil {
+		if x, ok := x.Op.(*BatchOperation_DeleteKey); ok {
+			return x.DeleteKey
+		}
+	}
+	return ""
+}
+
+func (x *BatchOperation) GetTtlSeconds() uint64 {
+	if x != nil {
+		return x.TtlSeconds
+	}
+	return 0
+}
+
+type isBatchOperation_Op interface {
+	isBatchOperation_Op()
+}
+
+type BatchOperation_Put struct {
+	Put *KeyValuePair `protobuf:"bytes,1,opt,name=put,proto3,oneof"`
+}
+
+type BatchOperation_DeleteKey struct {
+	DeleteKey string `protobuf:"bytes,2,opt,name=delete_key,json=deleteKey,proto3,oneof"`
+}
+
+func (*BatchOperation_Put) isBatchOperation_Op() {}
+
+func (*BatchOperation_DeleteKey) isBatchOperation_Op() {}
+
+type BatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Operations    []*BatchOperation      `protobuf:"bytes,1,rep,name=operations,proto3" json:"operations,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchRequest) Reset() {
+	*x = BatchRequest{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchRequest) ProtoMessage() {}
+
+func (x *BatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchRequest.ProtoReflect.Descriptor instead.
+func (*BatchRequest) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *BatchRequest) GetOperations() []*BatchOperation {
+	if x != nil {
+		return x.Operations
+	}
+	return nil
+}
+
+type BatchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Revision      uint64                 `protobuf:"varint,2,opt,name=revision,proto3" json:"revision,omitempty"` // Revisión compartida por todas las operaciones del batch
+	Applied       uint32                 `protobuf:"varint,3,opt,name=applied,proto3" json:"applied,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchResponse) Reset() {
+	*x = BatchResponse{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchResponse) ProtoMessage() {}
+
+func (x *BatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchResponse.ProtoReflect.Descriptor instead.
+func (*BatchResponse) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *BatchResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *BatchResponse) GetRevision() uint64 {
+	if x != nil {
+		return x.Revision
+	}
+	return 0
+}
+
+func (x *BatchResponse) GetApplied() uint32 {
+	if x != nil {
+		return x.Applied
+	}
+	return 0
+}
+
+// --- Transacciones (estilo etcd: compare / then / else) --- //
+type Compare struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Target        Compare_Target         `protobuf:"varint,2,opt,name=target,proto3,enum=kvstore.Compare_Target" json:"target,omitempty"`
+	Result        Compare_Result         `protobuf:"varint,3,opt,name=result,proto3,enum=kvstore.Compare_Result" json:"result,omitempty"`
+	Version       uint64                 `protobuf:"varint,4,opt,name=version,proto3" json:"version,omitempty"` // Usado si target = VERSION (una clave inexistente tiene versión 0)
+	Value         []byte                 `protobuf:"bytes,5,opt,name=value,proto3" json:"value,omitempty"`      // Usado si target = VALUE (una clave inexistente tiene valor vacío)
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Compare) Reset() {
+	*x = Compare{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Compare) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Compare) ProtoMessage() {}
+
+func (x *Compare) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Compare.ProtoReflect.Descriptor instead.
+func (*Compare) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *Compare) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *Compare) GetTarget() Compare_Target {
+	if x != nil {
+		return x.Target
+	}
+	return Compare_VERSION
+}
+
+func (x *Compare) GetResult() Compare_Result {
+	if x != nil {
+		return x.Result
+	}
+	return Compare_EQUAL
+}
+
+func (x *Compare) GetVersion() uint64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *Compare) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+type TxnOperation struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Op:
+	//
+	//	*TxnOperation_Put
+	//	*TxnOperation_DeleteKey
+	//	*TxnOperation_GetKey
+	Op            isTxnOperation_Op `protobuf_oneof:"op"`
+	TtlSeconds    uint64            `protobuf:"varint,4,opt,name=ttl_seconds,json=ttlSeconds,proto3" json:"ttl_seconds,omitempty"` // Solo para put (0 = no expira)
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TxnOperation) Reset() {
+	*x = TxnOperation{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TxnOperation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TxnOperation) ProtoMessage() {}
+
+func (x *TxnOperation) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TxnOperation.ProtoReflect.Descriptor instead.
+func (*TxnOperation) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *TxnOperation) GetOp() isTxnOperation_Op {
+	if x != nil {
+		return x.Op
+	}
+	return nil
+}
+
+func (x *TxnOperation) GetPut() *KeyValuePair {
+	if x != nil {
+		if x, ok := x.Op.(*TxnOperation_Put); ok {
+			return x.Put
+		}
+	}
+	return nil
+}
+
+func (x *TxnOperation) GetDeleteKey() string {
+	if x != nil {
+		if x, ok := x.Op.(*TxnOperation_DeleteKey); ok {
+			return x.DeleteKey
+		}
+	}
+	return ""
+}
+
+func (x *TxnOperation) GetGetKey() string {
+	if x != nil {
+		if x, ok := x.Op.(*TxnOperation_GetKey); ok {
+			return x.GetKey
+		}
+	}
+	return ""
+}
+
+func (x *TxnOperation) GetTtlSeconds() uint64 {
+	if x != nil {
+		return x.TtlSeconds
+	}
+	return 0
+}
+
+type isTxnOperation_Op interface {
+	isTxnOperation_Op()
+}
+
+type TxnOperation_Put struct {
+	Put *KeyValuePair `protobuf:"bytes,1,opt,name=put,proto3,oneof"`
+}
+
+type TxnOperation_DeleteKey struct {
+	DeleteKey string `protobuf:"bytes,2,opt,name=delete_key,json=deleteKey,proto3,oneof"`
+}
+
+type TxnOperation_GetKey struct {
+	GetKey string `protobuf:"bytes,3,opt,name=get_key,json=getKey,proto3,oneof"`
+}
+
+func (*TxnOperation_Put) isTxnOperation_Op() {}
+
+func (*TxnOperation_DeleteKey) isTxnOperation_Op() {}
+
+func (*TxnOperation_GetKey) isTxnOperation_Op() {}
+
+type TxnOperationResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value         []byte                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`      // Solo para get
+	Found         bool                   `protobuf:"varint,3,opt,name=found,proto3" json:"found,omitempty"`     // get: la clave existe; delete: la clave existía
+	Version       uint64                 `protobuf:"varint,4,opt,name=version,proto3" json:"version,omitempty"` // get: versión leída; put: versión escrita
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TxnOperationResult) Reset() {
+	*x = TxnOperationResult{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TxnOperationResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TxnOperationResult) ProtoMessage() {}
+
+func (x *TxnOperationResult) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TxnOperationResult.ProtoReflect.Descriptor instead.
+func (*TxnOperationResult) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *TxnOperationResult) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *TxnOperationResult) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *TxnOperationResult) GetFound() bool {
+	if x != nil {
+		return x.Found
+	}
+	return false
+}
+
+func (x *TxnOperationResult) GetVersion() uint64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+type TxnRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Compare       []*Compare             `protobuf:"bytes,1,rep,name=compare,proto3" json:"compare,omitempty"`
+	Success       []*TxnOperation        `protobuf:"bytes,2,rep,name=success,proto3" json:"success,omitempty"` // Se ejecuta si todas las comparaciones se cumplen
+	Failure       []*TxnOperation        `protobuf:"bytes,3,rep,name=failure,proto3" json:"failure,omitempty"` // Se ejecuta en caso contrario
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TxnRequest) Reset() {
+	*x = TxnRequest{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TxnRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TxnRequest) ProtoMessage() {}
+
+func (x *TxnRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TxnRequest.ProtoReflect.Descriptor instead.
+func (*TxnRequest) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *TxnRequest) GetCompare() []*Compare {
+	if x != nil {
+		return x.Compare
+	}
+	return nil
+}
+
+func (x *TxnRequest) GetSuccess() []*TxnOperation {
+	if x != nil {
+		return x.Success
+	}
+	return nil
+}
+
+func (x *TxnRequest) GetFailure() []*TxnOperation {
+	if x != nil {
+		return x.Failure
+	}
+	return nil
+}
+
+type TxnResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Succeeded     bool                   `protobuf:"varint,1,opt,name=succeeded,proto3" json:"succeeded,omitempty"` // Indica qué rama se ejecutó
+	Revision      uint64                 `protobuf:"varint,2,opt,name=revision,proto3" json:"revision,omitempty"`   // Revisión de las escrituras (0 si la rama solo leía)
+	Results       []*TxnOperationResult  `protobuf:"bytes,3,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TxnResponse) Reset() {
+	*x = TxnResponse{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TxnResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TxnResponse) ProtoMessage() {}
+
+func (x *TxnResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TxnResponse.ProtoReflect.Descriptor instead.
+func (*TxnResponse) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *TxnResponse) GetSucceeded() bool {
+	if x != nil {
+		return x.Succeeded
+	}
+	return false
+}
+
+func (x *TxnResponse) GetRevision() uint64 {
+	if x != nil {
+		return x.Revision
+	}
+	return 0
+}
+
+func (x *TxnResponse) GetResults() []*TxnOperationResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+// --- Operación GetPrefix (Streaming) --- //
+type GetPrefixRequest struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Prefix    string                 `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	Limit     uint32                 `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"`                          // Máximo de pares a enviar (0 = sin límite)
+	Cursor    string                 `protobuf:"bytes,3,opt,name=cursor,proto3" json:"cursor,omitempty"`                         // Token de continuación recibido en una respuesta anterior
+	KeysOnly  bool                   `protobuf:"varint,4,opt,name=keys_only,json=keysOnly,proto3" json:"keys_only,omitempty"`    // Enviar solo las claves, sin sus valores
+	CountOnly bool                   `protobuf:"varint,5,opt,name=count_only,json=countOnly,proto3" json:"count_only,omitempty"` // No enviar pares: solo el mensaje final con total_matches
+	// Consistencia de la lectura, como en GetRequest.
+	Consistency           GetRequest_Consistency `protobuf:"varint,6,opt,name=consistency,proto3,enum=kvstore.GetRequest_Consistency" json:"consistency,omitempty"`
+	MaxStalenessMs        uint64                 `protobuf:"varint,7,opt,name=max_staleness_ms,json=maxStalenessMs,proto3" json:"max_staleness_ms,omitempty"`
+	MaxStalenessRevisions uint64                 `protobuf:"varint,8,opt,name=max_staleness_revisions,json=maxStalenessRevisions,proto3" json:"max_staleness_revisions,omitempty"`
+	MinRevision           uint64                 `protobuf:"varint,9,opt,name=min_revision,json=minRevision,proto3" json:"min_revision,omitempty"`
+	unknownFields         protoimpl.UnknownFields
+	sizeCache             protoimpl.SizeCache
+}
+
+func (x *GetPrefixRequest) Reset() {
+	*x = GetPrefixRequest{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPrefixRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPrefixRequest) ProtoMessage() {}
+
+func (x *GetPrefixRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPrefixRequest.ProtoReflect.Descriptor instead.
+func (*GetPrefixRequest) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *GetPrefixRequest) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+func (x *GetPrefixRequest) GetLimit() uint32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *GetPrefixRequest) GetCursor() string {
+	if x != nil {
+		return x.Cursor
+	}
+	return ""
+}
+
+func (x *GetPrefixRequest) GetKeysOnly() bool {
+	if x != nil {
+		return x.KeysOnly
+	}
+	return false
+}
+
+func (x *GetPrefixRequest) GetCountOnly() bool {
+	if x != nil {
+		return x.CountOnly
+	}
+	return false
+}
+
+func (x *GetPrefixRequest) GetConsistency() GetRequest_Consistency {
+	if x != nil {
+		return x.Consistency
+	}
+	return GetRequest_EVENTUAL
+}
+
+func (x *GetPrefixRequest) GetMaxStalenessMs() uint64 {
+	if x != nil {
+		return x.MaxStalenessMs
+	}
+	return 0
+}
+
+func (x *GetPrefixRequest) GetMaxStalenessRevisions() uint64 {
+	if x != nil {
+		return x.MaxStalenessRevisions
+	}
+	return 0
+}
+
+func (x *GetPrefixRequest) GetMinRevision() uint64 {
+	if x != nil {
+		return x.MinRevision
+	}
+	return 0
+}
+
+type GetPrefixStreamResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Response:
+	//
+	//	*GetPrefixStreamResponse_Pair
+	//	*GetPrefixStreamResponse_TotalMatches
+	Response      isGetPrefixStreamResponse_Response `protobuf_oneof:"response"`
+	NextCursor    string                             `protobuf:"bytes,3,opt,name=next_cursor,json=nextCursor,proto3" json:"next_cursor,omitempty"` // Solo en el último mensaje; vacío si no quedan más resultados
+	Revision      uint64                             `protobuf:"varint,4,opt,name=revision,proto3" json:"revision,omitempty"`                      // Solo en el último mensaje: revisión con la que se sirvió la lectura
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPrefixStreamResponse) Reset() {
+	*x = GetPrefixStreamResponse{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPrefixStreamResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPrefixStreamResponse) ProtoMessage() {}
+
+func (x *GetPrefixStreamResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPrefixStreamResponse.ProtoReflect.Descriptor instead.
+func (*GetPrefixStreamResponse) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *GetPrefixStreamResponse) GetResponse() isGetPrefixStreamResponse_Response {
+	if x != nil {
+		return x.Response
+	}
+	return nil
+}
+
+func (x *GetPrefixStreamResponse) GetPair() *KeyValuePair {
+	if x != nil {
+		if x, ok := x.Response.(*GetPrefixStreamResponse_Pair); ok {
+			return x.Pair
+		}
+	}
+	return nil
+}
+
+func (x *GetPrefixStreamResponse) GetTotalMatches() uint32 {
+	if x != nil {
+		if x, ok := x.Response.(*GetPrefixStreamResponse_TotalMatches); ok {
+			return x.TotalMatches
+		}
+	}
+	return 0
+}
+
+func (x *GetPrefixStreamResponse) GetNextCursor() string {
+	if x != nil {
+		return x.NextCursor
+	}
+	return ""
+}
+
+func (x *GetPrefixStreamResponse) GetRevision() uint64 {
+	if x != nil {
+		return x.Revision
+	}
+	return 0
+}
+
+type isGetPrefixStreamResponse_Response interface {
+	isGetPrefixStreamResponse_Response()
+}
+
+type GetPrefixStreamResponse_Pair struct {
+	Pair *KeyValuePair `protobuf:"bytes,1,opt,name=pair,proto3,oneof"`
+}
+
+type GetPrefixStreamResponse_TotalMatches struct {
+	TotalMatches uint32 `protobuf:"varint,2,opt,name=total_matches,json=totalMatches,proto3,oneof"` // Último mensaje: total de claves con el prefijo (con cursor, el contado en la primera página)
+}
+
+func (*GetPrefixStreamResponse_Pair) isGetPrefixStreamResponse_Response() {}
 
 func (*GetPrefixStreamResponse_TotalMatches) isGetPrefixStreamResponse_Response() {}
 
-// --- Estadísticas (oculto) --- //
-type StatRequest struct {
+// --- Operación Range (recorrido ordenado con paginación) --- //
+type RangeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	StartKey      string                 `protobuf:"bytes,1,opt,name=start_key,json=startKey,proto3" json:"start_key,omitempty"` // Inclusivo ("" = desde la primera clave)
+	EndKey        string                 `protobuf:"bytes,2,opt,name=end_key,json=endKey,proto3" json:"end_key,omitempty"`       // Exclusivo ("" = hasta la última clave)
+	Limit         uint32                 `protobuf:"varint,3,opt,name=limit,proto3" json:"limit,omitempty"`                      // Máximo de pares a devolver (0 = sin límite)
+	Reverse       bool                   `protobuf:"varint,4,opt,name=reverse,proto3" json:"reverse,omitempty"`                  // Orden descendente
+	Cursor        string                 `protobuf:"bytes,5,opt,name=cursor,proto3" json:"cursor,omitempty"`                     // Token de continuación recibido en una respuesta anterior
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RangeRequest) Reset() {
+	*x = RangeRequest{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RangeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RangeRequest) ProtoMessage() {}
+
+func (x *RangeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RangeRequest.ProtoReflect.Descriptor instead.
+func (*RangeRequest) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *RangeRequest) GetStartKey() string {
+	if x != nil {
+		return x.StartKey
+	}
+	return ""
+}
+
+func (x *RangeRequest) GetEndKey() string {
+	if x != nil {
+		return x.EndKey
+	}
+	return ""
+}
+
+func (x *RangeRequest) GetLimit() uint32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *RangeRequest) GetReverse() bool {
+	if x != nil {
+		return x.Reverse
+	}
+	return false
+}
+
+func (x *RangeRequest) GetCursor() string {
+	if x != nil {
+		return x.Cursor
+	}
+	return ""
+}
+
+type RangeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pairs         []*KeyValuePair        `protobuf:"bytes,1,rep,name=pairs,proto3" json:"pairs,omitempty"`
+	TotalMatches  uint32                 `protobuf:"varint,2,opt,name=total_matches,json=totalMatches,proto3" json:"total_matches,omitempty"` // Total de claves en [start_key, end_key), no solo las de esta página (con cursor, el contado en la primera)
+	NextCursor    string                 `protobuf:"bytes,3,opt,name=next_cursor,json=nextCursor,proto3" json:"next_cursor,omitempty"`        // Vacío si no quedan más resultados
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RangeResponse) Reset() {
+	*x = RangeResponse{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RangeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RangeResponse) ProtoMessage() {}
+
+func (x *RangeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RangeResponse.ProtoReflect.Descriptor instead.
+func (*RangeResponse) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *RangeResponse) GetPairs() []*KeyValuePair {
+	if x != nil {
+		return x.Pairs
+	}
+	return nil
+}
+
+func (x *RangeResponse) GetTotalMatches() uint32 {
+	if x != nil {
+		return x.TotalMatches
+	}
+	return 0
+}
+
+func (x *RangeResponse) GetNextCursor() string {
+	if x != nil {
+		return x.NextCursor
+	}
+	return ""
+}
+
+// --- Operación Watch (notificaciones de cambios en streaming) --- //
+type WatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Prefix        string                 `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`                                     // Prefijo de las claves a observar ("" = todas)
+	StartRevision uint64                 `protobuf:"varint,2,opt,name=start_revision,json=startRevision,proto3" json:"start_revision,omitempty"` // Revisión desde la que reenviar eventos (0 = solo cambios nuevos)
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchRequest) Reset() {
+	*x = WatchRequest{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchRequest) ProtoMessage() {}
+
+func (x *WatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchRequest.ProtoReflect.Descriptor instead.
+func (*WatchRequest) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *WatchRequest) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+func (x *WatchRequest) GetStartRevision() uint64 {
+	if x != nil {
+		return x.StartRevision
+	}
+	return 0
+}
+
+type WatchEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          WatchEvent_EventType   `protobuf:"varint,1,opt,name=type,proto3,enum=kvstore.WatchEvent_EventType" json:"type,omitempty"`
+	Pair          *KeyValuePair          `protobuf:"bytes,2,opt,name=pair,proto3" json:"pair,omitempty"` // En DELETE solo se informa la clave
+	Revision      uint64                 `protobuf:"varint,3,opt,name=revision,proto3" json:"revision,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchEvent) Reset() {
+	*x = WatchEvent{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchEvent) ProtoMessage() {}
+
+func (x *WatchEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchEvent.ProtoReflect.Descriptor instead.
+func (*WatchEvent) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *WatchEvent) GetType() WatchEvent_EventType {
+	if x != nil {
+		return x.Type
+	}
+	return WatchEvent_PUT
+}
+
+func (x *WatchEvent) GetPair() *KeyValuePair {
+	if x != nil {
+		return x.Pair
+	}
+	return nil
+}
+
+func (x *WatchEvent) GetRevision() uint64 {
+	if x != nil {
+		return x.Revision
+	}
+	return 0
+}
+
+type WatchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Events        []*WatchEvent          `protobuf:"bytes,1,rep,name=events,proto3" json:"events,omitempty"` // Eventos de una misma revisión, en orden de commit
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchResponse) Reset() {
+	*x = WatchResponse{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchResponse) ProtoMessage() {}
+
+func (x *WatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchResponse.ProtoReflect.Descriptor instead.
+func (*WatchResponse) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *WatchResponse) GetEvents() []*WatchEvent {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+// --- Copias de seguridad en caliente --- //
+type BackupRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BackupRequest) Reset() {
+	*x = BackupRequest{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BackupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BackupRequest) ProtoMessage() {}
+
+func (x *BackupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BackupRequest.ProtoReflect.Descriptor instead.
+func (*BackupRequest) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{24}
+}
+
+// BackupChunk: Trozo de una imagen de copia de seguridad (snapshot + cola del WAL).
+// La imagen es la concatenación de los trozos, en orden.
+type BackupChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Data          []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	Lsn           uint64                 `protobuf:"varint,2,opt,name=lsn,proto3" json:"lsn,omitempty"` // LSN hasta la que llega la imagen (solo en el primer trozo de Backup)
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BackupChunk) Reset() {
+	*x = BackupChunk{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BackupChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BackupChunk) ProtoMessage() {}
+
+func (x *BackupChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BackupChunk.ProtoReflect.Descriptor instead.
+func (*BackupChunk) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *BackupChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *BackupChunk) GetLsn() uint64 {
+	if x != nil {
+		return x.Lsn
+	}
+	return 0
+}
+
+type RestoreResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Revision      uint64                 `protobuf:"varint,1,opt,name=revision,proto3" json:"revision,omitempty"` // LSN del almacén restaurado
+	Keys          uint64                 `protobuf:"varint,2,opt,name=keys,proto3" json:"keys,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RestoreResponse) Reset() {
+	*x = RestoreResponse{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[26]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RestoreResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RestoreResponse) ProtoMessage() {}
+
+func (x *RestoreResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[26]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RestoreResponse.ProtoReflect.Descriptor instead.
+func (*RestoreResponse) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{26}
+}
+
+func (x *RestoreResponse) GetRevision() uint64 {
+	if x != nil {
+		return x.Revision
+	}
+	return 0
+}
+
+func (x *RestoreResponse) GetKeys() uint64 {
+	if x != nil {
+		return x.Keys
+	}
+	return 0
+}
+
+// --- Estadísticas  --- //
+type StatRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatRequest) Reset() {
+	*x = StatRequest{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[27]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatRequest) ProtoMessage() {}
+
+func (x *StatRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[27]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatRequest.ProtoReflect.Descriptor instead.
+func (*StatRequest) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{27}
+}
+
+type StatResponse struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	TotalKeys        uint64                 `protobuf:"varint,1,opt,name=total_keys,json=totalKeys,proto3" json:"total_keys,omitempty"`
+	TotalSizeBytes   uint64                 `protobuf:"varint,2,opt,name=total_size_bytes,json=totalSizeBytes,proto3" json:"total_size_bytes,omitempty"`
+	SetOperations    uint64                 `protobuf:"varint,3,opt,name=set_operations,json=setOperations,proto3" json:"set_operations,omitempty"`
+	GetOperations    uint64                 `protobuf:"varint,4,opt,name=get_operations,json=getOperations,proto3" json:"get_operations,omitempty"`
+	PrefixOperations uint64                 `protobuf:"varint,5,opt,name=prefix_operations,json=prefixOperations,proto3" json:"prefix_operations,omitempty"`
+	ActiveClients    uint64                 `protobuf:"varint,6,opt,name=active_clients,json=activeClients,proto3" json:"active_clients,omitempty"`
+	OpsPerSecond     uint64                 `protobuf:"varint,7,opt,name=ops_per_second,json=opsPerSecond,proto3" json:"ops_per_second,omitempty"`
+	DeleteOperations uint64                 `protobuf:"varint,8,opt,name=delete_operations,json=deleteOperations,proto3" json:"delete_operations,omitempty"`
+	ExpiredKeys      uint64                 `protobuf:"varint,9,opt,name=expired_keys,json=expiredKeys,proto3" json:"expired_keys,omitempty"`
+	TxnOperations    uint64                 `protobuf:"varint,10,opt,name=txn_operations,json=txnOperations,proto3" json:"txn_operations,omitempty"`
+	WalSyncs         uint64                 `protobuf:"varint,11,opt,name=wal_syncs,json=walSyncs,proto3" json:"wal_syncs,omitempty"`          // fsyncs del WAL realizados
+	WalRecords       uint64                 `protobuf:"varint,12,opt,name=wal_records,json=walRecords,proto3" json:"wal_records,omitempty"`    // Registros del WAL hechos durables (varios por fsync gracias al group commit)
+	WalSegments      uint64                 `protobuf:"varint,13,opt,name=wal_segments,json=walSegments,proto3" json:"wal_segments,omitempty"` // Segmentos del WAL en disco (incluido el activo)
+	WalSegmentBytes  uint64                 `protobuf:"varint,14,opt,name=wal_segment_bytes,json=walSegmentBytes,proto3" json:"wal_segment_bytes,omitempty"`
+	ArchivedSegments uint64                 `protobuf:"varint,15,opt,name=archived_segments,json=archivedSegments,proto3" json:"archived_segments,omitempty"` // Segmentos movidos al directorio de archivo
+	ArchivedBytes    uint64                 `protobuf:"varint,16,opt,name=archived_bytes,json=archivedBytes,proto3" json:"archived_bytes,omitempty"`
+	Replicas         uint64                 `protobuf:"varint,17,opt,name=replicas,proto3" json:"replicas,omitempty"`                   // Réplicas conectadas a este servidor
+	ReplicaOf        string                 `protobuf:"bytes,18,opt,name=replica_of,json=replicaOf,proto3" json:"replica_of,omitempty"` // En una réplica, dirección de su primario
+	// Clúster Raft (vacío fuera de un clúster)
+	RaftRole    string `protobuf:"bytes,19,opt,name=raft_role,json=raftRole,proto3" json:"raft_role,omitempty"` // "líder", "seguidor" o "candidato"
+	RaftTerm    uint64 `protobuf:"varint,20,opt,name=raft_term,json=raftTerm,proto3" json:"raft_term,omitempty"`
+	RaftLeader  string `protobuf:"bytes,21,opt,name=raft_leader,json=raftLeader,proto3" json:"raft_leader,omitempty"`  // Dirección del líder conocido
+	RaftCommit  uint64 `protobuf:"varint,22,opt,name=raft_commit,json=raftCommit,proto3" json:"raft_commit,omitempty"` // Última entrada del log confirmada por la mayoría
+	RaftApplied uint64 `protobuf:"varint,23,opt,name=raft_applied,json=raftApplied,proto3" json:"raft_applied,omitempty"`
+	// Particionado (vacío si el servidor guarda todas las claves)
+	PartitionMembers []string `protobuf:"bytes,24,rep,name=partition_members,json=partitionMembers,proto3" json:"partition_members,omitempty"` // Miembros del anillo, incluido este servidor
+	PartitionShare   float64  `protobuf:"fixed64,25,opt,name=partition_share,json=partitionShare,proto3" json:"partition_share,omitempty"`     // Fracción del anillo que pertenece a este servidor
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *StatResponse) Reset() {
+	*x = StatResponse{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[28]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatResponse) ProtoMessage() {}
+
+func (x *StatResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[28]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatResponse.ProtoReflect.Descriptor instead.
+func (*StatResponse) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{28}
+}
+
+func (x *StatResponse) GetTotalKeys() uint64 {
+	if x != nil {
+		return x.TotalKeys
+	}
+	return 0
+}
+
+func (x *StatResponse) GetTotalSizeBytes() uint64 {
+	if x != nil {
+		return x.TotalSizeBytes
+	}
+	return 0
+}
+
+func (x *StatResponse) GetSetOperations() uint64 {
+	if x != nil {
+		return x.SetOperations
+	}
+	return 0
+}
+
+func (x *StatResponse) GetGetOperations() uint64 {
+	if x != nil {
+		return x.GetOperations
+	}
+	return 0
+}
+
+func (x *StatResponse) GetPrefixOperations() uint64 {
+	if x != nil {
+		return x.PrefixOperations
+	}
+	return 0
+}
+
+func (x *StatResponse) GetActiveClients() uint64 {
+	if x != nil {
+		return x.ActiveClients
+	}
+	return 0
+}
+
+func (x *StatResponse) GetOpsPerSecond() uint64 {
+	if x != nil {
+		return x.OpsPerSecond
+	}
+	return 0
+}
+
+func (x *StatResponse) GetDeleteOperations() uint64 {
+	if x != nil {
+		return x.DeleteOperations
+	}
+	return 0
+}
+
+func (x *StatResponse) GetExpiredKeys() uint64 {
+	if x != nil {
+		return x.ExpiredKeys
+	}
+	return 0
+}
+
+func (x *StatResponse) GetTxnOperations() uint64 {
+	if x != nil {
+		return x.TxnOperations
+	}
+	return 0
+}
+
+func (x *StatResponse) GetWalSyncs() uint64 {
+	if x != nil {
+		return x.WalSyncs
+	}
+	return 0
+}
+
+func (x *StatResponse) GetWalRecords() uint64 {
+	if x != nil {
+		return x.WalRecords
+	}
+	return 0
+}
+
+func (x *StatResponse) GetWalSegments() uint64 {
+	if x != nil {
+		return x.WalSegments
+	}
+	return 0
+}
+
+func (x *StatResponse) GetWalSegmentBytes() uint64 {
+	if x != nil {
+		return x.WalSegmentBytes
+	}
+	return 0
+}
+
+func (x *StatResponse) GetArchivedSegments() uint64 {
+	if x != nil {
+		return x.ArchivedSegments
+	}
+	return 0
+}
+
+func (x *StatResponse) GetArchivedBytes() uint64 {
+	if x != nil {
+		return x.ArchivedBytes
+	}
+	return 0
+}
+
+func (x *StatResponse) GetReplicas() uint64 {
+	if x != nil {
+		return x.Replicas
+	}
+	return 0
+}
+
+func (x *StatResponse) GetReplicaOf() string {
+	if x != nil {
+		return x.ReplicaOf
+	}
+	return ""
+}
+
+func (x *StatResponse) GetRaftRole() string {
+	if x != nil {
+		return x.RaftRole
+	}
+	return ""
+}
+
+func (x *StatResponse) GetRaftTerm() uint64 {
+	if x != nil {
+		return x.RaftTerm
+	}
+	return 0
+}
+
+func (x *StatResponse) GetRaftLeader() string {
+	if x != nil {
+		return x.RaftLeader
+	}
+	return ""
+}
+
+func (x *StatResponse) GetRaftCommit() uint64 {
+	if x != nil {
+		return x.RaftCommit
+	}
+	return 0
+}
+
+func (x *StatResponse) GetRaftApplied() uint64 {
+	if x != nil {
+		return x.RaftApplied
+	}
+	return 0
+}
+
+func (x *StatResponse) GetPartitionMembers() []string {
+	if x != nil {
+		return x.PartitionMembers
+	}
+	return nil
+}
+
+func (x *StatResponse) GetPartitionShare() float64 {
+	if x != nil {
+		return x.PartitionShare
+	}
+	return 0
+}
+
+// ReplicaAck: La réplica lo envía al conectarse y tras aplicar cada mensaje.
+type ReplicaAck struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ReplicaId     string                 `protobuf:"bytes,1,opt,name=replica_id,json=replicaId,proto3" json:"replica_id,omitempty"`
+	AppliedLsn    uint64                 `protobuf:"varint,2,opt,name=applied_lsn,json=appliedLsn,proto3" json:"applied_lsn,omitempty"` // Última LSN aplicada y registrada en el WAL de la réplica
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReplicaAck) Reset() {
+	*x = ReplicaAck{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[29]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReplicaAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReplicaAck) ProtoMessage() {}
+
+func (x *ReplicaAck) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[29]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReplicaAck.ProtoReflect.Descriptor instead.
+func (*ReplicaAck) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{29}
+}
+
+func (x *ReplicaAck) GetReplicaId() string {
+	if x != nil {
+		return x.ReplicaId
+	}
+	return ""
+}
+
+func (x *ReplicaAck) GetAppliedLsn() uint64 {
+	if x != nil {
+		return x.AppliedLsn
+	}
+	return 0
+}
+
+// ReplicationMessage: Registros del WAL del primario, en orden, o un trozo de la imagen con la
+// que una réplica demasiado atrasada se pone al día (el mismo formato que Backup).
+type ReplicationMessage struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Payload:
+	//
+	//	*ReplicationMessage_WalRecords
+	//	*ReplicationMessage_Snapshot
+	Payload       isReplicationMessage_Payload `protobuf_oneof:"payload"`
+	Compression   uint32                       `protobuf:"varint,3,opt,name=compression,proto3" json:"compression,omitempty"`        // Códec de los registros de wal_records
+	LastLsn       uint64                       `protobuf:"varint,4,opt,name=last_lsn,json=lastLsn,proto3" json:"last_lsn,omitempty"` // Última LSN del primario al enviar; sin payload es un latido
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReplicationMessage) Reset() {
+	*x = ReplicationMessage{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[30]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReplicationMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReplicationMessage) ProtoMessage() {}
+
+func (x *ReplicationMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[30]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReplicationMessage.ProtoReflect.Descriptor instead.
+func (*ReplicationMessage) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{30}
+}
+
+func (x *ReplicationMessage) GetPayload() isReplicationMessage_Payload {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *ReplicationMessage) GetWalRecords() []byte {
+	if x != nil {
+		if x, ok := x.Payload.(*ReplicationMessage_WalRecords); ok {
+			return x.WalRecords
+		}
+	}
+	return nil
+}
+
+func (x *ReplicationMessage) GetSnapshot() *BackupChunk {
+	if x != nil {
+		if x, ok := x.Payload.(*ReplicationMessage_Snapshot); ok {
+			return x.Snapshot
+		}
+	}
+	return nil
+}
+
+func (x *ReplicationMessage) GetCompression() uint32 {
+	if x != nil {
+		return x.Compression
+	}
+	return 0
+}
+
+func (x *ReplicationMessage) GetLastLsn() uint64 {
+	if x != nil {
+		return x.LastLsn
+	}
+	return 0
+}
+
+type isReplicationMessage_Payload interface {
+	isReplicationMessage_Payload()
+}
+
+type ReplicationMessage_WalRecords struct {
+	WalRecords []byte `protobuf:"bytes,1,opt,name=wal_records,json=walRecords,proto3,oneof"` // Registros consecutivos, con el mismo marco que en los segmentos
+}
+
+type ReplicationMessage_Snapshot struct {
+	Snapshot *BackupChunk `protobuf:"bytes,2,opt,name=snapshot,proto3,oneof"`
+}
+
+func (*ReplicationMessage_WalRecords) isReplicationMessage_Payload() {}
+
+func (*ReplicationMessage_Snapshot) isReplicationMessage_Payload() {}
+
+// LeaderHint: Va en los detalles del error cuando un nodo que no es el líder recibe una
+// escritura. leader está vacío si todavía no hay líder elegido.
+type LeaderHint struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Leader        string                 `protobuf:"bytes,1,opt,name=leader,proto3" json:"leader,omitempty"` // Dirección del líder, a la que el cliente debe reenviar la petición
+	Term          uint64                 `protobuf:"varint,2,opt,name=term,proto3" json:"term,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LeaderHint) Reset() {
+	*x = LeaderHint{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[31]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LeaderHint) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LeaderHint) ProtoMessage() {}
+
+func (x *LeaderHint) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[31]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LeaderHint.ProtoReflect.Descriptor instead.
+func (*LeaderHint) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{31}
+}
+
+func (x *LeaderHint) GetLeader() string {
+	if x != nil {
+		return x.Leader
+	}
+	return ""
+}
+
+func (x *LeaderHint) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+type VoteRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
+	Term          uint64                 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
+	Candidate     string                 `protobuf:"bytes,2,opt,name=candidate,proto3" json:"candidate,omitempty"`
+	LastLogIndex  uint64                 `protobuf:"varint,3,opt,name=last_log_index,json=lastLogIndex,proto3" json:"last_log_index,omitempty"`
+	LastLogTerm   uint64                 `protobuf:"varint,4,opt,name=last_log_term,json=lastLogTerm,proto3" json:"last_log_term,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *StatRequest) Reset() {
-	*x = StatRequest{}
-	mi := &file_proto_keyval_keyval_proto_msgTypes[7]
+func (x *VoteRequest) Reset() {
+	*x = VoteRequest{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[32]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *StatRequest) String() string {
+func (x *VoteRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*StatRequest) ProtoMessage() {}
+func (*VoteRequest) ProtoMessage() {}
 
-func (x *StatRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_keyval_keyval_proto_msgTypes[7]
+func (x *VoteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[32]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -427,39 +2583,62 @@ func (x *StatRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use StatRequest.ProtoReflect.Descriptor instead.
-func (*StatRequest) Descriptor() ([]byte, []int) {
-	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{7}
+// Deprecated: Use VoteRequest.ProtoReflect.Descriptor instead.
+func (*VoteRequest) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{32}
 }
 
-type StatResponse struct {
-	state            protoimpl.MessageState `protogen:"open.v1"`
-	TotalKeys        uint64                 `protobuf:"varint,1,opt,name=total_keys,json=totalKeys,proto3" json:"total_keys,omitempty"`
-	TotalSizeBytes   uint64                 `protobuf:"varint,2,opt,name=total_size_bytes,json=totalSizeBytes,proto3" json:"total_size_bytes,omitempty"`
-	SetOperations    uint64                 `protobuf:"varint,3,opt,name=set_operations,json=setOperations,proto3" json:"set_operations,omitempty"`
-	GetOperations    uint64                 `protobuf:"varint,4,opt,name=get_operations,json=getOperations,proto3" json:"get_operations,omitempty"`
-	PrefixOperations uint64                 `protobuf:"varint,5,opt,name=prefix_operations,json=prefixOperations,proto3" json:"prefix_operations,omitempty"`
-	ActiveClients    uint64                 `protobuf:"varint,6,opt,name=active_clients,json=activeClients,proto3" json:"active_clients,omitempty"`
-	OpsPerSecond     uint64                 `protobuf:"varint,7,opt,name=ops_per_second,json=opsPerSecond,proto3" json:"ops_per_second,omitempty"`
-	unknownFields    protoimpl.UnknownFields
-	sizeCache        protoimpl.SizeCache
+func (x *VoteRequest) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
 }
 
-func (x *StatResponse) Reset() {
-	*x = StatResponse{}
-	mi := &file_proto_keyval_keyval_proto_msgTypes[8]
+func (x *VoteRequest) GetCandidate() string {
+	if x != nil {
+		return x.Candidate
+	}
+	return ""
+}
+
+func (x *VoteRequest) GetLastLogIndex() uint64 {
+	if x != nil {
+		return x.LastLogIndex
+	}
+	return 0
+}
+
+func (x *VoteRequest) GetLastLogTerm() uint64 {
+	if x != nil {
+		return x.LastLogTerm
+	}
+	return 0
+}
+
+type VoteResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Term          uint64                 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
+	Granted       bool                   `protobuf:"varint,2,opt,name=granted,proto3" json:"granted,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VoteResponse) Reset() {
+	*x = VoteResponse{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[33]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *StatResponse) String() string {
+func (x *VoteResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*StatResponse) ProtoMessage() {}
+func (*VoteResponse) ProtoMessage() {}
 
-func (x *StatResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_keyval_keyval_proto_msgTypes[8]
+func (x *VoteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[33]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -470,60 +2649,295 @@ func (x *StatResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use StatResponse.ProtoReflect.Descriptor instead.
-func (*StatResponse) Descriptor() ([]byte, []int) {
-	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{8}
+// Deprecated: Use VoteResponse.ProtoReflect.Descriptor instead.
+func (*VoteResponse) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{33}
 }
 
-func (x *StatResponse) GetTotalKeys() uint64 {
+func (x *VoteResponse) GetTerm() uint64 {
 	if x != nil {
-		return x.TotalKeys
+		return x.Term
 	}
 	return 0
 }
 
-func (x *StatResponse) GetTotalSizeBytes() uint64 {
+func (x *VoteResponse) GetGranted() bool {
 	if x != nil {
-		return x.TotalSizeBytes
+		return x.Granted
+	}
+	return false
+}
+
+type AppendEntriesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Term          uint64                 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
+	Leader        string                 `protobuf:"bytes,2,opt,name=leader,proto3" json:"leader,omitempty"`
+	PrevLogIndex  uint64                 `protobuf:"varint,3,opt,name=prev_log_index,json=prevLogIndex,proto3" json:"prev_log_index,omitempty"`
+	PrevLogTerm   uint64                 `protobuf:"varint,4,opt,name=prev_log_term,json=prevLogTerm,proto3" json:"prev_log_term,omitempty"`
+	Entries       []byte                 `protobuf:"bytes,5,opt,name=entries,proto3" json:"entries,omitempty"`          // Registros del WAL consecutivos desde prev_log_index+1, con su marco
+	Compression   uint32                 `protobuf:"varint,6,opt,name=compression,proto3" json:"compression,omitempty"` // Códec de los registros
+	LeaderCommit  uint64                 `protobuf:"varint,7,opt,name=leader_commit,json=leaderCommit,proto3" json:"leader_commit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AppendEntriesRequest) Reset() {
+	*x = AppendEntriesRequest{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[34]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AppendEntriesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AppendEntriesRequest) ProtoMessage() {}
+
+func (x *AppendEntriesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[34]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AppendEntriesRequest.ProtoReflect.Descriptor instead.
+func (*AppendEntriesRequest) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{34}
+}
+
+func (x *AppendEntriesRequest) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
 	}
 	return 0
 }
 
-func (x *StatResponse) GetSetOperations() uint64 {
+func (x *AppendEntriesRequest) GetLeader() string {
 	if x != nil {
-		return x.SetOperations
+		return x.Leader
+	}
+	return ""
+}
+
+func (x *AppendEntriesRequest) GetPrevLogIndex() uint64 {
+	if x != nil {
+		return x.PrevLogIndex
 	}
 	return 0
 }
 
-func (x *StatResponse) GetGetOperations() uint64 {
+func (x *AppendEntriesRequest) GetPrevLogTerm() uint64 {
 	if x != nil {
-		return x.GetOperations
+		return x.PrevLogTerm
 	}
 	return 0
 }
 
-func (x *StatResponse) GetPrefixOperations() uint64 {
+func (x *AppendEntriesRequest) GetEntries() []byte {
 	if x != nil {
-		return x.PrefixOperations
+		return x.Entries
+	}
+	return nil
+}
+
+func (x *AppendEntriesRequest) GetCompression() uint32 {
+	if x != nil {
+		return x.Compression
 	}
 	return 0
 }
 
-func (x *StatResponse) GetActiveClients() uint64 {
+func (x *AppendEntriesRequest) GetLeaderCommit() uint64 {
 	if x != nil {
-		return x.ActiveClients
+		return x.LeaderCommit
 	}
 	return 0
 }
 
-func (x *StatResponse) GetOpsPerSecond() uint64 {
+type AppendEntriesResponse struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Term    uint64                 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
+	Success bool                   `protobuf:"varint,2,opt,name=success,proto3" json:"success,omitempty"`
+	// Con éxito, la última entrada que el seguidor tiene igual que el líder. Si no, una pista de
+	// por dónde seguir: su última entrada o, si hay conflicto, su última entrada confirmada.
+	LastIndex     uint64 `protobuf:"varint,3,opt,name=last_index,json=lastIndex,proto3" json:"last_index,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AppendEntriesResponse) Reset() {
+	*x = AppendEntriesResponse{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[35]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AppendEntriesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AppendEntriesResponse) ProtoMessage() {}
+
+func (x *AppendEntriesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[35]
 	if x != nil {
-		return x.OpsPerSecond
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AppendEntriesResponse.ProtoReflect.Descriptor instead.
+func (*AppendEntriesResponse) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{35}
+}
+
+func (x *AppendEntriesResponse) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+func (x *AppendEntriesResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *AppendEntriesResponse) GetLastIndex() uint64 {
+	if x != nil {
+		return x.LastIndex
+	}
+	return 0
+}
+
+// InstallSnapshotRequest: Trozo de la imagen (el formato de Backup) con la que el líder pone
+// al día a un seguidor que necesita entradas ya compactadas del log.
+type InstallSnapshotRequest struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	Term             uint64                 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
+	Leader           string                 `protobuf:"bytes,2,opt,name=leader,proto3" json:"leader,omitempty"`
+	LastIncludedTerm uint64                 `protobuf:"varint,3,opt,name=last_included_term,json=lastIncludedTerm,proto3" json:"last_included_term,omitempty"` // Mandato de la última entrada que cubre la imagen
+	Chunk            *BackupChunk           `protobuf:"bytes,4,opt,name=chunk,proto3" json:"chunk,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *InstallSnapshotRequest) Reset() {
+	*x = InstallSnapshotRequest{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[36]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InstallSnapshotRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InstallSnapshotRequest) ProtoMessage() {}
+
+func (x *InstallSnapshotRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[36]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InstallSnapshotRequest.ProtoReflect.Descriptor instead.
+func (*InstallSnapshotRequest) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{36}
+}
+
+func (x *InstallSnapshotRequest) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
 	}
 	return 0
 }
 
+func (x *InstallSnapshotRequest) GetLeader() string {
+	if x != nil {
+		return x.Leader
+	}
+	return ""
+}
+
+func (x *InstallSnapshotRequest) GetLastIncludedTerm() uint64 {
+	if x != nil {
+		return x.LastIncludedTerm
+	}
+	return 0
+}
+
+func (x *InstallSnapshotRequest) GetChunk() *BackupChunk {
+	if x != nil {
+		return x.Chunk
+	}
+	return nil
+}
+
+// PartitionHint: Va en los detalles del error cuando un servidor arrancado con
+// -partition-redirect recibe una petición sobre claves de otra partición.
+type PartitionHint struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Owner         string                 `protobuf:"bytes,1,opt,name=owner,proto3" json:"owner,omitempty"` // Servidor dueño de las claves, al que el cliente debe enviar la petición
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PartitionHint) Reset() {
+	*x = PartitionHint{}
+	mi := &file_proto_keyval_keyval_proto_msgTypes[37]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PartitionHint) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PartitionHint) ProtoMessage() {}
+
+func (x *PartitionHint) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_keyval_keyval_proto_msgTypes[37]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PartitionHint.ProtoReflect.Descriptor instead.
+func (*PartitionHint) Descriptor() ([]byte, []int) {
+	return file_proto_keyval_keyval_proto_rawDescGZIP(), []int{37}
+}
+
+func (x *PartitionHint) GetOwner() string {
+	if x != nil {
+		return x.Owner
+	}
+	return ""
+}
+
 var File_proto_keyval_keyval_proto protoreflect.FileDescriptor
 
 const file_proto_keyval_keyval_proto_rawDesc = "" +
@@ -531,27 +2945,161 @@ const file_proto_keyval_keyval_proto_rawDesc = "" +
 	"\x19proto/keyval/keyval.proto\x12\akvstore\"6\n" +
 	"\fKeyValuePair\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\fR\x05value\"7\n" +
+	"\x05value\x18\x02 \x01(\fR\x05value\"\xa4\x03\n" +
 	"\n" +
 	"SetRequest\x12)\n" +
-	"\x04pair\x18\x01 \x01(\v2\x15.kvstore.KeyValuePairR\x04pair\"A\n" +
+	"\x04pair\x18\x01 \x01(\v2\x15.kvstore.KeyValuePairR\x04pair\x12\x1f\n" +
+	"\vttl_seconds\x18\x02 \x01(\x04R\n" +
+	"ttlSeconds\x12>\n" +
+	"\n" +
+	"durability\x18\x03 \x01(\x0e2\x1e.kvstore.SetRequest.DurabilityR\n" +
+	"durability\x12A\n" +
+	"\vreplication\x18\x04 \x01(\x0e2\x1f.kvstore.SetRequest.ReplicationR\vreplication\x12#\n" +
+	"\rsync_replicas\x18\x05 \x01(\rR\fsyncReplicas\"M\n" +
+	"\n" +
+	"Durability\x12\v\n" +
+	"\aDEFAULT\x10\x00\x12\x10\n" +
+	"\fFSYNC_ALWAYS\x10\x01\x12\x12\n" +
+	"\x0eFSYNC_INTERVAL\x10\x02\x12\f\n" +
+	"\bNO_FSYNC\x10\x03\"S\n" +
+	"\vReplication\x12\x17\n" +
+	"\x13REPLICATION_DEFAULT\x10\x00\x12\x15\n" +
+	"\x11REPLICATION_ASYNC\x10\x01\x12\x14\n" +
+	"\x10REPLICATION_SYNC\x10\x02\"w\n" +
 	"\vSetResponse\x12\x18\n" +
 	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
-	"\amessage\x18\x02 \x01(\tR\amessage\"\x1e\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12\x18\n" +
+	"\aversion\x18\x03 \x01(\x04R\aversion\x12\x1a\n" +
+	"\breplicas\x18\x04 \x01(\rR\breplicas\"\xa2\x02\n" +
 	"\n" +
 	"GetRequest\x12\x10\n" +
-	"\x03key\x18\x01 \x01(\tR\x03key\"9\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12A\n" +
+	"\vconsistency\x18\x02 \x01(\x0e2\x1f.kvstore.GetRequest.ConsistencyR\vconsistency\x12(\n" +
+	"\x10max_staleness_ms\x18\x03 \x01(\x04R\x0emaxStalenessMs\x126\n" +
+	"\x17max_staleness_revisions\x18\x04 \x01(\x04R\x15maxStalenessRevisions\x12!\n" +
+	"\fmin_revision\x18\x05 \x01(\x04R\vminRevision\":\n" +
+	"\vConsistency\x12\f\n" +
+	"\bEVENTUAL\x10\x00\x12\v\n" +
+	"\aBOUNDED\x10\x01\x12\x10\n" +
+	"\fLINEARIZABLE\x10\x02\"o\n" +
 	"\vGetResponse\x12\x14\n" +
 	"\x05value\x18\x01 \x01(\fR\x05value\x12\x14\n" +
-	"\x05found\x18\x02 \x01(\bR\x05found\"*\n" +
+	"\x05found\x18\x02 \x01(\bR\x05found\x12\x18\n" +
+	"\aversion\x18\x03 \x01(\x04R\aversion\x12\x1a\n" +
+	"\brevision\x18\x04 \x01(\x04R\brevision\"l\n" +
+	"\x14CompareAndSetRequest\x12)\n" +
+	"\x04pair\x18\x01 \x01(\v2\x15.kvstore.KeyValuePairR\x04pair\x12)\n" +
+	"\x10expected_version\x18\x02 \x01(\x04R\x0fexpectedVersion\"K\n" +
+	"\x15CompareAndSetResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\x04R\aversion\"!\n" +
+	"\rDeleteRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\"&\n" +
+	"\x0eDeleteResponse\x12\x14\n" +
+	"\x05found\x18\x01 \x01(\bR\x05found\"\x83\x01\n" +
+	"\x0eBatchOperation\x12)\n" +
+	"\x03put\x18\x01 \x01(\v2\x15.kvstore.KeyValuePairH\x00R\x03put\x12\x1f\n" +
+	"\n" +
+	"delete_key\x18\x02 \x01(\tH\x00R\tdeleteKey\x12\x1f\n" +
+	"\vttl_seconds\x18\x03 \x01(\x04R\n" +
+	"ttlSecondsB\x04\n" +
+	"\x02op\"G\n" +
+	"\fBatchRequest\x127\n" +
+	"\n" +
+	"operations\x18\x01 \x03(\v2\x17.kvstore.BatchOperationR\n" +
+	"operations\"_\n" +
+	"\rBatchResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x1a\n" +
+	"\brevision\x18\x02 \x01(\x04R\brevision\x12\x18\n" +
+	"\aapplied\x18\x03 \x01(\rR\aapplied\"\x8a\x02\n" +
+	"\aCompare\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12/\n" +
+	"\x06target\x18\x02 \x01(\x0e2\x17.kvstore.Compare.TargetR\x06target\x12/\n" +
+	"\x06result\x18\x03 \x01(\x0e2\x17.kvstore.Compare.ResultR\x06result\x12\x18\n" +
+	"\aversion\x18\x04 \x01(\x04R\aversion\x12\x14\n" +
+	"\x05value\x18\x05 \x01(\fR\x05value\" \n" +
+	"\x06Target\x12\v\n" +
+	"\aVERSION\x10\x00\x12\t\n" +
+	"\x05VALUE\x10\x01\"9\n" +
+	"\x06Result\x12\t\n" +
+	"\x05EQUAL\x10\x00\x12\r\n" +
+	"\tNOT_EQUAL\x10\x01\x12\v\n" +
+	"\aGREATER\x10\x02\x12\b\n" +
+	"\x04LESS\x10\x03\"\x9c\x01\n" +
+	"\fTxnOperation\x12)\n" +
+	"\x03put\x18\x01 \x01(\v2\x15.kvstore.KeyValuePairH\x00R\x03put\x12\x1f\n" +
+	"\n" +
+	"delete_key\x18\x02 \x01(\tH\x00R\tdeleteKey\x12\x19\n" +
+	"\aget_key\x18\x03 \x01(\tH\x00R\x06getKey\x12\x1f\n" +
+	"\vttl_seconds\x18\x04 \x01(\x04R\n" +
+	"ttlSecondsB\x04\n" +
+	"\x02op\"l\n" +
+	"\x12TxnOperationResult\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\fR\x05value\x12\x14\n" +
+	"\x05found\x18\x03 \x01(\bR\x05found\x12\x18\n" +
+	"\aversion\x18\x04 \x01(\x04R\aversion\"\x9a\x01\n" +
+	"\n" +
+	"TxnRequest\x12*\n" +
+	"\acompare\x18\x01 \x03(\v2\x10.kvstore.CompareR\acompare\x12/\n" +
+	"\asuccess\x18\x02 \x03(\v2\x15.kvstore.TxnOperationR\asuccess\x12/\n" +
+	"\afailure\x18\x03 \x03(\v2\x15.kvstore.TxnOperationR\afailure\"~\n" +
+	"\vTxnResponse\x12\x1c\n" +
+	"\tsucceeded\x18\x01 \x01(\bR\tsucceeded\x12\x1a\n" +
+	"\brevision\x18\x02 \x01(\x04R\brevision\x125\n" +
+	"\aresults\x18\x03 \x03(\v2\x1b.kvstore.TxnOperationResultR\aresults\"\xdc\x02\n" +
 	"\x10GetPrefixRequest\x12\x16\n" +
-	"\x06prefix\x18\x01 \x01(\tR\x06prefix\"y\n" +
+	"\x06prefix\x18\x01 \x01(\tR\x06prefix\x12\x14\n" +
+	"\x05limit\x18\x02 \x01(\rR\x05limit\x12\x16\n" +
+	"\x06cursor\x18\x03 \x01(\tR\x06cursor\x12\x1b\n" +
+	"\tkeys_only\x18\x04 \x01(\bR\bkeysOnly\x12\x1d\n" +
+	"\n" +
+	"count_only\x18\x05 \x01(\bR\tcountOnly\x12A\n" +
+	"\vconsistency\x18\x06 \x01(\x0e2\x1f.kvstore.GetRequest.ConsistencyR\vconsistency\x12(\n" +
+	"\x10max_staleness_ms\x18\a \x01(\x04R\x0emaxStalenessMs\x126\n" +
+	"\x17max_staleness_revisions\x18\b \x01(\x04R\x15maxStalenessRevisions\x12!\n" +
+	"\fmin_revision\x18\t \x01(\x04R\vminRevision\"\xb6\x01\n" +
 	"\x17GetPrefixStreamResponse\x12+\n" +
 	"\x04pair\x18\x01 \x01(\v2\x15.kvstore.KeyValuePairH\x00R\x04pair\x12%\n" +
-	"\rtotal_matches\x18\x02 \x01(\rH\x00R\ftotalMatchesB\n" +
+	"\rtotal_matches\x18\x02 \x01(\rH\x00R\ftotalMatches\x12\x1f\n" +
+	"\vnext_cursor\x18\x03 \x01(\tR\n" +
+	"nextCursor\x12\x1a\n" +
+	"\brevision\x18\x04 \x01(\x04R\brevisionB\n" +
+	"\n" +
+	"\bresponse\"\x8c\x01\n" +
+	"\fRangeRequest\x12\x1b\n" +
+	"\tstart_key\x18\x01 \x01(\tR\bstartKey\x12\x17\n" +
+	"\aend_key\x18\x02 \x01(\tR\x06endKey\x12\x14\n" +
+	"\x05limit\x18\x03 \x01(\rR\x05limit\x12\x18\n" +
+	"\areverse\x18\x04 \x01(\bR\areverse\x12\x16\n" +
+	"\x06cursor\x18\x05 \x01(\tR\x06cursor\"\x82\x01\n" +
+	"\rRangeResponse\x12+\n" +
+	"\x05pairs\x18\x01 \x03(\v2\x15.kvstore.KeyValuePairR\x05pairs\x12#\n" +
+	"\rtotal_matches\x18\x02 \x01(\rR\ftotalMatches\x12\x1f\n" +
+	"\vnext_cursor\x18\x03 \x01(\tR\n" +
+	"nextCursor\"M\n" +
+	"\fWatchRequest\x12\x16\n" +
+	"\x06prefix\x18\x01 \x01(\tR\x06prefix\x12%\n" +
+	"\x0estart_revision\x18\x02 \x01(\x04R\rstartRevision\"\xa8\x01\n" +
+	"\n" +
+	"WatchEvent\x121\n" +
+	"\x04type\x18\x01 \x01(\x0e2\x1d.kvstore.WatchEvent.EventTypeR\x04type\x12)\n" +
+	"\x04pair\x18\x02 \x01(\v2\x15.kvstore.KeyValuePairR\x04pair\x12\x1a\n" +
+	"\brevision\x18\x03 \x01(\x04R\brevision\" \n" +
+	"\tEventType\x12\a\n" +
+	"\x03PUT\x10\x00\x12\n" +
 	"\n" +
-	"\bresponse\"\r\n" +
-	"\vStatRequest\"\x9f\x02\n" +
+	"\x06DELETE\x10\x01\"<\n" +
+	"\rWatchResponse\x12+\n" +
+	"\x06events\x18\x01 \x03(\v2\x13.kvstore.WatchEventR\x06events\"\x0f\n" +
+	"\rBackupRequest\"3\n" +
+	"\vBackupChunk\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data\x12\x10\n" +
+	"\x03lsn\x18\x02 \x01(\x04R\x03lsn\"A\n" +
+	"\x0fRestoreResponse\x12\x1a\n" +
+	"\brevision\x18\x01 \x01(\x04R\brevision\x12\x12\n" +
+	"\x04keys\x18\x02 \x01(\x04R\x04keys\"\r\n" +
+	"\vStatRequest\"\xa7\a\n" +
 	"\fStatResponse\x12\x1d\n" +
 	"\n" +
 	"total_keys\x18\x01 \x01(\x04R\ttotalKeys\x12(\n" +
@@ -560,12 +3108,94 @@ const file_proto_keyval_keyval_proto_rawDesc = "" +
 	"\x0eget_operations\x18\x04 \x01(\x04R\rgetOperations\x12+\n" +
 	"\x11prefix_operations\x18\x05 \x01(\x04R\x10prefixOperations\x12%\n" +
 	"\x0eactive_clients\x18\x06 \x01(\x04R\ractiveClients\x12$\n" +
-	"\x0eops_per_second\x18\a \x01(\x04R\fopsPerSecond2\xfc\x01\n" +
+	"\x0eops_per_second\x18\a \x01(\x04R\fopsPerSecond\x12+\n" +
+	"\x11delete_operations\x18\b \x01(\x04R\x10deleteOperations\x12!\n" +
+	"\fexpired_keys\x18\t \x01(\x04R\vexpiredKeys\x12%\n" +
+	"\x0etxn_operations\x18\n" +
+	" \x01(\x04R\rtxnOperations\x12\x1b\n" +
+	"\twal_syncs\x18\v \x01(\x04R\bwalSyncs\x12\x1f\n" +
+	"\vwal_records\x18\f \x01(\x04R\n" +
+	"walRecords\x12!\n" +
+	"\fwal_segments\x18\r \x01(\x04R\vwalSegments\x12*\n" +
+	"\x11wal_segment_bytes\x18\x0e \x01(\x04R\x0fwalSegmentBytes\x12+\n" +
+	"\x11archived_segments\x18\x0f \x01(\x04R\x10archivedSegments\x12%\n" +
+	"\x0earchived_bytes\x18\x10 \x01(\x04R\rarchivedBytes\x12\x1a\n" +
+	"\breplicas\x18\x11 \x01(\x04R\breplicas\x12\x1d\n" +
+	"\n" +
+	"replica_of\x18\x12 \x01(\tR\treplicaOf\x12\x1b\n" +
+	"\traft_role\x18\x13 \x01(\tR\braftRole\x12\x1b\n" +
+	"\traft_term\x18\x14 \x01(\x04R\braftTerm\x12\x1f\n" +
+	"\vraft_leader\x18\x15 \x01(\tR\n" +
+	"raftLeader\x12\x1f\n" +
+	"\vraft_commit\x18\x16 \x01(\x04R\n" +
+	"raftCommit\x12!\n" +
+	"\fraft_applied\x18\x17 \x01(\x04R\vraftApplied\x12+\n" +
+	"\x11partition_members\x18\x18 \x03(\tR\x10partitionMembers\x12'\n" +
+	"\x0fpartition_share\x18\x19 \x01(\x01R\x0epartitionShare\"L\n" +
+	"\n" +
+	"ReplicaAck\x12\x1d\n" +
+	"\n" +
+	"replica_id\x18\x01 \x01(\tR\treplicaId\x12\x1f\n" +
+	"\vapplied_lsn\x18\x02 \x01(\x04R\n" +
+	"appliedLsn\"\xb3\x01\n" +
+	"\x12ReplicationMessage\x12!\n" +
+	"\vwal_records\x18\x01 \x01(\fH\x00R\n" +
+	"walRecords\x122\n" +
+	"\bsnapshot\x18\x02 \x01(\v2\x14.kvstore.BackupChunkH\x00R\bsnapshot\x12 \n" +
+	"\vcompression\x18\x03 \x01(\rR\vcompression\x12\x19\n" +
+	"\blast_lsn\x18\x04 \x01(\x04R\alastLsnB\t\n" +
+	"\apayload\"8\n" +
+	"\n" +
+	"LeaderHint\x12\x16\n" +
+	"\x06leader\x18\x01 \x01(\tR\x06leader\x12\x12\n" +
+	"\x04term\x18\x02 \x01(\x04R\x04term\"\x89\x01\n" +
+	"\vVoteRequest\x12\x12\n" +
+	"\x04term\x18\x01 \x01(\x04R\x04term\x12\x1c\n" +
+	"\tcandidate\x18\x02 \x01(\tR\tcandidate\x12$\n" +
+	"\x0elast_log_index\x18\x03 \x01(\x04R\flastLogIndex\x12\"\n" +
+	"\rlast_log_term\x18\x04 \x01(\x04R\vlastLogTerm\"<\n" +
+	"\fVoteResponse\x12\x12\n" +
+	"\x04term\x18\x01 \x01(\x04R\x04term\x12\x18\n" +
+	"\agranted\x18\x02 \x01(\bR\agranted\"\xed\x01\n" +
+	"\x14AppendEntriesRequest\x12\x12\n" +
+	"\x04term\x18\x01 \x01(\x04R\x04term\x12\x16\n" +
+	"\x06leader\x18\x02 \x01(\tR\x06leader\x12$\n" +
+	"\x0eprev_log_index\x18\x03 \x01(\x04R\fprevLogIndex\x12\"\n" +
+	"\rprev_log_term\x18\x04 \x01(\x04R\vprevLogTerm\x12\x18\n" +
+	"\aentries\x18\x05 \x01(\fR\aentries\x12 \n" +
+	"\vcompression\x18\x06 \x01(\rR\vcompression\x12#\n" +
+	"\rleader_commit\x18\a \x01(\x04R\fleaderCommit\"d\n" +
+	"\x15AppendEntriesResponse\x12\x12\n" +
+	"\x04term\x18\x01 \x01(\x04R\x04term\x12\x18\n" +
+	"\asuccess\x18\x02 \x01(\bR\asuccess\x12\x1d\n" +
+	"\n" +
+	"last_index\x18\x03 \x01(\x04R\tlastIndex\"\x9e\x01\n" +
+	"\x16InstallSnapshotRequest\x12\x12\n" +
+	"\x04term\x18\x01 \x01(\x04R\x04term\x12\x16\n" +
+	"\x06leader\x18\x02 \x01(\tR\x06leader\x12,\n" +
+	"\x12last_included_term\x18\x03 \x01(\x04R\x10lastIncludedTerm\x12*\n" +
+	"\x05chunk\x18\x04 \x01(\v2\x14.kvstore.BackupChunkR\x05chunk\"%\n" +
+	"\rPartitionHint\x12\x14\n" +
+	"\x05owner\x18\x01 \x01(\tR\x05owner2\xda\x05\n" +
 	"\x0fKeyValueService\x120\n" +
 	"\x03Set\x12\x13.kvstore.SetRequest\x1a\x14.kvstore.SetResponse\x120\n" +
-	"\x03Get\x12\x13.kvstore.GetRequest\x1a\x14.kvstore.GetResponse\x12P\n" +
-	"\x0fGetPrefixStream\x12\x19.kvstore.GetPrefixRequest\x1a .kvstore.GetPrefixStreamResponse0\x01\x123\n" +
-	"\x04Stat\x12\x14.kvstore.StatRequest\x1a\x15.kvstore.StatResponseB\x0fZ\rkvstore/protob\x06proto3"
+	"\x03Get\x12\x13.kvstore.GetRequest\x1a\x14.kvstore.GetResponse\x12N\n" +
+	"\rCompareAndSet\x12\x1d.kvstore.CompareAndSetRequest\x1a\x1e.kvstore.CompareAndSetResponse\x129\n" +
+	"\x06Delete\x12\x16.kvstore.DeleteRequest\x1a\x17.kvstore.DeleteResponse\x126\n" +
+	"\x05Batch\x12\x15.kvstore.BatchRequest\x1a\x16.kvstore.BatchResponse\x120\n" +
+	"\x03Txn\x12\x13.kvstore.TxnRequest\x1a\x14.kvstore.TxnResponse\x12P\n" +
+	"\x0fGetPrefixStream\x12\x19.kvstore.GetPrefixRequest\x1a .kvstore.GetPrefixStreamResponse0\x01\x126\n" +
+	"\x05Range\x12\x15.kvstore.RangeRequest\x1a\x16.kvstore.RangeResponse\x128\n" +
+	"\x05Watch\x12\x15.kvstore.WatchRequest\x1a\x16.kvstore.WatchResponse0\x01\x123\n" +
+	"\x04Stat\x12\x14.kvstore.StatRequest\x1a\x15.kvstore.StatResponse\x128\n" +
+	"\x06Backup\x12\x16.kvstore.BackupRequest\x1a\x14.kvstore.BackupChunk0\x01\x12;\n" +
+	"\aRestore\x12\x14.kvstore.BackupChunk\x1a\x18.kvstore.RestoreResponse(\x012W\n" +
+	"\x12ReplicationService\x12A\n" +
+	"\tReplicate\x12\x13.kvstore.ReplicaAck\x1a\x1b.kvstore.ReplicationMessage(\x010\x012\xef\x01\n" +
+	"\vRaftService\x12:\n" +
+	"\vRequestVote\x12\x14.kvstore.VoteRequest\x1a\x15.kvstore.VoteResponse\x12N\n" +
+	"\rAppendEntries\x12\x1d.kvstore.AppendEntriesRequest\x1a\x1e.kvstore.AppendEntriesResponse\x12T\n" +
+	"\x0fInstallSnapshot\x12\x1f.kvstore.InstallSnapshotRequest\x1a\x1e.kvstore.AppendEntriesResponse(\x01B\x0fZ\rkvstore/protob\x06proto3"
 
 var (
 	file_proto_keyval_keyval_proto_rawDescOnce sync.Once
@@ -579,34 +3209,114 @@ func file_proto_keyval_keyval_proto_rawDescGZIP() []byte {
 	return file_proto_keyval_keyval_proto_rawDescData
 }
 
-var file_proto_keyval_keyval_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_proto_keyval_keyval_proto_enumTypes = make([]protoimpl.EnumInfo, 6)
+var file_proto_keyval_keyval_proto_msgTypes = make([]protoimpl.MessageInfo, 38)
 var file_proto_keyval_keyval_proto_goTypes = []any{
-	(*KeyValuePair)(nil),            // 0: kvstore.KeyValuePair
-	(*SetRequest)(nil),              // 1: kvstore.SetRequest
-	(*SetResponse)(nil),             // 2: kvstore.SetResponse
-	(*GetRequest)(nil),              // 3: kvstore.GetRequest
-	(*GetResponse)(nil),             // 4: kvstore.GetResponse
-	(*GetPrefixRequest)(nil),        // 5: kvstore.GetPrefixRequest
-	(*GetPrefixStreamResponse)(nil), // 6: kvstore.GetPrefixStreamResponse
-	(*StatRequest)(nil),             // 7: kvstore.StatRequest
-	(*StatResponse)(nil),            // 8: kvstore.StatResponse
+	(SetRequest_Durability)(0),      // 0: kvstore.SetRequest.Durability
+	(SetRequest_Replication)(0),     // 1: kvstore.SetRequest.Replication
+	(GetRequest_Consistency)(0),     // 2: kvstore.GetRequest.Consistency
+	(Compare_Target)(0),             // 3: kvstore.Compare.Target
+	(Compare_Result)(0),             // 4: kvstore.Compare.Result
+	(WatchEvent_EventType)(0),       // 5: kvstore.WatchEvent.EventType
+	(*KeyValuePair)(nil),            // 6: kvstore.KeyValuePair
+	(*SetRequest)(nil),              // 7: kvstore.SetRequest
+	(*SetResponse)(nil),             // 8: kvstore.SetResponse
+	(*GetRequest)(nil),              // 9: kvstore.GetRequest
+	(*GetResponse)(nil),             // 10: kvstore.GetResponse
+	(*CompareAndSetRequest)(nil),    // 11: kvstore.CompareAndSetRequest
+	(*CompareAndSetResponse)(nil),   // 12: kvstore.CompareAndSetResponse
+	(*DeleteRequest)(nil),           // 13: kvstore.DeleteRequest
+	(*DeleteResponse)(nil),          // 14: kvstore.DeleteResponse
+	(*BatchOperation)(nil),          // 15: kvstore.BatchOperation
+	(*BatchRequest)(nil),            // 16: kvstore.BatchRequest
+	(*BatchResponse)(nil),           // 17: kvstore.BatchResponse
+	(*Compare)(nil),                 // 18: kvstore.Compare
+	(*TxnOperation)(nil),            // 19: kvstore.TxnOperation
+	(*TxnOperationResult)(nil),      // 20: kvstore.TxnOperationResult
+	(*TxnRequest)(nil),              // 21: kvstore.TxnRequest
+	(*TxnResponse)(nil),             // 22: kvstore.TxnResponse
+	(*GetPrefixRequest)(nil),        // 23: kvstore.GetPrefixRequest
+	(*GetPrefixStreamResponse)(nil), // 24: kvstore.GetPrefixStreamResponse
+	(*RangeRequest)(nil),            // 25: kvstore.RangeRequest
+	(*RangeResponse)(nil),           // 26: kvstore.RangeResponse
+	(*WatchRequest)(nil),            // 27: kvstore.WatchRequest
+	(*WatchEvent)(nil),              // 28: kvstore.WatchEvent
+	(*WatchResponse)(nil),           // 29: kvstore.WatchResponse
+	(*BackupRequest)(nil),           // 30: kvstore.BackupRequest
+	(*BackupChunk)(nil),             // 31: kvstore.BackupChunk
+	(*RestoreResponse)(nil),         // 32: kvstore.RestoreResponse
+	(*StatRequest)(nil),             // 33: kvstore.StatRequest
+	(*StatResponse)(nil),            // 34: kvstore.StatResponse
+	(*ReplicaAck)(nil),              // 35: kvstore.ReplicaAck
+	(*ReplicationMessage)(nil),      // 36: kvstore.ReplicationMessage
+	(*LeaderHint)(nil),              // 37: kvstore.LeaderHint
+	(*VoteRequest)(nil),             // 38: kvstore.VoteRequest
+	(*VoteResponse)(nil),            // 39: kvstore.VoteResponse
+	(*AppendEntriesRequest)(nil),    // 40: kvstore.AppendEntriesRequest
+	(*AppendEntriesResponse)(nil),   // 41: kvstore.AppendEntriesResponse
+	(*InstallSnapshotRequest)(nil),  // 42: kvstore.InstallSnapshotRequest
+	(*PartitionHint)(nil),           // 43: kvstore.PartitionHint
 }
 var file_proto_keyval_keyval_proto_depIdxs = []int32{
-	0, // 0: kvstore.SetRequest.pair:type_name -> kvstore.KeyValuePair
-	0, // 1: kvstore.GetPrefixStreamResponse.pair:type_name -> kvstore.KeyValuePair
-	1, // 2: kvstore.KeyValueService.Set:input_type -> kvstore.SetRequest
-	3, // 3: kvstore.KeyValueService.Get:input_type -> kvstore.GetRequest
-	5, // 4: kvstore.KeyValueService.GetPrefixStream:input_type -> kvstore.GetPrefixRequest
-	7, // 5: kvstore.KeyValueService.Stat:input_type -> kvstore.StatRequest
-	2, // 6: kvstore.KeyValueService.Set:output_type -> kvstore.SetResponse
-	4, // 7: kvstore.KeyValueService.Get:output_type -> kvstore.GetResponse
-	6, // 8: kvstore.KeyValueService.GetPrefixStream:output_type -> kvstore.GetPrefixStreamResponse
-	8, // 9: kvstore.KeyValueService.Stat:output_type -> kvstore.StatResponse
-	6, // [6:10] is the sub-list for method output_type
-	2, // [2:6] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	6,  // 0: kvstore.SetRequest.pair:type_name -> kvstore.KeyValuePair
+	0,  // 1: kvstore.SetRequest.durability:type_name -> kvstore.SetRequest.Durability
+	1,  // 2: kvstore.SetRequest.replication:type_name -> kvstore.SetRequest.Replication
+	2,  // 3: kvstore.GetRequest.consistency:type_name -> kvstore.GetRequest.Consistency
+	6,  // 4: kvstore.CompareAndSetRequest.pair:type_name -> kvstore.KeyValuePair
+	6,  // 5: kvstore.BatchOperation.put:type_name -> kvstore.KeyValuePair
+	15, // 6: kvstore.BatchRequest.operations:type_name -> kvstore.BatchOperation
+	3,  // 7: kvstore.Compare.target:type_name -> kvstore.Compare.Target
+	4,  // 8: kvstore.Compare.result:type_name -> kvstore.Compare.Result
+	6,  // 9: kvstore.TxnOperation.put:type_name -> kvstore.KeyValuePair
+	18, // 10: kvstore.TxnRequest.compare:type_name -> kvstore.Compare
+	19, // 11: kvstore.TxnRequest.success:type_name -> kvstore.TxnOperation
+	19, // 12: kvstore.TxnRequest.failure:type_name -> kvstore.TxnOperation
+	20, // 13: kvstore.TxnResponse.results:type_name -> kvstore.TxnOperationResult
+	2,  // 14: kvstore.GetPrefixRequest.consistency:type_name -> kvstore.GetRequest.Consistency
+	6,  // 15: kvstore.GetPrefixStreamResponse.pair:type_name -> kvstore.KeyValuePair
+	6,  // 16: kvstore.RangeResponse.pairs:type_name -> kvstore.KeyValuePair
+	5,  // 17: kvstore.WatchEvent.type:type_name -> kvstore.WatchEvent.EventType
+	6,  // 18: kvstore.WatchEvent.pair:type_name -> kvstore.KeyValuePair
+	28, // 19: kvstore.WatchResponse.events:type_name -> kvstore.WatchEvent
+	31, // 20: kvstore.ReplicationMessage.snapshot:type_name -> kvstore.BackupChunk
+	31, // 21: kvstore.InstallSnapshotRequest.chunk:type_name -> kvstore.BackupChunk
+	7,  // 22: kvstore.KeyValueService.Set:input_type -> kvstore.SetRequest
+	9,  // 23: kvstore.KeyValueService.Get:input_type -> kvstore.GetRequest
+	11, // 24: kvstore.KeyValueService.CompareAndSet:input_type -> kvstore.CompareAndSetRequest
+	13, // 25: kvstore.KeyValueService.Delete:input_type -> kvstore.DeleteRequest
+	16, // 26: kvstore.KeyValueService.Batch:input_type -> kvstore.BatchRequest
+	21, // 27: kvstore.KeyValueService.Txn:input_type -> kvstore.TxnRequest
+	23, // 28: kvstore.KeyValueService.GetPrefixStream:input_type -> kvstore.GetPrefixRequest
+	25, // 29: kvstore.KeyValueService.Range:input_type -> kvstore.RangeRequest
+	27, // 30: kvstore.KeyValueService.Watch:input_type -> kvstore.WatchRequest
+	33, // 31: kvstore.KeyValueService.Stat:input_type -> kvstore.StatRequest
+	30, // 32: kvstore.KeyValueService.Backup:input_type -> kvstore.BackupRequest
+	31, // 33: kvstore.KeyValueService.Restore:input_type -> kvstore.BackupChunk
+	35, // 34: kvstore.ReplicationService.Replicate:input_type -> kvstore.ReplicaAck
+	38, // 35: kvstore.RaftService.RequestVote:input_type -> kvstore.VoteRequest
+	40, // 36: kvstore.RaftService.AppendEntries:input_type -> kvstore.AppendEntriesRequest
+	42, // 37: kvstore.RaftService.InstallSnapshot:input_type -> kvstore.InstallSnapshotRequest
+	8,  // 38: kvstore.KeyValueService.Set:output_type -> kvstore.SetResponse
+	10, // 39: kvstore.KeyValueService.Get:output_type -> kvstore.GetResponse
+	12, // 40: kvstore.KeyValueService.CompareAndSet:output_type -> kvstore.CompareAndSetResponse
+	14, // 41: kvstore.KeyValueService.Delete:output_type -> kvstore.DeleteResponse
+	17, // 42: kvstore.KeyValueService.Batch:output_type -> kvstore.BatchResponse
+	22, // 43: kvstore.KeyValueService.Txn:output_type -> kvstore.TxnResponse
+	24, // 44: kvstore.KeyValueService.GetPrefixStream:output_type -> kvstore.GetPrefixStreamResponse
+	26, // 45: kvstore.KeyValueService.Range:output_type -> kvstore.RangeResponse
+	29, // 46: kvstore.KeyValueService.Watch:output_type -> kvstore.WatchResponse
+	34, // 47: kvstore.KeyValueService.Stat:output_type -> kvstore.StatResponse
+	31, // 48: kvstore.KeyValueService.Backup:output_type -> kvstore.BackupChunk
+	32, // 49: kvstore.KeyValueService.Restore:output_type -> kvstore.RestoreResponse
+	36, // 50: kvstore.ReplicationService.Replicate:output_type -> kvstore.ReplicationMessage
+	39, // 51: kvstore.RaftService.RequestVote:output_type -> kvstore.VoteResponse
+	41, // 52: kvstore.RaftService.AppendEntries:output_type -> kvstore.AppendEntriesResponse
+	41, // 53: kvstore.RaftService.InstallSnapshot:output_type -> kvstore.AppendEntriesResponse
+	38, // [38:54] is the sub-list for method output_type
+	22, // [22:38] is the sub-list for method input_type
+	22, // [22:22] is the sub-list for extension type_name
+	22, // [22:22] is the sub-list for extension extendee
+	0,  // [0:22] is the sub-list for field type_name
 }
 
 func init() { file_proto_keyval_keyval_proto_init() }
@@ -614,22 +3324,36 @@ func file_proto_keyval_keyval_proto_init() {
 	if File_proto_keyval_keyval_proto != nil {
 		return
 	}
-	file_proto_keyval_keyval_proto_msgTypes[6].OneofWrappers = []any{
+	file_proto_keyval_keyval_proto_msgTypes[9].OneofWrappers = []any{
+		(*BatchOperation_Put)(nil),
+		(*BatchOperation_DeleteKey)(nil),
+	}
+	file_proto_keyval_keyval_proto_msgTypes[13].OneofWrappers = []any{
+		(*TxnOperation_Put)(nil),
+		(*TxnOperation_DeleteKey)(nil),
+		(*TxnOperation_GetKey)(nil),
+	}
+	file_proto_keyval_keyval_proto_msgTypes[18].OneofWrappers = []any{
 		(*GetPrefixStreamResponse_Pair)(nil),
 		(*GetPrefixStreamResponse_TotalMatches)(nil),
 	}
+	file_proto_keyval_keyval_proto_msgTypes[30].OneofWrappers = []any{
+		(*ReplicationMessage_WalRecords)(nil),
+		(*ReplicationMessage_Snapshot)(nil),
+	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_keyval_keyval_proto_rawDesc), len(file_proto_keyval_keyval_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   9,
+			NumEnums:      6,
+			NumMessages:   38,
 			NumExtensions: 0,
-			NumServices:   1,
+			NumServices:   3,
 		},
 		GoTypes:           file_proto_keyval_keyval_proto_goTypes,
 		DependencyIndexes: file_proto_keyval_keyval_proto_depIdxs,
+		EnumInfos:         file_proto_keyval_keyval_proto_enumTypes,
 		MessageInfos:      file_proto_keyval_keyval_proto_msgTypes,
 	}.Build()
 	File_proto_keyval_keyval_proto = out.File
@@ -12,7 +12,7 @@ SERVER_BIN="./lbserver"
 CLIENT_BIN="./lbclient"
 
 # --- Configuración del Experimento ---
-WORKLOADS=("read-only" "50-50") 
+WORKLOADS=("read-only" "50-50" "write-only") 
 CLIENT_COUNTS=(1 2 4 8 16 32) 
 VALUE_SIZE=4096
 
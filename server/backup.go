@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	pb "asignacionservidor/proto/keyval"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ---- Copias de seguridad en caliente ---- //
+//
+// Una imagen de copia de seguridad es el estado del almacén en una LSN fija, obtenida sin
+// detener el servidor: un snapshot (que puede ser difuso) más los registros del WAL que lo
+// llevan exactamente hasta esa LSN. El formato es:
+//
+//	backupMagic, con el códec de los registros del WAL en el byte backupCodecOffset
+//	cabecera: tipo | LSN del snapshot | LSN final | tamaño del snapshot (uvarint)
+//	el archivo del snapshot, tal cual (ver snapshot.go)
+//	los registros del WAL con LSN posterior a la del snapshot, con el mismo marco que en los segmentos
+//	pie: tipo | nº de registros | LSN final (uvarint)
+//
+// Sin el pie la imagen se considera incompleta.
+
+const (
+	backupMagic       = "KVBACK\x00\x01"
+	backupCodecOffset = 6
+	// backupTempFile: Snapshot de la copia en curso, dentro del directorio de datos.
+	backupTempFile = "backup.tmp"
+	// imageDirPrefix: Prefijo de los directorios, dentro del de datos, en los que se reconstruye
+	// una imagen recibida antes de instalarla.
+	imageDirPrefix = "restore-"
+)
+
+// Tipos de bloque de la imagen. El pie usa un tipo que ningún registro del WAL puede tener.
+const (
+	backupHeader byte = 1
+	backupFooter byte = 0x7f
+)
+
+// errBackupCorrupt: La imagen está incompleta, algún bloque no supera el CRC o le faltan registros.
+var errBackupCorrupt = errors.New("imagen de copia de seguridad incompleta o corrupta")
+
+// errStoreNotEmpty: Restore solo carga una imagen en un almacén sin ninguna escritura.
+var errStoreNotEmpty = errors.New("el almacén no está vacío: la restauración solo se hace sobre un directorio de datos vacío")
+
+// chunkWriter: Envía lo escrito como trozos del stream de Backup.
+type chunkWriter struct {
+	send  func(*pb.BackupChunk) error
+	lsn   uint64 // Se informa en el primer trozo.
+	sent  bool
+	bytes int64
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	// gRPC puede serializar el mensaje después de Send, así que el trozo no comparte el buffer.
+	chunk := &pb.BackupChunk{Data: append([]byte(nil), p...)}
+	if !c.sent { chunk.Lsn = c.lsn }
+	if err := c.send(chunk); err != nil { return 0, err }
+	c.sent = true
+	c.bytes += int64(len(p))
+	return len(p), nil
+}
+
+// chunkReader: Lee como un flujo continuo los trozos recibidos por Restore.
+type chunkReader struct {
+	recv func() (*pb.BackupChunk, error)
+	buf  []byte
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		chunk, err := c.recv()
+		if err != nil { return 0, err }
+		c.buf = chunk.Data
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// waitWritten: Espera a que la rutina del group commit haya escrito en el segmento activo
+// todos los registros hasta lsn y devuelve la última LSN escrita.
+func (s *ShardedStore) waitWritten(lsn uint64) (uint64, error) {
+	for {
+		s.walSyncMutex.Lock()
+		last, err := s.walLastWritten, s.walErr
+		s.walSyncMutex.Unlock()
+		if err != nil { return 0, err }
+		if last >= lsn { return last, nil }
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// writeBackup: Escribe en w una imagen del almacén. Primero copia el motor a un snapshot
+// temporal, a partir de una LSN en la que ninguna escritura está a medias, y fija la LSN final
+// en la última escrita, que cubre también los cambios que la copia difusa pudo incluir.
+// Mientras dura la copia se mantiene snapshotMutex: ningún punto de control borra los
+// segmentos siguientes. Las escrituras de los clientes no se detienen.
+func (s *ShardedStore) writeBackup(w *chunkWriter) (uint64, error) {
+	s.snapshotMutex.Lock()
+	defer s.snapshotMutex.Unlock()
+
+	var lsn uint64
+	if s.raft != nil {
+		lsn = s.raft.applied.Load()
+	} else {
+		unlock := s.rlockAllShards()
+		lsn = s.lastRevision()
+		unlock()
+	}
+	tempPath := filepath.Join(s.dataDir, backupTempFile)
+	defer os.Remove(tempPath)
+	snap, err := s.writeSnapshot(tempPath, time.Now().UnixNano(), lsn)
+	if err != nil { return 0, err }
+	end, err := s.waitWritten(snap.consistentLSN)
+	if err != nil { return 0, err }
+	// Con Raft las entradas posteriores a la última aplicada pueden no estar confirmadas.
+	if s.raft != nil { end = snap.consistentLSN }
+
+	file, err := os.Open(tempPath)
+	if err != nil { return 0, err }
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil { return 0, err }
+
+	w.lsn = end
+	bw := bufio.NewWriterSize(w, snapshotChunkSize)
+	bw.WriteString(magicWithCodec(backupMagic, backupCodecOffset, s.compression))
+	header := []byte{backupHeader}
+	header = binary.AppendUvarint(header, snap.lsn)
+	header = binary.AppendUvarint(header, end)
+	header = binary.AppendUvarint(header, uint64(info.Size()))
+	if _, err := bw.Write(appendFrame(nil, header)); err != nil { return 0, err }
+	if _, err := io.Copy(bw, file); err != nil { return 0, err }
+
+	// Cola del WAL: los registros (snap.lsn, end]. El último segmento puede estar recibiendo
+	// escrituras, pero todo lo anterior a end ya está escrito.
+	segments, err := listSegments(s.walDir)
+	if err != nil { return 0, err }
+	segments = segments[len(coveredSegments(segments, snap.lsn)):]
+	lastLSN, records := snap.lsn, uint64(0)
+	for _, seg := range segments {
+		err := replayWALFile(seg.path, false, func(rec walRecord) error {
+			if rec.revision <= snap.lsn { return nil }
+			if rec.revision > end { return errStopReplay }
+			if rec.revision != lastLSN+1 { return fmt.Errorf("%s: falta la LSN %d", seg.path, lastLSN+1) }
+			lastLSN = rec.revision
+			records++
+			_, err := bw.Write(encodeWALRecord(rec, s.compression))
+			return err
+		})
+		if errors.Is(err, errStopReplay) || (errors.Is(err, errTornRecord) && lastLSN == end) { break }
+		if err != nil { return 0, err }
+	}
+	if lastLSN != end { return 0, fmt.Errorf("el WAL solo contiene registros hasta la LSN %d de %d", lastLSN, end) }
+
+	footer := binary.AppendUvarint([]byte{backupFooter}, records)
+	footer = binary.AppendUvarint(footer, end)
+	if _, err := bw.Write(appendFrame(nil, footer)); err != nil { return 0, err }
+	return end, bw.Flush()
+}
+
+// readBackup: Valida una imagen completa y reconstruye en store, un almacén aparte (ver
+// readImage), el estado que contiene. Devuelve el timestamp de su último cambio.
+func readBackup(r *bufio.Reader, store *ShardedStore) (int64, error) {
+	magic := make([]byte, len(backupMagic))
+	if _, err := io.ReadFull(r, magic); err != nil { return 0, errBackupCorrupt }
+	codec, ok := codecFromMagic(magic, backupMagic, backupCodecOffset)
+	if !ok { return 0, errBackupCorrupt }
+	payload, _, err := readFrame(r)
+	if err != nil || payload[0] != backupHeader { return 0, errBackupCorrupt }
+	p := &payloadReader{buf: payload[1:]}
+	snapLSN, end, snapSize := p.uvarint(), p.uvarint(), p.uvarint()
+	if p.err != nil { return 0, errBackupCorrupt }
+
+	snap, err := store.readSnapshot(bufio.NewReaderSize(io.LimitReader(r, int64(snapSize)), snapshotChunkSize))
+	if err != nil { return 0, fmt.Errorf("%w: %v", errBackupCorrupt, err) }
+	if snap.lsn != snapLSN { return 0, errBackupCorrupt }
+
+	lastLSN, timestamp, records := snap.lsn, snap.timestamp, uint64(0)
+	for {
+		payload, _, err := readFrame(r)
+		if err != nil { return 0, fmt.Errorf("%w: %v", errBackupCorrupt, err) }
+		if payload[0] == backupFooter {
+			p := &payloadReader{buf: payload[1:]}
+			if count, footerEnd := p.uvarint(), p.uvarint(); p.err != nil || count != records || footerEnd != end { return 0, errBackupCorrupt }
+			break
+		}
+		rec, err := decodeWALPayload(payload, codec)
+		if err != nil { return 0, fmt.Errorf("%w: %v", errBackupCorrupt, err) }
+		if rec.revision != lastLSN+1 { return 0, fmt.Errorf("%w: falta la LSN %d", errBackupCorrupt, lastLSN+1) }
+		store.replayRecord(rec)
+		lastLSN, timestamp = rec.revision, rec.timestamp
+		records++
+	}
+	// Los cambios que el snapshot difuso incluyera deben quedar cubiertos por la cola del WAL.
+	if lastLSN != end || snap.consistentLSN > end || store.revision > end { return 0, errBackupCorrupt }
+	return timestamp, nil
+}
+
+// readImage: Valida la imagen que llega por r y la reconstruye, a medida que llega, en un
+// motor nuevo del tipo del almacén dentro de un directorio temporal del de datos, así que el
+// estado no se carga en memoria (salvo con el motor memory, que siempre lo tiene entero). El
+// resultado se entrega a installRestored o se descarta con discard.
+func (s *ShardedStore) readImage(r *bufio.Reader) (*ShardedStore, int64, error) {
+	dir, err := os.MkdirTemp(s.dataDir, imageDirPrefix)
+	if err != nil { return nil, 0, err }
+	image, err := newDetachedStore(s.engineName, engineConfig{dir: dir, compression: s.compression, cacheSize: s.engineCache})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, 0, err
+	}
+	timestamp, err := readBackup(r, image)
+	if err != nil {
+		image.discard()
+		return nil, 0, err
+	}
+	return image, timestamp, nil
+}
+
+// discard: Cierra el motor de un almacén aparte y borra su directorio.
+func (s *ShardedStore) discard() {
+	s.engine.Close()
+	os.RemoveAll(s.dataDir)
+}
+
+// removeStaleImages: Borra las imágenes a medio recibir o a medio instalar que dejó un proceso
+// anterior en el directorio de datos.
+func removeStaleImages(dataDir string) error {
+	stale, err := filepath.Glob(filepath.Join(dataDir, imageDirPrefix+"*"))
+	if err != nil { return err }
+	for _, dir := range stale {
+		if err := os.RemoveAll(dir); err != nil { return err }
+	}
+	return nil
+}
+
+// swapEngine: Cierra el motor, pone en su lugar los archivos del directorio dir (los de un
+// motor del mismo tipo con un punto de control durable) y lo vuelve a abrir sobre ellos. Los
+// archivos sustituidos se apartan a dir, que el llamador borra.
+func (s *ShardedStore) swapEngine(dir string) error {
+	if err := s.engine.Close(); err != nil { return err }
+	entries, err := os.ReadDir(dir)
+	if err != nil { return err }
+	for _, entry := range entries {
+		target := filepath.Join(s.dataDir, entry.Name())
+		if err := os.Rename(target, filepath.Join(dir, entry.Name()+".old")); err != nil && !os.IsNotExist(err) { return err }
+		if err := os.Rename(filepath.Join(dir, entry.Name()), target); err != nil { return err }
+	}
+	if err := syncDir(s.dataDir); err != nil { return err }
+	engine, err := newStorageEngine(s.engineName, engineConfig{dir: s.dataDir, compression: s.compression, cacheSize: s.engineCache, currentLSN: s.stateRevision})
+	if err != nil { return err }
+	if _, err := engine.Recover(); err != nil {
+		engine.Close()
+		return err
+	}
+	s.engine = engine
+	return nil
+}
+
+// installRestored: Sustituye el estado de un almacén vacío por el de image (ver readImage),
+// que queda consumida en cualquier caso. El punto de control de la imagen se hace durable en
+// su directorio, sus archivos pasan a ser los del motor y solo entonces se publica la nueva
+// revisión; todo con los candados de escritura de todos los shards tomados: ninguna escritura
+// de un cliente puede quedar en el WAL por delante de la restauración ni confirmarse antes de
+// que esta sea durable.
+// Con replace el almacén no tiene por qué estar vacío: una réplica atrasada descarta su estado
+// por el de una LSN posterior del primario. Sus segmentos del WAL quedan cubiertos por el nuevo
+// punto de control. Si el cambio de archivos falla a medias no hay vuelta atrás: el proceso
+// termina y el arranque siguiente parte de lo que haya en disco.
+func (s *ShardedStore) installRestored(image *ShardedStore, timestamp int64, replace bool) error {
+	s.snapshotMutex.Lock()
+	defer s.snapshotMutex.Unlock()
+	defer os.RemoveAll(image.dataDir)
+
+	unlock := s.lockAllShards()
+	err := func() error {
+		if replace {
+			if image.revision < s.lastRevision() {
+				image.engine.Close()
+				return fmt.Errorf("la imagen (LSN %d) es anterior al almacén (LSN %d)", image.revision, s.lastRevision())
+			}
+		} else if s.lastRevision() != 0 {
+			image.engine.Close()
+			return errStoreNotEmpty
+		}
+		_, err := image.engine.Checkpoint(image.revision, timestamp)
+		if closeErr := image.engine.Close(); err == nil { err = closeErr }
+		if err != nil { return err }
+		if err := s.swapEngine(image.dataDir); err != nil {
+			log.Fatalf("No se pudieron sustituir los archivos del motor por los de la imagen: %v", err)
+		}
+		s.walSyncMutex.Lock()
+		s.walMutex.Lock()
+		defer s.walSyncMutex.Unlock()
+		defer s.walMutex.Unlock()
+		// Las escrituras siguientes van a un segmento que empieza tras la LSN restaurada.
+		s.revision, s.walLastWritten = image.revision, image.revision
+		s.checkpointLSN = image.revision
+		if err := s.rotateSegmentLocked(); err != nil {
+			log.Printf("ERROR: no se pudo abrir un nuevo segmento del WAL, no se aceptarán más escrituras: %v", err)
+			s.walErr = err
+			return err
+		}
+		s.watchers.reset(image.revision)
+		return nil
+	}()
+	if err == nil { s.recomputeStats() }
+	unlock()
+	if err != nil { return err }
+	if s.raft != nil { s.raft.compacted(image.revision) }
+
+	// Los segmentos anteriores quedan cubiertos por el nuevo punto de control.
+	s.applyRetention(image.revision)
+	return nil
+}
+
+// Backup: Envía en streaming una imagen consistente del almacén sin detener el servidor.
+func (s *Server) Backup(req *pb.BackupRequest, stream pb.KeyValueService_BackupServer) error {
+	w := &chunkWriter{send: stream.Send}
+	lsn, err := s.kvStore.writeBackup(w)
+	if err != nil { return status.Errorf(codes.Internal, "no se pudo generar la copia de seguridad: %v", err) }
+	log.Printf("Copia de seguridad enviada: LSN %d, %d bytes.", lsn, w.bytes)
+	return nil
+}
+
+// Restore: Recibe una imagen generada por Backup y la carga en el servidor, que no debe
+// haber recibido ninguna escritura. La imagen completa se valida antes de aplicar nada.
+func (s *Server) Restore(stream pb.KeyValueService_RestoreServer) error {
+	if err := s.checkWritable(stream.Context()); err != nil { return err }
+	if s.kvStore.raft != nil { return status.Errorf(codes.FailedPrecondition, "Restore no está disponible en un clúster de Raft: restaure un nodo fuera del clúster y arranque los demás vacíos") }
+	if revision := s.kvStore.lastRevision(); revision != 0 { return status.Errorf(codes.FailedPrecondition, "%v (LSN %d)", errStoreNotEmpty, revision) }
+
+	restored, timestamp, err := s.kvStore.readImage(bufio.NewReaderSize(&chunkReader{recv: stream.Recv}, snapshotChunkSize))
+	if errors.Is(err, errBackupCorrupt) { return status.Errorf(codes.InvalidArgument, "%v", err) }
+	if err != nil { return status.Errorf(codes.Internal, "no se pudo preparar la restauración: %v", err) }
+	err = s.kvStore.installRestored(restored, timestamp, false)
+	if errors.Is(err, errStoreNotEmpty) { return status.Errorf(codes.FailedPrecondition, "%v", err) }
+	if err != nil { return status.Errorf(codes.Internal, "no se pudo restaurar la copia de seguridad: %v", err) }
+
+	s.kvStore.stats.mu.Lock()
+	keys := s.kvStore.stats.totalKeys
+	s.kvStore.stats.mu.Unlock()
+	log.Printf("Copia de seguridad restaurada: LSN %d, %d claves.", restored.revision, keys)
+	return stream.SendAndClose(&pb.RestoreResponse{Revision: restored.revision, Keys: keys})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+
+	pb "asignacionservidor/proto/keyval"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// backupImage: Imagen de copia de seguridad del almacén de s.
+func backupImage(t *testing.T, s *Server) []byte {
+	t.Helper()
+	var image bytes.Buffer
+	w := &chunkWriter{send: func(chunk *pb.BackupChunk) error {
+		image.Write(chunk.Data)
+		return nil
+	}}
+	if _, err := s.kvStore.writeBackup(w); err != nil { t.Fatalf("writeBackup: %v", err) }
+	return image.Bytes()
+}
+
+// installImage: Reconstruye la imagen en un motor aparte de s y la instala.
+func installImage(s *Server, image []byte, replace bool) error {
+	restored, timestamp, err := s.kvStore.readImage(bufio.NewReader(bytes.NewReader(image)))
+	if err != nil { return err }
+	return s.kvStore.installRestored(restored, timestamp, replace)
+}
+
+// serveTestClient: Sirve s por gRPC en un puerto local libre y devuelve un cliente conectado.
+func serveTestClient(t *testing.T, s *Server) pb.KeyValueServiceClient {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil { t.Fatal(err) }
+	g := grpc.NewServer(grpc.WaitForHandlers(true))
+	pb.RegisterKeyValueServiceServer(g, s)
+	go g.Serve(lis)
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil { t.Fatal(err) }
+	t.Cleanup(func() {
+		conn.Close()
+		g.Stop()
+	})
+	return pb.NewKeyValueServiceClient(conn)
+}
+
+// TestInstallImage: Una imagen se instala en cualquier motor, sobre un almacén vacío o en
+// lugar de su estado, y sobrevive a un reinicio; el directorio temporal no queda atrás.
+func TestInstallImage(t *testing.T) {
+	src := newTestServer(t, t.TempDir())
+	const keys = 3000
+	for i := 0; i < keys; i += 100 {
+		var ops []walOp
+		for j := i; j < i+100; j++ { ops = append(ops, walOp{op: opSet, key: fmt.Sprintf("k%05d", j), value: bytes.Repeat([]byte{byte(j)}, 100)}) }
+		if _, err := src.kvStore.applyBatch(ops); err != nil { t.Fatal(err) }
+	}
+	version := mustSet(t, src, "ultima", "x")
+	image := backupImage(t, src)
+
+	check := func(t *testing.T, s *Server, lsn uint64) {
+		t.Helper()
+		if got := s.kvStore.lastRevision(); got != lsn { t.Fatalf("LSN %d, se esperaba %d", got, lsn) }
+		if got := mustGet(t, s, "k00034"); !bytes.Equal(got.Value, bytes.Repeat([]byte{34}, 100)) { t.Fatalf("k00034: %q", got.Value) }
+		if got := mustGet(t, s, "borrar"); got.Found { t.Fatal("sigue la clave del estado sustituido") }
+		resp, err := s.Range(context.Background(), &pb.RangeRequest{StartKey: "k", EndKey: "l", Limit: 1})
+		if err != nil || resp.TotalMatches != keys { t.Fatalf("Range: %v %v", resp, err) }
+		if stale, _ := filepath.Glob(filepath.Join(s.kvStore.dataDir, imageDirPrefix+"*")); len(stale) > 0 { t.Fatalf("quedó el directorio temporal %v", stale) }
+	}
+	for _, engine := range engineNames() {
+		t.Run(engine, func(t *testing.T) {
+			dst := newEngineTestServer(t, t.TempDir(), engine)
+			if err := installImage(dst, image, false); err != nil { t.Fatalf("installRestored: %v", err) }
+			check(t, dst, version)
+			if err := installImage(dst, image, false); err == nil { t.Fatal("se restauró sobre un almacén que no está vacío") }
+			check(t, reopenTestServer(t, dst), version)
+		})
+		t.Run(engine+"/sustituir", func(t *testing.T) {
+			dst := newEngineTestServer(t, t.TempDir(), engine)
+			mustSet(t, dst, "borrar", "y")
+			if err := installImage(dst, image, true); err != nil { t.Fatalf("installRestored: %v", err) }
+			check(t, dst, version)
+			if next := mustSet(t, dst, "nueva", "z"); next != version+1 { t.Errorf("la escritura siguiente recibe la versión %d", next) }
+			check(t, reopenTestServer(t, dst), version+1)
+		})
+	}
+}
+
+// TestBackupRestore: La imagen que envía Backup, con el snapshot y la cola del WAL, se carga con
+// Restore en otro servidor con las mismas claves y LSN. Una imagen cortada se rechaza sin tocar
+// el almacén, y un almacén que ya tiene datos no admite Restore.
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	src := newTestServer(t, t.TempDir())
+	for i := 0; i < 50; i++ { mustSet(t, src, fmt.Sprintf("k%02d", i), fmt.Sprint(i)) }
+	src.kvStore.takeSnapshot()
+	if _, err := src.Delete(ctx, &pb.DeleteRequest{Key: "k07"}); err != nil { t.Fatalf("Delete: %v", err) }
+	version := mustSet(t, src, "k42", "cola")
+
+	stream, err := serveTestClient(t, src).Backup(ctx, &pb.BackupRequest{})
+	if err != nil { t.Fatalf("Backup: %v", err) }
+	var chunks []*pb.BackupChunk
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF { break }
+		if err != nil { t.Fatalf("Backup: %v", err) }
+		chunks = append(chunks, chunk)
+	}
+	if len(chunks) == 0 || chunks[0].Lsn != version { t.Fatalf("Backup: %d trozos, LSN %d", len(chunks), chunks[0].GetLsn()) }
+
+	restore := func(client pb.KeyValueServiceClient, chunks []*pb.BackupChunk) (*pb.RestoreResponse, error) {
+		stream, err := client.Restore(ctx)
+		if err != nil { return nil, err }
+		for _, chunk := range chunks {
+			if err := stream.Send(chunk); err != nil { break } // El error llega en CloseAndRecv.
+		}
+		return stream.CloseAndRecv()
+	}
+	dst := newEngineTestServer(t, t.TempDir(), "btree")
+	client := serveTestClient(t, dst)
+	last := chunks[len(chunks)-1]
+	cut := append(append([]*pb.BackupChunk(nil), chunks[:len(chunks)-1]...), &pb.BackupChunk{Data: last.Data[:len(last.Data)-8]})
+	if _, err := restore(client, cut); status.Code(err) != codes.InvalidArgument { t.Fatalf("Restore de una imagen cortada: %v, se esperaba InvalidArgument", err) }
+	if got := dst.kvStore.lastRevision(); got != 0 { t.Fatalf("la imagen cortada dejó el almacén en la LSN %d", got) }
+
+	resp, err := restore(client, chunks)
+	if err != nil || resp.Revision != version || resp.Keys != 49 { t.Fatalf("Restore: %v %v", resp, err) }
+	check := func(s *Server) {
+		t.Helper()
+		if got := mustGet(t, s, "k42"); string(got.Value) != "cola" || got.Version != version { t.Errorf("k42: %q versión %d", got.Value, got.Version) }
+		if got := mustGet(t, s, "k13"); string(got.Value) != "13" { t.Errorf("k13: %q", got.Value) }
+		if got := mustGet(t, s, "k07"); got.Found { t.Error("volvió una clave borrada") }
+	}
+	check(dst)
+	if _, err := restore(client, chunks); status.Code(err) != codes.FailedPrecondition { t.Errorf("Restore sobre un almacén con datos: %v, se esperaba FailedPrecondition", err) }
+	check(reopenTestServer(t, dst))
+}
@@ -0,0 +1,807 @@
+package main
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ---- Motor B+tree ---- //
+//
+// El motor "btree" guarda los datos en un único archivo, data/btree.db, dividido en páginas
+// de btPageSize bytes: un B+tree con las entradas en las hojas, ordenadas por clave. Cada
+// página lleva el mismo marco que los registros del WAL ([longitud][CRC32C][contenido]).
+// Los valores mayores que btMaxInline van a una cadena de páginas de desbordamiento.
+//
+// Las páginas 0 y 1 son de metadatos: raíz, páginas libres y LSN de un punto de control.
+// Se escriben alternadamente y vale la de transacción mayor con el CRC correcto. El árbol
+// del último punto de control nunca se sobrescribe (copy-on-write): un nodo que se modifica
+// pasa a una página nueva y la antigua solo se reutiliza cuando el siguiente punto de control
+// es durable. Así el archivo siempre contiene un árbol consistente, y tras una caída el
+// almacén reaplica el WAL desde su LSN.
+//
+// Los nodos se leen a través de una caché de tamaño acotado (-engine-cache). Cuando se llena,
+// los nodos modificados se escriben antes de descartarlos: sus páginas son nuevas, así que
+// hacerlo antes del punto de control no pone en riesgo el árbol confirmado.
+
+const (
+	btreeFile    = "btree.db"
+	btPageSize   = 4096
+	btMaxPayload = btPageSize - walFrameHeaderSize
+	// btMaxInline y btMaxKeySize: Con estos límites un nodo que desborda una página siempre
+	// se puede partir en dos que caben.
+	btMaxInline  = btPageSize / 8
+	btMaxKeySize = btPageSize / 16
+	// btMinFill: Un nodo más pequeño se fusiona con un hermano si caben juntos en una página.
+	btMinFill   = btMaxPayload / 4
+	btMetaMagic = "KVBTREE\x01"
+	// btMinCachedPages: Mínimo de nodos en caché, sea cual sea -engine-cache.
+	btMinCachedPages = 64
+	// btSnapshotChunk: Entradas que Snapshot copia de cada vez con el candado de lectura tomado.
+	btSnapshotChunk = 1024
+)
+
+// Tipos de página (primer byte del contenido).
+const (
+	btPageMeta byte = iota + 1
+	btPageBranch
+	btPageLeaf
+	btPageOverflow
+	btPageFreelist
+)
+
+// btOverflowChunk: Bytes de valor por página de desbordamiento.
+const btOverflowChunk = btMaxPayload - 1 - binary.MaxVarintLen64
+
+type pgid uint64
+
+// errBtreeCorrupt: Una página no supera el CRC o tiene un contenido inesperado.
+var errBtreeCorrupt = errors.New("página del B+tree corrupta")
+
+// btItem: Elemento de un nodo. En las hojas, una clave y su entrada (sin el valor si está en
+// páginas de desbordamiento); en los nodos internos, la clave menor del hijo y su página.
+type btItem struct {
+	key      string
+	entry    storeEntry
+	overflow pgid // Primera página del valor (0 = valor en la hoja).
+	valueLen int
+	child    pgid
+}
+
+type btNode struct {
+	id    pgid
+	leaf  bool
+	items []btItem
+	dirty bool // Modificado y aún no escrito en su página.
+}
+
+func uvarintLen(x uint64) int {
+	n := 1
+	for ; x >= 0x80; x >>= 7 { n++ }
+	return n
+}
+
+func varintLen(x int64) int {
+	ux := uint64(x) << 1
+	if x < 0 { ux = ^ux }
+	return uvarintLen(ux)
+}
+
+// size: Bytes que ocupa el elemento codificado.
+func (it *btItem) size(leaf bool) int {
+	n := uvarintLen(uint64(len(it.key))) + len(it.key)
+	if !leaf { return n + uvarintLen(uint64(it.child)) }
+	n += 1 + uvarintLen(it.entry.version) + varintLen(it.entry.expiresAt)
+	if it.overflow != 0 { return n + uvarintLen(uint64(it.overflow)) + uvarintLen(uint64(it.valueLen)) }
+	return n + uvarintLen(uint64(len(it.entry.value))) + len(it.entry.value)
+}
+
+// size: Bytes que ocupa el nodo codificado.
+func (n *btNode) size() int {
+	s := 1 + uvarintLen(uint64(len(n.items)))
+	for i := range n.items { s += n.items[i].size(n.leaf) }
+	return s
+}
+
+//	tipo | nº de elementos (uvarint) | por elemento: clave |
+//	    hoja: flags (1 = desbordamiento) | versión (uvarint) | expiración (varint) |
+//	          página y longitud del valor (uvarint) o el valor
+//	    interno: página del hijo (uvarint)
+func (n *btNode) encode() []byte {
+	kind := btPageBranch
+	if n.leaf { kind = btPageLeaf }
+	buf := binary.AppendUvarint(append(make([]byte, 0, n.size()), kind), uint64(len(n.items)))
+	for _, it := range n.items {
+		buf = appendBytes(buf, []byte(it.key))
+		if !n.leaf {
+			buf = binary.AppendUvarint(buf, uint64(it.child))
+			continue
+		}
+		var flags byte
+		if it.overflow != 0 { flags = 1 }
+		buf = append(buf, flags)
+		buf = binary.AppendUvarint(buf, it.entry.version)
+		buf = binary.AppendVarint(buf, it.entry.expiresAt)
+		if it.overflow != 0 {
+			buf = binary.AppendUvarint(buf, uint64(it.overflow))
+			buf = binary.AppendUvarint(buf, uint64(it.valueLen))
+		} else {
+			buf = appendBytes(buf, it.entry.value)
+		}
+	}
+	return buf
+}
+
+func appendBytes(buf, b []byte) []byte {
+	return append(binary.AppendUvarint(buf, uint64(len(b))), b...)
+}
+
+func decodeNode(id pgid, payload []byte) (*btNode, error) {
+	p := &payloadReader{buf: payload}
+	kind := p.byte()
+	if kind != btPageBranch && kind != btPageLeaf { return nil, fmt.Errorf("página %d: %w", id, errBtreeCorrupt) }
+	n := &btNode{id: id, leaf: kind == btPageLeaf}
+	count := p.uvarint()
+	if count > btPageSize { return nil, fmt.Errorf("página %d: %w", id, errBtreeCorrupt) }
+	n.items = make([]btItem, count)
+	for i := range n.items {
+		it := &n.items[i]
+		it.key = string(p.bytes())
+		if !n.leaf {
+			it.child = pgid(p.uvarint())
+			continue
+		}
+		flags := p.byte()
+		it.entry.version = p.uvarint()
+		it.entry.expiresAt = p.varint()
+		if flags&1 != 0 {
+			it.overflow = pgid(p.uvarint())
+			it.valueLen = int(p.uvarint())
+		} else {
+			it.entry.value = p.bytes()
+		}
+	}
+	if p.err != nil { return nil, fmt.Errorf("página %d: %w", id, errBtreeCorrupt) }
+	return n, nil
+}
+
+// search: En una hoja, posición de la primera clave >= key. En un nodo interno, el hijo que
+// puede contener key (la clave del primer hijo no se compara: cubre todo lo anterior).
+func (n *btNode) search(key string) int {
+	if n.leaf { return sort.Search(len(n.items), func(i int) bool { return n.items[i].key >= key }) }
+	return max(sort.Search(len(n.items), func(i int) bool { return n.items[i].key > key })-1, 0)
+}
+
+type btreeEngine struct {
+	path      string
+	file      *os.File
+	maxCached int
+
+	// mu protege el árbol y la asignación de páginas: las escrituras lo toman en exclusiva
+	// y las lecturas en compartido.
+	mu        sync.RWMutex
+	root      pgid // 0 = árbol vacío.
+	pageCount pgid // Páginas usadas del archivo.
+	free      []pgid
+	pending   []pgid        // Páginas del último punto de control liberadas desde entonces.
+	fresh     map[pgid]bool // Páginas asignadas desde el último punto de control.
+	txid      uint64
+	// Páginas donde el último punto de control guardó la lista de páginas libres.
+	freelistPage   pgid
+	freelistPages  uint64
+	checkpointLSN  uint64
+	checkpointTime int64
+
+	// cacheMu protege la caché: los lectores también la modifican.
+	cacheMu sync.Mutex
+	cache   map[pgid]*list.Element
+	lru     *list.List // Del nodo usado más recientemente al menos.
+}
+
+func newBtreeEngine(cfg engineConfig) (StorageEngine, error) {
+	if cfg.dir == "" { return nil, errors.New("el motor btree necesita un directorio de datos") }
+	path := filepath.Join(cfg.dir, btreeFile)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil { return nil, err }
+	return &btreeEngine{
+		path:      path,
+		file:      file,
+		maxCached: max(int(cfg.cacheSize/btPageSize), btMinCachedPages),
+		fresh:     make(map[pgid]bool),
+		cache:     make(map[pgid]*list.Element),
+		lru:       list.New(),
+	}, nil
+}
+
+// btreeFatal: Los errores de E/S al leer o escribir páginas son fatales (ver StorageEngine).
+func btreeFatal(err error) {
+	log.Fatalf("ERROR: fallo de E/S en el motor B+tree, se detiene el servidor (el WAL permite recuperar el estado): %v", err)
+}
+
+// ---- Páginas ---- //
+
+func (e *btreeEngine) writePage(id pgid, payload []byte) error {
+	if len(payload) > btMaxPayload { return fmt.Errorf("página %d: %d bytes no caben en una página", id, len(payload)) }
+	buf := make([]byte, btPageSize)
+	appendFrame(buf[:0], payload)
+	_, err := e.file.WriteAt(buf, int64(id)*btPageSize)
+	return err
+}
+
+func (e *btreeEngine) readPage(id pgid) ([]byte, error) {
+	buf := make([]byte, btPageSize)
+	if _, err := e.file.ReadAt(buf, int64(id)*btPageSize); err != nil {
+		if err == io.EOF { return nil, fmt.Errorf("página %d: %w", id, errBtreeCorrupt) }
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(buf[0:4])
+	if n > btMaxPayload { return nil, fmt.Errorf("página %d: %w", id, errBtreeCorrupt) }
+	payload := buf[walFrameHeaderSize : walFrameHeaderSize+n]
+	if crc32.Checksum(payload, crc32c) != binary.LittleEndian.Uint32(buf[4:8]) { return nil, fmt.Errorf("página %d: %w", id, errBtreeCorrupt) }
+	return payload, nil
+}
+
+// alloc: Reserva una página libre o, si no hay, una nueva al final del archivo.
+// El llamador debe tener tomado mu en exclusiva.
+func (e *btreeEngine) alloc() pgid {
+	var id pgid
+	if n := len(e.free); n > 0 {
+		id, e.free = e.free[n-1], e.free[:n-1]
+	} else {
+		id = e.pageCount
+		e.pageCount++
+	}
+	e.fresh[id] = true
+	return id
+}
+
+// release: Libera una página. Las del último punto de control esperan al siguiente.
+func (e *btreeEngine) release(id pgid) {
+	if e.fresh[id] {
+		delete(e.fresh, id)
+		e.free = append(e.free, id)
+	} else {
+		e.pending = append(e.pending, id)
+	}
+	e.cacheMu.Lock()
+	if el, ok := e.cache[id]; ok {
+		e.lru.Remove(el)
+		delete(e.cache, id)
+	}
+	e.cacheMu.Unlock()
+}
+
+// ---- Caché de nodos ---- //
+
+// node: Devuelve el nodo de la página id, de la caché o del disco.
+func (e *btreeEngine) node(id pgid) *btNode {
+	e.cacheMu.Lock()
+	if el, ok := e.cache[id]; ok {
+		e.lru.MoveToFront(el)
+		e.cacheMu.Unlock()
+		return el.Value.(*btNode)
+	}
+	e.cacheMu.Unlock()
+	payload, err := e.readPage(id)
+	if err != nil { btreeFatal(err) }
+	n, err := decodeNode(id, payload)
+	if err != nil { btreeFatal(err) }
+	e.cacheMu.Lock()
+	defer e.cacheMu.Unlock()
+	// Otro lector pudo cargar la misma página mientras tanto.
+	if el, ok := e.cache[id]; ok { return el.Value.(*btNode) }
+	e.cache[id] = e.lru.PushFront(n)
+	return n
+}
+
+func (e *btreeEngine) cacheNew(n *btNode) {
+	e.cacheMu.Lock()
+	e.cache[n.id] = e.lru.PushFront(n)
+	e.cacheMu.Unlock()
+}
+
+// trim: Descarta los nodos menos usados mientras la caché supere su tamaño, escribiendo
+// antes los modificados. Los escritores solo la llaman al terminar cada operación, así
+// nunca se descarta un nodo que aún van a modificar.
+func (e *btreeEngine) trim() {
+	e.cacheMu.Lock()
+	defer e.cacheMu.Unlock()
+	for e.lru.Len() > e.maxCached {
+		el := e.lru.Back()
+		n := el.Value.(*btNode)
+		if n.dirty {
+			if err := e.writePage(n.id, n.encode()); err != nil { btreeFatal(err) }
+			n.dirty = false
+		}
+		e.lru.Remove(el)
+		delete(e.cache, n.id)
+	}
+}
+
+// ---- Desbordamiento ---- //
+//
+//	tipo | página siguiente (uvarint, 0 = última) | fragmento del valor
+
+// writeOverflow: Escribe el valor en una cadena de páginas nuevas y devuelve la primera.
+func (e *btreeEngine) writeOverflow(value []byte) pgid {
+	ids := make([]pgid, (len(value)+btOverflowChunk-1)/btOverflowChunk)
+	for i := range ids { ids[i] = e.alloc() }
+	for i, id := range ids {
+		var next pgid
+		if i+1 < len(ids) { next = ids[i+1] }
+		chunk := value[i*btOverflowChunk : min((i+1)*btOverflowChunk, len(value))]
+		payload := append(binary.AppendUvarint([]byte{btPageOverflow}, uint64(next)), chunk...)
+		if err := e.writePage(id, payload); err != nil { btreeFatal(err) }
+	}
+	return ids[0]
+}
+
+// overflowPages: Recorre la cadena de un valor; fn recibe cada página y su fragmento.
+func (e *btreeEngine) overflowPages(it btItem, fn func(id pgid, chunk []byte)) {
+	for id := it.overflow; id != 0; {
+		payload, err := e.readPage(id)
+		if err == nil && (len(payload) == 0 || payload[0] != btPageOverflow) { err = fmt.Errorf("página %d: %w", id, errBtreeCorrupt) }
+		if err != nil { btreeFatal(err) }
+		p := &payloadReader{buf: payload[1:]}
+		next := pgid(p.uvarint())
+		fn(id, p.buf)
+		id = next
+	}
+}
+
+// entryOf: Entrada completa del elemento, leyendo el valor si está desbordado.
+func (e *btreeEngine) entryOf(it btItem) storeEntry {
+	if it.overflow == 0 { return it.entry }
+	entry := it.entry
+	entry.value = make([]byte, 0, it.valueLen)
+	e.overflowPages(it, func(_ pgid, chunk []byte) { entry.value = append(entry.value, chunk...) })
+	if len(entry.value) != it.valueLen { btreeFatal(fmt.Errorf("valor de la clave %q: %w", it.key, errBtreeCorrupt)) }
+	return entry
+}
+
+func (e *btreeEngine) freeOverflow(it btItem) {
+	if it.overflow == 0 { return }
+	var ids []pgid
+	e.overflowPages(it, func(id pgid, _ []byte) { ids = append(ids, id) })
+	for _, id := range ids { e.release(id) }
+}
+
+// ---- Operaciones ---- //
+
+// btStep: Nodo de un camino desde la raíz y posición elegida en él.
+type btStep struct {
+	n *btNode
+	i int
+}
+
+func (e *btreeEngine) descend(key string) []btStep {
+	var path []btStep
+	for id := e.root; id != 0; {
+		n := e.node(id)
+		i := n.search(key)
+		path = append(path, btStep{n, i})
+		if n.leaf { break }
+		id = n.items[i].child
+	}
+	return path
+}
+
+// writable: Prepara un nodo para modificarlo. Si está en el último punto de control se
+// traslada a una página nueva; devuelve true si cambió de página.
+func (e *btreeEngine) writable(n *btNode) bool {
+	n.dirty = true
+	if e.fresh[n.id] { return false }
+	old := n.id
+	e.pending = append(e.pending, old)
+	n.id = e.alloc()
+	e.cacheMu.Lock()
+	el, ok := e.cache[old]
+	delete(e.cache, old)
+	if !ok { el = e.lru.PushFront(n) }
+	e.cache[n.id] = el
+	e.cacheMu.Unlock()
+	return true
+}
+
+// writablePath: Prepara todo el camino, de la raíz hacia abajo, actualizando en cada padre
+// la página del hijo que cambió de sitio.
+func (e *btreeEngine) writablePath(path []btStep) {
+	for d, s := range path {
+		if !e.writable(s.n) { continue }
+		if d == 0 {
+			e.root = s.n.id
+		} else {
+			path[d-1].n.items[path[d-1].i].child = s.n.id
+		}
+	}
+}
+
+func (e *btreeEngine) Get(key string) (storeEntry, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	defer e.trim()
+	path := e.descend(key)
+	if len(path) == 0 { return storeEntry{}, false }
+	leaf := path[len(path)-1]
+	if leaf.i == len(leaf.n.items) || leaf.n.items[leaf.i].key != key { return storeEntry{}, false }
+	return e.entryOf(leaf.n.items[leaf.i]), true
+}
+
+func (e *btreeEngine) Put(key string, entry storeEntry) {
+	if len(key) > btMaxKeySize { btreeFatal(fmt.Errorf("clave de %d bytes, el máximo es %d", len(key), btMaxKeySize)) }
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer e.trim()
+	item := btItem{key: key, entry: entry}
+	if len(entry.value) > btMaxInline {
+		item.overflow, item.valueLen = e.writeOverflow(entry.value), len(entry.value)
+		item.entry.value = nil
+	}
+	if e.root == 0 {
+		n := &btNode{id: e.alloc(), leaf: true, dirty: true}
+		e.cacheNew(n)
+		e.root = n.id
+	}
+	path := e.descend(key)
+	e.writablePath(path)
+	leaf := path[len(path)-1]
+	if items := leaf.n.items; leaf.i < len(items) && items[leaf.i].key == key {
+		e.freeOverflow(items[leaf.i])
+		items[leaf.i] = item
+	} else {
+		leaf.n.items = append(items, btItem{})
+		copy(leaf.n.items[leaf.i+1:], leaf.n.items[leaf.i:])
+		leaf.n.items[leaf.i] = item
+	}
+	e.split(path)
+}
+
+// split: Parte en dos, de la hoja hacia arriba, los nodos que ya no caben en una página.
+func (e *btreeEngine) split(path []btStep) {
+	for d := len(path) - 1; d >= 0; d-- {
+		n := path[d].n
+		total := n.size()
+		if total <= btMaxPayload { return }
+		// Punto de corte: las dos mitades quedan por debajo de total/2 + un elemento.
+		at, acc := 0, 0
+		for ; at < len(n.items); at++ {
+			s := n.items[at].size(n.leaf)
+			if acc+s > total/2 { break }
+			acc += s
+		}
+		at = min(max(at, 1), len(n.items)-1)
+		right := &btNode{id: e.alloc(), leaf: n.leaf, dirty: true, items: append([]btItem(nil), n.items[at:]...)}
+		n.items = append([]btItem(nil), n.items[:at]...)
+		e.cacheNew(right)
+		sep := btItem{key: right.items[0].key, child: right.id}
+		if d == 0 {
+			root := &btNode{id: e.alloc(), dirty: true, items: []btItem{{child: n.id}, sep}}
+			e.cacheNew(root)
+			e.root = root.id
+			return
+		}
+		parent := path[d-1]
+		parent.n.items = append(parent.n.items, btItem{})
+		copy(parent.n.items[parent.i+2:], parent.n.items[parent.i+1:])
+		parent.n.items[parent.i+1] = sep
+	}
+}
+
+func (e *btreeEngine) Delete(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer e.trim()
+	path := e.descend(key)
+	if len(path) == 0 { return }
+	leaf := path[len(path)-1]
+	if leaf.i == len(leaf.n.items) || leaf.n.items[leaf.i].key != key { return }
+	e.writablePath(path)
+	e.freeOverflow(leaf.n.items[leaf.i])
+	leaf.n.items = append(leaf.n.items[:leaf.i], leaf.n.items[leaf.i+1:]...)
+	e.rebalance(path)
+}
+
+// rebalance: Tras un borrado, quita los nodos vacíos y fusiona con un hermano los que quedan
+// por debajo de btMinFill, de la hoja hacia arriba. Después acorta la raíz si tiene un solo hijo.
+func (e *btreeEngine) rebalance(path []btStep) {
+	for d := len(path) - 1; d > 0; d-- {
+		n, parent, i := path[d].n, path[d-1].n, path[d-1].i
+		if len(n.items) == 0 {
+			parent.items = append(parent.items[:i], parent.items[i+1:]...)
+			e.release(n.id)
+			continue
+		}
+		if n.size() >= btMinFill || len(parent.items) < 2 { break }
+		li := max(i-1, 0)
+		left, right := e.node(parent.items[li].child), e.node(parent.items[li+1].child)
+		// Margen para la clave que recibe el primer hijo de un nodo interno.
+		if left.size()+right.size()+btMaxKeySize > btMaxPayload { break }
+		if e.writable(left) { parent.items[li].child = left.id }
+		// La clave del primer hijo de un nodo interno es la que tenía en el padre.
+		if !right.leaf { right.items[0].key = parent.items[li+1].key }
+		left.items = append(left.items, right.items...)
+		parent.items = append(parent.items[:li+1], parent.items[li+2:]...)
+		e.release(right.id)
+	}
+	for e.root != 0 {
+		root := e.node(e.root)
+		if len(root.items) == 0 {
+			e.release(root.id)
+			e.root = 0
+		} else if !root.leaf && len(root.items) == 1 {
+			e.release(root.id)
+			e.root = root.items[0].child
+		} else {
+			break
+		}
+	}
+}
+
+// ---- Recorridos ---- //
+
+// btCursor: Posición en una hoja más el camino desde la raíz, para pasar a la hoja vecina.
+type btCursor struct {
+	e       *btreeEngine
+	stack   []btStep
+	reverse bool
+}
+
+// seek: Se coloca en la primera clave >= start o, con reverse, en la última < end
+// (end == "" = la última del árbol). El llamador debe tener tomado mu.
+func (e *btreeEngine) seek(start, end string, reverse bool) *btCursor {
+	c := &btCursor{e: e, reverse: reverse}
+	if e.root == 0 { return c }
+	if !reverse {
+		c.stack = e.descend(start)
+		if top := c.stack[len(c.stack)-1]; top.i == len(top.n.items) { c.advance() }
+		return c
+	}
+	if end == "" {
+		c.down(e.root)
+		return c
+	}
+	c.stack = e.descend(end)
+	// descend deja la hoja en la primera clave >= end; la anterior es la buscada.
+	c.retreat()
+	return c
+}
+
+// down: Baja desde la página id hasta la primera hoja (o la última, si reverse).
+func (c *btCursor) down(id pgid) {
+	for {
+		n := c.e.node(id)
+		i := 0
+		if c.reverse { i = len(n.items) - 1 }
+		c.stack = append(c.stack, btStep{n, i})
+		if n.leaf { return }
+		id = n.items[i].child
+	}
+}
+
+func (c *btCursor) valid() bool { return len(c.stack) > 0 }
+func (c *btCursor) item() btItem {
+	top := c.stack[len(c.stack)-1]
+	return top.n.items[top.i]
+}
+
+func (c *btCursor) next() {
+	if c.reverse {
+		c.retreat()
+	} else {
+		c.advance()
+	}
+}
+
+func (c *btCursor) advance() {
+	for len(c.stack) > 0 {
+		top := &c.stack[len(c.stack)-1]
+		if top.i++; top.i < len(top.n.items) {
+			if !top.n.leaf { c.down(top.n.items[top.i].child) }
+			return
+		}
+		c.stack = c.stack[:len(c.stack)-1]
+		c.e.trim()
+	}
+}
+
+func (c *btCursor) retreat() {
+	for len(c.stack) > 0 {
+		top := &c.stack[len(c.stack)-1]
+		if top.i--; top.i >= 0 {
+			if !top.n.leaf { c.down(top.n.items[top.i].child) }
+			return
+		}
+		c.stack = c.stack[:len(c.stack)-1]
+		c.e.trim()
+	}
+}
+
+// scanLocked: Cuerpo de Scan. El llamador debe tener tomado mu.
+func (e *btreeEngine) scanLocked(start, end string, reverse bool, fn func(key string, entry storeEntry) bool) {
+	for c := e.seek(start, end, reverse); c.valid(); c.next() {
+		it := c.item()
+		if (reverse && it.key < start) || (!reverse && end != "" && it.key >= end) { return }
+		if !fn(it.key, e.entryOf(it)) { return }
+	}
+}
+
+func (e *btreeEngine) Scan(start, end string, reverse bool, fn func(key string, entry storeEntry) bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.scanLocked(start, end, reverse, fn)
+}
+
+// Snapshot: Copia las entradas en tandas de btSnapshotChunk con el candado de lectura tomado
+// y llama a fn sin él, así las escrituras solo esperan a la copia de cada tanda.
+func (e *btreeEngine) Snapshot(fn func(key string, entry storeEntry) error) error {
+	var batch []snapshotItem
+	for start := ""; ; {
+		batch = batch[:0]
+		e.mu.RLock()
+		e.scanLocked(start, "", false, func(key string, entry storeEntry) bool {
+			batch = append(batch, snapshotItem{key: key, entry: entry})
+			return len(batch) < btSnapshotChunk
+		})
+		e.mu.RUnlock()
+		for _, it := range batch {
+			if err := fn(it.key, it.entry); err != nil { return err }
+		}
+		if len(batch) < btSnapshotChunk { return nil }
+		// La clave siguiente a la última copiada.
+		start = batch[len(batch)-1].key + "\x00"
+	}
+}
+
+// ---- Puntos de control ---- //
+//
+// Metadatos: tipo | btMetaMagic | tamaño de página | transacción | raíz | páginas usadas |
+// primera página y nº de páginas de la lista de libres | LSN (uvarint) | timestamp (varint)
+
+// Checkpoint: Escribe los nodos modificados en sus páginas nuevas y la lista de páginas
+// libres, hace fsync y solo entonces publica la nueva raíz en la página de metadatos que no
+// tiene el punto de control anterior, con otro fsync. Las escrituras esperan mientras tanto.
+func (e *btreeEngine) Checkpoint(lsn uint64, timestamp int64) (snapshotInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cacheMu.Lock()
+	for _, el := range e.cache {
+		n := el.Value.(*btNode)
+		if !n.dirty { continue }
+		if err := e.writePage(n.id, n.encode()); err != nil {
+			e.cacheMu.Unlock()
+			return snapshotInfo{}, err
+		}
+		n.dirty = false
+	}
+	e.cacheMu.Unlock()
+
+	// Tras este punto de control también quedan libres las páginas liberadas desde el
+	// anterior y las de su lista de libres. La nueva lista va al final del archivo.
+	free := append(append([]pgid(nil), e.free...), e.pending...)
+	for i := uint64(0); i < e.freelistPages; i++ { free = append(free, e.freelistPage+pgid(i)) }
+	sort.Slice(free, func(i, j int) bool { return free[i] < free[j] })
+	var list []byte
+	prev := pgid(0)
+	for _, id := range free {
+		list = binary.AppendUvarint(list, uint64(id-prev))
+		prev = id
+	}
+	chunk := btMaxPayload - 1
+	freelistPage, freelistPages := e.pageCount, uint64((len(list)+chunk-1)/chunk)
+	for i := uint64(0); i < freelistPages; i++ {
+		part := list[int(i)*chunk : min(int(i+1)*chunk, len(list))]
+		if err := e.writePage(freelistPage+pgid(i), append([]byte{btPageFreelist}, part...)); err != nil { return snapshotInfo{}, err }
+	}
+	pageCount := freelistPage + pgid(freelistPages)
+	if err := e.file.Sync(); err != nil { return snapshotInfo{}, err }
+	meta := btreeMeta{txid: e.txid + 1, root: e.root, pageCount: pageCount, freelistPage: freelistPage, freelistPages: freelistPages, lsn: lsn, timestamp: timestamp}
+	if err := e.writeMeta(meta); err != nil { return snapshotInfo{}, err }
+
+	e.txid, e.pageCount = meta.txid, pageCount
+	e.free, e.pending, e.fresh = free, nil, make(map[pgid]bool)
+	e.freelistPage, e.freelistPages = freelistPage, freelistPages
+	e.checkpointLSN, e.checkpointTime = lsn, timestamp
+	return snapshotInfo{lsn: lsn, timestamp: timestamp, revision: lsn}, nil
+}
+
+type btreeMeta struct {
+	txid          uint64
+	root          pgid
+	pageCount     pgid
+	freelistPage  pgid
+	freelistPages uint64
+	lsn           uint64
+	timestamp     int64
+}
+
+// writeMeta: Escribe los metadatos en la página txid % 2 y hace fsync.
+func (e *btreeEngine) writeMeta(m btreeMeta) error {
+	payload := append([]byte{btPageMeta}, btMetaMagic...)
+	for _, v := range []uint64{btPageSize, m.txid, uint64(m.root), uint64(m.pageCount), uint64(m.freelistPage), m.freelistPages, m.lsn} {
+		payload = binary.AppendUvarint(payload, v)
+	}
+	payload = binary.AppendVarint(payload, m.timestamp)
+	if err := e.writePage(pgid(m.txid%2), payload); err != nil { return err }
+	return e.file.Sync()
+}
+
+func (e *btreeEngine) readMeta(id pgid) (btreeMeta, error) {
+	var m btreeMeta
+	payload, err := e.readPage(id)
+	if err != nil { return m, err }
+	if len(payload) < 1+len(btMetaMagic) || payload[0] != btPageMeta || string(payload[1:1+len(btMetaMagic)]) != btMetaMagic {
+		return m, fmt.Errorf("página %d: %w", id, errBtreeCorrupt)
+	}
+	p := &payloadReader{buf: payload[1+len(btMetaMagic):]}
+	if size := p.uvarint(); size != btPageSize && p.err == nil { return m, fmt.Errorf("tamaño de página %d, se esperaba %d", size, btPageSize) }
+	m.txid, m.root, m.pageCount = p.uvarint(), pgid(p.uvarint()), pgid(p.uvarint())
+	m.freelistPage, m.freelistPages, m.lsn = pgid(p.uvarint()), p.uvarint(), p.uvarint()
+	m.timestamp = p.varint()
+	if p.err != nil { return m, fmt.Errorf("página %d: %w", id, errBtreeCorrupt) }
+	return m, nil
+}
+
+// Recover: Abre el último punto de control válido; un archivo vacío se inicializa.
+func (e *btreeEngine) Recover() (snapshotInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	stat, err := e.file.Stat()
+	if err != nil { return snapshotInfo{}, err }
+	if stat.Size() == 0 {
+		e.pageCount = 2
+		for txid := uint64(0); txid < 2; txid++ {
+			if err := e.writeMeta(btreeMeta{txid: txid, pageCount: 2}); err != nil { return snapshotInfo{}, err }
+		}
+		e.txid = 1
+		return snapshotInfo{}, syncDir(filepath.Dir(e.path))
+	}
+
+	// Una página de metadatos a medio escribir deja vigente el punto de control anterior.
+	var meta btreeMeta
+	valid := false
+	for id := pgid(0); id < 2; id++ {
+		m, err := e.readMeta(id)
+		if err != nil {
+			log.Printf("ADVERTENCIA: %s: metadatos %d no válidos: %v", e.path, id, err)
+			continue
+		}
+		if !valid || m.txid > meta.txid { meta, valid = m, true }
+	}
+	if !valid { return snapshotInfo{}, fmt.Errorf("%s: ninguna página de metadatos es válida", e.path) }
+
+	var list []byte
+	for i := uint64(0); i < meta.freelistPages; i++ {
+		payload, err := e.readPage(meta.freelistPage + pgid(i))
+		if err == nil && (len(payload) == 0 || payload[0] != btPageFreelist) { err = fmt.Errorf("página %d: %w", meta.freelistPage+pgid(i), errBtreeCorrupt) }
+		if err != nil { return snapshotInfo{}, fmt.Errorf("%s: lista de páginas libres: %w", e.path, err) }
+		list = append(list, payload[1:]...)
+	}
+	e.free = nil
+	p := &payloadReader{buf: list}
+	for prev := pgid(0); len(p.buf) > 0 && p.err == nil; {
+		prev += pgid(p.uvarint())
+		e.free = append(e.free, prev)
+	}
+	if p.err != nil { return snapshotInfo{}, fmt.Errorf("%s: lista de páginas libres: %w", e.path, errBtreeCorrupt) }
+
+	e.txid, e.root, e.pageCount = meta.txid, meta.root, meta.pageCount
+	e.freelistPage, e.freelistPages = meta.freelistPage, meta.freelistPages
+	e.checkpointLSN, e.checkpointTime = meta.lsn, meta.timestamp
+	log.Printf("Motor B+tree: punto de control en la LSN %d, %d páginas (%d libres).", meta.lsn, meta.pageCount, len(e.free))
+	return snapshotInfo{lsn: meta.lsn, timestamp: meta.timestamp, revision: meta.lsn}, nil
+}
+
+// Close: Cierra el archivo. Los cambios posteriores al último punto de control están en el WAL.
+func (e *btreeEngine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fileCodecs: Códecs de las cabeceras de los segmentos del WAL, las tablas del LSM y el
+// snapshot bajo dir, por extensión del archivo.
+func fileCodecs(t *testing.T, dir string) map[string]map[compressionCodec]bool {
+	t.Helper()
+	headers := map[string]struct {
+		magic  string
+		offset int
+	}{
+		".wal": {walMagic, walCodecOffset},
+		".sst": {lsmTableMagic, lsmTableCodecOffset},
+		".bin": {snapshotMagic, snapshotCodecOffset},
+	}
+	codecs := make(map[string]map[compressionCodec]bool)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() { return err }
+		h, ok := headers[filepath.Ext(path)]
+		if !ok { return nil }
+		data, err := os.ReadFile(path)
+		if err != nil { return err }
+		codec, ok := codecFromMagic(data[:len(h.magic)], h.magic, h.offset)
+		if !ok { t.Fatalf("%s: cabecera inválida", path) }
+		if codecs[filepath.Ext(path)] == nil { codecs[filepath.Ext(path)] = make(map[compressionCodec]bool) }
+		codecs[filepath.Ext(path)][codec] = true
+		return nil
+	})
+	if err != nil { t.Fatal(err) }
+	return codecs
+}
+
+// TestMixedCompression: Un almacén que se reinicia cambiando -compression sigue leyendo lo que
+// escribió con el códec anterior: cada segmento, tabla y snapshot se lee con el de su cabecera.
+func TestMixedCompression(t *testing.T) {
+	value := func(key string) string { return strings.Repeat(key, 500) }
+	for _, engine := range engineNames() {
+		t.Run(engine, func(t *testing.T) {
+			opts := testStoreOptions(t.TempDir(), engine)
+			s := openTestServer(t, opts)
+			phases := []struct {
+				codec compressionCodec
+				keys  []string
+			}{
+				{compressionNone, []string{"a", "b", "snapshot", "c"}},
+				{compressionZstd, []string{"d", "a", "snapshot", "e"}},
+				{compressionNone, []string{"f"}},
+			}
+			versions := make(map[string]uint64)
+			for i, phase := range phases {
+				if i > 0 {
+					if err := s.kvStore.Close(); err != nil { t.Fatalf("Close: %v", err) }
+					opts.compression = phase.codec
+					s = openTestServer(t, opts)
+				}
+				for _, key := range phase.keys {
+					if key == "snapshot" {
+						s.kvStore.takeSnapshot()
+						continue
+					}
+					versions[key] = mustSet(t, s, key, value(key+phase.codec.String()))
+				}
+			}
+
+			// Tras el snapshot de la fase zstd quedan su segmento y el de la última fase.
+			codecs := fileCodecs(t, opts.dataDir)
+			if !codecs[".wal"][compressionNone] || !codecs[".wal"][compressionZstd] { t.Errorf("códecs de los segmentos: %v", codecs[".wal"]) }
+			if engine == "lsm" && (!codecs[".sst"][compressionNone] || !codecs[".sst"][compressionZstd]) { t.Errorf("códecs de las tablas: %v", codecs[".sst"]) }
+			if engine == "memory" && !codecs[".bin"][compressionZstd] { t.Errorf("códecs del snapshot: %v", codecs[".bin"]) }
+
+			s = reopenTestServer(t, s)
+			want := map[string]string{"a": "azstd", "b": "bnone", "c": "cnone", "d": "dzstd", "e": "ezstd", "f": "fnone"}
+			for key, v := range want {
+				if got := mustGet(t, s, key); string(got.Value) != value(v) || got.Version != versions[key] {
+					t.Errorf("%s: %d bytes, versión %d (se esperaba la %d)", key, len(got.Value), got.Version, versions[key])
+				}
+			}
+		})
+	}
+}
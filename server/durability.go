@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	pb "asignacionservidor/proto/keyval"
+)
+
+// ---- Modos de durabilidad ---- //
+
+// durabilityMode: Cuándo se considera confirmada una escritura del WAL.
+type durabilityMode int
+
+const (
+	durabilityDefault  durabilityMode = iota // Usar la política del servidor.
+	durabilityAlways                         // fsync antes de responder (comportamiento original).
+	durabilityInterval                       // Responder tras escribir; fsync en segundo plano cada N ms.
+	durabilityNone                           // Responder tras escribir; el SO decide cuándo llega al disco.
+)
+
+// durabilityPolicy: Política de durabilidad del servidor para las escrituras que no la indican.
+type durabilityPolicy struct {
+	mode     durabilityMode
+	interval time.Duration // Periodo del fsync en segundo plano del modo 'interval'.
+}
+
+// parseDurabilityMode: Interpreta el valor de la opción -durability del servidor.
+func parseDurabilityMode(name string) (durabilityMode, error) {
+	switch name {
+	case "always":
+		return durabilityAlways, nil
+	case "interval":
+		return durabilityInterval, nil
+	case "none":
+		return durabilityNone, nil
+	}
+	return durabilityDefault, fmt.Errorf("modo de durabilidad desconocido '%s' (always, interval o none)", name)
+}
+
+// durabilityFromProto: Traduce la preferencia de una petición Set.
+func durabilityFromProto(d pb.SetRequest_Durability) durabilityMode {
+	switch d {
+	case pb.SetRequest_FSYNC_ALWAYS:
+		return durabilityAlways
+	case pb.SetRequest_FSYNC_INTERVAL:
+		return durabilityInterval
+	case pb.SetRequest_NO_FSYNC:
+		return durabilityNone
+	}
+	return durabilityDefault
+}
+
+// describe: Texto que se devuelve al cliente con la garantía que obtuvo su escritura.
+func (p durabilityPolicy) describe(mode durabilityMode) string {
+	switch mode {
+	case durabilityInterval:
+		return fmt.Sprintf("escrito en el WAL; fsync diferido como máximo %v", p.interval)
+	case durabilityNone:
+		return "escrito en el WAL sin fsync, en el buffer del sistema operativo"
+	}
+	return "durable: escrito en el WAL con fsync"
+}
+
+// runIntervalSyncer: Rutina del modo 'interval'. Cada periodo hace fsync del WAL si desde el
+// último hay escrituras confirmadas sin fsync en ese modo. Una caída del proceso no pierde
+// nada (los datos ya están en el SO); una caída de la máquina pierde como mucho un periodo.
+func (s *ShardedStore) runIntervalSyncer() {
+	ticker := time.NewTicker(s.durability.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.walSyncMutex.Lock()
+		if !s.walIntervalDirty || s.walErr != nil {
+			s.walSyncMutex.Unlock()
+			continue
+		}
+		if err := s.walFile.Sync(); err != nil {
+			log.Printf("ERROR: fallo en el fsync periódico del WAL, no se aceptarán más escrituras: %v", err)
+			s.walErr = err
+		} else {
+			s.syncedLocked()
+		}
+		s.walIntervalDirty = false
+		s.walSyncMutex.Unlock()
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ---- Motores de almacenamiento ---- //
+//
+// ShardedStore se encarga de todo lo que rodea a los datos: el WAL y el group commit, las
+// revisiones, los candados por clave (los shards de candados, ver lockShards), el TTL, las
+// estadísticas y los watchers. Dónde y cómo se guardan las entradas lo decide un motor de
+// almacenamiento, que se elige al arrancar con -engine.
+//
+// El motor solo ve operaciones ya registradas en el WAL, en el mismo orden en que se
+// registraron para cada clave, así que no necesita su propio registro de cambios: al
+// arrancar carga su último punto de control y el almacén reaplica encima los registros
+// posteriores. Reaplicar un registro que el punto de control ya incluía es inofensivo.
+
+// StorageEngine: Estructura de datos donde el almacén guarda las entradas.
+// Todos los métodos admiten llamadas concurrentes. Las claves vencidas se guardan como
+// cualquier otra: el almacén las filtra al leer y la rutina de limpieza las borra.
+// Los errores de E/S al leer o escribir entradas son fatales: el WAL es la fuente de verdad
+// y el arranque siguiente reconstruye el estado a partir de él.
+type StorageEngine interface {
+	// Get: Devuelve la entrada guardada para la clave.
+	Get(key string) (storeEntry, bool)
+	// Put: Guarda la entrada de la clave, reemplazando la anterior si la había.
+	Put(key string, e storeEntry)
+	// Delete: Borra la clave; no hace nada si no existe.
+	Delete(key string)
+	// Scan: Recorre en orden las entradas de las claves de [start, end) (end == "" significa
+	// sin límite superior), en orden descendente si reverse. Se detiene cuando fn devuelve
+	// false. Las escrituras concurrentes esperan a que termine el recorrido, así que fn no
+	// debe modificar el motor ni hacer trabajo lento.
+	Scan(start, end string, reverse bool, fn func(key string, e storeEntry) bool)
+	// Snapshot: Recorre todas las entradas, sin orden, sin detener las escrituras mientras fn
+	// trabaja (puede ver cambios hechos durante el recorrido). Se usa para copiar el almacén:
+	// snapshots, copias de seguridad, estadísticas.
+	Snapshot(fn func(key string, e storeEntry) error) error
+	// Checkpoint: Hace durable un punto de control que incluye al menos todos los cambios
+	// aplicados hasta la LSN lsn; desde él, Recover y la reaplicación de los registros
+	// posteriores reconstruyen el estado. timestamp es el instante del punto de control.
+	Checkpoint(lsn uint64, timestamp int64) (snapshotInfo, error)
+	// Recover: Carga el último punto de control. Sin ninguno devuelve una información vacía
+	// (LSN 0) y el motor queda vacío. Se llama una vez, antes que cualquier otro método.
+	Recover() (snapshotInfo, error)
+	// Close: Libera los recursos del motor.
+	Close() error
+}
+
+// engineConfig: Configuración común que recibe cada motor al crearse.
+type engineConfig struct {
+	dir         string           // Directorio de datos ("" en los motores de usar y tirar).
+	compression compressionCodec // Compresión de los archivos que escribe el motor.
+	// cacheSize: Memoria que el motor puede usar para datos recientes y cachés, en bytes
+	// (los motores que lo guardan todo en memoria la ignoran).
+	cacheSize int64
+	// currentLSN: Devuelve la última revisión asignada por el almacén.
+	currentLSN func() uint64
+}
+
+// engineMarkerFile: Archivo del directorio de datos con el nombre del motor que lo escribió.
+// Cada motor tiene su propio formato en disco, así que un directorio no puede abrirse con otro.
+const engineMarkerFile = "ENGINE"
+
+// defaultEngineCache: Valor de cacheSize si no se indica otro con -engine-cache.
+const defaultEngineCache = 64 << 20
+
+// storageEngines: Motores disponibles, por el nombre que se usa en -engine.
+var storageEngines = map[string]func(cfg engineConfig) (StorageEngine, error){
+	"memory": func(cfg engineConfig) (StorageEngine, error) { return newMemoryEngine(cfg), nil },
+	"lsm":    newLSMEngine,
+	"btree":  newBtreeEngine,
+}
+
+// engineNames: Nombres de los motores disponibles, ordenados.
+func engineNames() []string {
+	names := make([]string, 0, len(storageEngines))
+	for name := range storageEngines { names = append(names, name) }
+	sort.Strings(names)
+	return names
+}
+
+// newStorageEngine: Crea el motor indicado en la opción -engine del servidor.
+func newStorageEngine(name string, cfg engineConfig) (StorageEngine, error) {
+	newEngine, ok := storageEngines[name]
+	if !ok { return nil, fmt.Errorf("motor de almacenamiento desconocido '%s' (%s)", name, strings.Join(engineNames(), ", ")) }
+	if cfg.currentLSN == nil { cfg.currentLSN = func() uint64 { return 0 } }
+	if cfg.cacheSize <= 0 { cfg.cacheSize = defaultEngineCache }
+	return newEngine(cfg)
+}
+
+// claimDataDir: Comprueba que el directorio de datos es del motor name o está vacío, y en ese
+// caso lo marca como suyo. Los directorios anteriores al marcador son del motor en memoria,
+// el único que había. Para cambiar de motor se hace una copia de seguridad (Backup) y se
+// restaura en un directorio nuevo arrancado con el otro motor.
+func claimDataDir(dir, name string) error {
+	path := filepath.Join(dir, engineMarkerFile)
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) { return err }
+	owner := strings.TrimSpace(string(data))
+	if err != nil {
+		owner = name
+		for _, f := range []string{snapshotFile, legacySnapshotFile, walFile} {
+			if _, err := os.Stat(filepath.Join(dir, f)); err == nil { owner = "memory" }
+		}
+		if segments, err := listSegments(filepath.Join(dir, walDirName)); err == nil && len(segments) > 0 { owner = "memory" }
+	}
+	if owner != name {
+		return fmt.Errorf("el directorio de datos %s es del motor '%s', no de '%s'; para cambiar de motor haga una copia de seguridad y restáurela en un directorio vacío", dir, owner, name)
+	}
+	if len(data) > 0 { return nil }
+	if err := os.WriteFile(path+".tmp", []byte(name+"\n"), 0644); err != nil { return err }
+	if err := os.Rename(path+".tmp", path); err != nil { return err }
+	return syncDir(dir)
+}
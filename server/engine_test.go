@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ---- Pruebas de conformidad de los motores ---- //
+//
+// TestEngineConformance ejecuta sobre cada motor el conjunto de pruebas que todo motor de
+// almacenamiento debe superar: el contrato de StorageEngine visto desde el almacén. Cada
+// prueba usa un directorio temporal propio.
+
+// engineCase: Una prueba de conformidad. open crea una instancia del motor sobre dir y
+// carga su punto de control (Recover). Los motores se crean con una caché de
+// conformanceCacheSize, para que los que usan disco tengan que volcar y compactar.
+type engineCase struct {
+	name string
+	run  func(open engineOpener, dir string) error
+}
+
+type engineOpener func(dir string) (StorageEngine, snapshotInfo, error)
+
+const conformanceCacheSize = 256 * 1024
+
+var engineCases = []engineCase{
+	{"vacío", checkEmpty},
+	{"put, get y delete", checkPointOps},
+	{"recorrido ordenado", checkScan},
+	{"escrituras concurrentes", checkConcurrent},
+	{"snapshot", checkSnapshot},
+	{"punto de control y recuperación", checkRecover},
+	{"datos mayores que la caché", checkBulk},
+	{"borrados masivos y valores grandes", checkChurn},
+}
+
+func TestEngineConformance(t *testing.T) {
+	for _, name := range engineNames() {
+		for _, c := range engineCases {
+			t.Run(name+"/"+c.name, func(t *testing.T) {
+				if err := c.run(engineOpenerFor(name), t.TempDir()); err != nil { t.Fatal(err) }
+			})
+		}
+	}
+}
+
+// engineOpenerFor: engineOpener del motor name, con la compresión activada.
+func engineOpenerFor(name string) engineOpener {
+	return func(dir string) (StorageEngine, snapshotInfo, error) {
+		e, err := newStorageEngine(name, engineConfig{dir: dir, compression: compressionZstd, cacheSize: conformanceCacheSize})
+		if err != nil { return nil, snapshotInfo{}, err }
+		info, err := e.Recover()
+		if err != nil {
+			e.Close()
+			return nil, info, fmt.Errorf("Recover: %v", err)
+		}
+		return e, info, nil
+	}
+}
+
+// collect: Devuelve todas las entradas del motor con un recorrido ascendente completo.
+func collect(e StorageEngine) ([]string, map[string]storeEntry) {
+	var keys []string
+	entries := make(map[string]storeEntry)
+	e.Scan("", "", false, func(key string, entry storeEntry) bool {
+		keys = append(keys, key)
+		entries[key] = entry
+		return true
+	})
+	return keys, entries
+}
+
+// sameEntries: Compara el contenido del motor con el esperado.
+func sameEntries(e StorageEngine, want map[string]storeEntry) error {
+	keys, got := collect(e)
+	if !sort.StringsAreSorted(keys) { return errors.New("el recorrido no sale en orden") }
+	if len(keys) != len(got) { return errors.New("el recorrido repite claves") }
+	if len(got) != len(want) { return fmt.Errorf("%d claves, se esperaban %d", len(got), len(want)) }
+	for k, w := range want {
+		g, ok := got[k]
+		if !ok { return fmt.Errorf("falta la clave %q", k) }
+		if !bytes.Equal(g.value, w.value) || g.version != w.version || g.expiresAt != w.expiresAt {
+			return fmt.Errorf("clave %q: entrada distinta de la esperada", k)
+		}
+		if p, ok := e.Get(k); !ok || p.version != w.version || !bytes.Equal(p.value, w.value) {
+			return fmt.Errorf("Get(%q) no coincide con el recorrido", k)
+		}
+	}
+	return nil
+}
+
+// randomEntries: Genera n entradas con claves de longitud y bytes variados (incluidos 0x00
+// y 0xff, que ponen a prueba el orden y los límites de los prefijos).
+func randomEntries(r *rand.Rand, n int, version uint64) map[string]storeEntry {
+	alphabet := []byte{0x00, 'a', 'b', 'c', '/', 'z', 0xfe, 0xff}
+	entries := make(map[string]storeEntry, n)
+	for len(entries) < n {
+		key := make([]byte, 1+r.IntN(12))
+		for i := range key { key[i] = alphabet[r.IntN(len(alphabet))] }
+		value := make([]byte, r.IntN(64))
+		for i := range value { value[i] = byte(r.Uint32()) }
+		version++
+		entries[string(key)] = storeEntry{value: value, version: version}
+	}
+	return entries
+}
+
+func checkEmpty(open engineOpener, dir string) error {
+	e, _, err := open(dir)
+	if err != nil { return err }
+	defer e.Close()
+	if _, ok := e.Get("x"); ok { return errors.New("Get encuentra una clave en un motor vacío") }
+	e.Delete("x")
+	if err := sameEntries(e, nil); err != nil { return err }
+	return e.Snapshot(func(string, storeEntry) error { return errors.New("Snapshot visita una entrada en un motor vacío") })
+}
+
+func checkPointOps(open engineOpener, dir string) error {
+	e, _, err := open(dir)
+	if err != nil { return err }
+	defer e.Close()
+	expiresAt := time.Now().Add(-time.Second).UnixNano()
+	e.Put("a", storeEntry{value: []byte("1"), version: 1})
+	e.Put("b", storeEntry{value: []byte("2"), version: 2, expiresAt: expiresAt})
+	e.Put("", storeEntry{value: nil, version: 3})
+	e.Put("a", storeEntry{value: []byte("uno"), version: 4})
+	e.Delete("c")
+	want := map[string]storeEntry{
+		"a": {value: []byte("uno"), version: 4},
+		"b": {value: []byte("2"), version: 2, expiresAt: expiresAt}, // Las vencidas también se guardan.
+		"":  {version: 3},
+	}
+	if err := sameEntries(e, want); err != nil { return err }
+	e.Delete("a")
+	e.Delete("a")
+	delete(want, "a")
+	if _, ok := e.Get("a"); ok { return errors.New("Get encuentra una clave borrada") }
+	return sameEntries(e, want)
+}
+
+func checkScan(open engineOpener, dir string) error {
+	e, _, err := open(dir)
+	if err != nil { return err }
+	defer e.Close()
+	r := rand.New(rand.NewPCG(1, 2))
+	want := randomEntries(r, 2000, 0)
+	for k, v := range want { e.Put(k, v) }
+	sorted := make([]string, 0, len(want))
+	for k := range want { sorted = append(sorted, k) }
+	sort.Strings(sorted)
+
+	scan := func(start, end string, reverse bool, limit int) []string {
+		var keys []string
+		e.Scan(start, end, reverse, func(key string, entry storeEntry) bool {
+			keys = append(keys, key)
+			return limit == 0 || len(keys) < limit
+		})
+		return keys
+	}
+	expect := func(start, end string, reverse bool, limit int) []string {
+		var keys []string
+		for _, k := range sorted {
+			if k >= start && (end == "" || k < end) { keys = append(keys, k) }
+		}
+		if reverse {
+			for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 { keys[i], keys[j] = keys[j], keys[i] }
+		}
+		if limit > 0 && len(keys) > limit { keys = keys[:limit] }
+		return keys
+	}
+	bounds := []string{"", "a", "b/", "\x00", "z\xff", "\xff", "\xff\xff\xff"}
+	for i := 0; i < 50; i++ { bounds = append(bounds, sorted[r.IntN(len(sorted))]) }
+	for _, start := range bounds {
+		for _, end := range []string{"", prefixEnd(start), bounds[r.IntN(len(bounds))]} {
+			for _, reverse := range []bool{false, true} {
+				for _, limit := range []int{0, 1, 7} {
+					got, want := scan(start, end, reverse, limit), expect(start, end, reverse, limit)
+					if fmt.Sprint(got) != fmt.Sprint(want) {
+						return fmt.Errorf("Scan(%q, %q, reverse=%v, limit=%d): %d claves, se esperaban %d", start, end, reverse, limit, len(got), len(want))
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func checkConcurrent(open engineOpener, dir string) error {
+	e, _, err := open(dir)
+	if err != nil { return err }
+	defer e.Close()
+	const writers, perWriter = 8, 500
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	want := make(map[string]storeEntry)
+	stop := make(chan struct{})
+	scanErr := make(chan error, 1)
+	go func() {
+		// Los recorridos concurrentes con las escrituras deben salir siempre en orden.
+		for {
+			select {
+			case <-stop:
+				scanErr <- nil
+				return
+			default:
+			}
+			if keys, _ := collect(e); !sort.StringsAreSorted(keys) {
+				scanErr <- errors.New("un recorrido concurrente no sale en orden")
+				return
+			}
+		}
+	}()
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				key := fmt.Sprintf("w%d/%04d", w, i)
+				entry := storeEntry{value: []byte(key), version: uint64(w*perWriter + i + 1)}
+				e.Put(key, entry)
+				if i%5 == 0 {
+					e.Delete(key)
+					continue
+				}
+				mu.Lock()
+				want[key] = entry
+				mu.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(stop)
+	if err := <-scanErr; err != nil { return err }
+	return sameEntries(e, want)
+}
+
+func checkSnapshot(open engineOpener, dir string) error {
+	e, _, err := open(dir)
+	if err != nil { return err }
+	defer e.Close()
+	want := randomEntries(rand.New(rand.NewPCG(3, 4)), 1000, 0)
+	for k, v := range want { e.Put(k, v) }
+	seen := make(map[string]bool)
+	err = e.Snapshot(func(key string, entry storeEntry) error {
+		if seen[key] { return fmt.Errorf("Snapshot visita dos veces la clave %q", key) }
+		seen[key] = true
+		if w, ok := want[key]; !ok || w.version != entry.version || !bytes.Equal(w.value, entry.value) {
+			return fmt.Errorf("Snapshot devuelve una entrada inesperada para %q", key)
+		}
+		return nil
+	})
+	if err != nil { return err }
+	if len(seen) != len(want) { return fmt.Errorf("Snapshot visita %d claves de %d", len(seen), len(want)) }
+	stopErr := errors.New("parada")
+	calls := 0
+	err = e.Snapshot(func(string, storeEntry) error {
+		calls++
+		return stopErr
+	})
+	if !errors.Is(err, stopErr) || calls != 1 { return errors.New("Snapshot no se detiene con el primer error de fn") }
+	return nil
+}
+
+func checkRecover(open engineOpener, dir string) error {
+	e, info, err := open(dir)
+	if err != nil { return err }
+	if info.lsn != 0 { return fmt.Errorf("Recover en un directorio vacío devuelve la LSN %d", info.lsn) }
+	want := randomEntries(rand.New(rand.NewPCG(5, 6)), 3000, 0)
+	// Un valor mayor que un bloque del snapshot.
+	want["grande"] = storeEntry{value: bytes.Repeat([]byte("0123456789abcdef"), 128*1024), version: 9000}
+	for k, v := range want { e.Put(k, v) }
+	for k := range want {
+		if len(want) <= 2000 { break }
+		if k == "grande" { continue }
+		e.Delete(k)
+		delete(want, k)
+	}
+	ts := time.Now().UnixNano()
+	info, err = e.Checkpoint(9000, ts)
+	if err != nil { return fmt.Errorf("Checkpoint: %v", err) }
+	if info.lsn != 9000 { return fmt.Errorf("Checkpoint devuelve la LSN %d", info.lsn) }
+	if err := e.Close(); err != nil { return err }
+
+	for lsn := uint64(9000); lsn <= 9001; lsn++ {
+		e, info, err = open(dir)
+		if err != nil { return err }
+		if info.lsn != lsn || info.timestamp != ts { return fmt.Errorf("Recover devuelve la LSN %d y el instante %d", info.lsn, info.timestamp) }
+		if err := sameEntries(e, want); err != nil { return fmt.Errorf("tras Recover: %v", err) }
+		// Un segundo punto de control sobre el estado recuperado.
+		e.Put("nueva", storeEntry{value: []byte("y"), version: lsn + 1})
+		want["nueva"] = storeEntry{value: []byte("y"), version: lsn + 1}
+		ts++
+		if _, err := e.Checkpoint(lsn+1, ts); err != nil { return fmt.Errorf("Checkpoint: %v", err) }
+		if err := e.Close(); err != nil { return err }
+	}
+	return nil
+}
+
+// checkBulk: Muchas más entradas de las que caben en la caché, con sobrescrituras y borrados
+// mezclados y puntos de control intermedios.
+func checkBulk(open engineOpener, dir string) error {
+	e, _, err := open(dir)
+	if err != nil { return err }
+	r := rand.New(rand.NewPCG(7, 8))
+	want := make(map[string]storeEntry)
+	const ops = 60000
+	for version := uint64(1); version <= ops; version++ {
+		key := fmt.Sprintf("k%06d", r.IntN(ops/2))
+		if r.IntN(8) == 0 {
+			e.Delete(key)
+			delete(want, key)
+		} else {
+			entry := storeEntry{value: bytes.Repeat([]byte{byte(version)}, 50+r.IntN(150)), version: version}
+			e.Put(key, entry)
+			want[key] = entry
+		}
+		if version%20000 == 0 {
+			if _, err := e.Checkpoint(version, int64(version)); err != nil { return fmt.Errorf("Checkpoint: %v", err) }
+		}
+	}
+	if err := sameEntries(e, want); err != nil { return err }
+	if err := e.Close(); err != nil { return err }
+	e, info, err := open(dir)
+	if err != nil { return err }
+	defer e.Close()
+	if info.lsn != ops { return fmt.Errorf("Recover devuelve la LSN %d", info.lsn) }
+	if err := sameEntries(e, want); err != nil { return fmt.Errorf("tras Recover: %v", err) }
+	return nil
+}
+
+// checkChurn: Valores de tamaños muy distintos que se sobrescriben y se borran casi todos;
+// al final el motor debe quedar vacío también tras recuperar.
+func checkChurn(open engineOpener, dir string) error {
+	e, _, err := open(dir)
+	if err != nil { return err }
+	r := rand.New(rand.NewPCG(9, 10))
+	want := make(map[string]storeEntry)
+	version := uint64(0)
+	put := func(key string) {
+		version++
+		entry := storeEntry{value: bytes.Repeat([]byte{byte(version)}, r.IntN(6000)), version: version}
+		e.Put(key, entry)
+		want[key] = entry
+	}
+	for i := 0; i < 5000; i++ { put(fmt.Sprintf("c%05d", i)) }
+	for i := 0; i < 5000; i += 3 { put(fmt.Sprintf("c%05d", i)) }
+	for k := range want {
+		if r.IntN(10) == 0 { continue }
+		e.Delete(k)
+		delete(want, k)
+	}
+	if err := sameEntries(e, want); err != nil { return err }
+	if _, err := e.Checkpoint(version, 1); err != nil { return fmt.Errorf("Checkpoint: %v", err) }
+	if err := e.Close(); err != nil { return err }
+
+	e, _, err = open(dir)
+	if err != nil { return err }
+	if err := sameEntries(e, want); err != nil { return fmt.Errorf("tras Recover: %v", err) }
+	for k := range want { e.Delete(k) }
+	if err := sameEntries(e, nil); err != nil { return err }
+	if _, err := e.Checkpoint(version+1, 2); err != nil { return fmt.Errorf("Checkpoint: %v", err) }
+	if err := e.Close(); err != nil { return err }
+	e, _, err = open(dir)
+	if err != nil { return err }
+	defer e.Close()
+	return sameEntries(e, nil)
+}
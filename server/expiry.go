@@ -0,0 +1,62 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// ---- Cola de expiración ---- //
+//
+// La rutina de limpieza no recorre el motor entero (con un motor en disco eso sería leer todo
+// el almacén cada segundo): consulta una cola de claves con TTL ordenada por instante de
+// expiración. Cada SET con TTL añade un elemento; si la clave se sobrescribe o se borra antes,
+// su elemento sigue en la cola y, al vencer, se descarta al comprobar la entrada en el motor.
+// La cola no se persiste: al arrancar se reconstruye con el recorrido de recomputeStats.
+
+type expiryItem struct {
+	expiresAt int64
+	key       string
+}
+
+type expiryHeap []expiryItem
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expiresAt < h[j].expiresAt }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x any)        { *h = append(*h, x.(expiryItem)) }
+func (h *expiryHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// expiryQueue: Cola de expiración, segura para uso concurrente.
+type expiryQueue struct {
+	mu    sync.Mutex
+	items expiryHeap
+}
+
+// add: Apunta la clave si su entrada tiene TTL.
+func (q *expiryQueue) add(key string, e storeEntry) {
+	if e.expiresAt == 0 { return }
+	q.mu.Lock()
+	heap.Push(&q.items, expiryItem{expiresAt: e.expiresAt, key: key})
+	q.mu.Unlock()
+}
+
+// due: Saca de la cola y devuelve las claves cuyo instante de expiración es <= now.
+func (q *expiryQueue) due(now int64) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var keys []string
+	for len(q.items) > 0 && q.items[0].expiresAt <= now { keys = append(keys, heap.Pop(&q.items).(expiryItem).key) }
+	return keys
+}
+
+// reset: Vacía la cola; la reconstruye quien recorre después todo el motor.
+func (q *expiryQueue) reset() {
+	q.mu.Lock()
+	q.items = nil
+	q.mu.Unlock()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"container/heap"
+	"math/rand/v2"
+)
+
+// ---- Índice ordenado de claves ---- //
+
+// skipListMaxLevel: Niveles máximos de la skip list. Con p = 1/4 alcanza para miles de
+// millones de claves por shard.
+const skipListMaxLevel = 24
+
+// orderedKeys: Índice ordenado de las claves de un shard, implementado como skip list.
+// Convive con el mapa del shard: el mapa resuelve Get en O(1) y el índice permite recorrer
+// las claves en orden a partir de cualquier posición en O(log n).
+// Lo protege el mismo candado que el shard.
+type orderedKeys struct {
+	head   skipNode
+	level  int
+	length int
+}
+
+type skipNode struct {
+	key  string
+	next []*skipNode
+	prev *skipNode // Nodo anterior en el nivel 0 (nil en el primero), para recorridos inversos.
+}
+
+func newOrderedKeys() *orderedKeys {
+	return &orderedKeys{head: skipNode{next: make([]*skipNode, skipListMaxLevel)}, level: 1}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.IntN(4) == 0 { level++ }
+	return level
+}
+
+// findPredecessors: Rellena update con el último nodo de cada nivel cuya clave es menor que key.
+func (l *orderedKeys) findPredecessors(key string, update *[skipListMaxLevel]*skipNode) *skipNode {
+	x := &l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key < key { x = x.next[i] }
+		update[i] = x
+	}
+	return x.next[0]
+}
+
+func (l *orderedKeys) insert(key string) {
+	var update [skipListMaxLevel]*skipNode
+	if n := l.findPredecessors(key, &update); n != nil && n.key == key { return }
+	level := randomLevel()
+	for i := l.level; i < level; i++ { update[i] = &l.head }
+	if level > l.level { l.level = level }
+	n := &skipNode{key: key, next: make([]*skipNode, level)}
+	for i := 0; i < level; i++ {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+	if update[0] != &l.head { n.prev = update[0] }
+	if n.next[0] != nil { n.next[0].prev = n }
+	l.length++
+}
+
+func (l *orderedKeys) remove(key string) {
+	var update [skipListMaxLevel]*skipNode
+	n := l.findPredecessors(key, &update)
+	if n == nil || n.key != key { return }
+	for i := 0; i < len(n.next); i++ { update[i].next[i] = n.next[i] }
+	if n.next[0] != nil { n.next[0].prev = n.prev }
+	for l.level > 1 && l.head.next[l.level-1] == nil { l.level-- }
+	l.length--
+}
+
+// seek: Devuelve el primer nodo cuya clave es mayor o igual que key.
+func (l *orderedKeys) seek(key string) *skipNode {
+	var update [skipListMaxLevel]*skipNode
+	return l.findPredecessors(key, &update)
+}
+
+// seekBefore: Devuelve el último nodo cuya clave es menor que key ("" = el último de todos).
+func (l *orderedKeys) seekBefore(key string) *skipNode {
+	x := &l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && (key == "" || x.next[i].key < key) { x = x.next[i] }
+	}
+	if x == &l.head { return nil }
+	return x
+}
+
+// ---- Recorrido ordenado entre shards ---- //
+
+// shardCursor: Posición actual del recorrido dentro de un shard.
+type shardCursor struct {
+	shard *KeyValueStoreShard
+	node  *skipNode
+}
+
+// cursorHeap: Montículo de cursores ordenado por clave, para mezclar los 32 shards (k-way merge).
+// En un recorrido inverso la clave mayor queda arriba.
+type cursorHeap struct {
+	cursors []*shardCursor
+	reverse bool
+}
+
+func (h *cursorHeap) Len() int { return len(h.cursors) }
+func (h *cursorHeap) Less(i, j int) bool {
+	if h.reverse { return h.cursors[i].node.key > h.cursors[j].node.key }
+	return h.cursors[i].node.key < h.cursors[j].node.key
+}
+func (h *cursorHeap) Swap(i, j int) { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+func (h *cursorHeap) Push(x any)   { h.cursors = append(h.cursors, x.(*shardCursor)) }
+func (h *cursorHeap) Pop() any {
+	c := h.cursors[len(h.cursors)-1]
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return c
+}
+
+// scanShards: Recorre en orden las claves del rango [start, end) de todos los shards
+// (end == "" significa sin límite superior), mezclando los índices de cada shard.
+// Con reverse el recorrido es descendente. Se detiene cuando fn devuelve false.
+// Coste: O(log n) para posicionarse más O(log numShards) por clave visitada.
+// El llamador debe tener tomados los candados de lectura de todos los shards.
+func scanShards(shards []*KeyValueStoreShard, start, end string, reverse bool, fn func(key string, e storeEntry) bool) {
+	h := &cursorHeap{cursors: make([]*shardCursor, 0, len(shards)), reverse: reverse}
+	for _, shard := range shards {
+		var n *skipNode
+		if reverse {
+			n = shard.index.seekBefore(end)
+		} else {
+			n = shard.index.seek(start)
+		}
+		if n != nil { h.cursors = append(h.cursors, &shardCursor{shard: shard, node: n}) }
+	}
+	heap.Init(h)
+	for h.Len() > 0 {
+		c := h.cursors[0]
+		key := c.node.key
+		if (reverse && key < start) || (!reverse && end != "" && key >= end) { return }
+		if !fn(key, c.shard.store[key]) { return }
+		if reverse {
+			c.node = c.node.prev
+		} else {
+			c.node = c.node.next[0]
+		}
+		if c.node != nil {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+}
+
+// prefixEnd: Devuelve la menor clave mayor que todas las que empiezan por prefix, es decir,
+// el límite superior exclusivo del rango del prefijo ("" si no existe tal límite).
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
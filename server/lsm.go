@@ -0,0 +1,769 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ---- Motor LSM ---- //
+//
+// El motor "lsm" guarda los datos en disco, en data/lsm/, para almacenes mayores que la
+// memoria. Las escrituras van a una memtable ordenada; su durabilidad la da el WAL del
+// almacén, así que el motor no tiene registro propio. Cuando la memtable llena su cupo se
+// congela y una rutina en segundo plano la vuelca como SSTable al nivel 0 (ver sstable.go).
+//
+// Las tablas del nivel 0 pueden solaparse entre sí; las de los niveles 1 y siguientes no.
+// La compactación por niveles mezcla las tablas de un nivel que supera su tamaño con las que
+// se solapan en el siguiente, descartando las versiones antiguas de cada clave y, en el
+// último nivel ocupado, las lápidas. Cada nivel admite lsmLevelMultiplier veces el anterior.
+//
+// El MANIFEST lista las tablas de cada nivel y la LSN del último punto de control. Se
+// reescribe entero (archivo temporal y rename) tras cada volcado o compactación, y solo
+// entonces se borran las tablas que dejaron de usarse. Las lecturas combinan las memtables
+// y las tablas de todos los niveles con un iterador de mezcla, de la más nueva a la más antigua.
+
+const (
+	lsmDirName      = "lsm"
+	lsmManifestFile = "MANIFEST"
+	lsmManifestMagic = "KVLSM\x00\x01\n"
+	lsmMaxLevels    = 7
+	// lsmL0CompactionTrigger: Tablas del nivel 0 a partir de las que se compactan con el nivel 1.
+	lsmL0CompactionTrigger = 4
+	// lsmL0StopWrites y lsmMaxImmutable: Límites a partir de los que las escrituras esperan
+	// a la rutina de fondo, para que la memoria y las lecturas no crezcan sin control.
+	lsmL0StopWrites    = 12
+	lsmMaxImmutable    = 2
+	lsmLevelMultiplier = 10
+	// lsmMinMemtableSize: Cupo mínimo de la memtable, sea cual sea -engine-cache.
+	lsmMinMemtableSize = 64 * 1024
+)
+
+// ---- Memtable ---- //
+
+// memtable: Escrituras recientes, con las claves en una skip list (ver index.go).
+// La activa la protege lsmEngine.mu; una vez congelada no se modifica.
+type memtable struct {
+	entries map[string]lsmEntry
+	index   *orderedKeys
+	size    int64 // Bytes aproximados de claves y valores.
+}
+
+func newMemtable() *memtable {
+	return &memtable{entries: make(map[string]lsmEntry), index: newOrderedKeys()}
+}
+
+func (m *memtable) put(key string, e lsmEntry) {
+	if old, exists := m.entries[key]; exists {
+		m.size -= int64(len(old.entry.value))
+	} else {
+		m.index.insert(key)
+		m.size += int64(len(key)) + 64 // Coste aproximado del nodo y de la entrada del mapa.
+	}
+	m.entries[key] = e
+	m.size += int64(len(e.entry.value))
+}
+
+// sorted: Copia en orden las entradas de la memtable.
+func (m *memtable) sorted() []blockEntry {
+	entries := make([]blockEntry, 0, len(m.entries))
+	for n := m.index.seek(""); n != nil; n = n.next[0] { entries = append(entries, blockEntry{key: n.key, e: m.entries[n.key]}) }
+	return entries
+}
+
+// ---- Iteradores ---- //
+
+// lsmIterator: Recorre entradas en orden de clave (o en orden inverso), lápidas incluidas.
+type lsmIterator interface {
+	valid() bool
+	current() blockEntry
+	next()
+	iterErr() error
+}
+
+// memIterator: Recorre una memtable. La activa solo se puede recorrer con lsmEngine.mu tomado.
+type memIterator struct {
+	m       *memtable
+	node    *skipNode
+	reverse bool
+}
+
+func newMemIterator(m *memtable, start, end string, reverse bool) *memIterator {
+	it := &memIterator{m: m, reverse: reverse}
+	if reverse {
+		it.node = m.index.seekBefore(end)
+	} else {
+		it.node = m.index.seek(start)
+	}
+	return it
+}
+
+func (it *memIterator) valid() bool         { return it.node != nil }
+func (it *memIterator) current() blockEntry { return blockEntry{key: it.node.key, e: it.m.entries[it.node.key]} }
+func (it *memIterator) iterErr() error      { return nil }
+func (it *memIterator) next() {
+	if it.reverse {
+		it.node = it.node.prev
+	} else {
+		it.node = it.node.next[0]
+	}
+}
+
+// sliceIterator: Recorre hacia delante una copia ordenada de entradas.
+type sliceIterator struct {
+	entries []blockEntry
+	pos     int
+}
+
+func (it *sliceIterator) valid() bool         { return it.pos < len(it.entries) }
+func (it *sliceIterator) current() blockEntry { return it.entries[it.pos] }
+func (it *sliceIterator) next()               { it.pos++ }
+func (it *sliceIterator) iterErr() error      { return nil }
+
+// levelIterator: Recorre las tablas de un nivel sin solapamientos como una sola secuencia,
+// abriendo cada tabla solo cuando el recorrido llega a ella.
+type levelIterator struct {
+	tables  []*sstable
+	idx     int
+	it      *tableIterator
+	reverse bool
+}
+
+func newLevelIterator(tables []*sstable, start, end string, reverse bool) *levelIterator {
+	l := &levelIterator{tables: tables, reverse: reverse}
+	if reverse {
+		l.idx = len(tables) - 1
+		if end != "" { l.idx = sort.Search(len(tables), func(i int) bool { return tables[i].smallest >= end }) - 1 }
+		if l.idx >= 0 { l.it = tables[l.idx].iterator("", end, true) }
+	} else {
+		l.idx = sort.Search(len(tables), func(i int) bool { return tables[i].largest >= start })
+		if l.idx < len(tables) { l.it = tables[l.idx].iterator(start, "", false) }
+	}
+	l.settle()
+	return l
+}
+
+// settle: Pasa a la tabla siguiente mientras la actual esté agotada.
+func (l *levelIterator) settle() {
+	for l.it != nil && !l.it.valid() && l.it.err == nil {
+		l.it = nil
+		if l.reverse {
+			if l.idx--; l.idx >= 0 { l.it = l.tables[l.idx].iterator("", "", true) }
+		} else {
+			if l.idx++; l.idx < len(l.tables) { l.it = l.tables[l.idx].iterator("", "", false) }
+		}
+	}
+}
+
+func (l *levelIterator) valid() bool         { return l.it != nil && l.it.valid() }
+func (l *levelIterator) current() blockEntry { return l.it.current() }
+func (l *levelIterator) next() {
+	l.it.next()
+	l.settle()
+}
+func (l *levelIterator) iterErr() error {
+	if l.it == nil { return nil }
+	return l.it.err
+}
+
+// mergeIterator: Mezcla varias fuentes ordenadas (k-way merge). Las fuentes van de la más
+// nueva a la más antigua: de cada clave solo se entrega la entrada de la fuente más nueva
+// que la tiene, y las versiones antiguas se saltan.
+type mergeIterator struct {
+	sources []lsmIterator
+	heap    []int // Índices de las fuentes válidas.
+	reverse bool
+	err     error
+}
+
+func newMergeIterator(sources []lsmIterator, reverse bool) *mergeIterator {
+	m := &mergeIterator{sources: sources, reverse: reverse}
+	for i, s := range sources {
+		if s.valid() {
+			m.heap = append(m.heap, i)
+		} else if err := s.iterErr(); err != nil && m.err == nil {
+			m.err = err
+		}
+	}
+	heap.Init(m)
+	return m
+}
+
+func (m *mergeIterator) Len() int { return len(m.heap) }
+func (m *mergeIterator) Less(i, j int) bool {
+	a, b := m.sources[m.heap[i]].current().key, m.sources[m.heap[j]].current().key
+	if a != b {
+		if m.reverse { return a > b }
+		return a < b
+	}
+	return m.heap[i] < m.heap[j]
+}
+func (m *mergeIterator) Swap(i, j int) { m.heap[i], m.heap[j] = m.heap[j], m.heap[i] }
+func (m *mergeIterator) Push(x any)   { m.heap = append(m.heap, x.(int)) }
+func (m *mergeIterator) Pop() any {
+	x := m.heap[len(m.heap)-1]
+	m.heap = m.heap[:len(m.heap)-1]
+	return x
+}
+
+func (m *mergeIterator) valid() bool         { return m.err == nil && len(m.heap) > 0 }
+func (m *mergeIterator) current() blockEntry { return m.sources[m.heap[0]].current() }
+func (m *mergeIterator) iterErr() error      { return m.err }
+
+// next: Avanza todas las fuentes que están en la clave actual.
+func (m *mergeIterator) next() {
+	key := m.current().key
+	for len(m.heap) > 0 && m.current().key == key {
+		s := m.sources[m.heap[0]]
+		s.next()
+		if s.valid() {
+			heap.Fix(m, 0)
+			continue
+		}
+		if err := s.iterErr(); err != nil && m.err == nil { m.err = err }
+		heap.Pop(m)
+	}
+}
+
+// ---- Versiones ---- //
+
+// lsmVersion: Conjunto inmutable de tablas por nivel. Las lecturas toman una referencia a la
+// versión actual y las tablas que contiene no se borran hasta que la sueltan.
+type lsmVersion struct {
+	levels [lsmMaxLevels][]*sstable // Nivel 0: de la tabla más nueva a la más antigua. Resto: por clave.
+	refs   atomic.Int32
+}
+
+func newVersion(levels [lsmMaxLevels][]*sstable) *lsmVersion {
+	v := &lsmVersion{levels: levels}
+	v.refs.Store(1)
+	for _, tables := range levels {
+		for _, t := range tables { t.ref() }
+	}
+	return v
+}
+
+func (v *lsmVersion) unref() {
+	if v.refs.Add(-1) > 0 { return }
+	for _, tables := range v.levels {
+		for _, t := range tables { t.unref() }
+	}
+}
+
+// levelBytes: Tamaño total de las tablas de un nivel.
+func levelBytes(tables []*sstable) int64 {
+	var total int64
+	for _, t := range tables { total += t.size }
+	return total
+}
+
+// ---- Motor ---- //
+
+type lsmEngine struct {
+	dir          string
+	codec        compressionCodec
+	memtableSize int64
+	tableSize    int64 // Tamaño objetivo de las tablas que produce la compactación.
+
+	// mu protege la memtable activa, la lista de congeladas y la versión actual.
+	mu      sync.RWMutex
+	stall   *sync.Cond // Escrituras que esperan a que la rutina de fondo libere espacio.
+	mem     *memtable
+	imm     []*memtable // Memtables congeladas pendientes de volcar, de la más antigua a la más nueva.
+	current *lsmVersion
+
+	// workMu: Lo toma quien cambia las tablas (volcados, compactaciones y puntos de control),
+	// que así nunca se pisan. Protege también los campos siguientes.
+	workMu         sync.Mutex
+	nextFile       uint64
+	checkpointLSN  uint64
+	checkpointTime int64
+	compactPointer [lsmMaxLevels]string // Última clave compactada de cada nivel, para repartir el trabajo.
+
+	work    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newLSMEngine(cfg engineConfig) (StorageEngine, error) {
+	if cfg.dir == "" { return nil, errors.New("el motor lsm necesita un directorio de datos") }
+	dir := filepath.Join(cfg.dir, lsmDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil { return nil, err }
+	// La memoria se reparte entre la memtable activa, las congeladas y las copias de Snapshot.
+	memtableSize := max(cfg.cacheSize/4, lsmMinMemtableSize)
+	e := &lsmEngine{
+		dir:          dir,
+		codec:        cfg.compression,
+		memtableSize: memtableSize,
+		tableSize:    4 * memtableSize,
+		mem:          newMemtable(),
+		current:      newVersion([lsmMaxLevels][]*sstable{}),
+		nextFile:     1,
+		work:         make(chan struct{}, 1),
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+	}
+	e.stall = sync.NewCond(&e.mu)
+	go e.runWorker()
+	return e, nil
+}
+
+// lsmFatal: Los errores de E/S al leer o escribir tablas son fatales (ver StorageEngine).
+func lsmFatal(err error) {
+	log.Fatalf("ERROR: fallo de E/S en el motor LSM, se detiene el servidor (el WAL permite recuperar el estado): %v", err)
+}
+
+func (e *lsmEngine) tablePath(num uint64) string {
+	return filepath.Join(e.dir, fmt.Sprintf("%08d.sst", num))
+}
+
+func (e *lsmEngine) Get(key string) (storeEntry, bool) {
+	e.mu.RLock()
+	if le, ok := e.mem.entries[key]; ok {
+		e.mu.RUnlock()
+		return le.entry, !le.deleted
+	}
+	for i := len(e.imm) - 1; i >= 0; i-- {
+		if le, ok := e.imm[i].entries[key]; ok {
+			e.mu.RUnlock()
+			return le.entry, !le.deleted
+		}
+	}
+	v := e.current
+	v.refs.Add(1)
+	e.mu.RUnlock()
+	defer v.unref()
+
+	for level, tables := range v.levels {
+		if level > 0 {
+			// Sin solapamientos: como mucho una tabla del nivel puede contener la clave.
+			i := sort.Search(len(tables), func(i int) bool { return tables[i].largest >= key })
+			if i == len(tables) { continue }
+			tables = tables[i : i+1]
+		}
+		for _, t := range tables {
+			le, ok, err := t.get(key)
+			if err != nil { lsmFatal(err) }
+			if ok { return le.entry, !le.deleted }
+		}
+	}
+	return storeEntry{}, false
+}
+
+func (e *lsmEngine) Put(key string, entry storeEntry) { e.write(key, lsmEntry{entry: entry}) }
+
+// Delete: Escribe una lápida, que oculta las versiones de la clave en las tablas.
+func (e *lsmEngine) Delete(key string) { e.write(key, lsmEntry{deleted: true}) }
+
+func (e *lsmEngine) write(key string, le lsmEntry) {
+	e.mu.Lock()
+	for len(e.imm) >= lsmMaxImmutable || len(e.current.levels[0]) >= lsmL0StopWrites { e.stall.Wait() }
+	e.mem.put(key, le)
+	if e.mem.size >= e.memtableSize { e.freezeLocked() }
+	e.mu.Unlock()
+}
+
+// freezeLocked: Congela la memtable activa y avisa a la rutina de fondo para que la vuelque.
+// El llamador debe tener tomado mu.
+func (e *lsmEngine) freezeLocked() {
+	e.imm = append(e.imm, e.mem)
+	e.mem = newMemtable()
+	e.signal()
+}
+
+func (e *lsmEngine) signal() {
+	select {
+	case e.work <- struct{}{}:
+	default: // Ya hay un aviso pendiente.
+	}
+}
+
+// sources: Fuentes de un recorrido, de la más nueva a la más antigua. La memtable activa no
+// se incluye: el llamador decide cómo leerla.
+func (e *lsmEngine) sources(imm []*memtable, v *lsmVersion, start, end string, reverse bool) []lsmIterator {
+	var sources []lsmIterator
+	for i := len(imm) - 1; i >= 0; i-- { sources = append(sources, newMemIterator(imm[i], start, end, reverse)) }
+	for _, t := range v.levels[0] {
+		if t.largest >= start && (end == "" || t.smallest < end) { sources = append(sources, t.iterator(start, end, reverse)) }
+	}
+	for _, tables := range v.levels[1:] {
+		if len(tables) > 0 { sources = append(sources, newLevelIterator(tables, start, end, reverse)) }
+	}
+	return sources
+}
+
+// Scan: Mezcla la memtable activa, las congeladas y todos los niveles. mu se mantiene tomado
+// para leer la memtable activa, así que las escrituras esperan al recorrido.
+func (e *lsmEngine) Scan(start, end string, reverse bool, fn func(key string, e storeEntry) bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	sources := append([]lsmIterator{newMemIterator(e.mem, start, end, reverse)}, e.sources(e.imm, e.current, start, end, reverse)...)
+	m := newMergeIterator(sources, reverse)
+	for ; m.valid(); m.next() {
+		c := m.current()
+		if (reverse && c.key < start) || (!reverse && end != "" && c.key >= end) { break }
+		if c.e.deleted { continue }
+		if !fn(c.key, c.e.entry) { break }
+	}
+	if err := m.iterErr(); err != nil { lsmFatal(err) }
+}
+
+// Snapshot: Copia la memtable activa y toma una referencia a la versión actual; el
+// recorrido de las tablas se hace después, sin candados.
+func (e *lsmEngine) Snapshot(fn func(key string, e storeEntry) error) error {
+	e.mu.RLock()
+	active := &sliceIterator{entries: e.mem.sorted()}
+	imm := append([]*memtable(nil), e.imm...)
+	v := e.current
+	v.refs.Add(1)
+	e.mu.RUnlock()
+	defer v.unref()
+
+	m := newMergeIterator(append([]lsmIterator{active}, e.sources(imm, v, "", "", false)...), false)
+	for ; m.valid(); m.next() {
+		c := m.current()
+		if c.e.deleted { continue }
+		if err := fn(c.key, c.e.entry); err != nil { return err }
+	}
+	return m.iterErr()
+}
+
+// Checkpoint: Vuelca todas las memtables, que incluyen todos los cambios hasta lsn, y lo
+// anota en el MANIFEST. A partir de ahí los segmentos del WAL anteriores sobran.
+func (e *lsmEngine) Checkpoint(lsn uint64, timestamp int64) (snapshotInfo, error) {
+	e.workMu.Lock()
+	defer e.workMu.Unlock()
+	e.mu.Lock()
+	if len(e.mem.entries) > 0 { e.freezeLocked() }
+	e.mu.Unlock()
+	for {
+		flushed, err := e.flushOldest()
+		if err != nil { return snapshotInfo{}, err }
+		if !flushed { break }
+	}
+	e.checkpointLSN, e.checkpointTime = lsn, timestamp
+	if err := e.writeManifest(e.current); err != nil { return snapshotInfo{}, err }
+	return snapshotInfo{lsn: lsn, timestamp: timestamp, revision: lsn}, nil
+}
+
+// Recover: Abre las tablas del MANIFEST y borra las que quedaron a medias o sin usar.
+func (e *lsmEngine) Recover() (snapshotInfo, error) {
+	e.workMu.Lock()
+	defer e.workMu.Unlock()
+	levels, err := e.readManifest()
+	if err != nil { return snapshotInfo{}, fmt.Errorf("no se pudo leer %s: %w", filepath.Join(e.dir, lsmManifestFile), err) }
+	live := make(map[uint64]bool)
+	var opened [lsmMaxLevels][]*sstable
+	for level, nums := range levels {
+		for _, num := range nums {
+			t, err := openTable(e.tablePath(num), num)
+			if err != nil {
+				for _, tables := range opened {
+					for _, t := range tables { t.file.Close() }
+				}
+				return snapshotInfo{}, err
+			}
+			opened[level] = append(opened[level], t)
+			live[num] = true
+		}
+	}
+	files, err := os.ReadDir(e.dir)
+	if err != nil { return snapshotInfo{}, err }
+	for _, f := range files {
+		name := f.Name()
+		num, err := strconv.ParseUint(strings.TrimSuffix(name, ".sst"), 10, 64)
+		if strings.HasSuffix(name, ".tmp") || (strings.HasSuffix(name, ".sst") && err == nil && !live[num]) {
+			os.Remove(filepath.Join(e.dir, name))
+		}
+	}
+
+	e.mu.Lock()
+	old := e.current
+	e.current = newVersion(opened)
+	e.mu.Unlock()
+	old.unref()
+	tables := 0
+	for _, t := range opened { tables += len(t) }
+	log.Printf("Motor LSM: punto de control en la LSN %d, %d tablas.", e.checkpointLSN, tables)
+	return snapshotInfo{lsn: e.checkpointLSN, timestamp: e.checkpointTime, revision: e.checkpointLSN}, nil
+}
+
+// Close: Detiene la rutina de fondo y cierra las tablas. Lo que queda en las memtables está
+// en el WAL.
+func (e *lsmEngine) Close() error {
+	close(e.done)
+	<-e.stopped
+	e.workMu.Lock()
+	defer e.workMu.Unlock()
+	for _, tables := range e.current.levels {
+		for _, t := range tables { t.file.Close() }
+	}
+	return nil
+}
+
+// ---- Rutina de fondo: volcados y compactación ---- //
+
+func (e *lsmEngine) runWorker() {
+	defer close(e.stopped)
+	for {
+		select {
+		case <-e.work:
+		case <-e.done:
+			return
+		}
+		e.workMu.Lock()
+		err := e.doWork()
+		e.workMu.Unlock()
+		if err != nil { lsmFatal(err) }
+	}
+}
+
+// doWork: Vuelca las memtables congeladas y compacta mientras algún nivel lo necesite.
+// Los volcados tienen prioridad: entre paso y paso de compactación se vuelve a comprobar.
+func (e *lsmEngine) doWork() error {
+	for {
+		select {
+		case <-e.done:
+			return nil
+		default:
+		}
+		flushed, err := e.flushOldest()
+		if err != nil { return err }
+		if flushed { continue }
+		compacted, err := e.compactOnce()
+		if err != nil || !compacted { return err }
+	}
+}
+
+// flushOldest: Vuelca la memtable congelada más antigua como tabla del nivel 0.
+// El llamador debe tener tomado workMu.
+func (e *lsmEngine) flushOldest() (bool, error) {
+	e.mu.RLock()
+	if len(e.imm) == 0 {
+		e.mu.RUnlock()
+		return false, nil
+	}
+	m := e.imm[0]
+	e.mu.RUnlock()
+
+	tables, err := e.writeTables(newMemIterator(m, "", "", false), false, false)
+	if err != nil { return false, err }
+	levels := e.current.levels
+	levels[0] = append(tables, levels[0]...)
+	return true, e.install(levels, nil, func() { e.imm = e.imm[1:] })
+}
+
+// writeTables: Escribe en tablas nuevas las entradas del iterador. Con split las parte al
+// llegar a tableSize; con dropTombstones descarta las lápidas.
+func (e *lsmEngine) writeTables(it lsmIterator, split, dropTombstones bool) ([]*sstable, error) {
+	var tables []*sstable
+	var w *tableWriter
+	var num uint64
+	fail := func(err error) ([]*sstable, error) {
+		if w != nil { w.abort() }
+		for _, t := range tables {
+			t.file.Close()
+			os.Remove(t.path)
+		}
+		return nil, err
+	}
+	finish := func() error {
+		_, err := w.finish()
+		w = nil
+		if err != nil {
+			os.Remove(e.tablePath(num))
+			return err
+		}
+		t, err := openTable(e.tablePath(num), num)
+		if err != nil { return err }
+		tables = append(tables, t)
+		return nil
+	}
+	for ; it.valid(); it.next() {
+		c := it.current()
+		if c.e.deleted && dropTombstones { continue }
+		if w == nil {
+			num = e.nextFile
+			e.nextFile++
+			var err error
+			if w, err = newTableWriter(e.tablePath(num), e.codec); err != nil { return fail(err) }
+		}
+		if err := w.add(c.key, c.e); err != nil { return fail(err) }
+		if split && w.size() >= e.tableSize {
+			if err := finish(); err != nil { return fail(err) }
+		}
+	}
+	if err := it.iterErr(); err != nil { return fail(err) }
+	if w != nil {
+		if err := finish(); err != nil { return fail(err) }
+	}
+	return tables, nil
+}
+
+// install: Hace durable en el MANIFEST la nueva lista de tablas y la publica. Las tablas
+// de removed se borran cuando la última lectura que las usa termina. underLock se ejecuta
+// con mu tomado, a la vez que se publica la versión.
+func (e *lsmEngine) install(levels [lsmMaxLevels][]*sstable, removed []*sstable, underLock func()) error {
+	v := newVersion(levels)
+	if err := e.writeManifest(v); err != nil {
+		v.unref()
+		return err
+	}
+	e.mu.Lock()
+	old := e.current
+	e.current = v
+	if underLock != nil { underLock() }
+	e.stall.Broadcast()
+	e.mu.Unlock()
+	for _, t := range removed { t.obsolete.Store(true) }
+	old.unref()
+	return nil
+}
+
+// levelMaxBytes: Tamaño a partir del cual un nivel (1 o mayor) se compacta con el siguiente.
+func (e *lsmEngine) levelMaxBytes(level int) int64 {
+	size := lsmL0CompactionTrigger * e.tableSize
+	for i := 1; i < level; i++ { size *= lsmLevelMultiplier }
+	return size
+}
+
+// compactOnce: Compacta el nivel que más lo necesita, si alguno lo necesita. El nivel 0 se
+// compacta entero; de los demás se toma una tabla, por turnos a lo largo de las claves.
+// El llamador debe tener tomado workMu.
+func (e *lsmEngine) compactOnce() (bool, error) {
+	v := e.current
+	level, best := -1, 1.0
+	if score := float64(len(v.levels[0])) / lsmL0CompactionTrigger; score >= best { level, best = 0, score }
+	for l := 1; l < lsmMaxLevels-1; l++ {
+		if score := float64(levelBytes(v.levels[l])) / float64(e.levelMaxBytes(l)); score > best { level, best = l, score }
+	}
+	if level < 0 { return false, nil }
+
+	out := level + 1
+	var inputs []*sstable
+	if level == 0 {
+		inputs = v.levels[0]
+	} else {
+		tables := v.levels[level]
+		i := sort.Search(len(tables), func(i int) bool { return tables[i].smallest > e.compactPointer[level] })
+		if i == len(tables) { i = 0 }
+		inputs = tables[i : i+1]
+	}
+	smallest, largest := inputs[0].smallest, inputs[0].largest
+	for _, t := range inputs {
+		smallest, largest = min(smallest, t.smallest), max(largest, t.largest)
+	}
+	var overlap []*sstable
+	for _, t := range v.levels[out] {
+		if t.overlaps(smallest, largest) { overlap = append(overlap, t) }
+	}
+	e.compactPointer[level] = largest
+	levels := v.levels
+	levels[level] = without(levels[level], inputs)
+
+	// Una tabla que no se solapa con el nivel siguiente baja sin reescribirse.
+	if level > 0 && len(overlap) == 0 {
+		levels[out] = insertSorted(levels[out], inputs)
+		return true, e.install(levels, nil, nil)
+	}
+
+	if len(overlap) > 0 {
+		smallest, largest = min(smallest, overlap[0].smallest), max(largest, overlap[len(overlap)-1].largest)
+	}
+	// Las lápidas solo pueden descartarse si ningún nivel más profundo guarda la clave.
+	dropTombstones := true
+	for l := out + 1; l < lsmMaxLevels; l++ {
+		for _, t := range v.levels[l] {
+			if t.overlaps(smallest, largest) { dropTombstones = false }
+		}
+	}
+	var sources []lsmIterator
+	for _, t := range inputs { sources = append(sources, t.iterator("", "", false)) }
+	sources = append(sources, newLevelIterator(overlap, "", "", false))
+	tables, err := e.writeTables(newMergeIterator(sources, false), true, dropTombstones)
+	if err != nil { return false, err }
+	levels[out] = insertSorted(without(levels[out], overlap), tables)
+	return true, e.install(levels, append(append([]*sstable(nil), inputs...), overlap...), nil)
+}
+
+// without: Copia de tables sin las de remove.
+func without(tables, remove []*sstable) []*sstable {
+	removed := make(map[*sstable]bool, len(remove))
+	for _, t := range remove { removed[t] = true }
+	var kept []*sstable
+	for _, t := range tables {
+		if !removed[t] { kept = append(kept, t) }
+	}
+	return kept
+}
+
+// insertSorted: Copia de tables con add, ordenada por clave menor.
+func insertSorted(tables, add []*sstable) []*sstable {
+	merged := append(append([]*sstable(nil), tables...), add...)
+	sort.Slice(merged, func(i, j int) bool { return merged[i].smallest < merged[j].smallest })
+	return merged
+}
+
+// ---- MANIFEST ---- //
+//
+//	lsmManifestMagic
+//	un bloque con marco: LSN del punto de control (uvarint) | timestamp (varint) |
+//	    siguiente nº de tabla (uvarint) | por nivel: nº de tablas y sus números (uvarint)
+
+// writeManifest: Reemplaza el MANIFEST por uno con las tablas de v. El llamador debe tener
+// tomado workMu.
+func (e *lsmEngine) writeManifest(v *lsmVersion) error {
+	payload := binary.AppendUvarint(nil, e.checkpointLSN)
+	payload = binary.AppendVarint(payload, e.checkpointTime)
+	payload = binary.AppendUvarint(payload, e.nextFile)
+	for _, tables := range v.levels {
+		payload = binary.AppendUvarint(payload, uint64(len(tables)))
+		for _, t := range tables { payload = binary.AppendUvarint(payload, t.num) }
+	}
+	path := filepath.Join(e.dir, lsmManifestFile)
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil { return err }
+	_, err = file.Write(appendFrame([]byte(lsmManifestMagic), payload))
+	if err == nil { err = file.Sync() }
+	if closeErr := file.Close(); err == nil { err = closeErr }
+	if err == nil { err = os.Rename(path+".tmp", path) }
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	// Un solo fsync del directorio hace durables el rename y las tablas nuevas que nombra.
+	return syncDir(e.dir)
+}
+
+// readManifest: Lee el MANIFEST y devuelve los números de tabla de cada nivel. Sin MANIFEST
+// el motor está vacío.
+func (e *lsmEngine) readManifest() ([lsmMaxLevels][]uint64, error) {
+	var levels [lsmMaxLevels][]uint64
+	data, err := os.ReadFile(filepath.Join(e.dir, lsmManifestFile))
+	if os.IsNotExist(err) { return levels, nil }
+	if err != nil { return levels, err }
+	if !bytes.HasPrefix(data, []byte(lsmManifestMagic)) { return levels, errTableCorrupt }
+	payload, _, err := readFrame(bufio.NewReader(bytes.NewReader(data[len(lsmManifestMagic):])))
+	if err != nil { return levels, errTableCorrupt }
+	p := &payloadReader{buf: payload}
+	e.checkpointLSN = p.uvarint()
+	e.checkpointTime = p.varint()
+	e.nextFile = p.uvarint()
+	for l := range levels {
+		n := p.uvarint()
+		for i := uint64(0); i < n && p.err == nil; i++ { levels[l] = append(levels[l], p.uvarint()) }
+	}
+	if p.err != nil { return levels, errTableCorrupt }
+	return levels, nil
+}
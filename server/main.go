@@ -1,19 +1,21 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"hash/fnv"
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	pb "asignacionservidor/proto/keyval"
@@ -28,15 +30,32 @@ const (
 	// numShards: Divide los datos en múltiples mapas más pequeños (fragmentos o 'shards').
 	// Esto reduce la contención de bloqueos y mejora el rendimiento en sistemas con múltiples CPUs.
 	numShards        = 32
-	dataDir          = "./data"
-	snapshotFile     = "snapshot.json"
+	snapshotFile     = "snapshot.bin"
+	// legacySnapshotFile: Snapshot en el formato JSON anterior; solo se lee si no hay uno binario.
+	legacySnapshotFile = "snapshot.json"
 	// walFile: Write-Ahead Log. Un diario donde se registra cada operación de escritura ANTES de ejecutarla.
-	// Es crucial para recuperar datos si el servidor se cae.
+	// Es crucial para recuperar datos si el servidor se cae. Hoy se guarda en segmentos dentro
+	// de data/wal/ (ver wal.go); este nombre es el del WAL de un solo archivo anterior, que
+	// solo se lee para migrarlo.
 	walFile          = "kvstore.wal"
 	snapshotInterval = 5 * time.Minute
+	// reapInterval: Cada cuánto la rutina de limpieza elimina las claves con TTL vencido.
+	reapInterval     = 1 * time.Second
+	// reapBatchSize: Claves vencidas que la rutina de limpieza borra con cada registro del WAL.
+	reapBatchSize    = 1000
 	walSizeThreshold = 256 * 1024 * 1024
 )
 
+// Tipos de operación del WAL.
+// opDelete es la 'lápida' (tombstone): deja constancia durable de que la clave fue borrada.
+// opBatchBegin/opBatchCommit delimitaban un batch en el WAL de texto antiguo; solo se leen al migrarlo.
+const (
+	opSet         = "SET"
+	opDelete      = "DEL"
+	opBatchBegin  = "BEGIN"
+	opBatchCommit = "COMMIT"
+)
+
 // ---- Estructuras de Datos ---- //
 type Statistics struct {
 	mu               sync.Mutex
@@ -45,236 +64,892 @@ type Statistics struct {
 	setOperations    uint64
 	getOperations    uint64
 	prefixOperations uint64
+	deleteOperations uint64
+	expiredKeys      uint64
+	txnOperations    uint64
+	walSyncs         uint64 // fsyncs del WAL realizados.
+	walRecords       uint64 // Registros hechos durables por esos fsyncs; walRecords/walSyncs mide el group commit.
+}
+
+// storeEntry: Valor almacenado junto con su versión.
+// La versión es la revisión global del almacén en la que se escribió el valor, por lo que
+// crece de forma monótona y nunca se reutiliza (ni siquiera si la clave se borra y se recrea).
+type storeEntry struct {
+	value     []byte
+	version   uint64
+	expiresAt int64 // Instante de expiración en UnixNano; 0 si la clave no expira.
 }
 
-// KeyValueStoreShard: Un único fragmento de datos.
-// Tiene su propio candado (mutex) para permitir escrituras y lecturas concurrentes en diferentes fragmentos.
-type KeyValueStoreShard struct {
-	mu    sync.RWMutex
-	store map[string][]byte
+func (e storeEntry) expired(now int64) bool {
+	return e.expiresAt != 0 && e.expiresAt <= now
 }
 
-// ShardedStore: Estructura central que organiza los fragmentos (shards)
-// y gestiona la persistencia (WAL y snapshots).
+// ShardedStore: Estructura central que coordina el acceso a los datos, que guarda un motor
+// de almacenamiento (ver engine.go), y gestiona la persistencia (WAL y puntos de control).
+// Las claves se reparten entre numShards candados (shards): cada escritura se registra y se
+// aplica con el candado de su shard tomado.
 type ShardedStore struct {
-	shards       []*KeyValueStoreShard
+	engine       StorageEngine
+	engineName   string // Tipo del motor (ver storageEngines), para abrir otro igual.
+	engineCache  int64  // Memoria para el motor, en bytes (ver engineConfig).
+	locks        [numShards]sync.RWMutex
 	stats        *Statistics
-	walMutex     sync.Mutex // Protege solo el acceso al archivo WAL.
-	walFile      *os.File
-	walSize      int64
-	walPath      string
-	snapshotPath string
+	expiring     expiryQueue // Claves con TTL, para la rutina de limpieza (ver expiry.go).
+	walMutex     sync.Mutex // Protege el contador de revisiones, la cola del group commit y walSize.
+	revision     uint64     // Última revisión asignada; cada escritura en el WAL la incrementa.
+	walFile      *os.File   // Segmento activo del WAL.
+	walSize      int64      // Bytes escritos en el WAL desde el último snapshot.
+	dataDir      string
+	walDir       string
+	durability   durabilityPolicy
+	retention    retentionPolicy
+	compression  compressionCodec // Códec de los segmentos y snapshots que se escriben.
+
+	// Group commit: registros codificados y escritores que esperan el próximo fsync.
+	walPending []byte
+	walWaiters []*walCommit
+	walFlush   chan struct{} // Avisa a la rutina de escritura de que hay registros pendientes.
+
+	// walSyncMutex: Lo toma la rutina de escritura durante Write+Sync y la rotación del WAL,
+	// que así nunca cierra el archivo con una escritura en curso. Se toma antes que walMutex.
+	// Protege también los campos siguientes.
+	walSyncMutex sync.Mutex
+	walErr       error // Primer error de escritura del WAL; a partir de él se rechazan las escrituras.
+	// walIntervalDirty: Hay escrituras del modo 'interval' pendientes de fsync.
+	walIntervalDirty bool
+	segmentStart     uint64 // Primera LSN del segmento activo.
+	segmentSize      int64
+	walLastWritten   uint64 // LSN del último registro escrito en el segmento activo.
+	walUnsynced      uint64 // Registros escritos desde el último fsync.
+
+	// Formato anterior: el WAL de un solo archivo se reaplica y se deja atrás con un punto de
+	// control (needsCheckpoint).
+	legacyWALPath   string
+	needsCheckpoint bool
+
+	// checkpointLSN: LSN del último snapshot durable. Lo protege snapshotMutex (salvo al arrancar).
+	checkpointLSN uint64
+
+	// Difunde cada registro del WAL a los clientes suscritos con Watch, en orden de commit.
+	watchers *watchHub
+	// Envía cada grupo de registros escritos en el WAL a las réplicas (ver replication.go).
+	replicas *replicationHub
+	// Retraso de este nodo respecto del primario o del líder, para las lecturas BOUNDED (ver read.go).
+	lag lagTracker
+
+	// Consenso Raft (ver raft.go). leaderTerm es el mandato en el que este nodo acepta
+	// escrituras (0 si no es el líder o aún no está listo) y raftOwners, las escrituras del líder
+	// que esperan a que su entrada se aplique. Ambos los protege walMutex.
+	raft       *raftNode
+	leaderTerm uint64
+	raftOwners map[uint64]*walCommit
 
 	// Canal para desacoplar la solicitud de creación de snapshots del hilo principal de operaciones.
 	snapshotTrigger chan struct{}
 	snapshotMutex   sync.Mutex
+	closed          bool // El almacén se cerró (Close); lo protege snapshotMutex.
 }
 
+// errStoreClosed: Escritura sobre un almacén ya cerrado.
+var errStoreClosed = errors.New("el almacén está cerrado")
+
+// SnapshotData: Snapshot en el formato JSON anterior. Solo se lee para compatibilidad.
 type SnapshotData struct {
-	Timestamp int64             `json:"timestamp"`
-	Data      map[string][]byte `json:"data"`
+	Timestamp int64                    `json:"timestamp"`
+	Revision  uint64                   `json:"revision"`
+	Entries   map[string]SnapshotEntry `json:"entries"`
+	// Data: formato antiguo sin versiones. Solo se lee para compatibilidad.
+	Data map[string][]byte `json:"data,omitempty"`
+}
+
+type SnapshotEntry struct {
+	Value     []byte `json:"value"`
+	Version   uint64 `json:"version"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
 }
 
 // ---- Inicialización y Recuperación ---- //
 
-func NewShardedStore() (*ShardedStore, error) {
+// storeOptions: Configuración del almacén elegida con las opciones del servidor.
+type storeOptions struct {
+	dataDir     string
+	engine      string // Nombre del motor de almacenamiento (ver storageEngines).
+	cacheSize   int64  // Memoria para el motor, en bytes (ver engineConfig).
+	durability  durabilityPolicy
+	retention   retentionPolicy
+	compression compressionCodec
+	raft        *raftConfig // Miembros del clúster de Raft; nil sin Raft.
+}
+
+func NewShardedStore(opts storeOptions) (*ShardedStore, error) {
 	log.Println("Inicializando el almacén clave-valor...")
+	dataDir := opts.dataDir
 	if err := os.MkdirAll(dataDir, 0755); err != nil { return nil, err }
+	if err := claimDataDir(dataDir, opts.engine); err != nil { return nil, err }
+	// Una imagen que se estaba instalando cuando el proceso cayó ya no sirve.
+	if err := removeStaleImages(dataDir); err != nil { return nil, err }
 
 	store := &ShardedStore{
-		shards:          make([]*KeyValueStoreShard, numShards),
 		stats:           &Statistics{},
-		walPath:         filepath.Join(dataDir, walFile),
-		snapshotPath:    filepath.Join(dataDir, snapshotFile),
+		engineName:      opts.engine,
+		engineCache:     opts.cacheSize,
+		dataDir:         dataDir,
+		walDir:          filepath.Join(dataDir, walDirName),
+		legacyWALPath:   filepath.Join(dataDir, walFile),
+		walFlush:        make(chan struct{}, 1),
+		durability:      opts.durability,
+		retention:       opts.retention,
+		compression:     opts.compression,
 		// Se inicializa un canal para recibir peticiones de snapshot.
 		snapshotTrigger: make(chan struct{}, 1),
 	}
-
-	for i := range store.shards {
-		store.shards[i] = &KeyValueStoreShard{store: make(map[string][]byte)}
+	if opts.raft != nil {
+		raft, err := newRaftNode(store, *opts.raft)
+		if err != nil { return nil, fmt.Errorf("no se pudo cargar el estado de Raft: %w", err) }
+		store.raft, store.raftOwners = raft, make(map[uint64]*walCommit)
 	}
+	engine, err := newStorageEngine(opts.engine, engineConfig{dir: dataDir, compression: opts.compression, cacheSize: opts.cacheSize, currentLSN: store.stateRevision})
+	if err != nil { return nil, err }
+	store.engine = engine
 
 	// Al arrancar, intenta recuperar el estado desde el disco.
 	if err := store.recoverStore(); err != nil { return nil, err }
+	if store.raft != nil { store.raft.recovered(store.checkpointLSN) }
+	store.recomputeStats()
+	// Las claves que vencieron mientras el servidor estaba apagado no deben resucitar.
+	store.dropExpired()
+	store.watchers = newWatchHub(store.stateRevision())
+	store.replicas = newReplicationHub()
 
-	file, err := os.OpenFile(store.walPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil { return nil, err }
-	store.walFile = file
+	if store.needsCheckpoint {
+		if err := store.migrateToSegments(); err != nil { return nil, fmt.Errorf("no se pudo migrar el WAL a segmentos: %w", err) }
+	}
+	// Los segmentos que cubría el último snapshot pueden haber quedado sin limpiar.
+	store.applyRetention(store.checkpointLSN)
 
-	info, err := file.Stat()
+	// Se sigue escribiendo en el último segmento; si no hay ninguno se crea el primero.
+	store.walLastWritten = store.revision
+	segments, err := listSegments(store.walDir)
 	if err != nil { return nil, err }
-	store.walSize = info.Size()
+	start := store.revision + 1
+	if len(segments) > 0 { start = segments[len(segments)-1].start }
+	if err := store.openSegment(start); err != nil { return nil, err }
+	store.walSize = store.segmentSize
+	go store.runWALFlusher()
+	go store.runIntervalSyncer()
+	if store.raft != nil { store.raft.start() }
 
-	log.Printf("Almacén inicializado. Tamaño inicial del WAL: %d bytes.", store.walSize)
+	log.Printf("Almacén inicializado en la LSN %d con el motor %s. Segmento activo: %s (%d bytes). Durabilidad por defecto: %s. Compresión: %v.",
+		store.revision, opts.engine, segmentFileName(store.segmentStart), store.segmentSize, store.durability.describe(store.durability.mode), store.compression)
 	return store, nil
 }
 
-// getShard: Calcula un hash de la clave para determinar a qué fragmento (shard) pertenece.
-// Esta es la estrategia de distribución de datos.
-func (s *ShardedStore) getShard(key string) *KeyValueStoreShard {
+// newDetachedStore: Almacén sin WAL ni rutinas, con un motor nuevo del tipo name en cfg.dir,
+// en el que se reconstruye un estado a partir de un snapshot y de registros del WAL
+// (recuperación a un punto en el tiempo e imágenes recibidas). El llamador cierra el motor.
+func newDetachedStore(name string, cfg engineConfig) (*ShardedStore, error) {
+	store := &ShardedStore{stats: &Statistics{}, engineName: name, engineCache: cfg.cacheSize, dataDir: cfg.dir, compression: cfg.compression}
+	cfg.currentLSN = store.lastRevision
+	engine, err := newStorageEngine(name, cfg)
+	if err != nil { return nil, err }
+	if _, err := engine.Recover(); err != nil {
+		engine.Close()
+		return nil, err
+	}
+	store.engine = engine
+	return store, nil
+}
+
+// Close: Detiene el almacén. Para las rutinas de Raft y espera a que terminen, espera al
+// snapshot en curso y a las escrituras que tienen tomado su shard, hace fsync del WAL y cierra
+// el WAL y el motor. Las escrituras posteriores fallan con errStoreClosed; las rutinas de
+// escritura del WAL siguen vivas pero ya no tocan el disco. El llamador debe haber detenido
+// antes el servidor gRPC, así ninguna petición usa el motor una vez cerrado.
+func (s *ShardedStore) Close() error {
+	if s.raft != nil { s.raft.stop() }
+	s.snapshotMutex.Lock()
+	defer s.snapshotMutex.Unlock()
+	if s.closed { return nil }
+	s.closed = true
+	unlock := s.lockAllShards()
+	defer unlock()
+	s.walSyncMutex.Lock()
+	var err error
+	if s.walErr == nil {
+		if err = s.walFile.Sync(); err == nil { s.syncedLocked() }
+	}
+	// Tras un error del WAL (ya registrado) el segmento puede estar cerrado.
+	if closeErr := s.walFile.Close(); err == nil && s.walErr == nil { err = closeErr }
+	s.walErr = errStoreClosed
+	s.walSyncMutex.Unlock()
+	if closeErr := s.engine.Close(); err == nil { err = closeErr }
+	return err
+}
+
+// lastRevision: Devuelve la última revisión asignada.
+func (s *ShardedStore) lastRevision() uint64 {
+	s.walMutex.Lock()
+	defer s.walMutex.Unlock()
+	return s.revision
+}
+
+// stateRevision: Última revisión que refleja el motor. Con Raft es la última entrada aplicada:
+// las posteriores que ya están en el WAL pueden no estar confirmadas.
+func (s *ShardedStore) stateRevision() uint64 {
+	if s.raft != nil { return s.raft.applied.Load() }
+	return s.lastRevision()
+}
+
+// keyLock: Calcula un hash de la clave para determinar a qué shard pertenece y devuelve su
+// candado. Esta es la estrategia de distribución de las claves.
+func (s *ShardedStore) keyLock(key string) *sync.RWMutex {
+	return &s.locks[shardIndex(key)]
+}
+
+// live: Devuelve la entrada de la clave solo si existe y no ha expirado.
+// Las claves vencidas son invisibles aunque la rutina de limpieza aún no las haya borrado.
+// El llamador debe tener tomado el candado del shard.
+func (s *ShardedStore) live(key string, now int64) (storeEntry, bool) {
+	e, exists := s.engine.Get(key)
+	if !exists || e.expired(now) { return storeEntry{}, false }
+	return e, true
+}
+
+func shardIndex(key string) int {
 	h := fnv.New32a()
 	h.Write([]byte(key))
-	return s.shards[h.Sum32()%uint32(numShards)]
+	return int(h.Sum32() % uint32(numShards))
+}
+
+// lockShards: Toma los candados de escritura de los shards de todas las claves indicadas,
+// siempre en orden creciente de índice. Ese orden fijo evita interbloqueos entre dos
+// operaciones multi-clave concurrentes. Devuelve la función que los libera.
+func (s *ShardedStore) lockShards(keys []string) func() {
+	var involved [numShards]bool
+	for _, k := range keys { involved[shardIndex(k)] = true }
+	var locked []*sync.RWMutex
+	for i, ok := range involved {
+		if !ok { continue }
+		s.locks[i].Lock()
+		locked = append(locked, &s.locks[i])
+	}
+	return func() {
+		for i := len(locked) - 1; i >= 0; i-- { locked[i].Unlock() }
+	}
+}
+
+// lockAllShards: Toma los candados de escritura de todos los shards (en orden): detiene todas
+// las escrituras y lecturas de los clientes.
+func (s *ShardedStore) lockAllShards() func() {
+	for i := range s.locks { s.locks[i].Lock() }
+	return func() {
+		for i := len(s.locks) - 1; i >= 0; i-- { s.locks[i].Unlock() }
+	}
+}
+
+// rlockAllShards: Toma los candados de lectura de todos los shards (en orden) para obtener
+// una vista consistente entre shards, en la que ningún batch aparece aplicado a medias.
+func (s *ShardedStore) rlockAllShards() func() {
+	for i := range s.locks { s.locks[i].RLock() }
+	return func() {
+		for i := len(s.locks) - 1; i >= 0; i-- { s.locks[i].RUnlock() }
+	}
+}
+
+// recomputeStats: Recalcula el número de claves y el tamaño total a partir del motor, y con
+// el mismo recorrido la cola de expiración. Se usa tras la recuperación, ya que ni las
+// estadísticas ni la cola se persisten en disco.
+func (s *ShardedStore) recomputeStats() {
+	var keys, size uint64
+	s.expiring.reset()
+	s.engine.Snapshot(func(key string, e storeEntry) error {
+		keys++
+		size += uint64(len(e.value))
+		s.expiring.add(key, e)
+		return nil
+	})
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	s.stats.totalKeys, s.stats.totalSizeBytes = keys, size
 }
 
 // recoverStore: Proceso de recuperación de fallos.
-// 1. Carga el último 'snapshot' (la foto completa más reciente de los datos).
-// 2. Reaplica las operaciones del WAL (diario) que ocurrieron después de ese snapshot.
+// 1. Carga el último 'snapshot' (la foto completa más reciente de los datos) y su LSN.
+// 2. Reaplica, en orden, los registros de los segmentos del WAL con LSN posterior a la del snapshot.
 func (s *ShardedStore) recoverStore() error {
 	log.Println("Iniciando proceso de recuperación...")
-	var snapshotTimestamp int64 = 0
-	snapshotData, err := os.ReadFile(s.snapshotPath)
-	if err == nil {
-		var snap SnapshotData
-		if err := json.Unmarshal(snapshotData, &snap); err != nil {
-			log.Printf("ADVERTENCIA: No se pudo parsear el snapshot, se ignora. Error: %v", err)
-		} else {
-			log.Printf("Cargando estado desde snapshot con fecha %v...", time.Unix(0, snap.Timestamp).Format(time.RFC3339))
-			for k, v := range snap.Data {
-				shard := s.getShard(k)
-				shard.store[k] = v
+	snap, err := s.engine.Recover()
+	if err != nil { return err }
+	s.revision = snap.revision
+	s.checkpointLSN = snap.lsn
+
+	opsReplayed := 0
+	applyRecord := func(rec walRecord) { opsReplayed += s.replayRecord(rec) }
+
+	legacyFormat, err := detectWALFormat(s.legacyWALPath)
+	if err != nil { return fmt.Errorf("no se pudo abrir el WAL para lectura: %w", err) }
+	if legacyFormat != walMissing && s.raft != nil { return fmt.Errorf("el WAL de un solo archivo debe migrarse a segmentos antes de usar Raft") }
+	if legacyFormat != walMissing {
+		if err := s.recoverLegacyWAL(legacyFormat, snap, applyRecord); err != nil { return err }
+		log.Printf("Recuperación del WAL completada. %d operaciones reaplicadas.", opsReplayed)
+		return nil
+	}
+
+	segments, err := listSegments(s.walDir)
+	if err != nil { return fmt.Errorf("no se pudieron listar los segmentos del WAL: %w", err) }
+	if len(segments) == 0 {
+		log.Println("No se encontró ningún segmento del WAL, se asume estado limpio.")
+		return nil
+	}
+	log.Printf("Reaplicando %d segmentos del WAL desde la LSN %d...", len(segments), snap.lsn+1)
+	lastLSN := snap.lsn
+	for i, seg := range segments {
+		err := replayWALFile(seg.path, i == len(segments)-1, func(rec walRecord) error {
+			if s.raft != nil { s.raft.observe(rec) }
+			// Los registros hasta la LSN del snapshot ya están incluidos en él.
+			if rec.revision <= snap.lsn { return nil }
+			if rec.revision <= lastLSN {
+				return fmt.Errorf("%s: LSN %d fuera de orden (la anterior es %d)", seg.path, rec.revision, lastLSN)
+			}
+			if rec.revision != lastLSN+1 {
+				log.Printf("ADVERTENCIA: faltan los registros con LSN %d a %d en el WAL.", lastLSN+1, rec.revision-1)
 			}
-			snapshotTimestamp = snap.Timestamp
-			log.Printf("Snapshot cargado. %d claves restauradas.", len(snap.Data))
+			lastLSN = rec.revision
+			// Con Raft no se sabe cuáles se confirmaron: se aplican cuando el líder lo indique.
+			if s.raft != nil {
+				s.revision = max(s.revision, rec.revision)
+				return nil
+			}
+			applyRecord(rec)
+			return nil
+		})
+		if err != nil { return fmt.Errorf("no se pudo reaplicar el WAL: %w", err) }
+	}
+	log.Printf("Recuperación del WAL completada hasta la LSN %d. %d operaciones reaplicadas.", lastLSN, opsReplayed)
+	return nil
+}
+
+// replayRecord: Aplica en memoria un registro del WAL leído del disco y devuelve el número de
+// operaciones que contenía.
+func (s *ShardedStore) replayRecord(rec walRecord) int {
+	for _, o := range rec.ops {
+		switch o.op {
+		case opSet:
+			s.engine.Put(o.key, storeEntry{value: o.value, version: rec.revision, expiresAt: o.expiresAt})
+		case opDelete:
+			s.engine.Delete(o.key)
 		}
-	} else if !os.IsNotExist(err) {
-		return fmt.Errorf("error al leer el archivo de snapshot: %w", err)
 	}
+	if rec.revision > s.revision { s.revision = rec.revision }
+	return len(rec.ops)
+}
 
-	log.Println("Reaplicando operaciones desde el WAL...")
-	walReader, err := os.Open(s.walPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			log.Println("No se encontró archivo WAL, se asume estado limpio.")
-			return nil
+// recoverLegacyWAL: Reaplica un WAL de un solo archivo, anterior a los segmentos. Esos
+// snapshots no registraban una LSN exacta, así que se conserva el filtrado por timestamp con
+// el que se escribieron. Al terminar, NewShardedStore crea un punto de control exacto.
+func (s *ShardedStore) recoverLegacyWAL(format walFormat, snap snapshotInfo, applyRecord func(walRecord)) error {
+	log.Println("Reaplicando operaciones desde el WAL de un solo archivo...")
+	s.needsCheckpoint = true
+	apply := func(rec walRecord) {
+		// Solo se aplican las operaciones del WAL posteriores al snapshot.
+		if rec.timestamp > snap.timestamp {
+			applyRecord(rec)
+		} else if rec.revision > s.revision {
+			s.revision = rec.revision
 		}
-		return fmt.Errorf("no se pudo abrir el WAL para lectura: %w", err)
-	}
-	defer walReader.Close()
-	scanner := bufio.NewScanner(walReader)
-	const maxLineSize = 10 * 1024 * 1024
-	buf := make([]byte, maxLineSize)
-	scanner.Buffer(buf, maxLineSize)
-
-	linesReplayed := 0
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" { continue }
-		parts := strings.SplitN(line, ",", 3)
-		if len(parts) != 3 {
-			log.Printf("ADVERTENCIA: Línea de WAL malformada, se ignora: %s", line)
-			continue
+	}
+	if format == walLegacyText {
+		// Las líneas antiguas sin versión reciben la revisión siguiente a la última aplicada.
+		nextRevision := func() uint64 { return s.revision + 1 }
+		return migrateLegacyWAL(s.legacyWALPath, nextRevision, apply)
+	}
+	err := replayWALFile(s.legacyWALPath, true, func(rec walRecord) error {
+		apply(rec)
+		return nil
+	})
+	if err != nil { return fmt.Errorf("no se pudo reaplicar el WAL: %w", err) }
+	return nil
+}
+
+// walEntry: Una línea del WAL de texto antiguo ya interpretada.
+type walEntry struct {
+	timestamp int64
+	op        string
+	version   uint64
+	expiresAt int64
+	key       string
+	value     []byte
+	count     int // Solo en la cabecera de un batch: número de operaciones que contiene.
+}
+
+// parseWALLine: Interpreta una línea del WAL de texto antiguo (solo se usa al migrar).
+// Último formato de texto: "timestamp,op,versión,expiración,clave,valorBase64".
+// Un batch se escribe como "timestamp,BEGIN,versión,n", n líneas de operación y "timestamp,COMMIT,versión".
+// Formatos anteriores, sin versión ("timestamp,op,clave,valorBase64") o sin tipo de
+// operación ("timestamp,clave,valorBase64", que es un SET), reciben nextRevision.
+func parseWALLine(line string, nextRevision uint64) (walEntry, error) {
+	var e walEntry
+	parts := strings.SplitN(line, ",", 6)
+	if len(parts) >= 3 && (parts[1] == opBatchBegin || parts[1] == opBatchCommit) {
+		if parts[1] == opBatchBegin {
+			if len(parts) != 4 { return e, fmt.Errorf("cabecera de batch malformada") }
+			count, err := strconv.Atoi(parts[3])
+			if err != nil { return e, fmt.Errorf("cabecera de batch malformada") }
+			e.count = count
 		}
-		walTimestamp, err := strconv.ParseInt(parts[0], 10, 64)
-		if err != nil {
-			log.Printf("ADVERTENCIA: Timestamp de WAL inválido, se ignora: %s", line)
-			continue
+		parts = []string{parts[0], parts[1], parts[2], "0", "", ""}
+	}
+	switch len(parts) {
+	case 3:
+		parts = []string{parts[0], opSet, "", "0", parts[1], parts[2]}
+	case 4:
+		parts = []string{parts[0], parts[1], "", "0", parts[2], parts[3]}
+	case 5:
+		parts = []string{parts[0], parts[1], parts[2], "0", parts[3], parts[4]}
+	case 6:
+	default:
+		return e, fmt.Errorf("línea de WAL malformada")
+	}
+	var err error
+	if e.timestamp, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return e, fmt.Errorf("timestamp de WAL inválido")
+	}
+	e.op, e.key = parts[1], parts[4]
+	e.version = nextRevision
+	if parts[2] != "" {
+		if e.version, err = strconv.ParseUint(parts[2], 10, 64); err != nil {
+			return e, fmt.Errorf("versión de WAL inválida")
 		}
-		// Solo se aplican las operaciones del WAL posteriores al snapshot.
-		if walTimestamp > snapshotTimestamp {
-			key := parts[1]
-			valueBytes, err := base64.StdEncoding.DecodeString(parts[2])
-			if err != nil {
-				log.Printf("ADVERTENCIA: Valor en WAL no es Base64 válido, se ignora: %s", line)
-				continue
-			}
-			shard := s.getShard(key)
-			shard.store[key] = valueBytes
-			linesReplayed++
+	}
+	if e.expiresAt, err = strconv.ParseInt(parts[3], 10, 64); err != nil {
+		return e, fmt.Errorf("expiración de WAL inválida")
+	}
+	switch e.op {
+	case opSet:
+		if e.value, err = base64.StdEncoding.DecodeString(parts[5]); err != nil {
+			return e, fmt.Errorf("valor en WAL no es Base64 válido")
 		}
+	case opDelete, opBatchBegin, opBatchCommit:
+	default:
+		return e, fmt.Errorf("operación de WAL desconocida")
 	}
-	log.Printf("Recuperación del WAL completada. %d operaciones reaplicadas.", linesReplayed)
-	return scanner.Err()
+	return e, nil
 }
 
 // ---- Lógica de Persistencia (WAL y Snapshots) ---- //
 
+// walOp: Una operación a registrar en el WAL.
+type walOp struct {
+	op        string
+	key       string
+	value     []byte
+	expiresAt int64
+}
+
 // logOperation: Implementa el Write-Ahead Log (WAL). Cada escritura se registra en disco
 // ANTES de ser aplicada en memoria, garantizando la durabilidad ante caídas.
-func (s *ShardedStore) logOperation(key string, value []byte) error {
-	encodedValue := base64.StdEncoding.EncodeToString(value)
-	timestamp := time.Now().UnixNano()
-	entry := fmt.Sprintf("%d,%s,%s\n", timestamp, key, encodedValue)
+// Los borrados (opDelete) se registran como lápidas sin valor.
+// Devuelve la revisión asignada a la operación, que pasa a ser la versión de la clave, y la
+// garantía de durabilidad que obtuvo la escritura (mode = durabilityDefault usa la del servidor).
+func (s *ShardedStore) logOperation(op, key string, value []byte, expiresAt int64, mode durabilityMode) (uint64, durabilityMode, error) {
+	return s.logRecordMode([]walOp{{op: op, key: key, value: value, expiresAt: expiresAt}}, mode)
+}
+
+// walCommit: Un registro encolado a la espera del próximo fsync del WAL.
+type walCommit struct {
+	revision uint64
+	ops      []walOp
+	mode     durabilityMode // Garantía pedida; al confirmar, la garantía que obtuvo.
+	done     chan error     // Recibe el resultado cuando el registro está confirmado (o falló).
+	applied  chan error     // Raft: recibe el resultado cuando la entrada se aplica (o se pierde el mandato).
+}
+
+// logRecord: Escribe un registro binario del WAL con una o varias operaciones, que comparten
+// revisión, con la política de durabilidad del servidor.
+func (s *ShardedStore) logRecord(ops []walOp) (uint64, error) {
+	revision, _, err := s.logRecordMode(ops, durabilityDefault)
+	return revision, err
+}
+
+// logRecordMode: Todas las operaciones van en un único registro con CRC, de modo que se
+// recupera completo o no se recupera. El registro se encola y la llamada espera a que la
+// rutina de escritura del WAL lo confirme (group commit). En modo 'always' la confirmación
+// llega tras el fsync, con la misma garantía que un Sync por escritura.
+//
+// Con Raft el registro es una entrada del log del líder (siempre con fsync) y la llamada
+// espera además a que se confirme y se aplique al motor (ver raft.go).
+func (s *ShardedStore) logRecordMode(ops []walOp, mode durabilityMode) (uint64, durabilityMode, error) {
+	if mode == durabilityDefault { mode = s.durability.mode }
+	if s.raft != nil { mode = durabilityAlways }
+	c := &walCommit{ops: ops, mode: mode, done: make(chan error, 1)}
+	// La serialización y la compresión de las operaciones se hacen fuera del candado.
+	body := encodeWALBody(ops, s.compression)
 
 	s.walMutex.Lock()
-	n, err := s.walFile.WriteString(entry)
-	if err != nil {
+	term := s.leaderTerm
+	if s.raft != nil && term == 0 {
 		s.walMutex.Unlock()
-		return err
+		return 0, durabilityDefault, errNotLeader
 	}
-	// `Sync` fuerza la escritura al disco físico. Es lento pero seguro.
-	if err := s.walFile.Sync(); err != nil {
-		s.walMutex.Unlock()
-		return err
+	// La revisión y el timestamp se asignan con el candado del WAL tomado, así el orden de las
+	// revisiones coincide con el de los registros en el archivo y los timestamps no retroceden
+	// (la recuperación a un instante se detiene en el primer registro posterior a él).
+	timestamp := time.Now().UnixNano()
+	s.revision++
+	c.revision = s.revision
+	s.walPending = appendWALRecord(s.walPending, c.revision, term, timestamp, body)
+	s.walWaiters = append(s.walWaiters, c)
+	if s.raft != nil {
+		c.applied = make(chan error, 1)
+		s.raftOwners[c.revision] = c
+		s.raft.appendLog(walRecord{revision: c.revision, term: term, timestamp: timestamp, ops: ops})
 	}
-	s.walSize += int64(n)
-	currentSize := s.walSize
 	s.walMutex.Unlock()
 
-	// Si el WAL crece mucho, notifica a otra rutina para que cree un snapshot.
-	if currentSize > walSizeThreshold {
-		select {
-		case s.snapshotTrigger <- struct{}{}:
-		default: // No bloquear si ya hay una petición pendiente.
+	select {
+	case s.walFlush <- struct{}{}:
+	default: // La rutina de escritura ya tiene un aviso pendiente y recogerá este registro.
+	}
+	if err := <-c.done; err != nil {
+		if s.raft != nil {
+			s.walMutex.Lock()
+			delete(s.raftOwners, c.revision)
+			s.walMutex.Unlock()
 		}
+		return 0, durabilityDefault, err
 	}
+	if s.raft != nil {
+		if err := <-c.applied; err != nil { return 0, durabilityDefault, err }
+	}
+	return c.revision, c.mode, nil
+}
 
-	return nil
+// runWALFlusher: Rutina de group commit. Mientras un fsync está en curso, los registros de
+// otros escritores se acumulan en walPending; al terminar, todos ellos se escriben juntos
+// con un único Write y un único Sync, y se confirman a la vez. Así N escritores concurrentes
+// pagan un fsync entre todos en lugar de hacer cola para un fsync cada uno.
+// Si ningún registro del grupo pide modo 'always' se escriben sin fsync.
+func (s *ShardedStore) runWALFlusher() {
+	for range s.walFlush {
+		s.walSyncMutex.Lock()
+		s.walMutex.Lock()
+		pending, commits := s.walPending, s.walWaiters
+		s.walPending, s.walWaiters = nil, nil
+		s.walMutex.Unlock()
+		if len(commits) == 0 {
+			s.walSyncMutex.Unlock()
+			continue
+		}
+
+		// Tras un error de escritura el estado del final del archivo es desconocido: los
+		// registros siguientes podrían quedar detrás de uno cortado y perderse al recuperar,
+		// así que el WAL deja de aceptar escrituras.
+		needSync, intervalWrites := false, false
+		for _, c := range commits {
+			needSync = needSync || c.mode == durabilityAlways
+			intervalWrites = intervalWrites || c.mode == durabilityInterval
+		}
+		err := s.walErr
+		if err == nil {
+			if _, err = s.walFile.Write(pending); err == nil {
+				s.walUnsynced += uint64(len(commits))
+				if needSync {
+					// `Sync` fuerza la escritura al disco físico. Es lento pero seguro.
+					if err = s.walFile.Sync(); err == nil { s.syncedLocked() }
+				}
+			}
+			if err != nil {
+				log.Printf("ERROR: fallo al escribir el WAL, no se aceptarán más escrituras: %v", err)
+				s.walErr = err
+			}
+		}
+		if err == nil {
+			if needSync {
+				// El fsync también hace durables los registros anteriores y los del grupo
+				// que no lo pedían: todos obtienen la garantía completa.
+				s.walIntervalDirty = false
+				for _, c := range commits { c.mode = durabilityAlways }
+			} else if intervalWrites {
+				s.walIntervalDirty = true
+			}
+			s.walLastWritten = commits[len(commits)-1].revision
+			s.segmentSize += int64(len(pending))
+			if s.segmentSize >= walSegmentSize {
+				if rotateErr := s.rotateSegmentLocked(); rotateErr != nil {
+					log.Printf("ERROR: no se pudo abrir un nuevo segmento del WAL, no se aceptarán más escrituras: %v", rotateErr)
+					s.walErr = rotateErr
+				}
+			}
+		}
+		s.walSyncMutex.Unlock()
+
+		var currentSize int64
+		if err == nil {
+			if s.raft != nil {
+				// Con Raft los watchers reciben cada entrada al aplicarse, no al escribirse.
+				s.raft.onWritten(commits[len(commits)-1].revision)
+			} else {
+				// Solo esta rutina publica, así los watchers reciben los eventos en orden de commit.
+				// Sin needSync salen antes del fsync, con la misma garantía que la respuesta.
+				for _, c := range commits { s.watchers.publish(c.revision, c.ops) }
+				s.replicas.publish(commits[0].revision, commits[len(commits)-1].revision, pending)
+			}
+			s.walMutex.Lock()
+			s.walSize += int64(len(pending))
+			currentSize = s.walSize
+			s.walMutex.Unlock()
+		}
+		for _, c := range commits { c.done <- err }
+
+		// Si el WAL crece mucho, notifica a otra rutina para que cree un snapshot.
+		if currentSize > walSizeThreshold {
+			select {
+			case s.snapshotTrigger <- struct{}{}:
+			default: // No bloquear si ya hay una petición pendiente.
+			}
+		}
+	}
 }
 
-// takeSnapshot: Crea un 'snapshot': una copia completa de todos los datos en un momento dado.
-// Esto permite truncar el archivo WAL para que no crezca indefinidamente.
-func (s *ShardedStore) takeSnapshot() {
-	s.snapshotMutex.Lock()
-	defer s.snapshotMutex.Unlock()
+// syncedLocked: Anota en las estadísticas un fsync del WAL, que hace durables todos los
+// registros escritos desde el anterior. El llamador debe tener tomado walSyncMutex.
+func (s *ShardedStore) syncedLocked() {
+	s.stats.mu.Lock()
+	s.stats.walSyncs++
+	s.stats.walRecords += s.walUnsynced
+	s.stats.mu.Unlock()
+	s.walUnsynced = 0
+}
 
-	log.Println("Iniciando creación de snapshot...")
+// putLocked: Registra un SET en el WAL, lo aplica en memoria y actualiza las estadísticas.
+// expiresAt es el instante de expiración en UnixNano (0 = no expira).
+// Devuelve la versión asignada y la garantía de durabilidad que obtuvo la escritura.
+// El llamador debe tener tomado el candado de escritura del shard.
+func (s *ShardedStore) putLocked(key string, value []byte, expiresAt int64, mode durabilityMode) (uint64, durabilityMode, error) {
+	version, got, err := s.logOperation(opSet, key, value, expiresAt, mode)
+	if err != nil { return 0, got, err }
+	// Con Raft la entrada ya se aplicó al confirmarse.
+	if s.raft == nil { s.applyPutLocked(key, storeEntry{value: value, version: version, expiresAt: expiresAt}) }
+	return version, got, nil
+}
 
-	snapshotMap := make(map[string][]byte)
-	for _, shard := range s.shards {
-		// Se usa un Read Lock (RLock) para permitir lecturas mientras se crea el snapshot.
-		shard.mu.RLock()
-		for k, v := range shard.store { snapshotMap[k] = v }
-		shard.mu.RUnlock()
+// applyPutLocked: Aplica en el motor un SET ya registrado en el WAL y actualiza las estadísticas.
+func (s *ShardedStore) applyPutLocked(key string, entry storeEntry) {
+	old, exists := s.engine.Get(key)
+	s.engine.Put(key, entry)
+	s.expiring.add(key, entry)
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	if exists {
+		s.stats.totalSizeBytes -= uint64(len(old.value))
+	} else {
+		s.stats.totalKeys++
 	}
+	s.stats.totalSizeBytes += uint64(len(entry.value))
+}
 
-	snapshot := SnapshotData{Timestamp: time.Now().UnixNano(), Data: snapshotMap}
-	data, err := json.Marshal(snapshot)
-	if err != nil {
-		log.Printf("ERROR al crear snapshot: no se pudo serializar a JSON: %v", err)
-		return
+// applyDeleteLocked: Aplica en el motor un DEL ya registrado en el WAL y actualiza las estadísticas.
+func (s *ShardedStore) applyDeleteLocked(key string) {
+	old, exists := s.engine.Get(key)
+	if !exists { return }
+	s.engine.Delete(key)
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	s.stats.totalKeys--
+	s.stats.totalSizeBytes -= uint64(len(old.value))
+}
+
+// applyBatch: Registra varias operaciones como un único registro atómico del WAL y las aplica.
+// Toma los candados de todos los shards afectados (en orden) durante el registro y la
+// aplicación, así ningún lector observa el batch aplicado a medias.
+func (s *ShardedStore) applyBatch(ops []walOp) (uint64, error) {
+	keys := make([]string, len(ops))
+	for i, o := range ops { keys[i] = o.key }
+	unlock := s.lockShards(keys)
+	defer unlock()
+	return s.applyOpsLocked(ops)
+}
+
+// applyOpsLocked: Registra las operaciones como un único registro del WAL y las aplica en memoria.
+// El llamador debe tener tomados los candados de todos los shards afectados.
+func (s *ShardedStore) applyOpsLocked(ops []walOp) (uint64, error) {
+	revision, err := s.logRecord(ops)
+	if err != nil || s.raft != nil { return revision, err }
+	for _, o := range ops {
+		if o.op == opDelete {
+			s.applyDeleteLocked(o.key)
+		} else {
+			s.applyPutLocked(o.key, storeEntry{value: o.value, version: revision, expiresAt: o.expiresAt})
+		}
 	}
+	return revision, nil
+}
 
-	// Patrón seguro: Escribir en un archivo temporal y luego renombrarlo.
-	// Esto evita tener un snapshot corrupto si el servidor falla a mitad de la escritura.
-	tempPath := s.snapshotPath + ".tmp"
-	if err := os.WriteFile(tempPath, data, 0644); err != nil {
-		log.Printf("ERROR al crear snapshot: no se pudo escribir el archivo temporal: %v", err)
-		return
+// deleteLocked: Registra la lápida en el WAL y borra la clave del motor.
+// Si la clave no existe no se escribe nada. El llamador debe tener el candado del shard.
+func (s *ShardedStore) deleteLocked(key string) (bool, error) {
+	old, exists := s.engine.Get(key)
+	if !exists { return false, nil }
+	// Una clave vencida que la limpieza aún no borró también deja su lápida, como en
+	// reapExpired: los watchers y las réplicas reciben el DELETE. Para el cliente no existía.
+	live := !old.expired(time.Now().UnixNano())
+	if _, _, err := s.logOperation(opDelete, key, nil, 0, durabilityDefault); err != nil { return false, err }
+	if s.raft != nil { return live, nil }
+	s.engine.Delete(key)
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	s.stats.totalKeys--
+	s.stats.totalSizeBytes -= uint64(len(old.value))
+	if !live { s.stats.expiredKeys++ }
+	return live, nil
+}
+
+// reapExpired: Borra las claves cuyo TTL ya venció. Las candidatas salen de la cola de
+// expiración; cada una se vuelve a comprobar con el candado de su shard, por si se sobrescribió
+// o se borró después de apuntarla. Las que siguen vencidas se borran como un Batch de lápidas,
+// de a reapBatchSize por registro del WAL, así los watchers y las réplicas reciben un DELETE
+// con la revisión de ese registro. Con Raft solo puede hacerlo el líder: en un seguidor, o si
+// el WAL falla, las claves vuelven a la cola para la ronda siguiente (las lecturas ya no las
+// ven). Devuelve las claves borradas y el primer error.
+func (s *ShardedStore) reapExpired() (int, error) {
+	now := time.Now().UnixNano()
+	due := s.expiring.due(now)
+	reaped := 0
+	var firstErr error
+	for len(due) > 0 {
+		keys := due[:min(len(due), reapBatchSize)]
+		due = due[len(keys):]
+		n, err := s.reapKeys(keys, now)
+		reaped += n
+		if firstErr == nil { firstErr = err }
 	}
-	if err := os.Rename(tempPath, s.snapshotPath); err != nil {
-		log.Printf("ERROR al crear snapshot: no se pudo renombrar el archivo: %v", err)
-		return
+	return reaped, firstErr
+}
+
+// reapKeys: Una tanda de reapExpired.
+func (s *ShardedStore) reapKeys(keys []string, now int64) (int, error) {
+	unlock := s.lockShards(keys)
+	defer unlock()
+	var ops []walOp
+	var entries []storeEntry
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] { continue }
+		seen[key] = true
+		if e, exists := s.engine.Get(key); exists && e.expired(now) {
+			ops = append(ops, walOp{op: opDelete, key: key})
+			entries = append(entries, e)
+		}
 	}
+	if len(ops) == 0 { return 0, nil }
+	if _, err := s.applyOpsLocked(ops); err != nil {
+		for i, o := range ops { s.expiring.add(o.key, entries[i]) }
+		return 0, err
+	}
+	s.stats.mu.Lock()
+	s.stats.expiredKeys += uint64(len(ops))
+	s.stats.mu.Unlock()
+	return len(ops), nil
+}
 
-	log.Printf("Snapshot creado exitosamente con %d claves.", len(snapshotMap))
+// dropExpired: Al arrancar, elimina del motor las claves que vencieron con el servidor
+// apagado, sin escribir en el WAL: cada SET guarda su instante de expiración, así que tras
+// otro reinicio la recuperación vuelve a descartar las mismas claves.
+func (s *ShardedStore) dropExpired() int {
+	now := time.Now().UnixNano()
+	expired := s.expiring.due(now)
+	var freedBytes, freedKeys uint64
+	for _, key := range expired {
+		lock := s.keyLock(key)
+		lock.Lock()
+		if e, exists := s.engine.Get(key); exists && e.expired(now) {
+			s.engine.Delete(key)
+			freedKeys++
+			freedBytes += uint64(len(e.value))
+		}
+		lock.Unlock()
+	}
+	if freedKeys > 0 {
+		s.stats.mu.Lock()
+		s.stats.totalKeys -= freedKeys
+		s.stats.totalSizeBytes -= freedBytes
+		s.stats.expiredKeys += freedKeys
+		s.stats.mu.Unlock()
+	}
+	return int(freedKeys)
+}
 
-	// Rotación del WAL: Una vez el snapshot es seguro, el viejo WAL ya no es necesario.
-	// Se cierra, se renombra como backup y se crea uno nuevo y vacío.
+// takeSnapshot: Crea un 'snapshot': una copia completa de todos los datos en un momento dado.
+// Esto permite borrar los segmentos del WAL que cubre para que no crezca indefinidamente.
+func (s *ShardedStore) takeSnapshot() {
+	s.snapshotMutex.Lock()
+	defer s.snapshotMutex.Unlock()
+	if s.closed { return }
+	s.checkpointLocked()
+}
+
+// checkpointLocked: Cuerpo de takeSnapshot. Los errores ya quedan registrados en el log; se
+// devuelven para los llamadores que necesitan el snapshot (Backup).
+// El llamador debe tener tomado snapshotMutex.
+func (s *ShardedStore) checkpointLocked() (snapshotInfo, error) {
+	log.Println("Iniciando creación de snapshot...")
+
+	// Punto de control. Con los candados de lectura de todos los shards tomados ninguna
+	// escritura está a medias (se registran y se aplican con el candado de su shard), así que
+	// todo registro con LSN <= lsn ya está escrito en el WAL y aplicado en memoria. En ese
+	// mismo instante se rota el segmento activo: los registros siguientes van a un segmento
+	// que empieza en lsn+1, y los anteriores quedan cubiertos por este snapshot.
+	// Con Raft el motor solo cambia al aplicar entradas confirmadas, en orden, y el punto de
+	// control cubre hasta la última aplicada; los candados no hacen falta (y un líder los
+	// mantiene mientras espera a la mayoría).
+	unlock := func() {}
+	if s.raft == nil { unlock = s.rlockAllShards() }
+	s.walSyncMutex.Lock()
 	s.walMutex.Lock()
-	defer s.walMutex.Unlock()
-	
-	s.walFile.Close()
-	backupWalPath := fmt.Sprintf("%s.%d", s.walPath, time.Now().Unix())
-	os.Rename(s.walPath, backupWalPath)
-	
-	newWalFile, err := os.OpenFile(s.walPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	lsn := s.revision
+	if s.raft != nil { lsn = s.raft.applied.Load() }
+	s.walSize = 0
+	s.walMutex.Unlock()
+	err := s.rotateSegmentLocked()
 	if err != nil {
-		log.Fatalf("CRÍTICO: no se pudo crear un nuevo WAL después del snapshot: %v", err)
+		log.Printf("ERROR: no se pudo abrir un nuevo segmento del WAL, no se aceptarán más escrituras: %v", err)
+		s.walErr = err
 	}
-	s.walFile = newWalFile
-	s.walSize = 0 // El tamaño del nuevo WAL es cero.
-	log.Println("Rotación del WAL completada.")
+	s.walSyncMutex.Unlock()
+	unlock()
+	if err != nil { return snapshotInfo{}, err }
+
+	// El motor guarda su punto de control después, sin bloquear las escrituras, así que puede
+	// incluir cambios posteriores a lsn. No importa: al recuperar, los registros posteriores
+	// a lsn se reaplican en orden sobre él y cada clave termina en su último estado.
+	info, err := s.engine.Checkpoint(lsn, time.Now().UnixNano())
+	if err != nil {
+		log.Printf("ERROR al crear snapshot: %v", err)
+		return info, err
+	}
+	s.stats.mu.Lock()
+	keys := s.stats.totalKeys
+	s.stats.mu.Unlock()
+	log.Printf("Snapshot creado exitosamente con %d claves (LSN %d).", keys, lsn)
+	s.checkpointLSN = lsn
+	if s.raft != nil { s.raft.compacted(lsn) }
+
+	// Solo ahora, con el snapshot durable, pueden borrarse o archivarse los segmentos que cubre.
+	s.applyRetention(lsn)
+	return info, nil
+}
+
+// migrateToSegments: Deja atrás el WAL de un solo archivo. Se llama al arrancar, sin
+// escritores, así que el estado recuperado corresponde exactamente a la LSN actual: se guarda
+// como snapshot y, una vez durable, el WAL antiguo se aparta como "kvstore.wal.<unix>".
+// Si el proceso cae entre ambos pasos, el siguiente arranque repite la migración: el nuevo
+// snapshot es posterior a todos los registros del WAL antiguo y ninguno se reaplica dos veces.
+func (s *ShardedStore) migrateToSegments() error {
+	log.Println("Creando un punto de control para pasar el WAL a segmentos...")
+	if _, err := s.engine.Checkpoint(s.revision, time.Now().UnixNano()); err != nil { return err }
+	s.needsCheckpoint = false
+	s.checkpointLSN = s.revision
+	return os.Rename(s.legacyWALPath, fmt.Sprintf("%s.%d", s.legacyWALPath, time.Now().Unix()))
 }
 
 // ---- Servidor gRPC ---- //
@@ -282,82 +957,201 @@ func (s *ShardedStore) takeSnapshot() {
 type Server struct {
 	pb.UnimplementedKeyValueServiceServer
 	kvStore *ShardedStore
+	// Replicación (ver replication.go). replicaOf es la dirección del primario si este servidor
+	// es una réplica; syncReplicas, las réplicas que confirman cada escritura por defecto.
+	replicaOf          string
+	syncReplicas       int
+	replicationTimeout time.Duration
+	// Anillo de particiones (ver partition.go); nil si este servidor guarda todas las claves.
+	ring *partitionRing
 }
 
 // Set: Manejador de la petición Set. El orden es crucial para la consistencia:
 // 1. Escribe en el WAL (disco).
-// 2. Actualiza la memoria (el shard).
+// 2. Actualiza el motor de almacenamiento.
+// Ambos pasos se hacen con el candado del shard tomado, para que el orden de las operaciones
+// sobre una misma clave en el WAL coincida con el orden en que se aplican en memoria.
+// Con replicación síncrona la respuesta espera además a las réplicas, ya sin el candado.
 func (s *Server) Set(ctx context.Context, req *pb.SetRequest) (*pb.SetResponse, error) {
-	key, value := req.Pair.Key, req.Pair.Value
+	if err := s.checkWritable(ctx); err != nil { return nil, err }
+	pair := req.GetPair()
+	if pair == nil { return nil, status.Error(codes.InvalidArgument, "falta el par clave-valor") }
+	key, value := pair.Key, pair.Value
 	if len(key) > MaxKeySize {
 		return nil, status.Errorf(codes.InvalidArgument, "el tamaño de la clave excede %d bytes", MaxKeySize)
 	}
-	if err := s.kvStore.logOperation(key, value); err != nil {
-		return nil, status.Errorf(codes.Internal, "fallo al persistir la operación: %v", err)
+	var expiresAt int64
+	if req.TtlSeconds > 0 {
+		expiresAt = time.Now().Add(time.Duration(req.TtlSeconds) * time.Second).UnixNano()
+	}
+	lock := s.kvStore.keyLock(key)
+	lock.Lock()
+	version, got, err := s.kvStore.putLocked(key, value, expiresAt, durabilityFromProto(req.Durability))
+	lock.Unlock()
+	if err != nil {
+		return nil, s.persistenceError("la operación", err)
 	}
-	shard := s.kvStore.getShard(key)
-	shard.mu.Lock()
-	defer shard.mu.Unlock()
-	oldValue, exists := shard.store[key]
-	shard.store[key] = value
 	s.kvStore.stats.mu.Lock()
-	defer s.kvStore.stats.mu.Unlock()
-	if exists {
-		s.kvStore.stats.totalSizeBytes -= uint64(len(oldValue))
-	} else {
-		s.kvStore.stats.totalKeys++
+	s.kvStore.stats.setOperations++
+	s.kvStore.stats.mu.Unlock()
+	replicas, err := s.awaitReplicas(ctx, version, s.requiredReplicas(req))
+	if err != nil { return nil, err }
+	return &pb.SetResponse{Success: true, Version: version, Message: s.kvStore.durability.describe(got), Replicas: uint32(replicas)}, nil
+}
+
+// CompareAndSet: Set condicional. Solo escribe si la versión actual de la clave coincide con
+// la esperada (0 significa que la clave no debe existir). La comparación y la escritura se
+// hacen con el candado del shard tomado, así ninguna otra escritura puede colarse entre ambas.
+// Si la condición falla se devuelve codes.FailedPrecondition con la versión actual en los detalles.
+func (s *Server) CompareAndSet(ctx context.Context, req *pb.CompareAndSetRequest) (*pb.CompareAndSetResponse, error) {
+	if err := s.checkWritable(ctx); err != nil { return nil, err }
+	pair := req.GetPair()
+	if pair == nil { return nil, status.Error(codes.InvalidArgument, "falta el par clave-valor") }
+	key, value := pair.Key, pair.Value
+	if len(key) > MaxKeySize {
+		return nil, status.Errorf(codes.InvalidArgument, "el tamaño de la clave excede %d bytes", MaxKeySize)
+	}
+	lock := s.kvStore.keyLock(key)
+	lock.Lock()
+	entry, _ := s.kvStore.live(key, time.Now().UnixNano())
+	current := entry.version
+	if current != req.ExpectedVersion {
+		lock.Unlock()
+		st := status.Newf(codes.FailedPrecondition, "versión esperada %d, versión actual %d", req.ExpectedVersion, current)
+		if withDetails, err := st.WithDetails(&pb.CompareAndSetResponse{Success: false, Version: current}); err == nil {
+			st = withDetails
+		}
+		return nil, st.Err()
+	}
+	version, _, err := s.kvStore.putLocked(key, value, 0, durabilityDefault)
+	lock.Unlock()
+	if err != nil {
+		return nil, s.persistenceError("la operación", err)
 	}
-	s.kvStore.stats.totalSizeBytes += uint64(len(value))
+	s.kvStore.stats.mu.Lock()
 	s.kvStore.stats.setOperations++
-	return &pb.SetResponse{Success: true}, nil
+	s.kvStore.stats.mu.Unlock()
+	if _, err := s.awaitReplicas(ctx, version, s.syncReplicas); err != nil { return nil, err }
+	return &pb.CompareAndSetResponse{Success: true, Version: version}, nil
 }
 
+// Get: Lee una clave con la consistencia pedida (ver read.go). La revisión informada se toma
+// con el candado de la clave: ninguna escritura anterior de esa clave está a medias.
 func (s *Server) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
-	shard := s.kvStore.getShard(req.Key)
-	shard.mu.RLock()
-	defer shard.mu.RUnlock()
-	value, exists := shard.store[req.Key]
+	if err := s.readBarrier(ctx, req.Consistency, req.MaxStalenessMs, req.MaxStalenessRevisions, req.MinRevision); err != nil { return nil, err }
+	lock := s.kvStore.keyLock(req.Key)
+	lock.RLock()
+	defer lock.RUnlock()
+	entry, exists := s.kvStore.live(req.Key, time.Now().UnixNano())
+	revision := s.kvStore.stateRevision()
 	s.kvStore.stats.mu.Lock()
 	s.kvStore.stats.getOperations++
 	s.kvStore.stats.mu.Unlock()
-	return &pb.GetResponse{Value: value, Found: exists}, nil
+	return &pb.GetResponse{Value: entry.value, Found: exists, Version: entry.version, Revision: revision}, nil
+}
+
+// Delete: Borra una clave. Igual que Set, registra primero la lápida en el WAL y luego
+// actualiza la memoria. Si la clave no existe no se escribe nada en el WAL.
+// Con -sync-replicas, la respuesta espera a que las réplicas alcancen la LSN del almacén tras
+// el borrado, que es la de la lápida o una posterior.
+func (s *Server) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	if err := s.checkWritable(ctx); err != nil { return nil, err }
+	lock := s.kvStore.keyLock(req.Key)
+	lock.Lock()
+	found, err := s.kvStore.deleteLocked(req.Key)
+	lock.Unlock()
+	if err != nil {
+		return nil, s.persistenceError("la operación", err)
+	}
+	s.kvStore.stats.mu.Lock()
+	s.kvStore.stats.deleteOperations++
+	s.kvStore.stats.mu.Unlock()
+	if found {
+		if _, err := s.awaitReplicas(ctx, s.kvStore.lastRevision(), s.syncReplicas); err != nil { return nil, err }
+	}
+	return &pb.DeleteResponse{Found: found}, nil
+}
+
+// Batch: Aplica varias escrituras (puts y deletes) de forma atómica, con un único registro
+// en el WAL y un único Sync para todo el lote.
+func (s *Server) Batch(ctx context.Context, req *pb.BatchRequest) (*pb.BatchResponse, error) {
+	if err := s.checkWritable(ctx); err != nil { return nil, err }
+	if len(req.Operations) == 0 {
+		return &pb.BatchResponse{Success: true}, nil
+	}
+	now := time.Now()
+	ops := make([]walOp, 0, len(req.Operations))
+	var sets, deletes uint64
+	for i, o := range req.Operations {
+		switch op := o.Op.(type) {
+		case *pb.BatchOperation_Put:
+			if op.Put == nil || len(op.Put.Key) > MaxKeySize {
+				return nil, status.Errorf(codes.InvalidArgument, "operación %d: clave ausente o mayor de %d bytes", i, MaxKeySize)
+			}
+			var expiresAt int64
+			if o.TtlSeconds > 0 {
+				expiresAt = now.Add(time.Duration(o.TtlSeconds) * time.Second).UnixNano()
+			}
+			ops = append(ops, walOp{op: opSet, key: op.Put.Key, value: op.Put.Value, expiresAt: expiresAt})
+			sets++
+		case *pb.BatchOperation_DeleteKey:
+			if op.DeleteKey == "" || len(op.DeleteKey) > MaxKeySize {
+				return nil, status.Errorf(codes.InvalidArgument, "operación %d: clave ausente o mayor de %d bytes", i, MaxKeySize)
+			}
+			ops = append(ops, walOp{op: opDelete, key: op.DeleteKey})
+			deletes++
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "operación %d: tipo no especificado", i)
+		}
+	}
+	revision, err := s.kvStore.applyBatch(ops)
+	if err != nil {
+		return nil, s.persistenceError("el batch", err)
+	}
+	s.kvStore.stats.mu.Lock()
+	s.kvStore.stats.setOperations += sets
+	s.kvStore.stats.deleteOperations += deletes
+	s.kvStore.stats.mu.Unlock()
+	if _, err := s.awaitReplicas(ctx, revision, s.syncReplicas); err != nil { return nil, err }
+	return &pb.BatchResponse{Success: true, Revision: revision, Applied: uint32(len(ops))}, nil
 }
 
-// GetPrefixStream: Ejemplo de procesamiento paralelo. Lanza una goroutine por cada shard
-// para buscar coincidencias, y usa un canal para agregar los resultados.
-// Esto acelera la búsqueda en un sistema con múltiples CPUs.
+// GetPrefixStream: Busca las claves con el prefijo con un recorrido ordenado del motor.
+// El prefijo se trata como el rango [prefijo, prefixEnd(prefijo)), así el coste es
+// O(coincidencias + log n) y los resultados salen en orden de clave. El último mensaje
+// informa el total de coincidencias y, si se usó limit, el cursor para continuar.
+// Con keys_only no se envían los valores y con count_only solo se envía ese mensaje final.
+// La consistencia se elige como en Get, y el mensaje final informa la revisión leída.
+// En un anillo de particiones consulta todas y combina los resultados (ver partition.go).
 func (s *Server) GetPrefixStream(req *pb.GetPrefixRequest, stream pb.KeyValueService_GetPrefixStreamServer) error {
-	log.Printf("ADVERTENCIA DE RENDIMIENTO: Ejecutando GetPrefix con escaneo completo.")
+	if spans, err := s.spansPartitions(stream.Context()); spans || err != nil {
+		if err != nil { return err }
+		return s.fanOutPrefix(req, stream)
+	}
+	if err := s.readBarrier(stream.Context(), req.Consistency, req.MaxStalenessMs, req.MaxStalenessRevisions, req.MinRevision); err != nil { return err }
 	startTime := time.Now()
-	resultsChan := make(chan *pb.KeyValuePair, 100)
-	var wg sync.WaitGroup
-	for i := 0; i < numShards; i++ {
-		wg.Add(1)
-		go func(shardIndex int) {
-			defer wg.Done()
-			shard := s.kvStore.shards[shardIndex]
-			shard.mu.RLock()
-			defer shard.mu.RUnlock()
-			for k, v := range shard.store {
-				if strings.HasPrefix(k, req.Prefix) {
-					resultsChan <- &pb.KeyValuePair{Key: k, Value: v}
-				}
-			}
-		}(i)
+	q := rangeQuery{
+		start:     req.Prefix,
+		end:       prefixEnd(req.Prefix),
+		limit:     int(req.Limit),
+		keysOnly:  req.KeysOnly,
+		countOnly: req.CountOnly,
 	}
-	// Esta goroutine espera a que todas las búsquedas en los shards terminen y luego cierra el canal.
-	go func() {
-		wg.Wait()
-		close(resultsChan)
-	}()
-	var count uint64
-	for pair := range resultsChan {
+	if err := q.setCursor(req.Cursor); err != nil { return err }
+	matches, total, nextCursor, revision := s.kvStore.queryRange(q, startTime.UnixNano())
+	for _, pair := range matches {
 		if err := stream.Send(&pb.GetPrefixStreamResponse{Response: &pb.GetPrefixStreamResponse_Pair{Pair: pair}}); err != nil {
 			return err
 		}
-		count++
 	}
-	log.Printf("GetPrefix completado en %v, se encontraron %d coincidencias.", time.Since(startTime), count)
+	if err := stream.Send(&pb.GetPrefixStreamResponse{
+		Response:   &pb.GetPrefixStreamResponse_TotalMatches{TotalMatches: uint32(total)},
+		NextCursor: nextCursor,
+		Revision:   revision,
+	}); err != nil {
+		return err
+	}
+	log.Printf("GetPrefix completado en %v, se enviaron %d de %d coincidencias.", time.Since(startTime), len(matches), total)
 	s.kvStore.stats.mu.Lock()
 	s.kvStore.stats.prefixOperations++
 	s.kvStore.stats.mu.Unlock()
@@ -365,21 +1159,102 @@ func (s *Server) GetPrefixStream(req *pb.GetPrefixRequest, stream pb.KeyValueSer
 }
 
 func (s *Server) Stat(ctx context.Context, req *pb.StatRequest) (*pb.StatResponse, error) {
+	// El uso de disco del WAL se mide fuera del candado de las estadísticas.
+	live, archived := s.kvStore.diskUsage()
+	replicas := s.kvStore.replicas.count()
+	resp := &pb.StatResponse{Replicas: uint64(replicas), ReplicaOf: s.replicaOf}
+	if node := s.kvStore.raft; node != nil {
+		role, term, leader, commit, applied := node.status()
+		resp.RaftRole, resp.RaftTerm, resp.RaftLeader, resp.RaftCommit, resp.RaftApplied = role.String(), term, leader, commit, applied
+	}
+	if s.ring != nil { resp.PartitionMembers, resp.PartitionShare = s.ring.members, s.ring.share(s.ring.self) }
 	s.kvStore.stats.mu.Lock()
 	defer s.kvStore.stats.mu.Unlock()
-	return &pb.StatResponse{
-		TotalKeys:        s.kvStore.stats.totalKeys,
-		TotalSizeBytes:   s.kvStore.stats.totalSizeBytes,
-		SetOperations:    s.kvStore.stats.setOperations,
-		GetOperations:    s.kvStore.stats.getOperations,
-		PrefixOperations: s.kvStore.stats.prefixOperations,
-	}, nil
+	resp.TotalKeys, resp.TotalSizeBytes = s.kvStore.stats.totalKeys, s.kvStore.stats.totalSizeBytes
+	resp.SetOperations, resp.GetOperations = s.kvStore.stats.setOperations, s.kvStore.stats.getOperations
+	resp.PrefixOperations, resp.DeleteOperations = s.kvStore.stats.prefixOperations, s.kvStore.stats.deleteOperations
+	resp.ExpiredKeys, resp.TxnOperations = s.kvStore.stats.expiredKeys, s.kvStore.stats.txnOperations
+	resp.WalSyncs, resp.WalRecords = s.kvStore.stats.walSyncs, s.kvStore.stats.walRecords
+	resp.WalSegments, resp.WalSegmentBytes = uint64(live.files), uint64(live.bytes)
+	resp.ArchivedSegments, resp.ArchivedBytes = uint64(archived.files), uint64(archived.bytes)
+	return resp, nil
 }
 
 // ---- Función Principal ---- //
 
 func main() {
-	kvStore, err := NewShardedStore()
+	durabilityFlag := flag.String("durability", "always", "Durabilidad por defecto de las escrituras: 'always' (fsync por escritura), 'interval' o 'none'")
+	fsyncInterval := flag.Duration("fsync-interval", 100*time.Millisecond, "Periodo del fsync en segundo plano del modo 'interval'")
+	// Retención de los segmentos del WAL ya cubiertos por un snapshot.
+	retainSegments := flag.Int("wal-retain-segments", 0, "Segmentos del WAL ya cubiertos por un snapshot que se conservan (los más recientes)")
+	retainFor := flag.Duration("wal-retain-for", 0, "Conservar además los segmentos cubiertos modificados en este periodo (p. ej. 24h)")
+	archiveDir := flag.String("wal-archive-dir", "", "Mover los segmentos que vencen a este directorio en lugar de borrarlos")
+	dataDir := flag.String("data-dir", "./data", "Directorio de datos: snapshot y segmentos del WAL")
+	compressionFlag := flag.String("compression", "none", "Compresión de los nuevos segmentos del WAL y snapshots: 'none' o 'zstd'")
+	engineFlag := flag.String("engine", "memory", "Motor de almacenamiento: "+strings.Join(engineNames(), ", "))
+	engineCache := flag.Int64("engine-cache", defaultEngineCache>>20, "Memoria para datos recientes y cachés del motor, en MiB (los motores en memoria la ignoran)")
+	// Recuperación a un punto en el tiempo (ver pitr.go): reconstruye el almacén y termina.
+	recoverInto := flag.String("recover-into", "", "Reconstruir el almacén en este directorio nuevo hasta -recover-lsn o -recover-time y salir")
+	recoverLSN := flag.Uint64("recover-lsn", 0, "LSN hasta la que se reaplica el WAL (incluida)")
+	recoverTime := flag.String("recover-time", "", "Instante hasta el que se reaplica el WAL, en RFC 3339 o '2006-01-02T15:04:05' en hora local")
+	recoverSnapshot := flag.String("recover-snapshot", "", "Snapshot de partida (por defecto el de -data-dir, o ninguno si no lo hay; 'none' parte de un almacén vacío)")
+	listenAddr := flag.String("addr", ":50051", "Dirección en la que escucha el servidor gRPC")
+	// Replicación primario-réplica (ver replication.go).
+	replicaOf := flag.String("replica-of", "", "Arrancar como réplica de solo lectura del primario en esta dirección (host:puerto)")
+	syncReplicas := flag.Int("sync-replicas", 0, "Réplicas que deben confirmar cada escritura antes de responder (0 = replicación asíncrona)")
+	replicationTimeout := flag.Duration("replication-timeout", 5*time.Second, "Espera máxima por las confirmaciones de las réplicas")
+	// Consenso Raft (ver raft.go).
+	raftPeers := flag.String("raft-peers", "", "Miembros del clúster de Raft, incluido este nodo, separados por comas (host:puerto)")
+	raftSelf := flag.String("raft-self", "", "Dirección de este nodo en -raft-peers (por defecto localhost y el puerto de -addr)")
+	// Particionado con hash consistente (ver partition.go).
+	partitions := flag.String("partitions", "", "Servidores que se reparten las claves, incluido este, separados por comas (host:puerto)")
+	partitionSelf := flag.String("partition-self", "", "Dirección de este servidor en -partitions (por defecto localhost y el puerto de -addr)")
+	partitionVnodes := flag.Int("partition-vnodes", defaultVirtualNodes, "Nodos virtuales de cada servidor en el anillo")
+	partitionRedirect := flag.Bool("partition-redirect", false, "Rechazar las peticiones de claves de otra partición indicando su dueño, en lugar de reenviarlas")
+	flag.Parse()
+	compression, err := parseCompression(*compressionFlag)
+	if err != nil { log.Fatalf("%v", err) }
+	if *engineCache <= 0 { log.Fatalf("-engine-cache debe ser positivo") }
+
+	if *recoverInto != "" {
+		target, err := parseRecoveryTarget(*recoverLSN, *recoverTime)
+		if err != nil { log.Fatalf("%v", err) }
+		out := storeOptions{dataDir: *recoverInto, engine: *engineFlag, cacheSize: *engineCache << 20, compression: compression}
+		if err := recoverToPoint(*dataDir, *recoverSnapshot, *archiveDir, target, out); err != nil {
+			log.Fatalf("Recuperación a un punto en el tiempo fallida: %v", err)
+		}
+		return
+	}
+	mode, err := parseDurabilityMode(*durabilityFlag)
+	if err != nil { log.Fatalf("%v", err) }
+	if *fsyncInterval <= 0 { log.Fatalf("-fsync-interval debe ser positivo") }
+	if *retainSegments < 0 || *retainFor < 0 { log.Fatalf("las opciones de retención no pueden ser negativas") }
+	retention := retentionPolicy{keepSegments: *retainSegments, keepFor: *retainFor, archiveDir: *archiveDir}
+	if warning := retention.pitrWarning(*engineFlag); warning != "" { log.Print(warning) }
+	if *syncReplicas < 0 || *replicationTimeout <= 0 { log.Fatalf("-sync-replicas no puede ser negativo y -replication-timeout debe ser positivo") }
+	var raft *raftConfig
+	if *raftPeers != "" {
+		if *replicaOf != "" || *syncReplicas > 0 { log.Fatalf("-raft-peers no se combina con -replica-of ni con -sync-replicas") }
+		raft, err = parseRaftPeers(*raftPeers, *raftSelf, *listenAddr)
+		if err != nil { log.Fatalf("%v", err) }
+	}
+	var ring *partitionRing
+	if *partitions != "" {
+		if *raftPeers != "" || *replicaOf != "" { log.Fatalf("-partitions no se combina con -raft-peers ni con -replica-of") }
+		ring, err = newPartitionRing(*partitions, *partitionSelf, *listenAddr, *partitionVnodes, *partitionRedirect)
+		if err != nil { log.Fatalf("%v", err) }
+		log.Printf("Partición %s: %.1f%% del anillo de %d servidores.", ring.self, 100*ring.share(ring.self), len(ring.members))
+	}
+
+	kvStore, err := NewShardedStore(storeOptions{
+		dataDir:     *dataDir,
+		engine:      *engineFlag,
+		cacheSize:   *engineCache << 20,
+		durability:  durabilityPolicy{mode: mode, interval: *fsyncInterval},
+		retention:   retention,
+		compression: compression,
+		raft:        raft,
+	})
 	if err != nil {
 		log.Fatalf("No se pudo inicializar el almacén: %v", err)
 	}
@@ -406,14 +1281,51 @@ func main() {
 		}
 	}()
 
-	lis, err := net.Listen("tcp", ":50051")
+	// Goroutine de limpieza: elimina periódicamente las claves cuyo TTL ya venció. Una réplica
+	// no la necesita: recibe las lápidas del primario.
+	if *replicaOf == "" {
+		go func() {
+			ticker := time.NewTicker(reapInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				n, err := kvStore.reapExpired()
+				if n > 0 { log.Printf("Limpieza de TTL: %d claves expiradas eliminadas.", n) }
+				if err != nil && !errors.Is(err, errNotLeader) { log.Printf("ERROR: la limpieza de TTL no pudo registrar las lápidas: %v", err) }
+			}
+		}()
+	}
+
+	lis, err := net.Listen("tcp", *listenAddr)
 	if err != nil { log.Fatalf("falló al escuchar: %v", err) }
+	if *replicaOf != "" {
+		hostname, _ := os.Hostname()
+		go runReplica(kvStore, *replicaOf, fmt.Sprintf("%s:%d", hostname, lis.Addr().(*net.TCPAddr).Port))
+	}
 	
-	s := grpc.NewServer(
+	opts := []grpc.ServerOption{
     grpc.MaxRecvMsgSize(10 * 1024 * 1024), // Aumenta a 10 MB
     grpc.MaxSendMsgSize(10 * 1024 * 1024), // Aumenta a 10 MB
-	)
-	pb.RegisterKeyValueServiceServer(s, &Server{kvStore: kvStore})
+	// Al detenerse, el servidor espera a que terminen las peticiones antes de cerrar el almacén.
+	grpc.WaitForHandlers(true),
+	}
+	if ring != nil { opts = append(opts, grpc.UnaryInterceptor(ring.routeUnary)) }
+	s := grpc.NewServer(opts...)
+	pb.RegisterKeyValueServiceServer(s, &Server{kvStore: kvStore, replicaOf: *replicaOf, syncReplicas: *syncReplicas, replicationTimeout: *replicationTimeout, ring: ring})
+	pb.RegisterReplicationServiceServer(s, &ReplicationServer{kvStore: kvStore})
+	pb.RegisterRaftServiceServer(s, &RaftServer{node: kvStore.raft})
+	// Con SIGINT o SIGTERM se dejan de atender peticiones y se cierra el almacén.
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		sig := <-signals
+		log.Printf("Señal %v recibida: deteniendo el servidor...", sig)
+		s.Stop()
+		close(stopped)
+	}()
 	log.Printf("SERVIDOR ESCUCHANDO EN %v", lis.Addr())
 	if err := s.Serve(lis); err != nil { log.Fatalf("falló al servir: %v", err) }
+	<-stopped
+	if err := kvStore.Close(); err != nil { log.Fatalf("No se pudo cerrar el almacén: %v", err) }
+	log.Println("Servidor detenido.")
 }
\ No newline at end of file
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ---- Motor en memoria ---- //
+//
+// El motor "memory" guarda todas las entradas en mapas repartidos en numShards fragmentos,
+// cada uno con un índice ordenado de sus claves (ver index.go). Su punto de control es el
+// snapshot binario completo de data/snapshot.bin (ver snapshot.go).
+
+// KeyValueStoreShard: Un único fragmento de datos.
+// Tiene su propio candado (mutex) para permitir escrituras y lecturas concurrentes en diferentes fragmentos.
+type KeyValueStoreShard struct {
+	mu    sync.RWMutex
+	store map[string]storeEntry
+	index *orderedKeys // Claves del shard en orden, para búsquedas por prefijo y rango.
+}
+
+func newShard() *KeyValueStoreShard {
+	return &KeyValueStoreShard{store: make(map[string]storeEntry), index: newOrderedKeys()}
+}
+
+// set y remove mantienen sincronizados el mapa y el índice ordenado.
+// El llamador debe tener tomado el candado de escritura del shard.
+func (shard *KeyValueStoreShard) set(key string, e storeEntry) {
+	if _, exists := shard.store[key]; !exists { shard.index.insert(key) }
+	shard.store[key] = e
+}
+
+func (shard *KeyValueStoreShard) remove(key string) {
+	if _, exists := shard.store[key]; !exists { return }
+	delete(shard.store, key)
+	shard.index.remove(key)
+}
+
+// memoryEngine: Motor en memoria. Reparte las claves entre los fragmentos con shardIndex,
+// la misma función que usa el almacén para sus candados.
+type memoryEngine struct {
+	shards             []*KeyValueStoreShard
+	snapshotPath       string
+	legacySnapshotPath string
+	compression        compressionCodec
+	currentLSN         func() uint64
+}
+
+func newMemoryEngine(cfg engineConfig) *memoryEngine {
+	e := &memoryEngine{shards: make([]*KeyValueStoreShard, numShards), compression: cfg.compression, currentLSN: cfg.currentLSN}
+	for i := range e.shards { e.shards[i] = newShard() }
+	if cfg.dir != "" {
+		e.snapshotPath = filepath.Join(cfg.dir, snapshotFile)
+		e.legacySnapshotPath = filepath.Join(cfg.dir, legacySnapshotFile)
+	}
+	return e
+}
+
+func (e *memoryEngine) Get(key string) (storeEntry, bool) {
+	shard := e.shards[shardIndex(key)]
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	entry, exists := shard.store[key]
+	return entry, exists
+}
+
+func (e *memoryEngine) Put(key string, entry storeEntry) {
+	shard := e.shards[shardIndex(key)]
+	shard.mu.Lock()
+	shard.set(key, entry)
+	shard.mu.Unlock()
+}
+
+func (e *memoryEngine) Delete(key string) {
+	shard := e.shards[shardIndex(key)]
+	shard.mu.Lock()
+	shard.remove(key)
+	shard.mu.Unlock()
+}
+
+// Scan: Mezcla los índices de todos los shards con sus candados de lectura tomados.
+func (e *memoryEngine) Scan(start, end string, reverse bool, fn func(key string, e storeEntry) bool) {
+	for _, shard := range e.shards { shard.mu.RLock() }
+	defer func() {
+		for i := len(e.shards) - 1; i >= 0; i-- { e.shards[i].mu.RUnlock() }
+	}()
+	scanShards(e.shards, start, end, reverse, fn)
+}
+
+// snapshotItem: Referencia a una entrada copiada de un shard para procesarla sin candado.
+type snapshotItem struct {
+	key   string
+	entry storeEntry
+}
+
+// Snapshot: Recorre los shards uno a uno. De cada shard solo se copian, con su candado de
+// lectura, las referencias a sus entradas (los valores nunca se modifican en el sitio); fn se
+// llama después, sin candado, así las escrituras sobre el shard no esperan al disco.
+// La memoria adicional queda acotada por las referencias de un shard.
+func (e *memoryEngine) Snapshot(fn func(key string, e storeEntry) error) error {
+	var items []snapshotItem
+	for _, shard := range e.shards {
+		// Se usa un Read Lock (RLock) para permitir lecturas mientras se copia el shard.
+		shard.mu.RLock()
+		items = items[:0]
+		for k, entry := range shard.store { items = append(items, snapshotItem{key: k, entry: entry}) }
+		shard.mu.RUnlock()
+		for _, it := range items {
+			if err := fn(it.key, it.entry); err != nil { return err }
+		}
+	}
+	return nil
+}
+
+// Checkpoint: Escribe un snapshot completo. Patrón seguro: se escribe en un archivo temporal
+// y luego se renombra, así un fallo a mitad de la escritura no deja un snapshot corrupto.
+func (e *memoryEngine) Checkpoint(lsn uint64, timestamp int64) (snapshotInfo, error) {
+	if e.snapshotPath == "" { return snapshotInfo{}, errors.New("el motor no tiene directorio de datos") }
+	tempPath := e.snapshotPath + ".tmp"
+	info, err := writeSnapshotFile(tempPath, e.compression, e, timestamp, lsn, e.currentLSN)
+	if err != nil {
+		os.Remove(tempPath)
+		return info, fmt.Errorf("no se pudo escribir el archivo temporal: %w", err)
+	}
+	if err := e.publishSnapshot(tempPath); err != nil { return info, fmt.Errorf("no se pudo publicar el archivo: %w", err) }
+	return info, nil
+}
+
+// publishSnapshot: Hace visible un snapshot ya escrito y sincronizado en tempPath. Tras el
+// rename se sincroniza el directorio, así el nuevo snapshot sobrevive a una caída antes de
+// que se borre ningún segmento del WAL que cubre.
+func (e *memoryEngine) publishSnapshot(tempPath string) error {
+	if err := os.Rename(tempPath, e.snapshotPath); err != nil { return err }
+	// El snapshot JSON antiguo, si lo había, queda obsoleto.
+	os.Remove(e.legacySnapshotPath)
+	return syncDir(filepath.Dir(e.snapshotPath))
+}
+
+// Recover: Carga el snapshot más reciente (binario o, si no existe, el JSON antiguo).
+// Un snapshot corrupto se ignora, igual que antes se ignoraba un JSON que no se podía parsear.
+func (e *memoryEngine) Recover() (snapshotInfo, error) {
+	if e.snapshotPath == "" { return snapshotInfo{}, nil }
+	for _, snap := range []struct {
+		path string
+		load func(string, StorageEngine) (snapshotInfo, error)
+	}{
+		{e.snapshotPath, loadSnapshot},
+		{e.legacySnapshotPath, loadLegacySnapshot},
+	} {
+		info, err := snap.load(snap.path, e)
+		if os.IsNotExist(err) { continue }
+		if errors.Is(err, errSnapshotCorrupt) {
+			log.Printf("ADVERTENCIA: No se pudo leer el snapshot %s, se ignora. Error: %v", snap.path, err)
+			e.reset()
+			return snapshotInfo{}, nil
+		}
+		if err != nil { return snapshotInfo{}, fmt.Errorf("error al leer el archivo de snapshot: %w", err) }
+		log.Printf("Snapshot con fecha %v (LSN %d) cargado. %d claves restauradas.",
+			time.Unix(0, info.timestamp).Format(time.RFC3339), info.lsn, info.keys)
+		return info, nil
+	}
+	return snapshotInfo{}, nil
+}
+
+// reset: Vacía el motor; se usa para descartar un snapshot cargado a medias.
+func (e *memoryEngine) reset() {
+	for _, shard := range e.shards {
+		shard.mu.Lock()
+		shard.store, shard.index = make(map[string]storeEntry), newOrderedKeys()
+		shard.mu.Unlock()
+	}
+}
+
+func (e *memoryEngine) Close() error { return nil }
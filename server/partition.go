@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pb "asignacionservidor/proto/keyval"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ---- Particionado con hash consistente ---- //
+//
+// Con -partitions, varios servidores se reparten las claves como los shards de un servidor se
+// reparten las suyas, pero con un anillo de hash consistente: cada miembro ocupa
+// -partition-vnodes puntos (nodos virtuales) del anillo de 64 bits y es dueño de las claves
+// cuyo hash cae entre el punto anterior y cada uno de los suyos. Al añadir o quitar un miembro
+// solo cambian de dueño las claves de sus tramos, alrededor de 1/N del total.
+//
+// Cualquier miembro acepta cualquier petición. Las de una clave (o de varias claves de una
+// misma partición, como un Batch o una Txn) las atiende su dueño: el servidor que la recibe
+// la reenvía, o con -partition-redirect la rechaza con un PartitionHint que el cliente sigue.
+// GetPrefixStream y Range consultan todas las particiones y combinan los resultados en orden.
+// Watch, Stat, Backup y Restore actúan solo sobre la partición del servidor que los recibe.
+//
+// Los datos no se mueven solos: todos los miembros deben arrancar con el mismo -partitions y
+// el mismo -partition-vnodes. Las peticiones reenviadas llevan la huella del anillo, y un
+// miembro con otra configuración las rechaza en lugar de atender claves que no son suyas.
+
+const (
+	defaultVirtualNodes = 64
+	// partitionRingKey: Metadato de las peticiones reenviadas por otro miembro: la huella de su anillo.
+	partitionRingKey = "lb-partition-ring"
+)
+
+// ringPoint: Un nodo virtual: el punto hash del anillo pertenece a member.
+type ringPoint struct {
+	hash   uint64
+	member string
+}
+
+// partitionRing: El anillo de hash consistente y las conexiones con sus miembros.
+type partitionRing struct {
+	self     string
+	members  []string
+	points   []ringPoint // Ordenados por hash.
+	id       string      // Huella de los miembros y los nodos virtuales.
+	redirect bool
+	conns    map[string]*grpc.ClientConn
+}
+
+// ringHash: Posición en el anillo de una clave o de un nodo virtual: FNV-1a de 64 bits, como
+// shardIndex, seguido del mezclado final de MurmurHash3. Sin él, cadenas parecidas
+// ("host:puerto#0", "host:puerto#1"...) caen juntas y el reparto del anillo queda muy desigual.
+func ringHash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// newPartitionRing: Construye el anillo con los miembros de -partitions (incluido este
+// servidor, que sin -partition-self es localhost con el puerto de -addr) y conecta con ellos.
+func newPartitionRing(members, self, listenAddr string, vnodes int, redirect bool) (*partitionRing, error) {
+	if vnodes <= 0 { return nil, fmt.Errorf("-partition-vnodes debe ser positivo") }
+	if self == "" {
+		var err error
+		if self, err = localAddr(listenAddr); err != nil { return nil, err }
+	}
+	r := &partitionRing{self: self, redirect: redirect, conns: make(map[string]*grpc.ClientConn)}
+	for _, addr := range strings.Split(members, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" || r.conns[addr] != nil { continue }
+		conn, err := grpc.Dial(addr,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(10*1024*1024), grpc.MaxCallSendMsgSize(10*1024*1024)),
+		)
+		if err != nil { return nil, err }
+		r.conns[addr] = conn
+		r.members = append(r.members, addr)
+		for i := 0; i < vnodes; i++ {
+			r.points = append(r.points, ringPoint{hash: ringHash(fmt.Sprintf("%s#%d", addr, i)), member: addr})
+		}
+	}
+	if r.conns[self] == nil { return nil, fmt.Errorf("-partitions debe incluir a este servidor (%s); indíquelo con -partition-self si usa otra dirección", self) }
+	sort.Strings(r.members)
+	// Con hashes iguales (muy improbable) decide la dirección, para que todos los miembros
+	// construyan el mismo anillo.
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash != r.points[j].hash { return r.points[i].hash < r.points[j].hash }
+		return r.points[i].member < r.points[j].member
+	})
+	r.id = fmt.Sprintf("%016x", ringHash(fmt.Sprintf("%s#%d", strings.Join(r.members, ","), vnodes)))
+	return r, nil
+}
+
+// owner: Miembro dueño de la clave: el del primer punto del anillo en o después de su hash.
+func (r *partitionRing) owner(key string) string {
+	h := ringHash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) { i = 0 }
+	return r.points[i].member
+}
+
+// share: Fracción del anillo que pertenece al miembro, para Stat.
+func (r *partitionRing) share(member string) float64 {
+	var owned float64
+	for i, p := range r.points {
+		if p.member != member { continue }
+		// El tramo que termina en el primer punto empieza en el último (da la vuelta).
+		prev := r.points[(i+len(r.points)-1)%len(r.points)].hash
+		owned += float64(p.hash - prev)
+	}
+	if len(r.points) == 1 { return 1 }
+	return owned / (1 << 64)
+}
+
+// fromMember: Indica si la petición la reenvió otro miembro del anillo; en ese caso se atiende
+// aquí sin volver a enrutarla. Falla si ese miembro tiene otra configuración del anillo.
+func (r *partitionRing) fromMember(ctx context.Context) (bool, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ids := md.Get(partitionRingKey)
+	if len(ids) == 0 { return false, nil }
+	if ids[0] != r.id { return false, status.Errorf(codes.FailedPrecondition, "la petición viene de un servidor con otro anillo de particiones; todos los miembros deben usar el mismo -partitions y -partition-vnodes") }
+	return true, nil
+}
+
+// spansPartitions: Indica si un recorrido recibido por este servidor debe consultar todas las
+// particiones (hay anillo y no lo reenvió otro miembro).
+func (s *Server) spansPartitions(ctx context.Context) (bool, error) {
+	if s.ring == nil { return false, nil }
+	forwarded, err := s.ring.fromMember(ctx)
+	return !forwarded && err == nil, err
+}
+
+// outgoing: Contexto de una petición a otro miembro, marcada como reenviada.
+func (r *partitionRing) outgoing(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, partitionRingKey, r.id)
+}
+
+// partitionKeys: Claves de una petición unaria y una respuesta vacía de su tipo. Sin respuesta,
+// la petición no depende de la partición (Stat, Range, o las de otros servicios).
+func partitionKeys(req interface{}) ([]string, interface{}) {
+	switch req := req.(type) {
+	case *pb.SetRequest:
+		return []string{req.GetPair().GetKey()}, new(pb.SetResponse)
+	case *pb.GetRequest:
+		return []string{req.Key}, new(pb.GetResponse)
+	case *pb.CompareAndSetRequest:
+		return []string{req.GetPair().GetKey()}, new(pb.CompareAndSetResponse)
+	case *pb.DeleteRequest:
+		return []string{req.Key}, new(pb.DeleteResponse)
+	case *pb.BatchRequest:
+		var keys []string
+		for _, o := range req.Operations {
+			switch op := o.Op.(type) {
+			case *pb.BatchOperation_Put:
+				keys = append(keys, op.Put.GetKey())
+			case *pb.BatchOperation_DeleteKey:
+				keys = append(keys, op.DeleteKey)
+			}
+		}
+		return keys, new(pb.BatchResponse)
+	case *pb.TxnRequest:
+		var keys []string
+		for _, c := range req.Compare { keys = append(keys, c.Key) }
+		for _, o := range append(append([]*pb.TxnOperation(nil), req.Success...), req.Failure...) {
+			switch op := o.Op.(type) {
+			case *pb.TxnOperation_Put:
+				keys = append(keys, op.Put.GetKey())
+			case *pb.TxnOperation_DeleteKey:
+				keys = append(keys, op.DeleteKey)
+			case *pb.TxnOperation_GetKey:
+				keys = append(keys, op.GetKey)
+			}
+		}
+		return keys, new(pb.TxnResponse)
+	}
+	return nil, nil
+}
+
+// ownerOf: Dueño de todas las claves de una petición. Las escrituras atómicas de varias
+// claves no pueden repartirse entre servidores, así que deben caer en la misma partición.
+func (r *partitionRing) ownerOf(keys []string) (string, error) {
+	if len(keys) == 0 { return r.self, nil }
+	owner := r.owner(keys[0])
+	for _, key := range keys[1:] {
+		if other := r.owner(key); other != owner {
+			return "", status.Errorf(codes.InvalidArgument, "las claves '%s' y '%s' están en particiones distintas (%s y %s); una operación de varias claves debe quedar en una sola partición", keys[0], key, owner, other)
+		}
+	}
+	return owner, nil
+}
+
+// routeUnary: Interceptor de las peticiones unarias: las de claves de otra partición se
+// reenvían a su dueño o, con -partition-redirect, se rechazan con un PartitionHint.
+func (r *partitionRing) routeUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	keys, reply := partitionKeys(req)
+	if reply == nil { return handler(ctx, req) }
+	if forwarded, err := r.fromMember(ctx); forwarded || err != nil {
+		if err != nil { return nil, err }
+		return handler(ctx, req)
+	}
+	owner, err := r.ownerOf(keys)
+	if err != nil { return nil, err }
+	if owner == r.self { return handler(ctx, req) }
+	if r.redirect {
+		st := status.Newf(codes.FailedPrecondition, "las claves pertenecen a la partición de %s", owner)
+		if withDetails, err := st.WithDetails(&pb.PartitionHint{Owner: owner}); err == nil {
+			st = withDetails
+		}
+		return nil, st.Err()
+	}
+	if err := r.conns[owner].Invoke(r.outgoing(ctx), info.FullMethod, req, reply); err != nil { return nil, err }
+	return reply, nil
+}
+
+// partitionPage: Lo que devolvió una partición en un recorrido.
+type partitionPage struct {
+	pairs []*pb.KeyValuePair
+	total uint32
+	more  bool
+}
+
+// fanOut: Ejecuta query en todas las particiones a la vez. Un error de cualquiera hace fallar
+// el recorrido completo, indicando de qué partición viene.
+func (r *partitionRing) fanOut(ctx context.Context, query func(context.Context, pb.KeyValueServiceClient) (partitionPage, error)) ([]partitionPage, error) {
+	pages := make([]partitionPage, len(r.members))
+	errs := make([]error, len(r.members))
+	var wg sync.WaitGroup
+	for i, member := range r.members {
+		wg.Add(1)
+		go func(i int, member string) {
+			defer wg.Done()
+			pages[i], errs[i] = query(r.outgoing(ctx), pb.NewKeyValueServiceClient(r.conns[member]))
+		}(i, member)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			st := status.Convert(err)
+			return nil, status.Errorf(st.Code(), "partición %s: %s", r.members[i], st.Message())
+		}
+	}
+	return pages, nil
+}
+
+// mergePages: Junta las páginas de las particiones (claves disjuntas, cada una ya ordenada) en
+// una de como mucho q.limit pares. El cursor es la última clave entregada, que cada partición
+// interpreta por su cuenta en la petición siguiente, con la suma de los totales de la primera
+// página: con cursor, todas las particiones devuelven ese mismo total.
+func mergePages(pages []partitionPage, q rangeQuery) ([]*pb.KeyValuePair, uint32, string) {
+	var pairs []*pb.KeyValuePair
+	var total uint32
+	more := false
+	for _, p := range pages {
+		pairs = append(pairs, p.pairs...)
+		total += p.total
+		more = more || p.more
+	}
+	if q.hasCursor { total = uint32(q.total) }
+	sort.Slice(pairs, func(i, j int) bool {
+		if q.reverse { return pairs[i].Key > pairs[j].Key }
+		return pairs[i].Key < pairs[j].Key
+	})
+	if q.limit > 0 && len(pairs) > q.limit {
+		pairs = pairs[:q.limit]
+		more = true
+	}
+	nextCursor := ""
+	if more && len(pairs) > 0 { nextCursor = encodeCursor(pairs[len(pairs)-1].Key, int(total)) }
+	return pairs, total, nextCursor
+}
+
+// fanOutPrefix: GetPrefixStream sobre todas las particiones. Cada una aplica la consistencia
+// pedida por su cuenta; sus revisiones no son comparables, así que no se informa ninguna.
+func (s *Server) fanOutPrefix(req *pb.GetPrefixRequest, stream pb.KeyValueService_GetPrefixStreamServer) error {
+	if req.MinRevision > 0 { return status.Errorf(codes.InvalidArgument, "min_revision no se aplica a un GetPrefix sobre varias particiones: cada una numera sus revisiones") }
+	q := rangeQuery{limit: int(req.Limit)}
+	if err := q.setCursor(req.Cursor); err != nil { return err }
+	startTime := time.Now()
+	pages, err := s.ring.fanOut(stream.Context(), func(ctx context.Context, client pb.KeyValueServiceClient) (partitionPage, error) {
+		var page partitionPage
+		sub, err := client.GetPrefixStream(ctx, req)
+		if err != nil { return page, err }
+		for {
+			resp, err := sub.Recv()
+			if err == io.EOF { return page, nil }
+			if err != nil { return page, err }
+			if pair := resp.GetPair(); pair != nil {
+				page.pairs = append(page.pairs, pair)
+			} else {
+				page.total, page.more = resp.GetTotalMatches(), resp.NextCursor != ""
+			}
+		}
+	})
+	if err != nil { return err }
+	pairs, total, nextCursor := mergePages(pages, q)
+	for _, pair := range pairs {
+		if err := stream.Send(&pb.GetPrefixStreamResponse{Response: &pb.GetPrefixStreamResponse_Pair{Pair: pair}}); err != nil {
+			return err
+		}
+	}
+	if err := stream.Send(&pb.GetPrefixStreamResponse{
+		Response:   &pb.GetPrefixStreamResponse_TotalMatches{TotalMatches: total},
+		NextCursor: nextCursor,
+	}); err != nil {
+		return err
+	}
+	log.Printf("GetPrefix en %d particiones completado en %v, se enviaron %d de %d coincidencias.", len(pages), time.Since(startTime), len(pairs), total)
+	return nil
+}
+
+// fanOutRange: Range sobre todas las particiones.
+func (s *Server) fanOutRange(ctx context.Context, req *pb.RangeRequest) (*pb.RangeResponse, error) {
+	q := rangeQuery{reverse: req.Reverse, limit: int(req.Limit)}
+	if err := q.setCursor(req.Cursor); err != nil { return nil, err }
+	pages, err := s.ring.fanOut(ctx, func(ctx context.Context, client pb.KeyValueServiceClient) (partitionPage, error) {
+		resp, err := client.Range(ctx, req)
+		if err != nil { return partitionPage{}, err }
+		return partitionPage{pairs: resp.Pairs, total: resp.TotalMatches, more: resp.NextCursor != ""}, nil
+	})
+	if err != nil { return nil, err }
+	pairs, total, nextCursor := mergePages(pages, q)
+	return &pb.RangeResponse{Pairs: pairs, TotalMatches: total, NextCursor: nextCursor}, nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+
+	pb "asignacionservidor/proto/keyval"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// testPartition: Un miembro de un anillo de prueba y un cliente gRPC conectado a él.
+type testPartition struct {
+	*Server
+	client pb.KeyValueServiceClient
+}
+
+// startTestPartitions: Arranca size servidores en puertos locales libres que se reparten las
+// claves en un anillo, con el interceptor de enrutado de main.
+func startTestPartitions(t *testing.T, size int, redirect bool) map[string]*testPartition {
+	t.Helper()
+	listeners := make([]net.Listener, size)
+	var members []string
+	for i := range listeners {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil { t.Fatal(err) }
+		listeners[i] = lis
+		members = append(members, lis.Addr().String())
+	}
+	partitions := make(map[string]*testPartition)
+	for i, lis := range listeners {
+		ring, err := newPartitionRing(strings.Join(members, ","), members[i], "", defaultVirtualNodes, redirect)
+		if err != nil { t.Fatalf("newPartitionRing: %v", err) }
+		s := newTestServer(t, t.TempDir())
+		s.ring = ring
+		g := grpc.NewServer(grpc.UnaryInterceptor(ring.routeUnary), grpc.WaitForHandlers(true))
+		pb.RegisterKeyValueServiceServer(g, s)
+		go g.Serve(lis)
+		conn, err := grpc.Dial(members[i], grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil { t.Fatal(err) }
+		t.Cleanup(func() {
+			conn.Close()
+			g.Stop()
+			for _, c := range ring.conns { c.Close() }
+		})
+		partitions[members[i]] = &testPartition{Server: s, client: pb.NewKeyValueServiceClient(conn)}
+	}
+	return partitions
+}
+
+// keyOwnedBy: Una clave con el prefijo cuyo dueño es member.
+func keyOwnedBy(t *testing.T, ring *partitionRing, prefix, member string) string {
+	t.Helper()
+	for i := 0; i < 10000; i++ {
+		if key := fmt.Sprintf("%s%d", prefix, i); ring.owner(key) == member { return key }
+	}
+	t.Fatalf("ninguna clave de %s", member)
+	return ""
+}
+
+// TestPartitionRing: El anillo no depende del orden de los miembros, reparte el espacio de
+// claves a partes parecidas y, al añadir un miembro, solo mueve claves hacia él.
+func TestPartitionRing(t *testing.T) {
+	ring, err := newPartitionRing("a:1,b:1,c:1", "a:1", "", defaultVirtualNodes, false)
+	if err != nil { t.Fatalf("newPartitionRing: %v", err) }
+	shuffled, err := newPartitionRing("c:1, b:1,a:1", "b:1", "", defaultVirtualNodes, false)
+	if err != nil { t.Fatalf("newPartitionRing: %v", err) }
+	grown, err := newPartitionRing("a:1,b:1,c:1,d:1", "a:1", "", defaultVirtualNodes, false)
+	if err != nil { t.Fatalf("newPartitionRing: %v", err) }
+	if ring.id != shuffled.id || ring.id == grown.id { t.Errorf("huellas %s, %s y %s", ring.id, shuffled.id, grown.id) }
+	if other, _ := newPartitionRing("a:1,b:1,c:1", "a:1", "", 32, false); other.id == ring.id { t.Error("la huella no depende de los nodos virtuales") }
+
+	var sum float64
+	for _, m := range ring.members {
+		share := ring.share(m)
+		if share < 0.2 || share > 0.47 { t.Errorf("%s tiene el %.1f%% del anillo", m, 100*share) }
+		sum += share
+	}
+	if sum < 0.999 || sum > 1.001 { t.Errorf("las partes suman %f", sum) }
+
+	const keys = 10000
+	owned, moved := make(map[string]int), 0
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("clave-%d", i)
+		owner := ring.owner(key)
+		owned[owner]++
+		if shuffled.owner(key) != owner { t.Fatalf("%s: dueño %s o %s según el orden de los miembros", key, owner, shuffled.owner(key)) }
+		if now := grown.owner(key); now != owner {
+			if now != "d:1" { t.Fatalf("%s pasó de %s a %s al añadir d:1", key, owner, now) }
+			moved++
+		}
+	}
+	for m, n := range owned {
+		if n < keys/5 || n > keys/2 { t.Errorf("%s es dueño de %d de %d claves", m, n, keys) }
+	}
+	if moved < keys/8 || moved > keys*3/8 { t.Errorf("al añadir un miembro cambiaron de dueño %d de %d claves", moved, keys) }
+
+	if _, err := newPartitionRing("a:1,b:1", "c:1", "", defaultVirtualNodes, false); err == nil { t.Error("se aceptó un anillo sin este servidor") }
+	if _, err := newPartitionRing("a:1", "a:1", "", 0, false); err == nil { t.Error("se aceptó un anillo sin nodos virtuales") }
+}
+
+// TestPartitionRouting: Una petición de una clave de otra partición se reenvía a su dueño, o con
+// -partition-redirect se rechaza con un PartitionHint. Las operaciones de varias claves deben
+// caer en una sola partición, y un miembro con otro anillo no puede reenviar peticiones.
+func TestPartitionRouting(t *testing.T) {
+	ctx := context.Background()
+	for _, redirect := range []bool{false, true} {
+		t.Run(fmt.Sprintf("redirect=%v", redirect), func(t *testing.T) {
+			partitions := startTestPartitions(t, 3, redirect)
+			var members []string
+			for m := range partitions { members = append(members, m) }
+			sort.Strings(members)
+			entry, owner := partitions[members[0]], members[1]
+			key := keyOwnedBy(t, entry.ring, "k", owner)
+
+			_, err := entry.client.Set(ctx, &pb.SetRequest{Pair: &pb.KeyValuePair{Key: key, Value: []byte("v")}})
+			if redirect {
+				st := status.Convert(err)
+				var hint string
+				for _, d := range st.Details() {
+					if h, ok := d.(*pb.PartitionHint); ok { hint = h.Owner }
+				}
+				if st.Code() != codes.FailedPrecondition || hint != owner { t.Fatalf("Set en otra partición: %v, se esperaba un PartitionHint hacia %s", err, owner) }
+				_, err = partitions[owner].client.Set(ctx, &pb.SetRequest{Pair: &pb.KeyValuePair{Key: key, Value: []byte("v")}})
+			}
+			if err != nil { t.Fatalf("Set: %v", err) }
+			if got := mustGet(t, partitions[owner].Server, key); string(got.Value) != "v" { t.Errorf("%s en su dueño: %q", key, got.Value) }
+			if got := mustGet(t, entry.Server, key); got.Found { t.Errorf("%s quedó también en %s", key, members[0]) }
+			if !redirect {
+				resp, err := entry.client.Get(ctx, &pb.GetRequest{Key: key})
+				if err != nil || string(resp.Value) != "v" { t.Errorf("Get reenviado: %v %v", resp, err) }
+			}
+
+			local := keyOwnedBy(t, entry.ring, "b", members[0])
+			batch := &pb.BatchRequest{Operations: []*pb.BatchOperation{
+				{Op: &pb.BatchOperation_Put{Put: &pb.KeyValuePair{Key: local, Value: []byte("v")}}},
+				{Op: &pb.BatchOperation_Put{Put: &pb.KeyValuePair{Key: key, Value: []byte("v")}}},
+			}}
+			if _, err := entry.client.Batch(ctx, batch); status.Code(err) != codes.InvalidArgument {
+				t.Errorf("Batch en dos particiones: %v, se esperaba InvalidArgument", err)
+			}
+
+			foreign := metadata.AppendToOutgoingContext(ctx, partitionRingKey, "otro-anillo")
+			if _, err := partitions[owner].client.Get(foreign, &pb.GetRequest{Key: key}); status.Code(err) != codes.FailedPrecondition {
+				t.Errorf("petición reenviada desde otro anillo: %v, se esperaba FailedPrecondition", err)
+			}
+		})
+	}
+}
+
+// TestPartitionFanOut: Range y GetPrefixStream recorren todas las particiones y devuelven las
+// claves en orden, sin huecos ni repeticiones entre páginas, con el total de la primera página.
+func TestPartitionFanOut(t *testing.T) {
+	ctx := context.Background()
+	partitions := startTestPartitions(t, 3, false)
+	var entry *testPartition
+	for _, p := range partitions { entry = p }
+	const keys = 300
+	var want []string
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("k%04d", i)
+		want = append(want, key)
+		if _, err := entry.client.Set(ctx, &pb.SetRequest{Pair: &pb.KeyValuePair{Key: key, Value: []byte("v")}}); err != nil { t.Fatalf("Set: %v", err) }
+	}
+	if _, err := entry.client.Set(ctx, &pb.SetRequest{Pair: &pb.KeyValuePair{Key: "z", Value: []byte("fuera")}}); err != nil { t.Fatalf("Set: %v", err) }
+	for _, p := range partitions {
+		p.kvStore.stats.mu.Lock()
+		n := p.kvStore.stats.totalKeys
+		p.kvStore.stats.mu.Unlock()
+		if n == 0 || n > keys/2 { t.Errorf("una partición tiene %d de %d claves", n, keys) }
+	}
+
+	for _, reverse := range []bool{false, true} {
+		var got []string
+		cursor := ""
+		for page := 0; ; page++ {
+			resp, err := entry.client.Range(ctx, &pb.RangeRequest{StartKey: "k", EndKey: "l", Limit: 70, Reverse: reverse, Cursor: cursor})
+			if err != nil { t.Fatalf("Range: %v", err) }
+			if resp.TotalMatches != keys { t.Fatalf("página %d: total %d, se esperaba %d", page, resp.TotalMatches, keys) }
+			for _, pair := range resp.Pairs { got = append(got, pair.Key) }
+			if resp.NextCursor == "" { break }
+			if len(resp.Pairs) != 70 { t.Fatalf("página %d con cursor y %d pares", page, len(resp.Pairs)) }
+			// Una clave nueva (aún por recorrer) no cambia el total de las páginas siguientes.
+			if page == 0 && !reverse {
+				if _, err := entry.client.Set(ctx, &pb.SetRequest{Pair: &pb.KeyValuePair{Key: "k9999", Value: []byte("v")}}); err != nil { t.Fatal(err) }
+			}
+			cursor = resp.NextCursor
+		}
+		expected := append([]string(nil), want...)
+		if reverse {
+			sort.Sort(sort.Reverse(sort.StringSlice(expected)))
+		} else {
+			expected = append(expected, "k9999")
+			if _, err := entry.client.Delete(ctx, &pb.DeleteRequest{Key: "k9999"}); err != nil { t.Fatal(err) }
+		}
+		if strings.Join(got, ",") != strings.Join(expected, ",") { t.Errorf("Range (reverse=%v) devolvió %d claves: %v", reverse, len(got), got) }
+	}
+
+	var got []string
+	cursor := ""
+	for {
+		stream, err := entry.client.GetPrefixStream(ctx, &pb.GetPrefixRequest{Prefix: "k01", Limit: 30, Cursor: cursor})
+		if err != nil { t.Fatalf("GetPrefixStream: %v", err) }
+		cursor = ""
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF { break }
+			if err != nil { t.Fatalf("GetPrefixStream: %v", err) }
+			if pair := resp.GetPair(); pair != nil {
+				got = append(got, pair.Key)
+			} else {
+				if resp.GetTotalMatches() != 100 { t.Fatalf("total %d, se esperaba 100", resp.GetTotalMatches()) }
+				cursor = resp.NextCursor
+			}
+		}
+		if cursor == "" { break }
+	}
+	if strings.Join(got, ",") != strings.Join(want[100:200], ",") { t.Errorf("GetPrefixStream devolvió %d claves: %v", len(got), got) }
+}
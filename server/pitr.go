@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ---- Recuperación a un punto en el tiempo ---- //
+//
+// "lbserver -recover-into <dir>" reconstruye el almacén tal como estaba en una LSN o en un
+// instante y lo guarda como un directorio de datos nuevo, sin modificar el original (que
+// puede seguir en uso). Parte de un snapshot (el de -data-dir, una copia anterior guardada
+// aparte o, con "none", un almacén vacío) y reaplica los segmentos del WAL de -data-dir/wal y
+// de -wal-archive-dir hasta el punto pedido. El resultado se guarda con el motor de -engine y
+// se arranca con -data-dir <dir> y ese mismo motor.
+
+// recoveryTarget: Punto hasta el que se reaplica el WAL: una LSN o, si es 0, un instante.
+type recoveryTarget struct {
+	lsn  uint64
+	time time.Time
+}
+
+// parseRecoveryTarget: Interpreta las opciones -recover-lsn y -recover-time.
+func parseRecoveryTarget(lsn uint64, at string) (recoveryTarget, error) {
+	if (lsn == 0) == (at == "") { return recoveryTarget{}, errors.New("hay que indicar -recover-lsn o -recover-time (solo uno)") }
+	if at == "" { return recoveryTarget{lsn: lsn}, nil }
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil { t, err = time.ParseInLocation("2006-01-02T15:04:05", at, time.Local) }
+	if err != nil { return recoveryTarget{}, fmt.Errorf("instante de recuperación inválido '%s': %v", at, err) }
+	return recoveryTarget{time: t}, nil
+}
+
+// includes: Indica si el registro es anterior o igual al punto de recuperación.
+func (t recoveryTarget) includes(rec walRecord) bool {
+	if t.lsn > 0 { return rec.revision <= t.lsn }
+	return rec.timestamp <= t.time.UnixNano()
+}
+
+func (t recoveryTarget) String() string {
+	if t.lsn > 0 { return fmt.Sprintf("LSN %d", t.lsn) }
+	return t.time.Format(time.RFC3339Nano)
+}
+
+// errStopReplay: Lo devuelve la función de recorrido del WAL al alcanzar el punto pedido.
+var errStopReplay = errors.New("punto de recuperación alcanzado")
+
+// recoverToPoint: Reconstruye en out.dataDir el estado del almacén de dataDir en el punto
+// target, con el motor y la compresión de out. Si falla, el directorio de destino se borra.
+func recoverToPoint(dataDir, snapshotPath, archiveDir string, target recoveryTarget, out storeOptions) (err error) {
+	outDir := out.dataDir
+	if entries, err := os.ReadDir(outDir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("el directorio de destino %s no está vacío", outDir)
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil { return err }
+	defer func() {
+		if err != nil { os.RemoveAll(outDir) }
+	}()
+	// El estado se reconstruye directamente en el motor de destino.
+	store, err := newDetachedStore(out.engine, engineConfig{dir: outDir, compression: out.compression, cacheSize: out.cacheSize})
+	if err != nil { return err }
+	defer store.engine.Close()
+
+	// 1. Snapshot de partida. Debe ser anterior al punto pedido: el WAL solo permite avanzar.
+	// Sin snapshot en dataDir (nunca se tomó, o el motor no los usa) se parte de cero, como con
+	// 'none'; uno indicado con -recover-snapshot que no existe sí es un error.
+	noSnapshot := false
+	if snapshotPath == "" {
+		snapshotPath = filepath.Join(dataDir, snapshotFile)
+		if _, err := os.Stat(snapshotPath); os.IsNotExist(err) {
+			log.Printf("No hay snapshot en %s: se parte de un almacén vacío.", dataDir)
+			snapshotPath, noSnapshot = "none", true
+		}
+	}
+	var snap snapshotInfo
+	if snapshotPath != "none" {
+		var err error
+		if snap, err = store.loadSnapshot(snapshotPath); err != nil {
+			return fmt.Errorf("no se pudo leer el snapshot %s: %w", snapshotPath, err)
+		}
+		log.Printf("Snapshot %s cargado: LSN %d, %d claves.", snapshotPath, snap.lsn, snap.keys)
+		if (target.lsn > 0 && target.lsn < snap.lsn) || (target.lsn == 0 && target.time.UnixNano() < snap.timestamp) {
+			return fmt.Errorf("el snapshot (LSN %d, %s) es posterior a %v; indique uno anterior con -recover-snapshot o 'none'",
+				snap.lsn, time.Unix(0, snap.timestamp).Format(time.RFC3339), target)
+		}
+	}
+
+	// 2. Segmentos disponibles, en uso y archivados, ordenados por LSN inicial.
+	segments, err := listSegments(filepath.Join(dataDir, walDirName))
+	if err != nil { return err }
+	if archiveDir != "" {
+		archived, err := listSegments(archiveDir)
+		if err != nil { return err }
+		segments = append(segments, archived...)
+	}
+	sort.SliceStable(segments, func(i, j int) bool { return segments[i].start < segments[j].start })
+	// Una copia al archivo interrumpida puede dejar el mismo segmento en ambos directorios.
+	unique := segments[:0]
+	for _, seg := range segments {
+		if len(unique) > 0 && unique[len(unique)-1].start == seg.start { continue }
+		unique = append(unique, seg)
+	}
+	segments = unique[len(coveredSegments(unique, snap.lsn)):]
+	if len(segments) > 0 && segments[0].start > snap.lsn+1 {
+		err := fmt.Errorf("faltan los registros con LSN %d a %d: no están en %s ni en el archivo",
+			snap.lsn+1, segments[0].start-1, filepath.Join(dataDir, walDirName))
+		// Es lo normal con los motores que no guardan snapshot.bin y sin archivo (ver pitrWarning).
+		if noSnapshot { err = fmt.Errorf("%w; sin snapshot hay que conservar todo el WAL con -wal-archive-dir", err) }
+		return err
+	}
+
+	// 3. Se reaplican en orden los registros posteriores al snapshot hasta el punto pedido.
+	// Un hueco antes de ese punto haría el resultado incorrecto, así que es un error.
+	lastLSN, lastTimestamp := snap.lsn, snap.timestamp
+	opsReplayed, reached := 0, false
+	for i, seg := range segments {
+		err := replayWALFile(seg.path, false, func(rec walRecord) error {
+			if rec.revision <= snap.lsn { return nil }
+			if rec.revision <= lastLSN {
+				return fmt.Errorf("%s: LSN %d fuera de orden (la anterior es %d)", seg.path, rec.revision, lastLSN)
+			}
+			if rec.revision != lastLSN+1 {
+				return fmt.Errorf("faltan los registros con LSN %d a %d en el WAL", lastLSN+1, rec.revision-1)
+			}
+			if !target.includes(rec) { return errStopReplay }
+			opsReplayed += store.replayRecord(rec)
+			lastLSN, lastTimestamp = rec.revision, rec.timestamp
+			return nil
+		})
+		if errors.Is(err, errStopReplay) {
+			reached = true
+			break
+		}
+		// El último segmento puede estar escribiéndose ahora mismo: su cola no se toca.
+		if errors.Is(err, errTornRecord) && i == len(segments)-1 {
+			log.Printf("ADVERTENCIA: %v; se ignora la cola del último segmento.", err)
+			break
+		}
+		if err != nil { return fmt.Errorf("no se pudo reaplicar el WAL: %w", err) }
+	}
+	if target.lsn > 0 && lastLSN < target.lsn {
+		return fmt.Errorf("el WAL disponible solo llega hasta la LSN %d", lastLSN)
+	}
+	if target.lsn == 0 && !reached {
+		log.Printf("ADVERTENCIA: el WAL disponible termina antes de %v; se recupera hasta su último registro.", target)
+	}
+
+	// 4. El snapshot se copió sin detener las escrituras: puede contener cambios posteriores a
+	// su LSN, que solo quedan bien ordenados si se reaplica el WAL al menos hasta el final de
+	// la copia. En los snapshots sin esa LSN se comprueban al menos las versiones.
+	if snap.consistentLSN > lastLSN || store.revision > lastLSN {
+		return fmt.Errorf("el snapshot incluye cambios hasta la LSN %d, posterior a la LSN %d del punto pedido; indique uno anterior con -recover-snapshot o 'none'",
+			max(snap.consistentLSN, store.revision), lastLSN)
+	}
+	if snapshotPath != "none" && snap.consistentLSN == 0 && snap.lsn != lastLSN {
+		log.Printf("ADVERTENCIA: el snapshot no indica hasta qué LSN llega su copia; si se tomó con escrituras en curso el resultado puede incluir alguna posterior a la LSN %d.", lastLSN)
+	}
+
+	// 5. El estado se guarda como el punto de control de un directorio de datos nuevo, con el
+	// WAL vacío.
+	if err := os.MkdirAll(filepath.Join(outDir, walDirName), 0755); err != nil { return err }
+	if _, err := store.engine.Checkpoint(lastLSN, lastTimestamp); err != nil { return err }
+	if err := claimDataDir(outDir, out.engine); err != nil { return err }
+	store.recomputeStats()
+	log.Printf("Almacén recuperado en %s hasta la LSN %d (%s) con el motor %s: %d operaciones reaplicadas, %d claves.",
+		outDir, lastLSN, time.Unix(0, lastTimestamp).Format(time.RFC3339Nano), out.engine, opsReplayed, store.stats.totalKeys)
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRecoverWithoutSnapshot: Sin snapshot en el directorio de datos, la recuperación parte de
+// un almacén vacío y reaplica el WAL; un snapshot indicado que no existe es un error.
+func TestRecoverWithoutSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s := newEngineTestServer(t, dir, "btree")
+	mustSet(t, s, "a", "1")
+	lsn := mustSet(t, s, "b", "2")
+	mustSet(t, s, "b", "3")
+
+	out := storeOptions{engine: "memory", cacheSize: conformanceCacheSize}
+	out.dataDir = filepath.Join(t.TempDir(), "indicado")
+	if err := recoverToPoint(dir, filepath.Join(dir, "no-existe.bin"), "", recoveryTarget{lsn: lsn}, out); err == nil {
+		t.Fatal("se recuperó con un snapshot que no existe")
+	}
+
+	out.dataDir = filepath.Join(t.TempDir(), "recuperado")
+	if err := recoverToPoint(dir, "", "", recoveryTarget{lsn: lsn}, out); err != nil { t.Fatalf("recoverToPoint: %v", err) }
+	r := newTestServer(t, out.dataDir)
+	if got := mustGet(t, r, "a"); string(got.Value) != "1" { t.Errorf("a: %q", got.Value) }
+	if got := mustGet(t, r, "b"); string(got.Value) != "2" || got.Version != lsn { t.Errorf("b: %q versión %d", got.Value, got.Version) }
+}
+
+// TestRecoverAfterRetention: Con un motor sin snapshot.bin la recuperación necesita el WAL
+// completo. Si la retención borró los segmentos cubiertos falla indicando -wal-archive-dir; si
+// los archivó, se recupera con ellos.
+func TestRecoverAfterRetention(t *testing.T) {
+	for _, archived := range []bool{false, true} {
+		t.Run(fmt.Sprintf("archivo=%v", archived), func(t *testing.T) {
+			opts := testStoreOptions(t.TempDir(), "btree")
+			if archived { opts.retention.archiveDir = t.TempDir() }
+			if warning := opts.retention.pitrWarning(opts.engine); (warning == "") != archived {
+				t.Errorf("aviso al arrancar: %q", warning)
+			}
+			s := openTestServer(t, opts)
+			lsn := mustSet(t, s, "a", "1")
+			mustSet(t, s, "a", "2")
+			s.kvStore.takeSnapshot()
+			mustSet(t, s, "b", "3")
+			segments, err := listSegments(s.kvStore.walDir)
+			if err != nil || len(segments) != 1 || segments[0].start <= lsn { t.Fatalf("segmentos tras la retención: %v %v", segments, err) }
+
+			out := storeOptions{dataDir: filepath.Join(t.TempDir(), "recuperado"), engine: "memory", cacheSize: conformanceCacheSize}
+			err = recoverToPoint(opts.dataDir, "", opts.retention.archiveDir, recoveryTarget{lsn: lsn}, out)
+			if !archived {
+				if err == nil || !strings.Contains(err.Error(), "-wal-archive-dir") { t.Fatalf("recoverToPoint sin archivo: %v", err) }
+				return
+			}
+			if err != nil { t.Fatalf("recoverToPoint: %v", err) }
+			r := newTestServer(t, out.dataDir)
+			if got := mustGet(t, r, "a"); string(got.Value) != "1" || got.Version != lsn { t.Errorf("a: %q versión %d", got.Value, got.Version) }
+			if got := mustGet(t, r, "b"); got.Found { t.Error("se recuperó una escritura posterior al punto pedido") }
+		})
+	}
+	if warning := (retentionPolicy{}).pitrWarning("memory"); warning != "" { t.Errorf("aviso con el motor memory: %q", warning) }
+}
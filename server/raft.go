@@ -0,0 +1,1034 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pb "asignacionservidor/proto/keyval"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// ---- Consenso Raft ---- //
+//
+// Con -raft-peers, los nodos del clúster replican las escrituras con Raft. El log de Raft es
+// el propio WAL: cada registro lleva el mandato en el que se creó (ver wal.go) y su LSN es el
+// índice de la entrada. El líder registra cada escritura en su WAL, la envía a los seguidores
+// con AppendEntries y, cuando la tiene la mayoría, la da por confirmada. Todos los nodos
+// aplican a su motor las entradas confirmadas, en orden, con una única rutina (runApplier).
+//
+// En el líder, quien escribe mantiene los candados de sus shards desde que evalúa la petición
+// hasta que su entrada se aplica; así un CompareAndSet o una Txn nunca se evalúan sobre un
+// estado al que le falte una escritura anterior de las mismas claves. Por eso un líder recién
+// elegido no acepta escrituras hasta aplicar la entrada vacía con la que abre su mandato, que
+// confirma también todas las anteriores.
+//
+// Los snapshots de takeSnapshot compactan el log: cubren hasta la última entrada aplicada y la
+// retención borra los segmentos que cubren. Un seguidor que necesita entradas ya borradas
+// recibe con InstallSnapshot la misma imagen que genera Backup.
+//
+// Se persisten el mandato y el voto (raft.state) y el mandato de la última entrada que cubre
+// el punto de control del motor (raft.snap). El índice confirmado no se persiste: al arrancar
+// solo se da por aplicado el punto de control, y el resto del WAL se aplica cuando el líder
+// vuelve a confirmarlo.
+
+const (
+	raftHeartbeat = 50 * time.Millisecond
+	// raftElectionTimeout: Sin noticias del líder durante un tiempo aleatorio entre este y el
+	// doble, un seguidor se presenta a líder. Un líder que en ese tiempo no oye a la mayoría
+	// deja de serlo.
+	raftElectionTimeout = 300 * time.Millisecond
+	raftRPCTimeout      = 2 * time.Second
+	// raftCacheEntries: Entradas recientes del log que se guardan en memoria para enviarlas
+	// y aplicarlas sin leer el WAL.
+	raftCacheEntries = 4096
+	raftStateFile    = "raft.state"
+	raftSnapFile     = "raft.snap"
+	raftFileMagic    = "KVRAFT\x00\x01"
+)
+
+type raftRole int
+
+const (
+	raftFollower raftRole = iota
+	raftCandidate
+	raftLeader
+)
+
+func (r raftRole) String() string {
+	switch r {
+	case raftLeader:
+		return "líder"
+	case raftCandidate:
+		return "candidato"
+	}
+	return "seguidor"
+}
+
+// errNotLeader: La escritura llegó a un nodo que ya no es el líder. Nada se registró.
+var errNotLeader = errors.New("este nodo no es el líder del clúster")
+
+// errLeadershipLost: El líder perdió el mandato con la escritura ya en su log: el nuevo líder
+// puede confirmarla o descartarla.
+var errLeadershipLost = errors.New("el líder perdió el mandato antes de confirmar la escritura; puede haberse aplicado o no")
+
+// raftConfig: Miembros del clúster. self es la dirección de este nodo, tal como la ven los demás.
+type raftConfig struct {
+	self  string
+	peers []string // Los demás miembros.
+}
+
+// parseRaftPeers: Interpreta -raft-peers y -raft-self. Sin -raft-self, este nodo es localhost
+// con el puerto de -addr, lo habitual al probar un clúster de procesos locales.
+func parseRaftPeers(members, self, listenAddr string) (*raftConfig, error) {
+	if self == "" {
+		var err error
+		if self, err = localAddr(listenAddr); err != nil { return nil, err }
+	}
+	cfg := &raftConfig{self: self}
+	found := false
+	for _, addr := range strings.Split(members, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" { continue }
+		if addr == self {
+			found = true
+			continue
+		}
+		cfg.peers = append(cfg.peers, addr)
+	}
+	if !found { return nil, fmt.Errorf("-raft-peers debe incluir a este nodo (%s); indíquelo con -raft-self si usa otra dirección", self) }
+	return cfg, nil
+}
+
+// localAddr: localhost con el puerto de -addr.
+func localAddr(listenAddr string) (string, error) {
+	_, port, err := net.SplitHostPort(listenAddr)
+	if err != nil { return "", fmt.Errorf("-addr inválida: %w", err) }
+	return net.JoinHostPort("localhost", port), nil
+}
+
+// termRun: Desde la entrada start, las entradas del log son del mandato term (hasta el
+// siguiente tramo). Los mandatos cambian poco, así que unos pocos tramos describen todo el log.
+type termRun struct {
+	start, term uint64
+}
+
+// raftPeer: Otro miembro del clúster, visto desde este nodo.
+type raftPeer struct {
+	addr   string
+	conn   *grpc.ClientConn
+	client pb.RaftServiceClient
+	// Progreso de la replicación cuando este nodo es el líder (protegidos por raftNode.mu).
+	next, match uint64
+	lastAck     time.Time // Envío de la última petición que respondió en el mandato actual.
+	wake        chan struct{} // Hay entradas nuevas o un índice confirmado nuevo que enviarle.
+}
+
+// raftNode: Estado de Raft de este nodo.
+type raftNode struct {
+	store *ShardedStore
+	self  string
+	peers []*raftPeer
+
+	// mu protege el estado de Raft. Orden de los candados: applyMu, mu, snapshotMutex y
+	// candados de shards, candados del WAL, logMu.
+	mu       sync.Mutex
+	role     raftRole
+	term     uint64
+	votedFor string
+	leader   string
+	commit   uint64
+	deadline time.Time // Plazo de la elección (seguidor o candidato).
+	noop     uint64    // Entrada vacía del mandato del líder; ready cuando está aplicada.
+	ready    bool
+	since    time.Time // Inicio del mandato como líder.
+	// changed se cierra (y se sustituye) en cada cambio de rol, de líder o de ready.
+	changed chan struct{}
+
+	applied atomic.Uint64 // Última entrada aplicada al motor.
+	written atomic.Uint64 // Última entrada escrita en el WAL local.
+
+	// logMu protege los tramos de mandatos, la caché de entradas recientes y el punto de
+	// control del log. No se toma ningún otro candado mientras se tiene.
+	logMu     sync.Mutex
+	runs      []termRun
+	recent    []walRecord
+	snapIndex uint64
+	snapTerm  uint64
+
+	applyMu   sync.Mutex    // Lo toma la rutina que aplica entradas, y la instalación de una imagen.
+	applyWake chan struct{} // Hay entradas confirmadas sin aplicar.
+	writeWake chan struct{} // El WAL local avanzó (el líder recalcula el índice confirmado).
+	stopped   chan struct{} // Se cierra en stop.
+	// ctx se cancela en stop y corta las peticiones en curso a otros nodos; routines cuenta las
+	// rutinas del nodo, a las que stop espera.
+	ctx      context.Context
+	cancel   context.CancelFunc
+	routines sync.WaitGroup
+}
+
+// newRaftNode: Prepara el nodo y carga su estado persistido. Las rutinas arrancan con start,
+// una vez recuperado el almacén.
+func newRaftNode(store *ShardedStore, cfg raftConfig) (*raftNode, error) {
+	n := &raftNode{
+		store:     store,
+		self:      cfg.self,
+		changed:   make(chan struct{}),
+		applyWake: make(chan struct{}, 1),
+		writeWake: make(chan struct{}, 1),
+		stopped:   make(chan struct{}),
+	}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	for _, addr := range cfg.peers {
+		conn, err := grpc.Dial(addr,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultCallOptions(grpc.MaxCallSendMsgSize(maxWALRecordSize+replicationBatchSize)),
+			// Sin esto, tras una caída el líder tardaría en reconectar (la espera de gRPC crece
+			// hasta minutos) y el nodo, sin latidos, forzaría elecciones al volver.
+			grpc.WithConnectParams(grpc.ConnectParams{
+				Backoff:           backoff.Config{BaseDelay: raftHeartbeat, Multiplier: 1.6, Jitter: 0.2, MaxDelay: 2 * raftHeartbeat},
+				MinConnectTimeout: raftRPCTimeout,
+			}),
+		)
+		if err != nil { return nil, err }
+		n.peers = append(n.peers, &raftPeer{addr: addr, conn: conn, client: pb.NewRaftServiceClient(conn), wake: make(chan struct{}, 1)})
+	}
+	payload, err := readRaftFile(store.dataDir, raftStateFile)
+	if err != nil { return nil, err }
+	if payload != nil {
+		p := &payloadReader{buf: payload}
+		n.term, n.votedFor = p.uvarint(), string(p.bytes())
+		if p.err != nil { return nil, fmt.Errorf("%s: %w", raftStateFile, p.err) }
+	}
+	payload, err = readRaftFile(store.dataDir, raftSnapFile)
+	if err != nil { return nil, err }
+	if payload != nil {
+		p := &payloadReader{buf: payload}
+		n.snapIndex, n.snapTerm = p.uvarint(), p.uvarint()
+		if p.err != nil { return nil, fmt.Errorf("%s: %w", raftSnapFile, p.err) }
+	}
+	return n, nil
+}
+
+// writeRaftFile: Reemplaza de forma atómica un archivo de estado de Raft: cabecera mágica y
+// un bloque con marco.
+func writeRaftFile(dir, name string, payload []byte) error {
+	path := filepath.Join(dir, name)
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil { return err }
+	_, err = file.Write(appendFrame([]byte(raftFileMagic), payload))
+	if err == nil { err = file.Sync() }
+	if closeErr := file.Close(); err == nil { err = closeErr }
+	if err == nil { err = os.Rename(path+".tmp", path) }
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return syncDir(dir)
+}
+
+// readRaftFile: Lee el contenido de un archivo de estado de Raft (nil si no existe).
+func readRaftFile(dir, name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) { return nil, nil }
+	if err != nil { return nil, err }
+	if !bytes.HasPrefix(data, []byte(raftFileMagic)) { return nil, fmt.Errorf("%s: cabecera inválida", name) }
+	payload, _, err := readFrame(bufio.NewReader(bytes.NewReader(data[len(raftFileMagic):])))
+	if err != nil { return nil, fmt.Errorf("%s: %w", name, err) }
+	return payload, nil
+}
+
+// persistLocked: Hace durables el mandato y el voto antes de actuar según ellos.
+// El llamador debe tener tomado mu.
+func (n *raftNode) persistLocked() {
+	payload := binary.AppendUvarint(nil, n.term)
+	payload = binary.AppendUvarint(payload, uint64(len(n.votedFor)))
+	payload = append(payload, n.votedFor...)
+	// Sin el voto en disco, un reinicio podría votar dos veces en el mismo mandato.
+	if err := writeRaftFile(n.store.dataDir, raftStateFile, payload); err != nil { log.Fatalf("No se pudo guardar el estado de Raft: %v", err) }
+}
+
+// ---- Log ---- //
+
+// observe: Anota una entrada leída del WAL al recuperar. Un salto de LSN (tras una restauración)
+// invalida los tramos anteriores.
+func (n *raftNode) observe(rec walRecord) {
+	n.logMu.Lock()
+	defer n.logMu.Unlock()
+	if len(n.recent) > 0 && rec.revision != n.recent[len(n.recent)-1].revision+1 { n.runs, n.recent = nil, nil }
+	n.appendLogLocked(rec)
+}
+
+// appendLog: Anota entradas recién añadidas al WAL (y todavía sin escribir, en el líder).
+func (n *raftNode) appendLog(records ...walRecord) {
+	n.logMu.Lock()
+	defer n.logMu.Unlock()
+	for _, rec := range records { n.appendLogLocked(rec) }
+}
+
+func (n *raftNode) appendLogLocked(rec walRecord) {
+	if len(n.runs) == 0 || n.runs[len(n.runs)-1].term != rec.term { n.runs = append(n.runs, termRun{start: rec.revision, term: rec.term}) }
+	n.recent = append(n.recent, rec)
+	if excess := len(n.recent) - raftCacheEntries; excess > 0 { n.recent = append([]walRecord(nil), n.recent[excess:]...) }
+}
+
+// truncateLogCache: Olvida las entradas desde from, que se acaban de borrar del WAL.
+func (n *raftNode) truncateLogCache(from uint64) {
+	n.logMu.Lock()
+	defer n.logMu.Unlock()
+	for len(n.runs) > 0 && n.runs[len(n.runs)-1].start >= from { n.runs = n.runs[:len(n.runs)-1] }
+	for len(n.recent) > 0 && n.recent[len(n.recent)-1].revision >= from { n.recent = n.recent[:len(n.recent)-1] }
+}
+
+// termAt: Mandato de la entrada index, que debe estar en el log o ser la del punto de control.
+// Devuelve false si la entrada ya no se conoce (se compactó).
+func (n *raftNode) termAt(index uint64) (uint64, bool) {
+	n.logMu.Lock()
+	defer n.logMu.Unlock()
+	if index == 0 { return 0, true }
+	if index == n.snapIndex { return n.snapTerm, true }
+	i := sort.Search(len(n.runs), func(i int) bool { return n.runs[i].start > index })
+	if i == 0 { return 0, false }
+	return n.runs[i-1].term, true
+}
+
+// lastLog: Índice y mandato de la última entrada del log.
+func (n *raftNode) lastLog() (uint64, uint64) {
+	last := n.store.lastRevision()
+	term, _ := n.termAt(last)
+	return last, term
+}
+
+// entries: Entradas del log desde from hasta to (incluidas), hasta unos maxBytes. Las recientes
+// salen de la caché; las demás, de los segmentos del WAL (errReplicaBehind si ya no están).
+func (n *raftNode) entries(from, to uint64, maxBytes int) ([]walRecord, error) {
+	size := 0
+	full := func(rec walRecord) bool {
+		for _, o := range rec.ops { size += len(o.key) + len(o.value) + 16 }
+		return size > maxBytes
+	}
+	n.logMu.Lock()
+	if len(n.recent) > 0 && from >= n.recent[0].revision && to <= n.recent[len(n.recent)-1].revision {
+		var records []walRecord
+		for _, rec := range n.recent[from-n.recent[0].revision:] {
+			if rec.revision > to { break }
+			records = append(records, rec)
+			if full(rec) { break }
+		}
+		n.logMu.Unlock()
+		return records, nil
+	}
+	n.logMu.Unlock()
+	var records []walRecord
+	err := n.store.scanWAL(from, to, func(rec walRecord) error {
+		records = append(records, rec)
+		if full(rec) { return errStopReplay }
+		return nil
+	})
+	return records, err
+}
+
+// compacted: El motor hizo durable un punto de control hasta lsn. Su mandato se guarda antes
+// de que la retención borre los segmentos con esa entrada.
+func (n *raftNode) compacted(lsn uint64) {
+	term, ok := n.termAt(lsn)
+	if !ok { return }
+	n.logMu.Lock()
+	defer n.logMu.Unlock()
+	payload := binary.AppendUvarint(nil, lsn)
+	payload = binary.AppendUvarint(payload, term)
+	if err := writeRaftFile(n.store.dataDir, raftSnapFile, payload); err != nil { log.Fatalf("No se pudo guardar el punto de control de Raft: %v", err) }
+	n.snapIndex, n.snapTerm = lsn, term
+}
+
+// recovered: Termina el arranque: el punto de control del motor es lo único aplicado.
+func (n *raftNode) recovered(checkpointLSN uint64) {
+	// Una caída justo después de instalar una imagen puede dejar sin guardar su mandato. El nodo
+	// sigue funcionando: como mucho pierde elecciones o el líder le reenvía la imagen.
+	if _, ok := n.termAt(checkpointLSN); !ok {
+		log.Printf("ADVERTENCIA: no se conoce el mandato de la LSN %d del punto de control.", checkpointLSN)
+	}
+	n.applied.Store(checkpointLSN)
+	n.written.Store(n.store.revision)
+	n.commit = checkpointLSN
+}
+
+// ---- Roles y elecciones ---- //
+
+// start: Arranca las rutinas del nodo.
+func (n *raftNode) start() {
+	n.mu.Lock()
+	n.resetDeadlineLocked()
+	n.mu.Unlock()
+	n.routines.Add(3)
+	go n.runTicker()
+	go n.runApplier()
+	go n.runCommitter()
+}
+
+// stop: Detiene el nodo (al cerrar el almacén): cancela las peticiones en curso, espera a que
+// terminen sus rutinas y cierra las conexiones con los demás. Si era el líder deja de serlo,
+// así fallan las escrituras que esperaban confirmación. Su estado persistido solo cambia ya si
+// recibe mensajes de otro nodo, así que el servidor gRPC debe haberse detenido antes.
+func (n *raftNode) stop() {
+	close(n.stopped)
+	n.cancel()
+	n.routines.Wait()
+	n.mu.Lock()
+	if n.role == raftLeader { n.becomeFollowerLocked(n.term, "") }
+	n.mu.Unlock()
+	for _, p := range n.peers { p.conn.Close() }
+}
+
+// halted: Indica si ya se llamó a stop.
+func (n *raftNode) halted() bool {
+	select {
+	case <-n.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+func (n *raftNode) resetDeadlineLocked() {
+	n.deadline = time.Now().Add(raftElectionTimeout + time.Duration(rand.Int63n(int64(raftElectionTimeout))))
+}
+
+func (n *raftNode) notifyLocked() {
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+// majority: Votos o copias necesarios en un clúster con los miembros actuales.
+func (n *raftNode) majority() int { return (len(n.peers)+1)/2 + 1 }
+
+// runTicker: Vigila los plazos: un seguidor sin noticias del líder se presenta a líder, y un
+// líder que no oye a la mayoría deja de serlo.
+func (n *raftNode) runTicker() {
+	defer n.routines.Done()
+	ticker := time.NewTicker(raftHeartbeat / 5)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case <-n.stopped:
+			return
+		case now = <-ticker.C:
+		}
+		n.mu.Lock()
+		switch {
+		case n.halted():
+		case n.role == raftLeader:
+			heard := 1
+			for _, p := range n.peers {
+				if now.Sub(p.lastAck) < raftElectionTimeout { heard++ }
+			}
+			if heard < n.majority() && now.Sub(n.since) > raftElectionTimeout {
+				log.Printf("Raft: el líder no oye a la mayoría del clúster en el mandato %d; deja de serlo.", n.term)
+				n.becomeFollowerLocked(n.term, "")
+			}
+		case now.After(n.deadline):
+			n.startElectionLocked()
+		}
+		n.mu.Unlock()
+	}
+}
+
+// becomeFollowerLocked: Pasa a seguidor en el mandato term (si es mayor que el actual se
+// olvida el voto). Un líder que deja de serlo hace fallar las escrituras que esperaban.
+func (n *raftNode) becomeFollowerLocked(term uint64, leader string) {
+	if term > n.term {
+		n.term, n.votedFor = term, ""
+		n.persistLocked()
+	}
+	wasLeader := n.role == raftLeader
+	if n.role != raftFollower || n.leader != leader {
+		n.role, n.leader, n.ready = raftFollower, leader, false
+		n.notifyLocked()
+	}
+	n.resetDeadlineLocked()
+	if wasLeader { n.store.revokeLeadership() }
+}
+
+// startElectionLocked: Se presenta a líder en un mandato nuevo y pide el voto a los demás.
+func (n *raftNode) startElectionLocked() {
+	n.term++
+	n.role, n.votedFor, n.leader = raftCandidate, n.self, ""
+	n.persistLocked()
+	n.resetDeadlineLocked()
+	n.notifyLocked()
+	term := n.term
+	lastIndex, lastTerm := n.lastLog()
+	log.Printf("Raft: elección en el mandato %d (última entrada %d del mandato %d).", term, lastIndex, lastTerm)
+
+	votes := 1
+	if votes >= n.majority() {
+		n.becomeLeaderLocked()
+		return
+	}
+	req := &pb.VoteRequest{Term: term, Candidate: n.self, LastLogIndex: lastIndex, LastLogTerm: lastTerm}
+	for _, p := range n.peers {
+		n.routines.Add(1)
+		go func(p *raftPeer) {
+			defer n.routines.Done()
+			ctx, cancel := context.WithTimeout(n.ctx, raftElectionTimeout)
+			defer cancel()
+			resp, err := p.client.RequestVote(ctx, req)
+			if err != nil { return }
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if n.halted() { return }
+			if resp.Term > n.term {
+				n.becomeFollowerLocked(resp.Term, "")
+				return
+			}
+			if !resp.Granted || n.role != raftCandidate || n.term != term { return }
+			votes++
+			if votes >= n.majority() { n.becomeLeaderLocked() }
+		}(p)
+	}
+}
+
+// becomeLeaderLocked: Asume el mandato actual. Registra la entrada vacía que lo abre y empieza
+// a replicar; las escrituras se aceptan cuando esa entrada se aplica.
+func (n *raftNode) becomeLeaderLocked() {
+	lastIndex, _ := n.lastLog()
+	n.role, n.leader, n.ready, n.since = raftLeader, n.self, false, time.Now()
+	for _, p := range n.peers { p.next, p.match, p.lastAck = lastIndex+1, 0, time.Now() }
+	n.noop = n.store.appendNoop(n.term)
+	n.notifyLocked()
+	log.Printf("Raft: elegido líder del mandato %d.", n.term)
+	for _, p := range n.peers {
+		n.routines.Add(1)
+		go n.replicate(p, n.term)
+	}
+}
+
+// handleVote: Concede el voto si no lo dio ya en este mandato a otro candidato y el log del
+// candidato está al menos tan al día como el propio.
+func (n *raftNode) handleVote(req *pb.VoteRequest) *pb.VoteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if req.Term < n.term { return &pb.VoteResponse{Term: n.term} }
+	if req.Term > n.term { n.becomeFollowerLocked(req.Term, "") }
+	lastIndex, lastTerm := n.lastLog()
+	upToDate := req.LastLogTerm > lastTerm || (req.LastLogTerm == lastTerm && req.LastLogIndex >= lastIndex)
+	if (n.votedFor != "" && n.votedFor != req.Candidate) || !upToDate { return &pb.VoteResponse{Term: n.term} }
+	if n.votedFor == "" {
+		n.votedFor = req.Candidate
+		n.persistLocked()
+	}
+	n.resetDeadlineLocked()
+	return &pb.VoteResponse{Term: n.term, Granted: true}
+}
+
+// ---- Líder: replicación ---- //
+
+// onWritten: La rutina del group commit escribió el WAL local hasta last.
+func (n *raftNode) onWritten(last uint64) {
+	n.written.Store(last)
+	select {
+	case n.writeWake <- struct{}{}:
+	default:
+	}
+}
+
+// runCommitter: En el líder, recalcula el índice confirmado cuando avanza el WAL local y avisa
+// a los seguidores de que hay entradas nuevas.
+func (n *raftNode) runCommitter() {
+	defer n.routines.Done()
+	for {
+		select {
+		case <-n.stopped:
+			return
+		case <-n.writeWake:
+		}
+		n.mu.Lock()
+		if n.role == raftLeader {
+			n.advanceCommitLocked()
+			for _, p := range n.peers { wakePeer(p) }
+		}
+		n.mu.Unlock()
+	}
+}
+
+func wakePeer(p *raftPeer) {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (n *raftNode) wakeApplier() {
+	select {
+	case n.applyWake <- struct{}{}:
+	default:
+	}
+}
+
+// advanceCommitLocked: La mayor entrada que ya está en la mayoría de los WAL queda confirmada,
+// si es del mandato actual (las de mandatos anteriores se confirman con ella).
+func (n *raftNode) advanceCommitLocked() {
+	matches := []uint64{n.written.Load()}
+	for _, p := range n.peers { matches = append(matches, p.match) }
+	sort.Slice(matches, func(i, j int) bool { return matches[i] > matches[j] })
+	index := matches[n.majority()-1]
+	if index <= n.commit { return }
+	if term, ok := n.termAt(index); !ok || term != n.term { return }
+	n.commit = index
+	n.wakeApplier()
+	for _, p := range n.peers { wakePeer(p) }
+}
+
+// replicate: Mientras dure el mandato, mantiene al día a un seguidor: le envía las entradas que
+// le faltan (o una imagen si ya se compactaron) y, sin nada nuevo, un latido cada raftHeartbeat.
+func (n *raftNode) replicate(p *raftPeer, term uint64) {
+	defer n.routines.Done()
+	heartbeat := time.NewTicker(raftHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		n.mu.Lock()
+		if n.role != raftLeader || n.term != term || n.halted() {
+			n.mu.Unlock()
+			return
+		}
+		next, commit := p.next, n.commit
+		n.mu.Unlock()
+
+		last := n.written.Load()
+		err := n.sendEntries(p, term, next, last, commit)
+		if errors.Is(err, errReplicaBehind) { err = n.sendSnapshot(p, term) }
+		n.mu.Lock()
+		pending := p.next <= last
+		n.mu.Unlock()
+		if err == nil && pending { continue }
+		select {
+		case <-n.stopped:
+			return
+		case <-p.wake:
+		case <-heartbeat.C:
+		}
+	}
+}
+
+// sendEntries: Un AppendEntries con las entradas desde next (puede ir vacío: un latido).
+func (n *raftNode) sendEntries(p *raftPeer, term, next, last, commit uint64) error {
+	prevTerm, ok := n.termAt(next - 1)
+	if !ok { return errReplicaBehind }
+	var frames []byte
+	if next <= last {
+		records, err := n.entries(next, last, replicationBatchSize)
+		if err != nil { return err }
+		for _, rec := range records { frames = append(frames, encodeWALRecord(rec, n.store.compression)...) }
+	}
+	req := &pb.AppendEntriesRequest{
+		Term: term, Leader: n.self, PrevLogIndex: next - 1, PrevLogTerm: prevTerm,
+		Entries: frames, Compression: uint32(n.store.compression), LeaderCommit: commit,
+	}
+	ctx, cancel := context.WithTimeout(n.ctx, raftRPCTimeout)
+	defer cancel()
+	sent := time.Now()
+	resp, err := p.client.AppendEntries(ctx, req)
+	if err != nil { return err }
+	n.handleResponse(p, term, sent, resp)
+	return nil
+}
+
+// sendSnapshot: Envía a un seguidor una imagen del almacén hasta la última entrada aplicada.
+func (n *raftNode) sendSnapshot(p *raftPeer, term uint64) error {
+	ctx, cancel := context.WithCancel(n.ctx)
+	defer cancel()
+	sent := time.Now()
+	stream, err := p.client.InstallSnapshot(ctx)
+	if err != nil { return err }
+	log.Printf("Raft: %s necesita entradas ya compactadas; se le envía una imagen completa.", p.addr)
+	w := &chunkWriter{send: func(c *pb.BackupChunk) error {
+		req := &pb.InstallSnapshotRequest{Term: term, Leader: n.self, Chunk: c}
+		if c.Lsn != 0 {
+			lastTerm, ok := n.termAt(c.Lsn)
+			if !ok { return fmt.Errorf("no se conoce el mandato de la LSN %d", c.Lsn) }
+			req.LastIncludedTerm = lastTerm
+		}
+		return stream.Send(req)
+	}}
+	if _, err := n.store.writeBackup(w); err != nil { return err }
+	resp, err := stream.CloseAndRecv()
+	if err != nil { return err }
+	n.handleResponse(p, term, sent, resp)
+	return nil
+}
+
+// handleResponse: Actualiza el progreso del seguidor con su respuesta a la petición enviada en sent.
+func (n *raftNode) handleResponse(p *raftPeer, term uint64, sent time.Time, resp *pb.AppendEntriesResponse) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if resp.Term > n.term {
+		n.becomeFollowerLocked(resp.Term, "")
+		return
+	}
+	if n.role != raftLeader || n.term != term { return }
+	if sent.After(p.lastAck) { p.lastAck = sent }
+	if resp.Success {
+		if resp.LastIndex > p.match { p.match = resp.LastIndex }
+		p.next = p.match + 1
+		n.advanceCommitLocked()
+		return
+	}
+	// El log del seguidor no coincide en next-1: se retrocede hasta su pista.
+	p.next = max(min(resp.LastIndex+1, p.next-1), p.match+1, 1)
+}
+
+// ---- Seguidor ---- //
+
+// handleAppend: Comprueba que el log coincide con el del líder en prev_log_index, descarta las
+// entradas propias que contradicen las recibidas y añade las nuevas a su WAL.
+func (n *raftNode) handleAppend(req *pb.AppendEntriesRequest) (*pb.AppendEntriesResponse, error) {
+	codec := compressionCodec(req.Compression)
+	if codec != compressionNone && codec != compressionZstd { return nil, status.Errorf(codes.InvalidArgument, "códec de compresión desconocido %d", codec) }
+	var records []walRecord
+	r := bufio.NewReader(bytes.NewReader(req.Entries))
+	for {
+		payload, _, err := readFrame(r)
+		if err == io.EOF { break }
+		if err != nil { return nil, status.Errorf(codes.InvalidArgument, "%v", err) }
+		rec, err := decodeWALPayload(payload, codec)
+		if err != nil { return nil, status.Errorf(codes.InvalidArgument, "%v", err) }
+		if rec.revision != req.PrevLogIndex+uint64(len(records))+1 { return nil, status.Errorf(codes.InvalidArgument, "entradas no consecutivas") }
+		records = append(records, rec)
+	}
+
+	matched := req.PrevLogIndex + uint64(len(records))
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if req.Term < n.term { return &pb.AppendEntriesResponse{Term: n.term}, nil }
+	n.becomeFollowerLocked(req.Term, req.Leader)
+
+	last := n.store.lastRevision()
+	if req.PrevLogIndex > last { return &pb.AppendEntriesResponse{Term: n.term, LastIndex: last}, nil }
+	// Las entradas confirmadas coinciden con las del líder; las demás se comparan por mandato.
+	if req.PrevLogIndex > n.commit {
+		if term, ok := n.termAt(req.PrevLogIndex); !ok || term != req.PrevLogTerm {
+			return &pb.AppendEntriesResponse{Term: n.term, LastIndex: n.commit}, nil
+		}
+	}
+	for len(records) > 0 && records[0].revision <= last {
+		rec := records[0]
+		if rec.revision > n.commit {
+			if term, ok := n.termAt(rec.revision); !ok || term != rec.term {
+				log.Printf("Raft: se descartan las entradas desde la %d, que no coinciden con las del líder.", rec.revision)
+				if err := n.store.truncateLog(rec.revision); err != nil { log.Fatalf("No se pudo recortar el WAL: %v", err) }
+				n.truncateLogCache(rec.revision)
+				break
+			}
+		}
+		records = records[1:]
+	}
+	if len(records) > 0 {
+		if err := n.store.logReplicated(records); err != nil { return nil, status.Errorf(codes.Internal, "fallo al persistir las entradas: %v", err) }
+		n.appendLog(records...)
+	}
+	if commit := min(req.LeaderCommit, matched); commit > n.commit {
+		n.commit = commit
+		n.wakeApplier()
+	}
+	n.store.lag.heard(req.LeaderCommit, n.applied.Load())
+	return &pb.AppendEntriesResponse{Term: n.term, Success: true, LastIndex: matched}, nil
+}
+
+// handleInstall: Sustituye el estado del seguidor por la imagen que le envía el líder, salvo
+// que su log ya contenga la última entrada que cubre.
+func (n *raftNode) handleInstall(stream pb.RaftService_InstallSnapshotServer) error {
+	first, err := stream.Recv()
+	if err != nil { return err }
+	n.mu.Lock()
+	if first.Term < n.term {
+		resp := &pb.AppendEntriesResponse{Term: n.term}
+		n.mu.Unlock()
+		return stream.SendAndClose(resp)
+	}
+	n.becomeFollowerLocked(first.Term, first.Leader)
+	n.mu.Unlock()
+
+	// La imagen se recibe y se valida entera antes de tocar nada.
+	chunks := &chunkReader{buf: first.Chunk.GetData(), recv: func() (*pb.BackupChunk, error) {
+		req, err := stream.Recv()
+		if err != nil { return nil, err }
+		return req.Chunk, nil
+	}}
+	image, timestamp, err := n.store.readImage(bufio.NewReaderSize(chunks, snapshotChunkSize))
+	if errors.Is(err, errBackupCorrupt) { return status.Errorf(codes.InvalidArgument, "%v", err) }
+	if err != nil { return status.Errorf(codes.Internal, "no se pudo recibir la imagen: %v", err) }
+	// Si al final no se instala, la imagen se descarta.
+	defer func() {
+		if image != nil { image.discard() }
+	}()
+	index, lastTerm := image.revision, first.LastIncludedTerm
+	if index != first.Chunk.GetLsn() { return status.Errorf(codes.InvalidArgument, "%v", errBackupCorrupt) }
+
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if first.Term < n.term { return stream.SendAndClose(&pb.AppendEntriesResponse{Term: n.term}) }
+	n.resetDeadlineLocked()
+	reply := &pb.AppendEntriesResponse{Term: n.term, Success: true, LastIndex: index}
+	applied := n.applied.Load()
+	if index <= applied { return stream.SendAndClose(reply) }
+	if term, ok := n.termAt(index); ok && term == lastTerm && index <= n.store.lastRevision() {
+		// El log ya contiene la última entrada de la imagen: basta con aplicarlo hasta ella.
+		n.commit = max(n.commit, index)
+		n.wakeApplier()
+		return stream.SendAndClose(reply)
+	}
+
+	// El resto del log no sirve: se descarta y el estado pasa a ser el de la imagen.
+	if err := n.store.truncateLog(applied + 1); err != nil { log.Fatalf("No se pudo recortar el WAL: %v", err) }
+	n.logMu.Lock()
+	n.runs, n.recent = nil, nil
+	n.snapIndex, n.snapTerm = index, lastTerm
+	n.logMu.Unlock()
+	restored := image
+	image = nil // installRestored se encarga de ella.
+	if err := n.store.installRestored(restored, timestamp, true); err != nil {
+		// El motor pudo quedar a medias entre el estado anterior y la imagen.
+		log.Fatalf("No se pudo instalar la imagen recibida del líder: %v", err)
+	}
+	n.applied.Store(index)
+	n.written.Store(index)
+	n.commit = max(n.commit, index)
+	log.Printf("Raft: estado sustituido por una imagen del líder %s (LSN %d, mandato %d).", first.Leader, index, lastTerm)
+	return stream.SendAndClose(reply)
+}
+
+// ---- Aplicación de las entradas confirmadas ---- //
+
+// runApplier: Aplica al motor, en orden, las entradas confirmadas.
+func (n *raftNode) runApplier() {
+	defer n.routines.Done()
+	for {
+		select {
+		case <-n.stopped:
+			return
+		case <-n.applyWake:
+		}
+		n.applyMu.Lock()
+		for {
+			n.mu.Lock()
+			commit := n.commit
+			n.mu.Unlock()
+			applied := n.applied.Load()
+			if applied >= commit { break }
+			records, err := n.entries(applied+1, commit, replicationBatchSize)
+			// Una entrada confirmada que no se puede leer dejaría el motor atrasado para siempre.
+			if err != nil { log.Fatalf("No se pudieron leer las entradas %d a %d del WAL: %v", applied+1, commit, err) }
+			for _, rec := range records { n.store.applyCommitted(rec) }
+			n.store.lag.advanced(n.applied.Load())
+		}
+		n.mu.Lock()
+		if n.role == raftLeader && !n.ready && n.applied.Load() >= n.noop {
+			n.ready = true
+			n.store.grantLeadership(n.term)
+			n.notifyLocked()
+			log.Printf("Raft: el líder acepta escrituras desde la LSN %d.", n.noop)
+		}
+		n.mu.Unlock()
+		n.applyMu.Unlock()
+	}
+}
+
+// applyCommitted: Aplica al motor una entrada confirmada y la publica a los watchers. Si la
+// registró una escritura de este líder, esa escritura tiene tomados los candados de sus
+// shards y recibe aquí el aviso; si no, se toman ahora.
+func (s *ShardedStore) applyCommitted(rec walRecord) {
+	s.walMutex.Lock()
+	owner := s.raftOwners[rec.revision]
+	delete(s.raftOwners, rec.revision)
+	s.walMutex.Unlock()
+	unlock := func() {}
+	if owner == nil {
+		keys := make([]string, len(rec.ops))
+		for i, o := range rec.ops { keys[i] = o.key }
+		unlock = s.lockShards(keys)
+	}
+	for _, o := range rec.ops {
+		if o.op == opDelete {
+			s.applyDeleteLocked(o.key)
+		} else {
+			s.applyPutLocked(o.key, storeEntry{value: o.value, version: rec.revision, expiresAt: o.expiresAt})
+		}
+	}
+	s.raft.applied.Store(rec.revision)
+	unlock()
+	s.watchers.publish(rec.revision, rec.ops)
+	if owner != nil { owner.applied <- nil }
+}
+
+// appendNoop: Registra la entrada vacía con la que un líder abre su mandato y devuelve su LSN.
+func (s *ShardedStore) appendNoop(term uint64) uint64 {
+	body := encodeWALBody(nil, s.compression)
+	s.walMutex.Lock()
+	timestamp := time.Now().UnixNano()
+	s.revision++
+	c := &walCommit{revision: s.revision, mode: durabilityAlways, done: make(chan error, 1)}
+	s.walPending = appendWALRecord(s.walPending, c.revision, term, timestamp, body)
+	s.walWaiters = append(s.walWaiters, c)
+	s.raft.appendLog(walRecord{revision: c.revision, term: term, timestamp: timestamp})
+	s.walMutex.Unlock()
+	select {
+	case s.walFlush <- struct{}{}:
+	default:
+	}
+	return c.revision
+}
+
+// grantLeadership: A partir de ahora las escrituras de los clientes se registran en el mandato term.
+func (s *ShardedStore) grantLeadership(term uint64) {
+	s.walMutex.Lock()
+	defer s.walMutex.Unlock()
+	s.leaderTerm = term
+}
+
+// revokeLeadership: El nodo dejó de ser el líder: no registra más escrituras y las que
+// esperaban su confirmación fallan con errLeadershipLost.
+func (s *ShardedStore) revokeLeadership() {
+	s.walMutex.Lock()
+	defer s.walMutex.Unlock()
+	s.leaderTerm = 0
+	for revision, c := range s.raftOwners {
+		c.applied <- errLeadershipLost
+		delete(s.raftOwners, revision)
+	}
+}
+
+// ---- Clientes ---- //
+
+// writable: Espera, como mucho una elección, a que este nodo sea el líder y acepte escrituras.
+// Si el líder es otro nodo devuelve su dirección en los detalles del error.
+func (n *raftNode) writable(ctx context.Context) error {
+	timer := time.NewTimer(2 * raftElectionTimeout)
+	defer timer.Stop()
+	for {
+		n.mu.Lock()
+		ready, leader, changed := n.ready, n.leader, n.changed
+		n.mu.Unlock()
+		if ready { return nil }
+		if leader != "" && leader != n.self { return n.notLeader() }
+		// Elección en curso, o líder que aún no aplicó la entrada de su mandato.
+		select {
+		case <-changed:
+		case <-timer.C:
+			return n.notLeader()
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// notLeader: Error para una escritura que este nodo no puede aceptar: FailedPrecondition con un
+// LeaderHint si se conoce el líder (el cliente la reenvía allí), o Unavailable durante una
+// elección. En ambos casos no se registró nada y se puede reintentar.
+func (n *raftNode) notLeader() error {
+	n.mu.Lock()
+	leader, term := n.leader, n.term
+	n.mu.Unlock()
+	if leader == "" || leader == n.self {
+		return status.Errorf(codes.Unavailable, "el clúster está eligiendo líder (mandato %d); reintente en unos instantes", term)
+	}
+	return redirectTo(leader, term, "este nodo no es el líder; envíe las escrituras a %s", leader)
+}
+
+// readIndex: Prepara una lectura linealizable en el líder (ReadIndex de Raft). Anota el índice
+// confirmado, comprueba con una ronda de latidos que la mayoría aún lo reconoce como líder
+// (ningún otro pudo confirmar escrituras más nuevas) y espera a haber aplicado ese índice.
+func (n *raftNode) readIndex(ctx context.Context) error {
+	if err := n.writable(ctx); err != nil { return err }
+	n.mu.Lock()
+	index, term, start := n.commit, n.term, time.Now()
+	for _, p := range n.peers { wakePeer(p) }
+	n.mu.Unlock()
+	deadline := start.Add(raftElectionTimeout)
+	for {
+		n.mu.Lock()
+		if n.role != raftLeader || n.term != term {
+			n.mu.Unlock()
+			return n.notLeader()
+		}
+		// Solo cuentan las respuestas a peticiones enviadas después de anotar el índice.
+		heard := 1
+		for _, p := range n.peers {
+			if p.lastAck.After(start) { heard++ }
+		}
+		n.mu.Unlock()
+		if heard >= n.majority() { break }
+		if time.Now().After(deadline) { return status.Errorf(codes.Unavailable, "el líder no pudo confirmar su mandato %d con la mayoría del clúster", term) }
+		if err := ctx.Err(); err != nil { return status.FromContextError(err).Err() }
+		time.Sleep(time.Millisecond)
+	}
+	for n.applied.Load() < index {
+		if err := ctx.Err(); err != nil { return status.FromContextError(err).Err() }
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
+
+// persistenceError: Error de una escritura que no se pudo registrar. Los de Raft conservan su
+// significado para el cliente; el resto son errores internos.
+func (s *Server) persistenceError(what string, err error) error {
+	switch {
+	case errors.Is(err, errNotLeader):
+		return s.kvStore.raft.notLeader()
+	case errors.Is(err, errLeadershipLost):
+		return status.Errorf(codes.Aborted, "%v", err)
+	}
+	return status.Errorf(codes.Internal, "fallo al persistir %s: %v", what, err)
+}
+
+// status: Rol, mandato, líder, índice confirmado y última entrada aplicada, para Stat.
+func (n *raftNode) status() (raftRole, uint64, string, uint64, uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role, n.term, n.leader, n.commit, n.applied.Load()
+}
+
+// ---- Servicio gRPC entre los nodos ---- //
+
+// RaftServer: Atiende los mensajes de Raft de los demás nodos del clúster.
+type RaftServer struct {
+	pb.UnimplementedRaftServiceServer
+	node *raftNode
+}
+
+func (s *RaftServer) RequestVote(ctx context.Context, req *pb.VoteRequest) (*pb.VoteResponse, error) {
+	if s.node == nil { return nil, status.Errorf(codes.FailedPrecondition, "este servidor no forma parte de un clúster de Raft") }
+	return s.node.handleVote(req), nil
+}
+
+func (s *RaftServer) AppendEntries(ctx context.Context, req *pb.AppendEntriesRequest) (*pb.AppendEntriesResponse, error) {
+	if s.node == nil { return nil, status.Errorf(codes.FailedPrecondition, "este servidor no forma parte de un clúster de Raft") }
+	return s.node.handleAppend(req)
+}
+
+func (s *RaftServer) InstallSnapshot(stream pb.RaftService_InstallSnapshotServer) error {
+	if s.node == nil { return status.Errorf(codes.FailedPrecondition, "este servidor no forma parte de un clúster de Raft") }
+	return s.node.handleInstall(stream)
+}
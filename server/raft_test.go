@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	pb "asignacionservidor/proto/keyval"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testNetwork: Red simulada entre los nodos de una prueba: los mensajes de Raft desde o hacia
+// un nodo aislado se rechazan como si no hubiera conexión.
+type testNetwork struct {
+	mu       sync.Mutex
+	isolated map[string]bool
+}
+
+func (n *testNetwork) setIsolated(addr string, isolated bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.isolated[addr] = isolated
+}
+
+func (n *testNetwork) blocked(from, to string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.isolated[from] || n.isolated[to] { return status.Error(codes.Unavailable, "nodo aislado") }
+	return nil
+}
+
+// isolatedRaftServer: RaftServer que pasa por la red simulada.
+type isolatedRaftServer struct {
+	RaftServer
+	net  *testNetwork
+	self string
+}
+
+func (s *isolatedRaftServer) RequestVote(ctx context.Context, req *pb.VoteRequest) (*pb.VoteResponse, error) {
+	if err := s.net.blocked(req.Candidate, s.self); err != nil { return nil, err }
+	return s.RaftServer.RequestVote(ctx, req)
+}
+
+func (s *isolatedRaftServer) AppendEntries(ctx context.Context, req *pb.AppendEntriesRequest) (*pb.AppendEntriesResponse, error) {
+	if err := s.net.blocked(req.Leader, s.self); err != nil { return nil, err }
+	return s.RaftServer.AppendEntries(ctx, req)
+}
+
+func (s *isolatedRaftServer) InstallSnapshot(stream pb.RaftService_InstallSnapshotServer) error {
+	if err := s.net.blocked(s.self, s.self); err != nil { return err }
+	return s.RaftServer.InstallSnapshot(stream)
+}
+
+// startTestCluster: Arranca un clúster de Raft de size nodos con el motor engine en puertos
+// locales libres. Al terminar la prueba se detienen los servidores y después se cierran los
+// almacenes, antes de que se borren sus directorios.
+func startTestCluster(t *testing.T, size int, engine string) (map[string]*Server, *testNetwork) {
+	t.Helper()
+	network := &testNetwork{isolated: make(map[string]bool)}
+	listeners := make([]net.Listener, size)
+	var members []string
+	for i := range listeners {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil { t.Fatal(err) }
+		listeners[i] = lis
+		members = append(members, lis.Addr().String())
+	}
+	servers := make(map[string]*Server)
+	var grpcServers []*grpc.Server
+	for i, lis := range listeners {
+		self := members[i]
+		var peers []string
+		for _, m := range members {
+			if m != self { peers = append(peers, m) }
+		}
+		store, err := NewShardedStore(storeOptions{
+			dataDir:    t.TempDir(),
+			engine:     engine,
+			cacheSize:  conformanceCacheSize,
+			durability: durabilityPolicy{mode: durabilityAlways, interval: 100 * time.Millisecond},
+			raft:       &raftConfig{self: self, peers: peers},
+		})
+		if err != nil { t.Fatalf("NewShardedStore: %v", err) }
+		s := &Server{kvStore: store}
+		g := grpc.NewServer(grpc.WaitForHandlers(true))
+		pb.RegisterKeyValueServiceServer(g, s)
+		pb.RegisterRaftServiceServer(g, &isolatedRaftServer{RaftServer: RaftServer{node: store.raft}, net: network, self: self})
+		go g.Serve(lis)
+		grpcServers = append(grpcServers, g)
+		servers[self] = s
+	}
+	t.Cleanup(func() {
+		for _, g := range grpcServers { g.Stop() }
+		for addr, s := range servers {
+			if err := s.kvStore.Close(); err != nil { t.Errorf("Close de %s: %v", addr, err) }
+		}
+	})
+	return servers, network
+}
+
+// awaitLeader: Espera a que uno de los nodos no aislados sea un líder listo para escribir.
+func awaitLeader(t *testing.T, servers map[string]*Server, network *testNetwork) string {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		for addr, s := range servers {
+			if network.blocked(addr, addr) != nil { continue }
+			if _, _, self := s.upstream(); self { return addr }
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("el clúster no eligió líder")
+	return ""
+}
+
+// awaitValue: Espera a que el nodo aplique la clave con el valor dado.
+func awaitValue(t *testing.T, s *Server, key, value string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if resp, err := s.Get(context.Background(), &pb.GetRequest{Key: key}); err == nil && string(resp.Value) == value { return }
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("%s no llegó a %q", key, value)
+}
+
+// TestRaftFailover: Al aislar al líder, los otros dos nodos eligen uno nuevo que conserva las
+// escrituras confirmadas y acepta otras; el antiguo líder no puede confirmar nada por su
+// cuenta y, al volver, se pone al día.
+func TestRaftFailover(t *testing.T) {
+	servers, network := startTestCluster(t, 3, "memory")
+	leader := awaitLeader(t, servers, network)
+	mustSet(t, servers[leader], "antes", "1")
+	for _, s := range servers { awaitValue(t, s, "antes", "1") }
+
+	network.setIsolated(leader, true)
+	// Sin la mayoría, el líder aislado no confirma la escritura.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	_, err := servers[leader].Set(ctx, &pb.SetRequest{Pair: &pb.KeyValuePair{Key: "perdida", Value: []byte("x")}})
+	cancel()
+	if err == nil { t.Fatal("el líder aislado confirmó una escritura") }
+
+	next := awaitLeader(t, servers, network)
+	if got := mustGet(t, servers[next], "antes"); string(got.Value) != "1" { t.Fatalf("el líder nuevo perdió una escritura confirmada: %q", got.Value) }
+	mustSet(t, servers[next], "despues", "2")
+
+	network.setIsolated(leader, false)
+	for _, s := range servers {
+		awaitValue(t, s, "despues", "2")
+		if got := mustGet(t, s, "perdida"); got.Found { t.Fatal("una escritura sin confirmar sobrevivió al cambio de líder") }
+	}
+}
+
+// TestRaftExpiredKeys: La limpieza de TTL del líder borra la clave en todo el clúster; la de un
+// seguidor no escribe nada y devuelve la clave a su cola.
+func TestRaftExpiredKeys(t *testing.T) {
+	servers, network := startTestCluster(t, 3, "memory")
+	leader := awaitLeader(t, servers, network)
+	if _, err := servers[leader].Set(context.Background(), &pb.SetRequest{Pair: &pb.KeyValuePair{Key: "ttl", Value: []byte("v")}, TtlSeconds: 1}); err != nil { t.Fatalf("Set: %v", err) }
+	for _, s := range servers { awaitValue(t, s, "ttl", "v") }
+	time.Sleep(1100 * time.Millisecond)
+
+	for addr, s := range servers {
+		if addr == leader { continue }
+		if n, err := s.kvStore.reapExpired(); n != 0 || !errors.Is(err, errNotLeader) { t.Fatalf("limpieza en un seguidor: %d %v", n, err) }
+	}
+	if n, err := servers[leader].kvStore.reapExpired(); n != 1 || err != nil { t.Fatalf("limpieza en el líder: %d %v", n, err) }
+	revision := servers[leader].kvStore.lastRevision()
+	for _, s := range servers {
+		deadline := time.Now().Add(5 * time.Second)
+		for s.kvStore.stateRevision() < revision {
+			if time.Now().After(deadline) { t.Fatal("un seguidor no aplicó la lápida de la limpieza") }
+			time.Sleep(10 * time.Millisecond)
+		}
+		if _, exists := s.kvStore.engine.Get("ttl"); exists { t.Error("la clave vencida sigue en el motor") }
+	}
+}
+
+// TestRaftInstallSnapshot: Un seguidor que se perdió entradas ya compactadas en el líder recibe
+// una imagen del almacén, que sustituye su estado también en disco.
+func TestRaftInstallSnapshot(t *testing.T) {
+	servers, network := startTestCluster(t, 3, "lsm")
+	leader := awaitLeader(t, servers, network)
+	mustSet(t, servers[leader], "borrada", "1")
+	for _, s := range servers { awaitValue(t, s, "borrada", "1") }
+	var follower string
+	for addr := range servers {
+		if addr != leader { follower = addr }
+	}
+
+	network.setIsolated(follower, true)
+	if _, err := servers[leader].Delete(context.Background(), &pb.DeleteRequest{Key: "borrada"}); err != nil { t.Fatalf("Delete: %v", err) }
+	// Más entradas de las que caben en la caché del líder, en paralelo para agruparlas en el WAL.
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < raftCacheEntries+200; i += 8 { mustSet(t, servers[leader], fmt.Sprintf("k%04d", i), "v") }
+		}(w)
+	}
+	wg.Wait()
+	// El punto de control compacta el log: las entradas que le faltan al seguidor ya no están.
+	servers[leader].kvStore.takeSnapshot()
+	mustSet(t, servers[leader], "ultima", "2")
+
+	network.setIsolated(follower, false)
+	s := servers[follower]
+	awaitValue(t, s, "ultima", "2")
+	awaitValue(t, s, "k4000", "v")
+	if got := mustGet(t, s, "borrada"); got.Found { t.Fatal("el seguidor conserva una clave borrada en el líder") }
+	// Si al volver el seguidor fuerza una elección, el líder nuevo puede estar enviándole otra
+	// imagen: su directorio temporal solo tiene que desaparecer al terminar.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		stale, _ := filepath.Glob(filepath.Join(s.kvStore.dataDir, imageDirPrefix+"*"))
+		if len(stale) == 0 { break }
+		if time.Now().After(deadline) { t.Fatalf("quedó el directorio temporal %v", stale) }
+		time.Sleep(20 * time.Millisecond)
+	}
+}
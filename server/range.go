@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"time"
+
+	pb "asignacionservidor/proto/keyval"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ---- Recorridos por rango con paginación ---- //
+
+// rangeQuery: Parámetros de un recorrido ordenado sobre [start, end).
+type rangeQuery struct {
+	start, end string
+	reverse    bool
+	limit      int // 0 = sin límite
+	keysOnly   bool // No copiar los valores en los pares devueltos.
+	countOnly  bool // Solo contar: no se devuelve ningún par.
+	// after: Última clave entregada en la página anterior y total contado en la primera
+	// (solo si hasCursor).
+	after     string
+	total     int
+	hasCursor bool
+}
+
+// setCursor: Decodifica el token de continuación. El token lleva el total de la primera
+// página (varint) y la última clave entregada, codificados en Base64 para que el cliente lo
+// trate como un valor opaco.
+func (q *rangeQuery) setCursor(cursor string) error {
+	if cursor == "" { return nil }
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil { return status.Errorf(codes.InvalidArgument, "cursor inválido: %v", err) }
+	total, n := binary.Uvarint(raw)
+	if n <= 0 { return status.Errorf(codes.InvalidArgument, "cursor inválido: falta el total") }
+	q.after, q.total, q.hasCursor = string(raw[n:]), int(total), true
+	return nil
+}
+
+// encodeCursor: Token de continuación tras la clave key, con el total del rango.
+func encodeCursor(key string, total int) string {
+	return base64.RawURLEncoding.EncodeToString(append(binary.AppendUvarint(nil, uint64(total)), key...))
+}
+
+// pageBounds: Límites del recorrido de la página pedida: empieza justo después del cursor
+// (sin incluirlo) en el sentido del recorrido.
+func (q *rangeQuery) pageBounds() (start, end string) {
+	start, end = q.start, q.end
+	if !q.hasCursor { return start, end }
+	if q.reverse {
+		if end == "" || q.after < end { end = q.after }
+	} else if next := q.after + "\x00"; next > start {
+		start = next
+	}
+	return start, end
+}
+
+// queryRange: Ejecuta el recorrido y devuelve la página pedida, el total de claves vigentes
+// en todo el rango, el cursor para la página siguiente ("" si no quedan más) y la revisión
+// del almacén que refleja la vista.
+// La página se lee con los candados de lectura de todos los shards tomados a la vez, para
+// obtener una vista consistente (sin batches a medias). La primera página (y count_only)
+// recorre todo el rango para contar el total en esa misma vista; las siguientes empiezan en
+// el cursor y se detienen tras limit+1 claves, así que cuestan O(log n + limit), y repiten el
+// total que trae el cursor: el de la revisión de la primera página.
+func (s *ShardedStore) queryRange(q rangeQuery, now int64) ([]*pb.KeyValuePair, int, string, uint64) {
+	var pairs []*pb.KeyValuePair
+	counting := !q.hasCursor
+	total, more := q.total, false
+	start, end := q.pageBounds()
+	unlock := s.rlockAllShards()
+	revision := s.stateRevision()
+	s.engine.Scan(start, end, q.reverse, func(key string, e storeEntry) bool {
+		if e.expired(now) { return true }
+		if counting { total++ }
+		if q.countOnly || more { return counting }
+		if q.limit > 0 && len(pairs) == q.limit {
+			more = true
+			return counting
+		}
+		if q.keysOnly {
+			pairs = append(pairs, &pb.KeyValuePair{Key: key})
+		} else {
+			pairs = append(pairs, &pb.KeyValuePair{Key: key, Value: e.value})
+		}
+		return true
+	})
+	unlock()
+	nextCursor := ""
+	if more { nextCursor = encodeCursor(pairs[len(pairs)-1].Key, total) }
+	return pairs, total, nextCursor, revision
+}
+
+// Range: Devuelve los pares de [start_key, end_key) en orden (o en orden inverso), de a
+// 'limit' por llamada. Para pedir la página siguiente se repite la petición con next_cursor.
+// En un anillo de particiones recorre todas (ver partition.go).
+func (s *Server) Range(ctx context.Context, req *pb.RangeRequest) (*pb.RangeResponse, error) {
+	if spans, err := s.spansPartitions(ctx); spans || err != nil {
+		if err != nil { return nil, err }
+		return s.fanOutRange(ctx, req)
+	}
+	q := rangeQuery{start: req.StartKey, end: req.EndKey, reverse: req.Reverse, limit: int(req.Limit)}
+	if err := q.setCursor(req.Cursor); err != nil { return nil, err }
+	pairs, total, nextCursor, _ := s.kvStore.queryRange(q, time.Now().UnixNano())
+	s.kvStore.stats.mu.Lock()
+	s.kvStore.stats.prefixOperations++
+	s.kvStore.stats.mu.Unlock()
+	return &pb.RangeResponse{Pairs: pairs, TotalMatches: uint32(total), NextCursor: nextCursor}, nil
+}
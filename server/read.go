@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	pb "asignacionservidor/proto/keyval"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ---- Consistencia de las lecturas ---- //
+//
+// Get y GetPrefixStream eligen, por petición, cuánto pueden ir atrasados los datos:
+//   - EVENTUAL: lo que tenga el nodo que recibe la petición (una réplica o un seguidor pueden
+//     ir por detrás del primario o del líder).
+//   - BOUNDED: el nodo la sirve si no va más atrasado que el límite pedido, en milisegundos o en
+//     revisiones; si no, la redirige al primario o al líder.
+//   - LINEARIZABLE: la sirve el líder tras confirmar con la mayoría que sigue siéndolo
+//     (ReadIndex de Raft), o el primario. Una réplica la redirige al primario.
+//
+// Las redirecciones usan el mismo LeaderHint que las escrituras rechazadas por un seguidor.
+// Cada respuesta informa la revisión del almacén con la que se sirvió; pasándola como
+// min_revision en la lectura siguiente, un cliente no ve nunca un estado anterior a lo que ya
+// leyó o escribió (read-your-writes), aunque la lectura la atienda otro nodo.
+
+const (
+	// readWaitTimeout: Espera máxima a que un nodo alcance el min_revision de una lectura.
+	readWaitTimeout = 2 * time.Second
+	// maxLagSamples: Revisiones anunciadas por la fuente que se recuerdan hasta aplicarlas.
+	maxLagSamples = 64
+)
+
+// lagSample: La fuente anunció la revisión revision en el instante at.
+type lagSample struct {
+	revision uint64
+	at       time.Time
+}
+
+// lagTracker: Estima lo atrasado que va este nodo respecto de su fuente (el primario o el líder
+// de Raft) con las revisiones que la fuente anuncia en cada mensaje, latidos incluidos. El
+// retraso en tiempo es lo que pasó desde el último instante en el que este nodo tenía todo lo
+// que la fuente había anunciado; sin contacto con la fuente crece sin límite.
+type lagTracker struct {
+	mu      sync.Mutex
+	source  uint64      // Última revisión anunciada por la fuente.
+	pending []lagSample // Revisiones anunciadas y aún no aplicadas, en orden.
+	current time.Time   // Cero si este nodo nunca estuvo al día.
+}
+
+// heard: La fuente anuncia que está en la revisión source; este nodo aplicó hasta applied.
+func (t *lagTracker) heard(source, applied uint64) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.source = max(t.source, source)
+	t.advanceLocked(applied)
+	if source <= applied {
+		t.current = now
+		return
+	}
+	if n := len(t.pending); n == 0 || t.pending[n-1].revision < source {
+		// Sin sitio se olvida la más antigua: el retraso se sobrestima, nunca se subestima.
+		if n == maxLagSamples { t.pending = append(t.pending[:0], t.pending[1:]...) }
+		t.pending = append(t.pending, lagSample{revision: source, at: now})
+	}
+}
+
+// advanced: Este nodo aplicó hasta applied.
+func (t *lagTracker) advanced(applied uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.advanceLocked(applied)
+}
+
+func (t *lagTracker) advanceLocked(applied uint64) {
+	for len(t.pending) > 0 && t.pending[0].revision <= applied {
+		t.current = t.pending[0].at
+		t.pending = t.pending[1:]
+	}
+}
+
+// lag: Revisiones y tiempo que este nodo va por detrás de la fuente. known es false si nunca
+// estuvo al día (recién arrancado, o sin contacto con la fuente).
+func (t *lagTracker) lag(applied uint64) (revisions uint64, age time.Duration, known bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.current.IsZero() { return 0, 0, false }
+	if t.source > applied { revisions = t.source - applied }
+	return revisions, time.Since(t.current), true
+}
+
+// upstream: Nodo con los datos más recientes, al que se redirigen las lecturas que este no
+// puede servir: el líder de Raft ("" durante una elección) o el primario. self indica que es
+// este mismo nodo (el líder listo, el primario o un servidor sin replicación).
+func (s *Server) upstream() (addr string, term uint64, self bool) {
+	if n := s.kvStore.raft; n != nil {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if n.role == raftLeader && n.ready { return n.self, n.term, true }
+		return n.leader, n.term, false
+	}
+	if s.replicaOf == "" { return "", 0, true }
+	return s.replicaOf, 0, false
+}
+
+// redirectTo: FailedPrecondition con un LeaderHint hacia addr: el cliente repite allí la petición.
+func redirectTo(addr string, term uint64, format string, args ...interface{}) error {
+	st := status.Newf(codes.FailedPrecondition, format, args...)
+	if withDetails, err := st.WithDetails(&pb.LeaderHint{Leader: addr, Term: term}); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+// redirectRead: Error de una lectura que este nodo no puede servir con la consistencia pedida.
+func (s *Server) redirectRead(reason string) error {
+	addr, term, _ := s.upstream()
+	if addr == "" { return status.Errorf(codes.Unavailable, "%s y el clúster está eligiendo líder (mandato %d); reintente en unos instantes", reason, term) }
+	return redirectTo(addr, term, "%s; repita la lectura en %s", reason, addr)
+}
+
+// readBarrier: Espera, o rechaza con una redirección, hasta que este nodo pueda servir una
+// lectura con la consistencia pedida y haya aplicado al menos minRevision.
+func (s *Server) readBarrier(ctx context.Context, consistency pb.GetRequest_Consistency, maxStalenessMs, maxStalenessRevisions, minRevision uint64) error {
+	switch consistency {
+	case pb.GetRequest_EVENTUAL:
+	case pb.GetRequest_BOUNDED:
+		if maxStalenessMs == 0 && maxStalenessRevisions == 0 {
+			return status.Errorf(codes.InvalidArgument, "una lectura BOUNDED necesita max_staleness_ms o max_staleness_revisions")
+		}
+		if _, _, self := s.upstream(); self { break }
+		revisions, age, known := s.kvStore.lag.lag(s.kvStore.stateRevision())
+		if !known { return s.redirectRead("este nodo aún no sabe cuánto va atrasado") }
+		if (maxStalenessMs > 0 && age > time.Duration(maxStalenessMs)*time.Millisecond) || (maxStalenessRevisions > 0 && revisions > maxStalenessRevisions) {
+			return s.redirectRead(fmt.Sprintf("este nodo va atrasado (%d revisiones, %v)", revisions, age.Round(time.Millisecond)))
+		}
+	case pb.GetRequest_LINEARIZABLE:
+		if s.kvStore.raft != nil {
+			if addr, term, self := s.upstream(); !self && addr != "" && addr != s.kvStore.raft.self {
+				return redirectTo(addr, term, "este nodo no es el líder; las lecturas linealizables se sirven en %s", addr)
+			}
+			if err := s.kvStore.raft.readIndex(ctx); err != nil { return err }
+		} else if s.replicaOf != "" {
+			return redirectTo(s.replicaOf, 0, "este servidor es una réplica; las lecturas linealizables se sirven en el primario %s", s.replicaOf)
+		}
+	default:
+		return status.Errorf(codes.InvalidArgument, "consistencia desconocida %d", consistency)
+	}
+	if minRevision == 0 { return nil }
+	return s.awaitRevision(ctx, minRevision)
+}
+
+// awaitRevision: Espera, como mucho readWaitTimeout, a que el motor refleje la revisión. Si no
+// llega, una réplica o un seguidor redirigen la lectura a su fuente.
+func (s *Server) awaitRevision(ctx context.Context, revision uint64) error {
+	_, _, self := s.upstream()
+	if last := s.kvStore.lastRevision(); self && revision > last {
+		return status.Errorf(codes.OutOfRange, "la revisión %d aún no existe (la última es la %d)", revision, last)
+	}
+	deadline := time.Now().Add(readWaitTimeout)
+	for s.kvStore.stateRevision() < revision {
+		if time.Now().After(deadline) {
+			if self { return status.Errorf(codes.DeadlineExceeded, "el almacén no alcanzó la revisión %d en %v", revision, readWaitTimeout) }
+			return s.redirectRead(fmt.Sprintf("este nodo no alcanzó la revisión %d en %v", revision, readWaitTimeout))
+		}
+		if err := ctx.Err(); err != nil { return status.FromContextError(err).Err() }
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
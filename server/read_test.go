@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "asignacionservidor/proto/keyval"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// redirectedTo: Dirección del LeaderHint de un error FailedPrecondition ("" si no lo es).
+func redirectedTo(err error) string {
+	st := status.Convert(err)
+	if st.Code() != codes.FailedPrecondition { return "" }
+	for _, d := range st.Details() {
+		if hint, ok := d.(*pb.LeaderHint); ok { return hint.Leader }
+	}
+	return ""
+}
+
+// TestLagTracker: El retraso en revisiones es lo que la fuente anunció y el nodo aún no aplicó;
+// el retraso en tiempo cuenta desde el anuncio más reciente que el nodo ya tiene aplicado.
+func TestLagTracker(t *testing.T) {
+	var lag lagTracker
+	if _, _, known := lag.lag(0); known { t.Fatal("un nodo que nunca oyó a su fuente conoce su retraso") }
+
+	lag.heard(10, 10)
+	if revisions, age, known := lag.lag(10); !known || revisions != 0 || age > 100*time.Millisecond {
+		t.Fatalf("al día: %d revisiones, %v, %v", revisions, age, known)
+	}
+	lag.heard(15, 10)
+	time.Sleep(20 * time.Millisecond)
+	lag.heard(20, 10)
+	if revisions, age, _ := lag.lag(10); revisions != 10 || age < 20*time.Millisecond {
+		t.Fatalf("atrasado: %d revisiones, %v", revisions, age)
+	}
+	// Aplicar hasta la 15 deja el retraso en lo que pasó desde que se anunció la 15.
+	lag.advanced(15)
+	if revisions, age, _ := lag.lag(15); revisions != 5 || age < 20*time.Millisecond {
+		t.Fatalf("tras aplicar la 15: %d revisiones, %v", revisions, age)
+	}
+	lag.advanced(20)
+	if revisions, age, _ := lag.lag(20); revisions != 0 || age >= 20*time.Millisecond {
+		t.Fatalf("tras aplicar la 20: %d revisiones, %v", revisions, age)
+	}
+
+	// Sin sitio para más anuncios se olvida el más antiguo: al aplicar la 21 el retraso sigue
+	// contando desde que el nodo estaba en la 20, no desde el anuncio de la 21.
+	time.Sleep(20 * time.Millisecond)
+	for i := uint64(1); i <= maxLagSamples+1; i++ { lag.heard(20+i, 20) }
+	lag.advanced(21)
+	if _, age, _ := lag.lag(21); age < 20*time.Millisecond { t.Errorf("se subestimó el retraso: %v", age) }
+}
+
+// TestReadConsistency: En una réplica EVENTUAL sirve lo que tenga, BOUNDED solo si no va más
+// atrasada que el límite y LINEARIZABLE redirige al primario; el primario las sirve todas.
+func TestReadConsistency(t *testing.T) {
+	primary := newTestServer(t, t.TempDir())
+	mustSet(t, primary, "k", "v")
+	replica := newTestServer(t, t.TempDir())
+	replica.replicaOf = "primario:50051"
+	if _, err := replica.kvStore.applyReplicated(encodeWALRecord(testRecord(1, "k"), compressionNone), compressionNone); err != nil {
+		t.Fatalf("applyReplicated: %v", err)
+	}
+
+	get := func(s *Server, req *pb.GetRequest) error {
+		req.Key = "k"
+		resp, err := s.Get(context.Background(), req)
+		if err == nil && (!resp.Found || resp.Revision != 1) { t.Errorf("Get %v: %v", req, resp) }
+		return err
+	}
+	bounded := func(ms, revisions uint64) *pb.GetRequest {
+		return &pb.GetRequest{Consistency: pb.GetRequest_BOUNDED, MaxStalenessMs: ms, MaxStalenessRevisions: revisions}
+	}
+	for _, req := range []*pb.GetRequest{{}, bounded(1, 0), {Consistency: pb.GetRequest_LINEARIZABLE}} {
+		if err := get(primary, req); err != nil { t.Errorf("Get %v en el primario: %v", req, err) }
+	}
+	if err := get(replica, &pb.GetRequest{}); err != nil { t.Errorf("Get EVENTUAL en la réplica: %v", err) }
+	if err := get(replica, bounded(0, 0)); status.Code(err) != codes.InvalidArgument { t.Errorf("BOUNDED sin límite: %v", err) }
+	if err := get(replica, &pb.GetRequest{Consistency: pb.GetRequest_LINEARIZABLE}); redirectedTo(err) != replica.replicaOf {
+		t.Errorf("LINEARIZABLE en la réplica: %v, se esperaba una redirección al primario", err)
+	}
+
+	// Sin noticias del primario la réplica no sabe cuánto va atrasada.
+	if err := get(replica, bounded(60000, 0)); redirectedTo(err) != replica.replicaOf { t.Errorf("BOUNDED sin contacto: %v", err) }
+	replica.kvStore.lag.heard(1, 1)
+	if err := get(replica, bounded(1000, 0)); err != nil { t.Errorf("BOUNDED al día: %v", err) }
+	replica.kvStore.lag.heard(6, 1)
+	if err := get(replica, bounded(0, 10)); err != nil { t.Errorf("BOUNDED con 5 revisiones de retraso y límite 10: %v", err) }
+	if err := get(replica, bounded(0, 2)); redirectedTo(err) != replica.replicaOf { t.Errorf("BOUNDED con 5 revisiones de retraso y límite 2: %v", err) }
+	time.Sleep(20 * time.Millisecond)
+	if err := get(replica, bounded(10, 0)); redirectedTo(err) != replica.replicaOf { t.Errorf("BOUNDED con 20 ms de retraso y límite 10 ms: %v", err) }
+}
+
+// TestReadMinRevision: Con min_revision el nodo espera a haberla aplicado. El primario rechaza
+// una revisión que aún no existe; una réplica que no la alcanza a tiempo redirige la lectura.
+func TestReadMinRevision(t *testing.T) {
+	primary := newTestServer(t, t.TempDir())
+	version := mustSet(t, primary, "k", "v")
+	if resp, err := primary.Get(context.Background(), &pb.GetRequest{Key: "k", MinRevision: version}); err != nil || resp.Revision < version {
+		t.Fatalf("Get con una revisión aplicada: %v %v", resp, err)
+	}
+	if _, err := primary.Get(context.Background(), &pb.GetRequest{Key: "k", MinRevision: version + 1}); status.Code(err) != codes.OutOfRange {
+		t.Fatalf("Get con una revisión futura en el primario: %v, se esperaba OutOfRange", err)
+	}
+
+	replica := newTestServer(t, t.TempDir())
+	replica.replicaOf = "primario:50051"
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		replica.kvStore.applyReplicated(encodeWALRecord(testRecord(1, "k"), compressionNone), compressionNone)
+	}()
+	resp, err := replica.Get(context.Background(), &pb.GetRequest{Key: "k", MinRevision: 1})
+	if err != nil || !resp.Found || resp.Revision != 1 { t.Fatalf("Get esperando la revisión 1: %v %v", resp, err) }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := replica.Get(ctx, &pb.GetRequest{Key: "k", MinRevision: 2}); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Get con el plazo del cliente vencido: %v, se esperaba DeadlineExceeded", err)
+	}
+	start := time.Now()
+	_, err = replica.Get(context.Background(), &pb.GetRequest{Key: "k", MinRevision: 2})
+	if redirectedTo(err) != replica.replicaOf { t.Errorf("Get con una revisión que no llega: %v, se esperaba una redirección", err) }
+	if elapsed := time.Since(start); elapsed < readWaitTimeout { t.Errorf("redirigió a los %v, antes de readWaitTimeout", elapsed) }
+}
+
+// TestRaftLinearizableRead: El líder sirve las lecturas linealizables con lo último confirmado;
+// un seguidor las redirige al líder.
+func TestRaftLinearizableRead(t *testing.T) {
+	servers, network := startTestCluster(t, 3, "memory")
+	leader := awaitLeader(t, servers, network)
+	version := mustSet(t, servers[leader], "k", "v")
+	resp, err := servers[leader].Get(context.Background(), &pb.GetRequest{Key: "k", Consistency: pb.GetRequest_LINEARIZABLE})
+	if err != nil || string(resp.Value) != "v" || resp.Revision < version { t.Fatalf("Get LINEARIZABLE en el líder: %v %v", resp, err) }
+	for addr, s := range servers {
+		if addr == leader { continue }
+		awaitValue(t, s, "k", "v")
+		_, err := s.Get(context.Background(), &pb.GetRequest{Key: "k", Consistency: pb.GetRequest_LINEARIZABLE})
+		if redirectedTo(err) != leader { t.Errorf("Get LINEARIZABLE en el seguidor %s: %v, se esperaba una redirección a %s", addr, err, leader) }
+	}
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"sync"
+	"time"
+
+	pb "asignacionservidor/proto/keyval"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// ---- Replicación primario-réplica ---- //
+//
+// El primario envía a cada réplica, por el stream interno Replicate, todos los registros de su
+// WAL en orden de LSN. La réplica los registra en su propio WAL con la misma LSN y el mismo
+// timestamp, los aplica a su almacén y confirma la última LSN aplicada. Así, cada réplica es
+// en todo momento el estado del primario en alguna LSN anterior.
+//
+// Al conectarse, la réplica indica su última LSN. El primario le envía primero los registros
+// siguientes leyéndolos de sus segmentos del WAL y después los que la rutina del group commit
+// va escribiendo. Si la réplica está tan atrasada que esos registros ya no están en el WAL,
+// recibe una imagen de copia de seguridad (ver backup.go) que sustituye su estado.
+
+const (
+	// replicationBufferSize: Grupos de registros pendientes por réplica. Si se llena, la
+	// réplica va atrasada: deja de recibir los registros en vivo y vuelve a leerlos del WAL.
+	replicationBufferSize = 256
+	// replicationBatchSize: Tamaño aproximado de los mensajes al enviar registros leídos del WAL.
+	replicationBatchSize = 1024 * 1024
+	// replicationRetry: Espera de una réplica antes de volver a conectarse al primario.
+	replicationRetry = time.Second
+	// replicationHeartbeat: Sin registros nuevos, el primario envía un latido con su última LSN
+	// con este periodo; así la réplica sabe cuánto va atrasada (ver read.go).
+	replicationHeartbeat = 100 * time.Millisecond
+)
+
+// errReplicaBehind: Los registros que necesita la réplica ya no están en el WAL del primario.
+var errReplicaBehind = errors.New("la réplica necesita registros que ya no están en el WAL")
+
+// replicationBatch: Registros consecutivos (first..last) escritos por un mismo group commit,
+// ya codificados con el códec del almacén.
+type replicationBatch struct {
+	first, last uint64
+	frames      []byte
+}
+
+// replica: Una réplica conectada al primario.
+type replica struct {
+	id      string
+	applied uint64 // Última LSN confirmada. La protege replicationHub.mu.
+	batches chan replicationBatch
+	// lagged se cierra cuando la réplica deja de recibir registros en vivo por no consumirlos a tiempo.
+	lagged chan struct{}
+}
+
+// replicationHub: Reparte los registros escritos en el WAL entre las réplicas conectadas y
+// lleva la cuenta de la LSN que cada una confirmó.
+type replicationHub struct {
+	mu       sync.Mutex
+	replicas map[*replica]struct{}
+	// changed se cierra (y se sustituye) cada vez que una réplica confirma registros.
+	changed chan struct{}
+}
+
+func newReplicationHub() *replicationHub {
+	return &replicationHub{replicas: make(map[*replica]struct{}), changed: make(chan struct{})}
+}
+
+// publish: Envía sin bloquear los registros de un group commit a las réplicas suscritas. Solo
+// la llama la rutina de escritura del WAL, en orden de LSN.
+func (h *replicationHub) publish(first, last uint64, frames []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for r := range h.replicas {
+		if r.batches == nil { continue }
+		select {
+		case r.batches <- replicationBatch{first: first, last: last, frames: frames}:
+		default:
+			r.batches = nil
+			close(r.lagged)
+		}
+	}
+}
+
+// register: Da de alta una réplica que ya tiene aplicados los registros hasta applied.
+func (h *replicationHub) register(id string, applied uint64) *replica {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r := &replica{id: id, applied: applied}
+	h.replicas[r] = struct{}{}
+	return r
+}
+
+func (h *replicationHub) unregister(r *replica) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.replicas, r)
+}
+
+// subscribe: Empieza (o vuelve a empezar) a recibir los registros en vivo para la réplica.
+// Devuelve los canales de la nueva suscripción.
+func (h *replicationHub) subscribe(r *replica) (<-chan replicationBatch, <-chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r.batches, r.lagged = make(chan replicationBatch, replicationBufferSize), make(chan struct{})
+	return r.batches, r.lagged
+}
+
+// ack: Anota la última LSN aplicada por la réplica y despierta a quien espera confirmaciones.
+func (h *replicationHub) ack(r *replica, lsn uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r.applied = lsn
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// count: Número de réplicas conectadas.
+func (h *replicationHub) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.replicas)
+}
+
+// confirmed: Número de réplicas conectadas que ya aplicaron lsn, y el canal que se cierra con
+// la próxima confirmación.
+func (h *replicationHub) confirmed(lsn uint64) (int, <-chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for r := range h.replicas {
+		if r.applied >= lsn { n++ }
+	}
+	return n, h.changed
+}
+
+// await: Espera a que n réplicas hayan aplicado lsn, como mucho timeout. Devuelve cuántas lo
+// hicieron.
+func (h *replicationHub) await(ctx context.Context, lsn uint64, n int, timeout time.Duration) (int, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		got, changed := h.confirmed(lsn)
+		if got >= n { return got, nil }
+		select {
+		case <-changed:
+		case <-timer.C:
+			return got, context.DeadlineExceeded
+		case <-ctx.Done():
+			return got, ctx.Err()
+		}
+	}
+}
+
+// ---- Primario ---- //
+
+// ReplicationServer: Servicio interno con el que las réplicas reciben el WAL.
+type ReplicationServer struct {
+	pb.UnimplementedReplicationServiceServer
+	kvStore *ShardedStore
+}
+
+// Replicate: Stream de una réplica. El primer mensaje de la réplica indica su última LSN; a
+// partir de ahí recibe los registros siguientes y confirma con más mensajes lo que aplica.
+func (s *ReplicationServer) Replicate(stream pb.ReplicationService_ReplicateServer) error {
+	if s.kvStore.raft != nil { return status.Errorf(codes.FailedPrecondition, "este servidor es un nodo de Raft: sus entradas sin confirmar no pueden enviarse a una réplica") }
+	hello, err := stream.Recv()
+	if err != nil { return err }
+	if last := s.kvStore.lastRevision(); hello.AppliedLsn > last {
+		return status.Errorf(codes.FailedPrecondition,
+			"la réplica está en la LSN %d y el primario en la %d: no comparten historia; vacíe el directorio de datos de la réplica", hello.AppliedLsn, last)
+	}
+	hub := s.kvStore.replicas
+	r := hub.register(hello.ReplicaId, hello.AppliedLsn)
+	defer hub.unregister(r)
+	log.Printf("Réplica %s conectada en la LSN %d.", r.id, hello.AppliedLsn)
+
+	acks := make(chan error, 1)
+	go func() {
+		for {
+			ack, err := stream.Recv()
+			if err != nil {
+				acks <- err
+				return
+			}
+			hub.ack(r, ack.AppliedLsn)
+		}
+	}()
+
+	// Cada mensaje lleva la última LSN del primario al enviarlo.
+	send := func(msg *pb.ReplicationMessage) error {
+		msg.LastLsn = s.kvStore.lastRevision()
+		return stream.Send(msg)
+	}
+	heartbeat := time.NewTicker(replicationHeartbeat)
+	defer heartbeat.Stop()
+	next := hello.AppliedLsn + 1
+	for {
+		// La suscripción se hace antes de leer el WAL: ningún registro queda entre ambos.
+		batches, lagged := hub.subscribe(r)
+		if next, err = s.kvStore.shipWAL(next, send); errors.Is(err, errReplicaBehind) {
+			log.Printf("La réplica %s está en la LSN %d, anterior al WAL retenido: se le envía una imagen completa.", r.id, next-1)
+			w := &chunkWriter{send: func(c *pb.BackupChunk) error {
+				return send(&pb.ReplicationMessage{Payload: &pb.ReplicationMessage_Snapshot{Snapshot: c}})
+			}}
+			var end uint64
+			if end, err = s.kvStore.writeBackup(w); err == nil {
+				next = end + 1
+				next, err = s.kvStore.shipWAL(next, send)
+			}
+		}
+		if err != nil {
+			log.Printf("Réplica %s desconectada: %v", r.id, err)
+			return err
+		}
+
+	live:
+		for {
+			select {
+			case <-stream.Context().Done():
+				log.Printf("Réplica %s desconectada.", r.id)
+				return stream.Context().Err()
+			case err := <-acks:
+				if err == io.EOF { err = nil }
+				log.Printf("Réplica %s desconectada.", r.id)
+				return err
+			case <-lagged:
+				log.Printf("La réplica %s va atrasada: se reanuda desde el WAL en la LSN %d.", r.id, next)
+				break live
+			case <-heartbeat.C:
+				if err := send(&pb.ReplicationMessage{}); err != nil { return err }
+			case b := <-batches:
+				if b.last < next { continue }
+				// Un hueco solo aparece si el almacén saltó de LSN (una restauración).
+				if b.first > next { break live }
+				frames := skipFrames(b.frames, next-b.first)
+				msg := &pb.ReplicationMessage{Payload: &pb.ReplicationMessage_WalRecords{WalRecords: frames}, Compression: uint32(s.kvStore.compression)}
+				if err := send(msg); err != nil { return err }
+				next = b.last + 1
+			}
+		}
+	}
+}
+
+// skipFrames: Descarta los n primeros marcos de un grupo de registros.
+func skipFrames(frames []byte, n uint64) []byte {
+	for ; n > 0; n-- {
+		length := binary.LittleEndian.Uint32(frames[0:4])
+		frames = frames[walFrameHeaderSize+int(length):]
+	}
+	return frames
+}
+
+// scanWAL: Recorre, leídos de los segmentos, los registros desde la LSN from hasta la to, que
+// ya deben estar escritos. fn puede devolver errStopReplay para terminar antes. Devuelve
+// errReplicaBehind si alguno ya no está en el WAL (la retención lo borró o el almacén se
+// restauró después).
+func (s *ShardedStore) scanWAL(from, to uint64, fn func(walRecord) error) error {
+	segments, err := listSegments(s.walDir)
+	if err != nil { return err }
+	if len(segments) == 0 || segments[0].start > from { return errReplicaBehind }
+	for len(segments) > 1 && segments[1].start <= from { segments = segments[1:] }
+	next := from
+	for _, seg := range segments {
+		err := replayWALFile(seg.path, false, func(rec walRecord) error {
+			if rec.revision < next { return nil }
+			if rec.revision > to { return errStopReplay }
+			if rec.revision != next { return errReplicaBehind }
+			next++
+			return fn(rec)
+		})
+		if errors.Is(err, fs.ErrNotExist) { err = errReplicaBehind }
+		if errors.Is(err, errStopReplay) || (errors.Is(err, errTornRecord) && next > to) { return nil }
+		if err != nil { return err }
+	}
+	if next <= to { return errReplicaBehind }
+	return nil
+}
+
+// shipWAL: Envía los registros desde la LSN next hasta el último escrito, y devuelve la LSN
+// siguiente al último enviado (errReplicaBehind si alguno ya no está en el WAL).
+func (s *ShardedStore) shipWAL(next uint64, send func(*pb.ReplicationMessage) error) (uint64, error) {
+	end, err := s.waitWritten(0)
+	if err != nil { return next, err }
+	if next > end { return next, nil }
+
+	var buf []byte
+	flush := func() error {
+		if len(buf) == 0 { return nil }
+		msg := &pb.ReplicationMessage{Payload: &pb.ReplicationMessage_WalRecords{WalRecords: buf}, Compression: uint32(s.compression)}
+		buf = nil
+		return send(msg)
+	}
+	err = s.scanWAL(next, end, func(rec walRecord) error {
+		frame := encodeWALRecord(rec, s.compression)
+		if len(buf) > 0 && len(buf)+len(frame) > replicationBatchSize {
+			if err := flush(); err != nil { return err }
+		}
+		buf = append(buf, frame...)
+		next++
+		return nil
+	})
+	if err != nil { return next, err }
+	return next, flush()
+}
+
+// awaitReplicas: Con required > 0, espera a que esa cantidad de réplicas haya aplicado la
+// escritura con LSN lsn. Si no lo consiguen en -replication-timeout la escritura sigue hecha
+// en el primario, pero el cliente recibe codes.DeadlineExceeded.
+func (s *Server) awaitReplicas(ctx context.Context, lsn uint64, required int) (int, error) {
+	if required <= 0 { return 0, nil }
+	got, err := s.kvStore.replicas.await(ctx, lsn, required, s.replicationTimeout)
+	if err != nil {
+		return got, status.Errorf(codes.DeadlineExceeded,
+			"escritura registrada en el primario (LSN %d), pero solo %d de %d réplicas la confirmaron", lsn, got, required)
+	}
+	return got, nil
+}
+
+// requiredReplicas: Réplicas que deben confirmar un Set según su opción de replicación.
+func (s *Server) requiredReplicas(req *pb.SetRequest) int {
+	switch req.Replication {
+	case pb.SetRequest_REPLICATION_ASYNC:
+		return 0
+	case pb.SetRequest_REPLICATION_SYNC:
+		if req.SyncReplicas > 0 { return int(req.SyncReplicas) }
+		if s.syncReplicas > 0 { return s.syncReplicas }
+		return 1
+	}
+	return s.syncReplicas
+}
+
+// checkWritable: Una réplica no acepta escrituras de los clientes: solo aplica las del primario.
+// En un clúster de Raft solo las acepta el líder (ver raftNode.writable).
+func (s *Server) checkWritable(ctx context.Context) error {
+	if s.kvStore.raft != nil { return s.kvStore.raft.writable(ctx) }
+	if s.replicaOf == "" { return nil }
+	return status.Errorf(codes.FailedPrecondition, "este servidor es una réplica de solo lectura; envíe las escrituras al primario %s", s.replicaOf)
+}
+
+// ---- Réplica ---- //
+
+// runReplica: Mantiene la conexión de la réplica con el primario, reconectando tras cada
+// corte. Si el primario rechaza la réplica por no compartir historia, el proceso termina.
+func runReplica(store *ShardedStore, primary, id string) {
+	conn, err := grpc.Dial(primary,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxWALRecordSize+replicationBatchSize)),
+	)
+	if err != nil { log.Fatalf("No se pudo preparar la conexión con el primario %s: %v", primary, err) }
+	client := pb.NewReplicationServiceClient(conn)
+	for {
+		err := store.followPrimary(client, id)
+		if status.Code(err) == codes.FailedPrecondition { log.Fatalf("El primario %s rechazó la réplica: %v", primary, err) }
+		log.Printf("Replicación desde %s interrumpida (LSN %d): %v. Reintentando...", primary, store.lastRevision(), err)
+		time.Sleep(replicationRetry)
+	}
+}
+
+// followPrimary: Una conexión con el primario: aplica cada mensaje y confirma la LSN alcanzada.
+// Los latidos no se confirman; solo actualizan el retraso respecto del primario.
+func (s *ShardedStore) followPrimary(client pb.ReplicationServiceClient, id string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Replicate(ctx)
+	if err != nil { return err }
+	if err := stream.Send(&pb.ReplicaAck{ReplicaId: id, AppliedLsn: s.lastRevision()}); err != nil { return err }
+	log.Printf("Conectado al primario en la LSN %d.", s.lastRevision())
+	for {
+		msg, err := stream.Recv()
+		if err != nil { return err }
+		var applied uint64
+		switch p := msg.Payload.(type) {
+		case *pb.ReplicationMessage_WalRecords:
+			applied, err = s.applyReplicated(p.WalRecords, compressionCodec(msg.Compression))
+		case *pb.ReplicationMessage_Snapshot:
+			applied, err = s.installReplicated(p.Snapshot, stream)
+		default:
+			s.lag.heard(msg.LastLsn, s.lastRevision())
+			continue
+		}
+		if err != nil { return err }
+		s.lag.heard(msg.LastLsn, applied)
+		if err := stream.Send(&pb.ReplicaAck{ReplicaId: id, AppliedLsn: applied}); err != nil { return err }
+	}
+}
+
+// installReplicated: Recibe la imagen que empieza con first y sustituye por ella el estado de
+// la réplica. Devuelve la LSN de la imagen.
+func (s *ShardedStore) installReplicated(first *pb.BackupChunk, stream pb.ReplicationService_ReplicateClient) (uint64, error) {
+	chunks := &chunkReader{buf: first.Data, recv: func() (*pb.BackupChunk, error) {
+		msg, err := stream.Recv()
+		if err != nil { return nil, err }
+		if msg.GetSnapshot() == nil { return nil, errBackupCorrupt }
+		return msg.GetSnapshot(), nil
+	}}
+	r := bufio.NewReaderSize(chunks, snapshotChunkSize)
+	restored, timestamp, err := s.readImage(r)
+	if err != nil { return 0, err }
+	// La imagen debe terminar justo al final de un trozo: lo siguiente ya son registros.
+	if r.Buffered() > 0 || len(chunks.buf) > 0 {
+		restored.discard()
+		return 0, errBackupCorrupt
+	}
+	if err := s.installRestored(restored, timestamp, true); err != nil {
+		// El motor pudo quedar a medias entre el estado anterior y la imagen.
+		log.Fatalf("No se pudo instalar la imagen recibida del primario: %v", err)
+	}
+	log.Printf("Réplica puesta al día con una imagen completa del primario (LSN %d).", restored.revision)
+	return restored.revision, nil
+}
+
+// applyReplicated: Registra en el WAL y aplica los registros recibidos del primario, con su LSN y
+// su timestamp. Devuelve la última LSN aplicada.
+func (s *ShardedStore) applyReplicated(frames []byte, codec compressionCodec) (uint64, error) {
+	if codec != compressionNone && codec != compressionZstd { return 0, fmt.Errorf("códec de compresión desconocido %d", codec) }
+	var records []walRecord
+	var keys []string
+	r := bufio.NewReader(bytes.NewReader(frames))
+	for {
+		payload, _, err := readFrame(r)
+		if err == io.EOF { break }
+		if err != nil { return 0, err }
+		rec, err := decodeWALPayload(payload, codec)
+		if err != nil { return 0, err }
+		for _, o := range rec.ops { keys = append(keys, o.key) }
+		records = append(records, rec)
+	}
+	if len(records) == 0 { return s.lastRevision(), nil }
+
+	unlock := s.lockShards(keys)
+	defer unlock()
+	if err := s.logReplicated(records); err != nil { return 0, err }
+	for _, rec := range records {
+		for _, o := range rec.ops {
+			if o.op == opDelete {
+				s.applyDeleteLocked(o.key)
+			} else {
+				s.applyPutLocked(o.key, storeEntry{value: o.value, version: rec.revision, expiresAt: o.expiresAt})
+			}
+		}
+	}
+	return records[len(records)-1].revision, nil
+}
+
+// logReplicated: Como logRecordMode, pero los registros conservan la LSN, el mandato y el
+// timestamp que les dio el primario (o el líder de Raft), y deben ser los siguientes a la
+// última LSN de la réplica. Todos se encolan juntos y comparten el próximo fsync.
+func (s *ShardedStore) logReplicated(records []walRecord) error {
+	bodies := make([][]byte, len(records))
+	for i, rec := range records { bodies[i] = encodeWALBody(rec.ops, s.compression) }
+	commits := make([]*walCommit, len(records))
+	mode := s.durability.mode
+	// Un seguidor de Raft solo confirma al líder entradas que ya están en disco.
+	if s.raft != nil { mode = durabilityAlways }
+
+	s.walMutex.Lock()
+	for i, rec := range records {
+		if rec.revision != s.revision+uint64(i)+1 {
+			last := s.revision
+			s.walMutex.Unlock()
+			return fmt.Errorf("se recibió la LSN %d y la réplica está en la %d", rec.revision, last+uint64(i))
+		}
+	}
+	for i, rec := range records {
+		commits[i] = &walCommit{revision: rec.revision, ops: rec.ops, mode: mode, done: make(chan error, 1)}
+		s.walPending = appendWALRecord(s.walPending, rec.revision, rec.term, rec.timestamp, bodies[i])
+	}
+	s.revision = records[len(records)-1].revision
+	s.walWaiters = append(s.walWaiters, commits...)
+	s.walMutex.Unlock()
+
+	select {
+	case s.walFlush <- struct{}{}:
+	default:
+	}
+	var err error
+	for _, c := range commits {
+		if cerr := <-c.done; cerr != nil && err == nil { err = cerr }
+	}
+	return err
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	pb "asignacionservidor/proto/keyval"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// startTestPrimary: Arranca un primario sobre dir que escucha en addr ("127.0.0.1:0" para un
+// puerto libre). Devuelve el servidor, su dirección y la función que lo detiene y cierra su
+// almacén, como al apagar el proceso; si la prueba no la llama, se llama al terminar.
+func startTestPrimary(t *testing.T, dir, addr string) (*Server, string, func()) {
+	t.Helper()
+	s := newTestServer(t, dir)
+	s.replicationTimeout = 300 * time.Millisecond
+	lis, err := net.Listen("tcp", addr)
+	if err != nil { t.Fatal(err) }
+	g := grpc.NewServer(grpc.WaitForHandlers(true))
+	pb.RegisterKeyValueServiceServer(g, s)
+	pb.RegisterReplicationServiceServer(g, &ReplicationServer{kvStore: s.kvStore})
+	go g.Serve(lis)
+	stop := func() {
+		g.Stop()
+		if err := s.kvStore.Close(); err != nil { t.Errorf("Close del primario: %v", err) }
+	}
+	t.Cleanup(stop)
+	return s, lis.Addr().String(), stop
+}
+
+// startTestReplica: Arranca una réplica de primary que, como runReplica, vuelve a conectarse
+// tras cada corte hasta que termina la prueba.
+func startTestReplica(t *testing.T, primary string) *Server {
+	t.Helper()
+	s := newTestServer(t, t.TempDir())
+	s.replicaOf = primary
+	conn, err := grpc.Dial(primary,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxWALRecordSize+replicationBatchSize)),
+	)
+	if err != nil { t.Fatal(err) }
+	client := pb.NewReplicationServiceClient(conn)
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			err := s.kvStore.followPrimary(client, "replica-"+t.Name())
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if status.Code(err) == codes.FailedPrecondition { t.Errorf("el primario rechazó la réplica: %v", err) }
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	// Se detiene antes de que se cierre su almacén.
+	t.Cleanup(func() {
+		close(done)
+		conn.Close()
+		<-stopped
+	})
+	return s
+}
+
+// awaitReplicaRevision: Espera a que la réplica haya aplicado los registros hasta lsn.
+func awaitReplicaRevision(t *testing.T, replica *Server, lsn uint64) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for replica.kvStore.lastRevision() < lsn {
+		if time.Now().After(deadline) { t.Fatalf("la réplica sigue en la LSN %d, se esperaba la %d", replica.kvStore.lastRevision(), lsn) }
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// awaitConnectedReplicas: Espera a que el primario tenga n réplicas conectadas.
+func awaitConnectedReplicas(t *testing.T, primary *Server, n int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for primary.kvStore.replicas.count() != n {
+		if time.Now().After(deadline) { t.Fatalf("%d réplicas conectadas, se esperaban %d", primary.kvStore.replicas.count(), n) }
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestReplicaCatchUp: Una réplica nueva recibe primero los registros que ya estaban en el WAL
+// del primario, con su misma LSN, y después los nuevos; si el primario ya no los retiene,
+// recibe una imagen completa. La réplica rechaza las escrituras de los clientes.
+func TestReplicaCatchUp(t *testing.T) {
+	primary, addr, _ := startTestPrimary(t, t.TempDir(), "127.0.0.1:0")
+	for i := 0; i < 200; i++ { mustSet(t, primary, fmt.Sprintf("k%03d", i), fmt.Sprint(i)) }
+	if _, err := primary.Delete(context.Background(), &pb.DeleteRequest{Key: "k007"}); err != nil { t.Fatalf("Delete: %v", err) }
+
+	check := func(t *testing.T, replica *Server) {
+		t.Helper()
+		awaitReplicaRevision(t, replica, primary.kvStore.lastRevision())
+		for _, key := range []string{"k000", "k123", "k199", "k007"} {
+			want, got := mustGet(t, primary, key), mustGet(t, replica, key)
+			if got.Found != want.Found || string(got.Value) != string(want.Value) || got.Version != want.Version {
+				t.Errorf("%s en la réplica: %v, en el primario: %v", key, got, want)
+			}
+		}
+	}
+	fromWAL := startTestReplica(t, addr)
+	check(t, fromWAL)
+	live := mustSet(t, primary, "nueva", "x")
+	awaitReplicaRevision(t, fromWAL, live)
+	if got := mustGet(t, fromWAL, "nueva"); got.Version != live { t.Errorf("nueva en la réplica: versión %d", got.Version) }
+	_, err := fromWAL.Set(context.Background(), &pb.SetRequest{Pair: &pb.KeyValuePair{Key: "k", Value: []byte("v")}})
+	if status.Code(err) != codes.FailedPrecondition { t.Errorf("Set en la réplica: %v, se esperaba FailedPrecondition", err) }
+
+	// El snapshot deja cubiertos los segmentos anteriores y la retención los borra.
+	primary.kvStore.takeSnapshot()
+	if _, err := primary.kvStore.waitWritten(0); err != nil { t.Fatal(err) }
+	if err := primary.kvStore.scanWAL(1, 1, func(walRecord) error { return nil }); !errors.Is(err, errReplicaBehind) {
+		t.Fatalf("el primario aún retiene la LSN 1: %v", err)
+	}
+	check(t, startTestReplica(t, addr))
+}
+
+// TestReplicaSyncAcks: Una escritura síncrona responde cuando las réplicas pedidas ya la
+// aplicaron. Si no hay tantas, el cliente recibe DeadlineExceeded, pero la escritura queda hecha
+// en el primario.
+func TestReplicaSyncAcks(t *testing.T) {
+	primary, addr, _ := startTestPrimary(t, t.TempDir(), "127.0.0.1:0")
+	replica := startTestReplica(t, addr)
+	awaitConnectedReplicas(t, primary, 1)
+
+	set := func(key string, mode pb.SetRequest_Replication, replicas uint32) (*pb.SetResponse, error) {
+		return primary.Set(context.Background(), &pb.SetRequest{Pair: &pb.KeyValuePair{Key: key, Value: []byte("v")}, Replication: mode, SyncReplicas: replicas})
+	}
+	resp, err := set("sync", pb.SetRequest_REPLICATION_SYNC, 0)
+	if err != nil || resp.Replicas != 1 { t.Fatalf("Set síncrono: %v %v", resp, err) }
+	// Sin esperar: la confirmación llega después de aplicarla.
+	if got := mustGet(t, replica, "sync"); got.Version != resp.Version { t.Errorf("sync en la réplica: versión %d, se esperaba %d", got.Version, resp.Version) }
+
+	resp, err = set("async", pb.SetRequest_REPLICATION_ASYNC, 0)
+	if err != nil || resp.Replicas != 0 { t.Fatalf("Set asíncrono: %v %v", resp, err) }
+
+	start := time.Now()
+	_, err = set("dos", pb.SetRequest_REPLICATION_SYNC, 2)
+	if status.Code(err) != codes.DeadlineExceeded { t.Fatalf("Set con 2 réplicas y solo 1 conectada: %v, se esperaba DeadlineExceeded", err) }
+	if elapsed := time.Since(start); elapsed < primary.replicationTimeout { t.Errorf("respondió en %v, antes de -replication-timeout", elapsed) }
+	if got := mustGet(t, primary, "dos"); !got.Found { t.Error("la escritura no quedó en el primario") }
+	awaitValue(t, replica, "dos", "v")
+}
+
+// TestReplicaReconnect: Tras un reinicio del primario la réplica vuelve a conectarse y sigue
+// desde su última LSN, sin perder ni repetir registros.
+func TestReplicaReconnect(t *testing.T) {
+	dir := t.TempDir()
+	primary, addr, stop := startTestPrimary(t, dir, "127.0.0.1:0")
+	replica := startTestReplica(t, addr)
+	before := mustSet(t, primary, "antes", "1")
+	awaitReplicaRevision(t, replica, before)
+
+	stop()
+	primary, _, _ = startTestPrimary(t, dir, addr)
+	after := mustSet(t, primary, "despues", "2")
+	if after != before+1 { t.Fatalf("el primario reinició en la LSN %d", after-1) }
+	awaitReplicaRevision(t, replica, after)
+	if got := mustGet(t, replica, "antes"); got.Version != before { t.Errorf("antes en la réplica: versión %d", got.Version) }
+	if got := mustGet(t, replica, "despues"); got.Version != after || string(got.Value) != "2" { t.Errorf("despues en la réplica: %v", got) }
+	awaitConnectedReplicas(t, primary, 1)
+	if got := replica.kvStore.lastRevision(); got != after { t.Errorf("la réplica está en la LSN %d, el primario en la %d", got, after) }
+}
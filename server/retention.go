@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---- Retención y archivo de segmentos del WAL ---- //
+
+// retentionPolicy: Qué hacer con los segmentos del WAL que ya cubre un snapshot durable.
+// Se conservan los keepSegments más recientes y los modificados en las últimas keepFor;
+// el resto se borra o, si archiveDir no está vacío, se mueve a ese directorio.
+// Los segmentos que ningún snapshot cubre nunca se tocan.
+type retentionPolicy struct {
+	keepSegments int
+	keepFor      time.Duration
+	archiveDir   string
+}
+
+// pitrWarning: Aviso para un motor que no guarda snapshot.bin (lsm, btree): sin él, la
+// recuperación a un punto en el tiempo parte de un almacén vacío y necesita el WAL completo,
+// pero sin archiveDir la retención borra los segmentos cubiertos. Vacío si no hace falta.
+func (p retentionPolicy) pitrWarning(engine string) string {
+	if engine == "memory" || p.archiveDir != "" { return "" }
+	return fmt.Sprintf("ADVERTENCIA: el motor %s no guarda un snapshot que sirva de partida a -recover-into, que necesita todo el WAL; "+
+		"sin -wal-archive-dir la retención borra los segmentos cubiertos (salvo los %d más recientes) y solo se podrá recuperar a partir del primero que quede.",
+		engine, p.keepSegments)
+}
+
+// walFileInfo: Un archivo del WAL candidato a la política de retención.
+type walFileInfo struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// legacyWALBackups: Copias "kvstore.wal.<unix>" que dejaba la rotación del WAL de un solo
+// archivo. Todas son anteriores al punto de control de la migración, así que están cubiertas.
+func (s *ShardedStore) legacyWALBackups() ([]walFileInfo, error) {
+	matches, err := filepath.Glob(s.legacyWALPath + ".*")
+	if err != nil { return nil, err }
+	var backups []walFileInfo
+	for _, path := range matches {
+		suffix := strings.TrimPrefix(path, s.legacyWALPath+".")
+		if _, err := strconv.ParseInt(suffix, 10, 64); err != nil { continue } // p. ej. ".legacy"
+		info, err := os.Stat(path)
+		if err != nil { continue }
+		backups = append(backups, walFileInfo{path: path, size: info.Size(), modTime: info.ModTime()})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].path < backups[j].path })
+	return backups, nil
+}
+
+// applyRetention: Aplica la política de retención a los segmentos cubiertos por el snapshot
+// con LSN lsn (y a las copias del WAL antiguo). Solo debe llamarse cuando ese snapshot ya es
+// durable.
+func (s *ShardedStore) applyRetention(lsn uint64) {
+	segments, err := listSegments(s.walDir)
+	if err != nil {
+		log.Printf("ERROR: no se pudieron listar los segmentos del WAL: %v", err)
+		return
+	}
+	candidates, err := s.legacyWALBackups()
+	if err != nil { log.Printf("ERROR: no se pudieron listar las copias del WAL antiguo: %v", err) }
+	for _, seg := range coveredSegments(segments, lsn) {
+		info, err := os.Stat(seg.path)
+		if err != nil { continue }
+		candidates = append(candidates, walFileInfo{path: seg.path, size: info.Size(), modTime: info.ModTime()})
+	}
+
+	// Los candidatos están ordenados del más antiguo al más reciente.
+	expired := len(candidates) - s.retention.keepSegments
+	now := time.Now()
+	removed, archived := 0, 0
+	for i := 0; i < expired; i++ {
+		f := candidates[i]
+		if s.retention.keepFor > 0 && now.Sub(f.modTime) < s.retention.keepFor { continue }
+		if s.retention.archiveDir != "" {
+			if err := archiveFile(f.path, s.retention.archiveDir); err != nil {
+				log.Printf("ERROR: no se pudo archivar %s: %v", f.path, err)
+				continue
+			}
+			archived++
+			continue
+		}
+		if err := os.Remove(f.path); err != nil {
+			log.Printf("ERROR: no se pudo borrar %s: %v", f.path, err)
+			continue
+		}
+		removed++
+	}
+	if removed+archived > 0 {
+		syncDir(s.walDir)
+		syncDir(filepath.Dir(s.legacyWALPath))
+		log.Printf("Retención del WAL (snapshot en LSN %d): %d segmentos borrados, %d archivados.", lsn, removed, archived)
+	}
+}
+
+// archiveFile: Mueve un archivo al directorio de archivo. Si está en otro sistema de archivos
+// se copia, se sincroniza y solo entonces se borra el original.
+func archiveFile(path, archiveDir string) error {
+	if err := os.MkdirAll(archiveDir, 0755); err != nil { return err }
+	dest := filepath.Join(archiveDir, filepath.Base(path))
+	if err := os.Rename(path, dest); err == nil { return syncDir(archiveDir) }
+
+	src, err := os.Open(path)
+	if err != nil { return err }
+	defer src.Close()
+	tempPath := dest + ".tmp"
+	dst, err := os.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil { return err }
+	_, err = io.Copy(dst, src)
+	if err == nil { err = dst.Sync() }
+	if closeErr := dst.Close(); err == nil { err = closeErr }
+	if err == nil { err = os.Rename(tempPath, dest) }
+	if err == nil { err = syncDir(archiveDir) }
+	if err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("copia al archivo fallida: %w", err)
+	}
+	return os.Remove(path)
+}
+
+// walUsage: Número de archivos y bytes de un conjunto de archivos del WAL.
+type walUsage struct {
+	files int
+	bytes int64
+}
+
+// diskUsage: Recuento para Stat de los segmentos en data/wal (incluido el activo) más las
+// copias del WAL antiguo, y de los segmentos archivados.
+func (s *ShardedStore) diskUsage() (live, archived walUsage) {
+	count := func(paths []string) walUsage {
+		var u walUsage
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil { continue }
+			u.files++
+			u.bytes += info.Size()
+		}
+		return u
+	}
+	segments, _ := listSegments(s.walDir)
+	backups, _ := s.legacyWALBackups()
+	var paths []string
+	for _, seg := range segments { paths = append(paths, seg.path) }
+	for _, b := range backups { paths = append(paths, b.path) }
+	live = count(paths)
+	if s.retention.archiveDir != "" {
+		archivedPaths, _ := filepath.Glob(filepath.Join(s.retention.archiveDir, "*.wal*"))
+		var complete []string
+		for _, path := range archivedPaths {
+			if !strings.HasSuffix(path, ".tmp") { complete = append(complete, path) }
+		}
+		archived = count(complete)
+	}
+	return live, archived
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestRetention: Tras cada snapshot la retención borra los segmentos cubiertos, salvo los más
+// recientes que pida keepSegments y los que aún no cumplen keepFor; con archiveDir los mueve
+// allí intactos. El segmento activo no se toca.
+func TestRetention(t *testing.T) {
+	cases := []struct {
+		name    string
+		policy  retentionPolicy
+		kept    int // Segmentos cubiertos que quedan en el WAL.
+		archive bool
+	}{
+		{name: "borrar", kept: 0},
+		{name: "conservar-1", policy: retentionPolicy{keepSegments: 1}, kept: 1},
+		{name: "conservar-1h", policy: retentionPolicy{keepFor: time.Hour}, kept: 3},
+		{name: "archivar", kept: 0, archive: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := testStoreOptions(t.TempDir(), "memory")
+			opts.retention = tc.policy
+			if tc.archive { opts.retention.archiveDir = t.TempDir() }
+			s := openTestServer(t, opts)
+
+			// Cada snapshot cierra el segmento activo, que queda cubierto.
+			contents := make(map[string][]byte)
+			for _, key := range []string{"a", "b", "c"} {
+				mustSet(t, s, key, "v")
+				segments, err := listSegments(s.kvStore.walDir)
+				if err != nil || len(segments) == 0 { t.Fatalf("listSegments: %v %v", segments, err) }
+				active := segments[len(segments)-1].path
+				data, err := os.ReadFile(active)
+				if err != nil { t.Fatal(err) }
+				contents[filepath.Base(active)] = data
+				s.kvStore.takeSnapshot()
+			}
+			last := mustSet(t, s, "d", "v")
+
+			segments, err := listSegments(s.kvStore.walDir)
+			if err != nil { t.Fatalf("listSegments: %v", err) }
+			if len(segments) != tc.kept+1 { t.Fatalf("%d segmentos en el WAL, se esperaban %d: %v", len(segments), tc.kept+1, segments) }
+			if active := segments[len(segments)-1]; active.start != last { t.Errorf("el segmento activo empieza en la LSN %d, se esperaba la %d", active.start, last) }
+			if tc.kept == 1 && segments[0].start != last-1 { t.Errorf("se conservó el segmento de la LSN %d, no el más reciente", segments[0].start) }
+
+			if !tc.archive { return }
+			archived, err := listSegments(opts.retention.archiveDir)
+			if err != nil || len(archived) != len(contents) { t.Fatalf("segmentos archivados: %v %v", archived, err) }
+			for _, seg := range archived {
+				data, err := os.ReadFile(seg.path)
+				if err != nil { t.Fatal(err) }
+				if string(data) != string(contents[filepath.Base(seg.path)]) { t.Errorf("%s cambió al archivarse", filepath.Base(seg.path)) }
+				if revisions, err := replayAll(seg.path, false); err != nil || len(revisions) != 1 || revisions[0] != seg.start {
+					t.Errorf("%s archivado: revisiones %v %v", filepath.Base(seg.path), revisions, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ---- Formato binario del snapshot ---- //
+//
+// El snapshot se escribe y se lee en streaming, sin construir nunca una copia completa del
+// almacén en memoria. Es el punto de control del motor en memoria y el formato con el que
+// cualquier motor se copia (copias de seguridad, recuperación a un punto en el tiempo). El archivo empieza con snapshotMagic y sigue con
+// bloques con el mismo marco que los registros del WAL ([longitud][CRC32C][contenido]):
+//
+//	cabecera: tipo | LSN del punto de control (uvarint) | timestamp (varint)
+//	entradas: tipo | y por cada entrada: clave | valor | versión (uvarint) | expiración (varint)
+//	pie:      tipo | nº total de entradas (uvarint) | LSN al terminar la copia (uvarint)
+//
+// Sin el pie el snapshot se considera incompleto. El byte snapshotCodecOffset de la cabecera
+// indica la compresión de los bloques de entradas (ver compression.go).
+//
+// El LSN (log sequence number) es la revisión del último registro del WAL cuyo efecto está
+// garantizado en el snapshot. Al recuperar se reaplican solo los registros posteriores.
+// Como la copia se hace sin detener las escrituras, el snapshot puede incluir además cambios
+// hasta la LSN del pie: es el primer punto al que se puede recuperar partiendo de él.
+
+const (
+	snapshotMagic       = "KVSNAP\x00\x01"
+	snapshotCodecOffset = 6
+	// snapshotChunkSize: Tamaño aproximado de cada bloque de entradas. Acota la memoria extra
+	// que se usa al escribir y al leer el snapshot.
+	snapshotChunkSize = 1024 * 1024
+)
+
+// Tipos de bloque del snapshot.
+const (
+	snapshotHeader  byte = 1
+	snapshotEntries byte = 2
+	snapshotFooter  byte = 3
+)
+
+// snapshotInfo: Datos de un snapshot ya cargado.
+type snapshotInfo struct {
+	timestamp int64
+	lsn       uint64
+	keys      int
+	// consistentLSN: LSN al terminar la copia; 0 en los snapshots que no la guardaban.
+	consistentLSN uint64
+	// revision: Al cargarlo, la mayor entre la LSN y las versiones de sus entradas.
+	revision uint64
+}
+
+// writeSnapshot: Escribe en path un snapshot del almacén con la compresión configurada.
+func (s *ShardedStore) writeSnapshot(path string, timestamp int64, lsn uint64) (snapshotInfo, error) {
+	return writeSnapshotFile(path, s.compression, s.engine, timestamp, lsn, s.stateRevision)
+}
+
+// writeSnapshotFile: Escribe en path un snapshot con las entradas de src, que recorre con
+// src.Snapshot: la serialización y la escritura a disco no detienen las escrituras.
+// currentLSN da la última revisión asignada, que al terminar la copia se guarda en el pie.
+// La memoria adicional queda acotada por la que use src.Snapshot más un bloque.
+func writeSnapshotFile(path string, codec compressionCodec, src StorageEngine, timestamp int64, lsn uint64, currentLSN func() uint64) (snapshotInfo, error) {
+	info := snapshotInfo{timestamp: timestamp, lsn: lsn}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil { return info, err }
+	defer file.Close()
+	w := bufio.NewWriterSize(file, snapshotChunkSize)
+
+	w.WriteString(magicWithCodec(snapshotMagic, snapshotCodecOffset, codec))
+	header := []byte{snapshotHeader}
+	header = binary.AppendUvarint(header, lsn)
+	header = binary.AppendVarint(header, timestamp)
+	if _, err := w.Write(appendFrame(nil, header)); err != nil { return info, err }
+
+	total := 0
+	chunk := make([]byte, 0, snapshotChunkSize+walFrameHeaderSize)
+	chunk = append(chunk, snapshotEntries)
+	var compressed []byte
+	flush := func() error {
+		if len(chunk) == 1 { return nil }
+		payload := chunk
+		if codec != compressionNone {
+			compressed = codec.compress(append(compressed[:0], snapshotEntries), chunk[1:])
+			payload = compressed
+		}
+		_, err := w.Write(appendFrame(nil, payload))
+		chunk = chunk[:1]
+		return err
+	}
+
+	now := time.Now().UnixNano()
+	err = src.Snapshot(func(key string, e storeEntry) error {
+		// Las claves vencidas no se guardan: al recuperar se descartarían igualmente.
+		if e.expired(now) { return nil }
+		chunk = binary.AppendUvarint(chunk, uint64(len(key)))
+		chunk = append(chunk, key...)
+		chunk = binary.AppendUvarint(chunk, uint64(len(e.value)))
+		chunk = append(chunk, e.value...)
+		chunk = binary.AppendUvarint(chunk, e.version)
+		chunk = binary.AppendVarint(chunk, e.expiresAt)
+		total++
+		if len(chunk) >= snapshotChunkSize { return flush() }
+		return nil
+	})
+	if err != nil { return info, err }
+	if err := flush(); err != nil { return info, err }
+
+	// Toda entrada copiada tiene una versión ya asignada, así que no supera esta LSN.
+	// Un almacén que aún no publicó la revisión del punto de control (una restauración)
+	// no puede tener cambios posteriores a ella.
+	info.consistentLSN = max(currentLSN(), lsn)
+	info.keys = total
+	footer := binary.AppendUvarint([]byte{snapshotFooter}, uint64(total))
+	footer = binary.AppendUvarint(footer, info.consistentLSN)
+	if _, err := w.Write(appendFrame(nil, footer)); err != nil { return info, err }
+	if err := w.Flush(); err != nil { return info, err }
+	// El snapshot debe estar en disco antes de renombrarlo y de rotar el WAL.
+	if err := file.Sync(); err != nil { return info, err }
+	return info, file.Close()
+}
+
+// errSnapshotCorrupt: El snapshot está incompleto o algún bloque no supera el CRC.
+var errSnapshotCorrupt = errors.New("snapshot incompleto o corrupto")
+
+// loadSnapshot: Carga en el almacén un snapshot binario y ajusta su revisión.
+func (s *ShardedStore) loadSnapshot(path string) (snapshotInfo, error) {
+	info, err := loadSnapshot(path, s.engine)
+	s.revision = info.revision
+	return info, err
+}
+
+// readSnapshot: Carga en el almacén un snapshot binario leído de r y ajusta su revisión.
+func (s *ShardedStore) readSnapshot(r *bufio.Reader) (snapshotInfo, error) {
+	info, err := readSnapshot(r, s.engine)
+	s.revision = info.revision
+	return info, err
+}
+
+// loadSnapshot: Lee en streaming un snapshot binario y carga sus entradas en dst.
+// Si devuelve un error dst puede haber quedado cargado a medias: el llamador debe descartarlo.
+func loadSnapshot(path string, dst StorageEngine) (snapshotInfo, error) {
+	file, err := os.Open(path)
+	if err != nil { return snapshotInfo{}, err }
+	defer file.Close()
+	return readSnapshot(bufio.NewReaderSize(file, snapshotChunkSize), dst)
+}
+
+// readSnapshot: Carga en dst un snapshot binario leído de r, que puede ser parte de otro archivo.
+func readSnapshot(r *bufio.Reader, dst StorageEngine) (snapshotInfo, error) {
+	var info snapshotInfo
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(r, magic); err != nil { return info, errSnapshotCorrupt }
+	codec, ok := codecFromMagic(magic, snapshotMagic, snapshotCodecOffset)
+	if !ok { return info, errSnapshotCorrupt }
+	payload, _, err := readFrame(r)
+	if err != nil || payload[0] != snapshotHeader { return info, errSnapshotCorrupt }
+	p := &payloadReader{buf: payload[1:]}
+	info.lsn = p.uvarint()
+	info.timestamp = p.varint()
+	if p.err != nil { return info, errSnapshotCorrupt }
+	info.revision = info.lsn
+
+	for {
+		payload, _, err := readFrame(r)
+		if err != nil { return info, errSnapshotCorrupt }
+		p := &payloadReader{buf: payload[1:]}
+		switch payload[0] {
+		case snapshotEntries:
+			if p.buf, err = codec.decompress(p.buf); err != nil { return info, fmt.Errorf("%w: %v", errSnapshotCorrupt, err) }
+			for len(p.buf) > 0 && p.err == nil {
+				key := string(p.bytes())
+				// El valor se copia para no retener el bloque completo en memoria.
+				value := append([]byte(nil), p.bytes()...)
+				e := storeEntry{value: value, version: p.uvarint(), expiresAt: p.varint()}
+				if p.err != nil { break }
+				dst.Put(key, e)
+				if e.version > info.revision { info.revision = e.version }
+				info.keys++
+			}
+			if p.err != nil { return info, errSnapshotCorrupt }
+		case snapshotFooter:
+			if total := p.uvarint(); p.err != nil || total != uint64(info.keys) { return info, errSnapshotCorrupt }
+			if len(p.buf) > 0 { info.consistentLSN = p.uvarint() }
+			if p.err != nil { return info, errSnapshotCorrupt }
+			return info, nil
+		default:
+			return info, errSnapshotCorrupt
+		}
+	}
+}
+
+// loadLegacySnapshot: Carga en dst un snapshot en el formato JSON anterior.
+func loadLegacySnapshot(path string, dst StorageEngine) (snapshotInfo, error) {
+	snapshotData, err := os.ReadFile(path)
+	if err != nil { return snapshotInfo{}, err }
+	var snap SnapshotData
+	if err := json.Unmarshal(snapshotData, &snap); err != nil {
+		return snapshotInfo{}, fmt.Errorf("%w: %v", errSnapshotCorrupt, err)
+	}
+	info := snapshotInfo{timestamp: snap.Timestamp, lsn: snap.Revision, keys: len(snap.Entries) + len(snap.Data), revision: snap.Revision}
+	for k, e := range snap.Entries {
+		dst.Put(k, storeEntry{value: e.Value, version: e.Version, expiresAt: e.ExpiresAt})
+		if e.Version > info.revision { info.revision = e.Version }
+	}
+	// Snapshot antiguo sin versiones: cada clave recibe una revisión nueva.
+	for k, v := range snap.Data {
+		info.revision++
+		dst.Put(k, storeEntry{value: v, version: info.revision})
+	}
+	return info, nil
+}
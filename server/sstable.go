@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"sync/atomic"
+)
+
+// ---- SSTables del motor LSM ---- //
+//
+// Una SSTable es un archivo inmutable con entradas ordenadas por clave. Los bloques usan el
+// mismo marco que los registros del WAL ([longitud][CRC32C][contenido]):
+//
+//	lsmTableMagic, con el códec de los bloques de datos en el byte lsmTableCodecOffset
+//	bloques de datos de ~lsmBlockSize, y en cada uno, por entrada:
+//	    clave | flags (1 = lápida) | versión (uvarint) | expiración (varint) | valor
+//	índice: por bloque: primera clave | offset | longitud del marco (uvarint)
+//	filtro bloom: nº de funciones hash (uvarint) | bits
+//	meta: nº de entradas (uvarint) | clave menor | clave mayor
+//	pie: offsets del índice, del filtro y de la meta (uint64 little endian) | lsmTableMagic
+//
+// Al abrir una tabla se cargan en memoria el índice y el filtro; cada lectura posterior lee
+// un único bloque.
+
+const (
+	lsmTableMagic       = "KVSST\x00\x01\n"
+	lsmTableCodecOffset = 5
+	lsmTableFooterSize  = 24 + len(lsmTableMagic)
+	// lsmBlockSize: Tamaño aproximado de cada bloque de datos.
+	lsmBlockSize = 16 * 1024
+	// lsmBloomBitsPerKey: Con 10 bits por clave el filtro da ~1% de falsos positivos.
+	lsmBloomBitsPerKey = 10
+)
+
+// errTableCorrupt: La tabla está incompleta o algún bloque no supera el CRC.
+var errTableCorrupt = errors.New("SSTable incompleta o corrupta")
+
+// lsmEntry: Entrada del motor LSM. Un borrado se guarda como lápida hasta que la
+// compactación la lleva al último nivel.
+type lsmEntry struct {
+	entry   storeEntry
+	deleted bool
+}
+
+// blockEntry: Una entrada decodificada de un bloque (o copiada de una memtable).
+type blockEntry struct {
+	key string
+	e   lsmEntry
+}
+
+// ---- Filtro bloom ---- //
+
+type bloomFilter struct {
+	bits []byte
+	k    uint32
+}
+
+// bloomHash: Los k índices se obtienen por doble hashing a partir de un único FNV-64.
+func bloomHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+func newBloomFilter(hashes []uint64) bloomFilter {
+	nbits := max(len(hashes)*lsmBloomBitsPerKey, 64)
+	f := bloomFilter{bits: make([]byte, (nbits+7)/8), k: 7}
+	m := uint32(len(f.bits) * 8)
+	for _, h := range hashes {
+		h1, h2 := uint32(h), uint32(h>>32)
+		for i := uint32(0); i < f.k; i++ {
+			bit := (h1 + i*h2) % m
+			f.bits[bit/8] |= 1 << (bit % 8)
+		}
+	}
+	return f
+}
+
+// mayContain: false garantiza que la clave no está en la tabla.
+func (f bloomFilter) mayContain(key string) bool {
+	if len(f.bits) == 0 { return true }
+	h := bloomHash(key)
+	h1, h2 := uint32(h), uint32(h>>32)
+	m := uint32(len(f.bits) * 8)
+	for i := uint32(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % m
+		if f.bits[bit/8]&(1<<(bit%8)) == 0 { return false }
+	}
+	return true
+}
+
+// ---- Escritura ---- //
+
+// tableWriter: Escribe una SSTable a partir de entradas que llegan en orden.
+type tableWriter struct {
+	path     string
+	file     *os.File
+	w        *bufio.Writer
+	codec    compressionCodec
+	offset   int64
+	block    []byte
+	first    string // Primera clave del bloque en curso.
+	index    []byte
+	blocks   uint64
+	hashes   []uint64
+	entries  uint64
+	smallest string
+	largest  string
+}
+
+func newTableWriter(path string, codec compressionCodec) (*tableWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil { return nil, err }
+	t := &tableWriter{path: path, file: file, w: bufio.NewWriterSize(file, 4*lsmBlockSize), codec: codec}
+	t.w.WriteString(magicWithCodec(lsmTableMagic, lsmTableCodecOffset, codec))
+	t.offset = int64(len(lsmTableMagic))
+	return t, nil
+}
+
+// add: Añade una entrada; las claves deben llegar en orden estrictamente creciente.
+func (t *tableWriter) add(key string, e lsmEntry) error {
+	if len(t.block) == 0 { t.first = key }
+	if t.entries == 0 { t.smallest = key }
+	t.largest = key
+	t.block = binary.AppendUvarint(t.block, uint64(len(key)))
+	t.block = append(t.block, key...)
+	var flags byte
+	if e.deleted { flags = 1 }
+	t.block = append(t.block, flags)
+	t.block = binary.AppendUvarint(t.block, e.entry.version)
+	t.block = binary.AppendVarint(t.block, e.entry.expiresAt)
+	t.block = binary.AppendUvarint(t.block, uint64(len(e.entry.value)))
+	t.block = append(t.block, e.entry.value...)
+	t.hashes = append(t.hashes, bloomHash(key))
+	t.entries++
+	if len(t.block) >= lsmBlockSize { return t.finishBlock() }
+	return nil
+}
+
+// size: Tamaño aproximado que tendrá el archivo; sirve para partir las salidas de la compactación.
+func (t *tableWriter) size() int64 { return t.offset + int64(len(t.block)) }
+
+func (t *tableWriter) finishBlock() error {
+	if len(t.block) == 0 { return nil }
+	frame := appendFrame(nil, t.codec.compress(nil, t.block))
+	if _, err := t.w.Write(frame); err != nil { return err }
+	t.index = binary.AppendUvarint(t.index, uint64(len(t.first)))
+	t.index = append(t.index, t.first...)
+	t.index = binary.AppendUvarint(t.index, uint64(t.offset))
+	t.index = binary.AppendUvarint(t.index, uint64(len(frame)))
+	t.blocks++
+	t.offset += int64(len(frame))
+	t.block = t.block[:0]
+	return nil
+}
+
+// finish: Escribe el índice, el filtro, la meta y el pie, y sincroniza el archivo.
+func (t *tableWriter) finish() (int64, error) {
+	if err := t.finishBlock(); err != nil { return 0, err }
+	var footer []byte
+	for _, payload := range [][]byte{
+		append(binary.AppendUvarint(nil, t.blocks), t.index...),
+		func() []byte {
+			f := newBloomFilter(t.hashes)
+			return append(binary.AppendUvarint(nil, uint64(f.k)), f.bits...)
+		}(),
+		func() []byte {
+			meta := binary.AppendUvarint(nil, t.entries)
+			meta = binary.AppendUvarint(meta, uint64(len(t.smallest)))
+			meta = append(meta, t.smallest...)
+			meta = binary.AppendUvarint(meta, uint64(len(t.largest)))
+			return append(meta, t.largest...)
+		}(),
+	} {
+		footer = binary.LittleEndian.AppendUint64(footer, uint64(t.offset))
+		frame := appendFrame(nil, payload)
+		if _, err := t.w.Write(frame); err != nil { return 0, err }
+		t.offset += int64(len(frame))
+	}
+	footer = append(footer, lsmTableMagic...)
+	if _, err := t.w.Write(footer); err != nil { return 0, err }
+	t.offset += int64(len(footer))
+	if err := t.w.Flush(); err != nil { return 0, err }
+	// La tabla debe estar en disco antes de que el MANIFEST la nombre.
+	if err := t.file.Sync(); err != nil { return 0, err }
+	return t.offset, t.file.Close()
+}
+
+// abort: Descarta una tabla a medio escribir.
+func (t *tableWriter) abort() {
+	t.file.Close()
+	os.Remove(t.path)
+}
+
+// ---- Lectura ---- //
+
+// tableBlock: Entrada del índice de una tabla.
+type tableBlock struct {
+	first  string
+	offset int64
+	length int64
+}
+
+// sstable: Una tabla abierta. Las versiones del motor que la incluyen mantienen una referencia;
+// cuando la última se suelta se cierra y, si ya no forma parte del motor, se borra.
+type sstable struct {
+	num      uint64
+	path     string
+	file     *os.File
+	codec    compressionCodec
+	index    []tableBlock
+	bloom    bloomFilter
+	entries  uint64
+	smallest string
+	largest  string
+	size     int64
+	refs     atomic.Int32
+	obsolete atomic.Bool
+}
+
+// readFrameAt: Lee el bloque con marco que empieza en offset.
+func readFrameAt(file *os.File, offset, length int64) ([]byte, error) {
+	if length < walFrameHeaderSize || length > walFrameHeaderSize+maxWALRecordSize { return nil, errTableCorrupt }
+	buf := make([]byte, length)
+	if _, err := file.ReadAt(buf, offset); err != nil {
+		if err == io.EOF { return nil, errTableCorrupt }
+		return nil, err
+	}
+	payload := buf[walFrameHeaderSize:]
+	if binary.LittleEndian.Uint32(buf[0:4]) != uint32(len(payload)) || crc32.Checksum(payload, crc32c) != binary.LittleEndian.Uint32(buf[4:8]) {
+		return nil, errTableCorrupt
+	}
+	return payload, nil
+}
+
+// openTable: Abre una tabla y carga su índice y su filtro.
+func openTable(path string, num uint64) (*sstable, error) {
+	file, err := os.Open(path)
+	if err != nil { return nil, err }
+	t := &sstable{num: num, path: path, file: file}
+	if err := t.load(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+func (t *sstable) load() error {
+	info, err := t.file.Stat()
+	if err != nil { return err }
+	t.size = info.Size()
+	if t.size < int64(len(lsmTableMagic)+lsmTableFooterSize) { return errTableCorrupt }
+	head := make([]byte, len(lsmTableMagic))
+	footer := make([]byte, lsmTableFooterSize)
+	if _, err := t.file.ReadAt(head, 0); err != nil { return err }
+	if _, err := t.file.ReadAt(footer, t.size-int64(lsmTableFooterSize)); err != nil { return err }
+	codec, ok := codecFromMagic(head, lsmTableMagic, lsmTableCodecOffset)
+	if !ok || string(footer[24:]) != lsmTableMagic { return errTableCorrupt }
+	t.codec = codec
+	offsets := []int64{
+		int64(binary.LittleEndian.Uint64(footer[0:8])),
+		int64(binary.LittleEndian.Uint64(footer[8:16])),
+		int64(binary.LittleEndian.Uint64(footer[16:24])),
+		t.size - int64(lsmTableFooterSize),
+	}
+	var parts [3][]byte
+	for i := range parts {
+		if offsets[i+1] <= offsets[i] { return errTableCorrupt }
+		if parts[i], err = readFrameAt(t.file, offsets[i], offsets[i+1]-offsets[i]); err != nil { return err }
+	}
+
+	p := &payloadReader{buf: parts[0]}
+	n := p.uvarint()
+	for i := uint64(0); i < n && p.err == nil; i++ {
+		t.index = append(t.index, tableBlock{first: string(p.bytes()), offset: int64(p.uvarint()), length: int64(p.uvarint())})
+	}
+	if p.err != nil || len(p.buf) > 0 { return errTableCorrupt }
+	p = &payloadReader{buf: parts[1]}
+	t.bloom.k = uint32(p.uvarint())
+	t.bloom.bits = p.buf
+	p = &payloadReader{buf: parts[2]}
+	t.entries = p.uvarint()
+	t.smallest, t.largest = string(p.bytes()), string(p.bytes())
+	if p.err != nil || len(t.index) == 0 { return errTableCorrupt }
+	return nil
+}
+
+func (t *sstable) ref() { t.refs.Add(1) }
+
+func (t *sstable) unref() {
+	if t.refs.Add(-1) > 0 { return }
+	t.file.Close()
+	if t.obsolete.Load() { os.Remove(t.path) }
+}
+
+// overlaps: Indica si la tabla puede contener claves de [start, end] (ambos incluidos).
+func (t *sstable) overlaps(start, end string) bool {
+	return t.largest >= start && t.smallest <= end
+}
+
+// readBlock: Lee y decodifica el bloque i.
+func (t *sstable) readBlock(i int) ([]blockEntry, error) {
+	b := t.index[i]
+	payload, err := readFrameAt(t.file, b.offset, b.length)
+	if err != nil { return nil, fmt.Errorf("%s: %w", t.path, err) }
+	if payload, err = t.codec.decompress(payload); err != nil { return nil, fmt.Errorf("%s: %w", t.path, err) }
+	var entries []blockEntry
+	p := &payloadReader{buf: payload}
+	for len(p.buf) > 0 && p.err == nil {
+		key := string(p.bytes())
+		flags := p.byte()
+		e := lsmEntry{entry: storeEntry{version: p.uvarint(), expiresAt: p.varint()}, deleted: flags&1 != 0}
+		e.entry.value = p.bytes()
+		entries = append(entries, blockEntry{key: key, e: e})
+	}
+	if p.err != nil { return nil, fmt.Errorf("%s: %w", t.path, errTableCorrupt) }
+	return entries, nil
+}
+
+// blockFor: Índice del último bloque cuya primera clave es menor o igual que key (-1 si ninguno).
+func (t *sstable) blockFor(key string) int {
+	return sort.Search(len(t.index), func(i int) bool { return t.index[i].first > key }) - 1
+}
+
+// get: Busca la clave en la tabla. El filtro bloom evita leer el disco en casi todas las
+// tablas que no la contienen.
+func (t *sstable) get(key string) (lsmEntry, bool, error) {
+	if key < t.smallest || key > t.largest || !t.bloom.mayContain(key) { return lsmEntry{}, false, nil }
+	i := t.blockFor(key)
+	if i < 0 { return lsmEntry{}, false, nil }
+	entries, err := t.readBlock(i)
+	if err != nil { return lsmEntry{}, false, err }
+	j := sort.Search(len(entries), func(j int) bool { return entries[j].key >= key })
+	if j < len(entries) && entries[j].key == key { return entries[j].e, true, nil }
+	return lsmEntry{}, false, nil
+}
+
+// tableIterator: Recorre una tabla en orden (o en orden inverso) bloque a bloque.
+type tableIterator struct {
+	t       *sstable
+	block   int
+	entries []blockEntry
+	pos     int
+	reverse bool
+	err     error
+}
+
+// iterator: Se posiciona en la primera clave >= start o, con reverse, en la última < end
+// (end == "" = la última de la tabla).
+func (t *sstable) iterator(start, end string, reverse bool) *tableIterator {
+	it := &tableIterator{t: t, reverse: reverse}
+	if !reverse {
+		it.load(max(t.blockFor(start), 0))
+		it.pos = sort.Search(len(it.entries), func(j int) bool { return it.entries[j].key >= start })
+		if it.pos == len(it.entries) { it.load(it.block + 1) }
+		return it
+	}
+	block := len(t.index) - 1
+	if end != "" { block = sort.Search(len(t.index), func(i int) bool { return t.index[i].first >= end }) - 1 }
+	it.load(block)
+	if end != "" {
+		it.pos = sort.Search(len(it.entries), func(j int) bool { return it.entries[j].key >= end }) - 1
+		if it.pos < 0 { it.load(it.block - 1) }
+	}
+	return it
+}
+
+// load: Carga el bloque i y se coloca en su primera entrada (o la última, si reverse).
+// Fuera de la tabla el iterador queda agotado.
+func (it *tableIterator) load(i int) {
+	it.block, it.entries, it.pos = i, nil, 0
+	if i < 0 || i >= len(it.t.index) || it.err != nil { return }
+	entries, err := it.t.readBlock(i)
+	if err != nil {
+		it.err = err
+		return
+	}
+	it.entries = entries
+	if it.reverse { it.pos = len(entries) - 1 }
+}
+
+func (it *tableIterator) valid() bool { return it.pos >= 0 && it.pos < len(it.entries) }
+func (it *tableIterator) current() blockEntry { return it.entries[it.pos] }
+
+func (it *tableIterator) next() {
+	if it.reverse {
+		if it.pos--; it.pos < 0 { it.load(it.block - 1) }
+		return
+	}
+	if it.pos++; it.pos >= len(it.entries) { it.load(it.block + 1) }
+}
+
+func (it *tableIterator) iterErr() error { return it.err }
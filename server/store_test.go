@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	pb "asignacionservidor/proto/keyval"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestServer: Servidor sin replicación sobre un almacén con el motor memory en dir, con
+// fsync en cada escritura.
+func newTestServer(t *testing.T, dir string) *Server {
+	t.Helper()
+	return newEngineTestServer(t, dir, "memory")
+}
+
+func newEngineTestServer(t *testing.T, dir, engine string) *Server {
+	t.Helper()
+	return openTestServer(t, testStoreOptions(dir, engine))
+}
+
+// testStoreOptions: Opciones de los almacenes de prueba: fsync en cada escritura.
+func testStoreOptions(dir, engine string) storeOptions {
+	return storeOptions{
+		dataDir:    dir,
+		engine:     engine,
+		cacheSize:  conformanceCacheSize,
+		durability: durabilityPolicy{mode: durabilityAlways, interval: 100 * time.Millisecond},
+	}
+}
+
+// openTestServer: Arranca un almacén con opts; se cierra al terminar la prueba.
+func openTestServer(t *testing.T, opts storeOptions) *Server {
+	t.Helper()
+	store, err := NewShardedStore(opts)
+	if err != nil { t.Fatalf("NewShardedStore: %v", err) }
+	t.Cleanup(func() {
+		if err := store.Close(); err != nil { t.Errorf("Close: %v", err) }
+	})
+	return &Server{kvStore: store}
+}
+
+// reopenTestServer: Cierra el almacén de s y arranca otro con el mismo motor sobre su
+// directorio, como un reinicio del servidor.
+func reopenTestServer(t *testing.T, s *Server) *Server {
+	t.Helper()
+	if err := s.kvStore.Close(); err != nil { t.Fatalf("Close: %v", err) }
+	return newEngineTestServer(t, s.kvStore.dataDir, s.kvStore.engineName)
+}
+
+func mustSet(t *testing.T, s *Server, key, value string) uint64 {
+	t.Helper()
+	resp, err := s.Set(context.Background(), &pb.SetRequest{Pair: &pb.KeyValuePair{Key: key, Value: []byte(value)}})
+	if err != nil { t.Fatalf("Set(%q): %v", key, err) }
+	return resp.Version
+}
+
+func mustGet(t *testing.T, s *Server, key string) *pb.GetResponse {
+	t.Helper()
+	resp, err := s.Get(context.Background(), &pb.GetRequest{Key: key})
+	if err != nil { t.Fatalf("Get(%q): %v", key, err) }
+	return resp
+}
+
+func TestWritesRequirePair(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	ctx := context.Background()
+	if _, err := s.Set(ctx, &pb.SetRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Set sin par: %v, se esperaba InvalidArgument", err)
+	}
+	if _, err := s.CompareAndSet(ctx, &pb.CompareAndSetRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CompareAndSet sin par: %v, se esperaba InvalidArgument", err)
+	}
+}
+
+// TestDeleteSurvivesRestart: Un borrado se registra como lápida y la clave sigue borrada tras
+// reiniciar, lo cubra o no el punto de control. Las estadísticas la descuentan.
+func TestDeleteSurvivesRestart(t *testing.T) {
+	for _, engine := range engineNames() {
+		t.Run(engine, func(t *testing.T) {
+			s := newEngineTestServer(t, t.TempDir(), engine)
+			del := func(key string) {
+				t.Helper()
+				resp, err := s.Delete(context.Background(), &pb.DeleteRequest{Key: key})
+				if err != nil || !resp.Found { t.Fatalf("Delete(%q): %v %v", key, resp, err) }
+			}
+			keyStats := func() (uint64, uint64) {
+				resp, err := s.Stat(context.Background(), &pb.StatRequest{})
+				if err != nil { t.Fatalf("Stat: %v", err) }
+				return resp.TotalKeys, resp.TotalSizeBytes
+			}
+			mustSet(t, s, "a", "1")
+			mustSet(t, s, "b", "22")
+			mustSet(t, s, "c", "333")
+			_, fullSize := keyStats()
+			del("a")
+			s.kvStore.takeSnapshot()
+			del("b")
+			keys, size := keyStats()
+			if keys != 1 || size >= fullSize { t.Fatalf("tras borrar: %d claves y %d bytes (antes %d bytes)", keys, size, fullSize) }
+
+			s = reopenTestServer(t, s)
+			for _, key := range []string{"a", "b"} {
+				if got := mustGet(t, s, key); got.Found { t.Errorf("%s volvió tras reiniciar", key) }
+			}
+			if got := mustGet(t, s, "c"); string(got.Value) != "333" { t.Errorf("c tras reiniciar: %q", got.Value) }
+			if gotKeys, gotSize := keyStats(); gotKeys != keys || gotSize != size {
+				t.Errorf("tras reiniciar: %d claves y %d bytes, se esperaban %d y %d", gotKeys, gotSize, keys, size)
+			}
+		})
+	}
+}
+
+// TestTTLExpiry: Una clave vencida deja de verse antes de que la limpieza la borre, y la
+// limpieza la descuenta de las estadísticas. Tras un reinicio las claves con TTL conservan su
+// vencimiento, vengan del punto de control o del WAL, y las que vencieron con el servidor
+// apagado no vuelven.
+func TestTTLExpiry(t *testing.T) {
+	for _, engine := range engineNames() {
+		t.Run(engine, func(t *testing.T) {
+			s := newEngineTestServer(t, t.TempDir(), engine)
+			ctx := context.Background()
+			setTTL := func(key string, ttl time.Duration) {
+				t.Helper()
+				op := walOp{op: opSet, key: key, value: []byte("v"), expiresAt: time.Now().Add(ttl).UnixNano()}
+				if _, err := s.kvStore.applyBatch([]walOp{op}); err != nil { t.Fatalf("applyBatch: %v", err) }
+			}
+			setLong := func(key string) {
+				t.Helper()
+				if _, err := s.Set(ctx, &pb.SetRequest{Pair: &pb.KeyValuePair{Key: key, Value: []byte("v")}, TtlSeconds: 3600}); err != nil { t.Fatalf("Set: %v", err) }
+			}
+			expiresAt := func(key string) int64 {
+				t.Helper()
+				lock := s.kvStore.keyLock(key)
+				lock.RLock()
+				defer lock.RUnlock()
+				e, ok := s.kvStore.engine.Get(key)
+				if !ok { t.Fatalf("%s no está en el motor", key) }
+				return e.expiresAt
+			}
+			stat := func() *pb.StatResponse {
+				resp, err := s.Stat(ctx, &pb.StatRequest{})
+				if err != nil { t.Fatalf("Stat: %v", err) }
+				return resp
+			}
+
+			mustSet(t, s, "fija", "v")
+			setLong("larga")
+			setTTL("corta", 20*time.Millisecond)
+			setTTL("apagado-snap", 50*time.Millisecond)
+			time.Sleep(30 * time.Millisecond)
+			if got := mustGet(t, s, "corta"); got.Found { t.Error("Get devuelve una clave vencida") }
+			resp, err := s.Range(ctx, &pb.RangeRequest{StartKey: "c", EndKey: "d"})
+			if err != nil || len(resp.Pairs) != 0 || resp.TotalMatches != 0 { t.Errorf("Range devuelve una clave vencida: %v %v", resp, err) }
+			if n, err := s.kvStore.reapExpired(); n != 1 || err != nil { t.Fatalf("reapExpired: %d %v", n, err) }
+			if st := stat(); st.TotalKeys != 3 || st.ExpiredKeys != 1 { t.Errorf("tras la limpieza: %d claves y %d expiradas", st.TotalKeys, st.ExpiredKeys) }
+
+			s.kvStore.takeSnapshot()
+			setLong("larga-wal")
+			setTTL("apagado-wal", 20*time.Millisecond)
+			want := map[string]int64{"larga": expiresAt("larga"), "larga-wal": expiresAt("larga-wal")}
+			time.Sleep(50 * time.Millisecond)
+			s = reopenTestServer(t, s)
+			for _, key := range []string{"corta", "apagado-snap", "apagado-wal"} {
+				if got := mustGet(t, s, key); got.Found { t.Errorf("%s volvió tras reiniciar", key) }
+			}
+			for key, at := range want {
+				if got := expiresAt(key); got != at { t.Errorf("%s vence en %d tras reiniciar, antes en %d", key, got, at) }
+			}
+			if st := stat(); st.TotalKeys != 3 { t.Errorf("tras reiniciar: %d claves, se esperaban 3", st.TotalKeys) }
+		})
+	}
+}
+
+func TestBatch(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	ctx := context.Background()
+	mustSet(t, s, "vieja", "1")
+	put := func(key string) *pb.BatchOperation {
+		return &pb.BatchOperation{Op: &pb.BatchOperation_Put{Put: &pb.KeyValuePair{Key: key, Value: []byte("v")}}}
+	}
+	del := func(key string) *pb.BatchOperation { return &pb.BatchOperation{Op: &pb.BatchOperation_DeleteKey{DeleteKey: key}} }
+
+	// Una operación inválida rechaza el batch entero, sin aplicar ninguna.
+	for name, bad := range map[string]*pb.BatchOperation{"delete sin clave": del(""), "delete con clave larga": del(strings.Repeat("k", MaxKeySize+1)), "put sin par": {Op: &pb.BatchOperation_Put{}}} {
+		_, err := s.Batch(ctx, &pb.BatchRequest{Operations: []*pb.BatchOperation{put("nueva"), del("vieja"), bad}})
+		if status.Code(err) != codes.InvalidArgument { t.Errorf("%s: %v, se esperaba InvalidArgument", name, err) }
+	}
+	if got := mustGet(t, s, "nueva"); got.Found { t.Fatal("un batch rechazado aplicó un put") }
+	if got := mustGet(t, s, "vieja"); !got.Found { t.Fatal("un batch rechazado aplicó un delete") }
+
+	resp, err := s.Batch(ctx, &pb.BatchRequest{Operations: []*pb.BatchOperation{put("nueva"), del("vieja")}})
+	if err != nil || resp.Applied != 2 { t.Fatalf("Batch: %v %v", resp, err) }
+	if got := mustGet(t, s, "nueva"); got.Version != resp.Revision { t.Errorf("nueva: versión %d, se esperaba la del batch %d", got.Version, resp.Revision) }
+	if got := mustGet(t, s, "vieja"); got.Found { t.Error("el batch no borró vieja") }
+}
+
+func TestCompareAndSet(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	ctx := context.Background()
+	cas := func(value string, expected uint64) (*pb.CompareAndSetResponse, error) {
+		return s.CompareAndSet(ctx, &pb.CompareAndSetRequest{Pair: &pb.KeyValuePair{Key: "k", Value: []byte(value)}, ExpectedVersion: expected})
+	}
+
+	resp, err := cas("uno", 0)
+	if err != nil || !resp.Success { t.Fatalf("crear con la versión 0: %v", err) }
+	created := resp.Version
+	if _, err := cas("otro", 0); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("crear una clave que ya existe: %v, se esperaba FailedPrecondition", err)
+	}
+	_, err = cas("dos", created+1)
+	st := status.Convert(err)
+	if st.Code() != codes.FailedPrecondition { t.Fatalf("versión equivocada: %v, se esperaba FailedPrecondition", err) }
+	var current uint64
+	for _, d := range st.Details() {
+		if r, ok := d.(*pb.CompareAndSetResponse); ok { current = r.Version }
+	}
+	if current != created { t.Errorf("el error informa la versión %d, la actual es %d", current, created) }
+
+	resp, err = cas("dos", created)
+	if err != nil || !resp.Success || resp.Version <= created { t.Fatalf("versión correcta: %v %v", resp, err) }
+	if got := mustGet(t, s, "k"); string(got.Value) != "dos" || got.Version != resp.Version {
+		t.Errorf("Get tras CompareAndSet: %q versión %d", got.Value, got.Version)
+	}
+}
+
+func TestTxn(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	ctx := context.Background()
+	version := mustSet(t, s, "saldo", "10")
+	put := func(key, value string) *pb.TxnOperation {
+		return &pb.TxnOperation{Op: &pb.TxnOperation_Put{Put: &pb.KeyValuePair{Key: key, Value: []byte(value)}}}
+	}
+	get := func(key string) *pb.TxnOperation { return &pb.TxnOperation{Op: &pb.TxnOperation_GetKey{GetKey: key}} }
+	txn := func(expected uint64) *pb.TxnRequest {
+		return &pb.TxnRequest{
+			Compare: []*pb.Compare{{Key: "saldo", Target: pb.Compare_VERSION, Result: pb.Compare_EQUAL, Version: expected}},
+			Success: []*pb.TxnOperation{put("saldo", "5"), put("movimiento", "-5"), get("saldo")},
+			Failure: []*pb.TxnOperation{get("saldo")},
+		}
+	}
+
+	resp, err := s.Txn(ctx, txn(version))
+	if err != nil { t.Fatalf("Txn: %v", err) }
+	if !resp.Succeeded || resp.Revision == 0 { t.Fatalf("la comparación debía cumplirse: %v", resp) }
+	// Un get posterior a un put de la misma transacción ve el valor nuevo.
+	if r := resp.Results[2]; string(r.Value) != "5" || r.Version != resp.Revision {
+		t.Errorf("get dentro de la transacción: %q versión %d", r.Value, r.Version)
+	}
+	for key, want := range map[string]string{"saldo": "5", "movimiento": "-5"} {
+		if got := mustGet(t, s, key); string(got.Value) != want || got.Version != resp.Revision {
+			t.Errorf("%s: %q versión %d, se esperaba %q versión %d", key, got.Value, got.Version, want, resp.Revision)
+		}
+	}
+
+	// Con la versión anterior se ejecuta la rama failure, que solo lee.
+	resp, err = s.Txn(ctx, txn(version))
+	if err != nil { t.Fatalf("Txn: %v", err) }
+	if resp.Succeeded || resp.Revision != 0 { t.Fatalf("la comparación no debía cumplirse: %v", resp) }
+	if r := resp.Results[0]; !r.Found || string(r.Value) != "5" { t.Errorf("rama failure: %q", r.Value) }
+	if got := mustGet(t, s, "saldo"); string(got.Value) != "5" { t.Errorf("la rama failure modificó saldo: %q", got.Value) }
+}
+
+func TestRangePages(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	const n = 5000
+	ops := make([]walOp, 0, n)
+	for i := 0; i < n; i++ { ops = append(ops, walOp{op: opSet, key: fmt.Sprintf("r%05d", i), value: []byte("v")}) }
+	if _, err := s.kvStore.applyBatch(ops); err != nil { t.Fatalf("applyBatch: %v", err) }
+	mustSet(t, s, "s", "fuera del rango")
+
+	for _, reverse := range []bool{false, true} {
+		var keys []string
+		cursor := ""
+		for page := 0; ; page++ {
+			resp, err := s.Range(context.Background(), &pb.RangeRequest{StartKey: "r", EndKey: "s", Limit: 1000, Reverse: reverse, Cursor: cursor})
+			if err != nil { t.Fatalf("Range: %v", err) }
+			if resp.TotalMatches != n { t.Fatalf("página %d: total %d, se esperaba %d", page, resp.TotalMatches, n) }
+			for _, p := range resp.Pairs { keys = append(keys, p.Key) }
+			if cursor = resp.NextCursor; cursor == "" { break }
+		}
+		if len(keys) != n { t.Fatalf("reverse=%v: %d claves, se esperaban %d", reverse, len(keys), n) }
+		for i, key := range keys {
+			want := i
+			if reverse { want = n - 1 - i }
+			if key != fmt.Sprintf("r%05d", want) { t.Fatalf("reverse=%v: clave %d es %q", reverse, i, key) }
+		}
+	}
+
+	// Las páginas siguientes repiten el total de la primera, que viaja en el cursor.
+	first, err := s.Range(context.Background(), &pb.RangeRequest{StartKey: "r", EndKey: "s", Limit: 10})
+	if err != nil { t.Fatalf("Range: %v", err) }
+	mustSet(t, s, "r99999", "nueva")
+	next, err := s.Range(context.Background(), &pb.RangeRequest{StartKey: "r", EndKey: "s", Limit: 10, Cursor: first.NextCursor})
+	if err != nil { t.Fatalf("Range: %v", err) }
+	if next.TotalMatches != n { t.Errorf("segunda página: total %d, se esperaba el de la primera (%d)", next.TotalMatches, n) }
+	if _, err := s.Range(context.Background(), &pb.RangeRequest{StartKey: "r", EndKey: "s", Cursor: "no es base64"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("cursor inválido: %v, se esperaba InvalidArgument", err)
+	}
+}
+
+// TestWALStatsCountSyncedRecords: walRecords solo cuenta los registros que ya cubrió un fsync.
+func TestWALStatsCountSyncedRecords(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	stats := func() (uint64, uint64) {
+		s.kvStore.stats.mu.Lock()
+		defer s.kvStore.stats.mu.Unlock()
+		return s.kvStore.stats.walSyncs, s.kvStore.stats.walRecords
+	}
+	syncs, records := stats()
+	set := func(key string, d pb.SetRequest_Durability) {
+		if _, err := s.Set(context.Background(), &pb.SetRequest{Pair: &pb.KeyValuePair{Key: key, Value: []byte("v")}, Durability: d}); err != nil { t.Fatalf("Set: %v", err) }
+	}
+	set("a", pb.SetRequest_NO_FSYNC)
+	set("b", pb.SetRequest_NO_FSYNC)
+	if gotSyncs, gotRecords := stats(); gotSyncs != syncs || gotRecords != records {
+		t.Fatalf("sin fsync: %d fsyncs y %d registros, se esperaban %d y %d", gotSyncs, gotRecords, syncs, records)
+	}
+	// El fsync siguiente hace durables también los dos registros anteriores.
+	set("c", pb.SetRequest_FSYNC_ALWAYS)
+	if gotSyncs, gotRecords := stats(); gotSyncs != syncs+1 || gotRecords != records+3 {
+		t.Fatalf("tras un fsync: %d fsyncs y %d registros, se esperaban %d y %d", gotSyncs, gotRecords, syncs+1, records+3)
+	}
+}
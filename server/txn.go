@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	pb "asignacionservidor/proto/keyval"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ---- Transacciones (compare / then / else) ---- //
+
+// Txn: Transacción de lectura-modificación-escritura con concurrencia optimista, al estilo de etcd.
+// 1. Toma los candados de todos los shards implicados (comparaciones y operaciones de ambas
+//    ramas), siempre en orden creciente de índice para evitar interbloqueos.
+// 2. Evalúa las comparaciones. Si todas se cumplen ejecuta 'success'; si no, 'failure'.
+// 3. Las escrituras de la rama elegida se registran como un único registro atómico del WAL.
+// Como los candados se mantienen durante todo el proceso, ninguna otra escritura puede
+// modificar las claves leídas entre la comparación y el commit.
+// Una réplica solo acepta transacciones de lectura. Con -sync-replicas, la respuesta espera a
+// las réplicas una vez liberados los candados.
+func (s *Server) Txn(ctx context.Context, req *pb.TxnRequest) (*pb.TxnResponse, error) {
+	for _, branch := range [][]*pb.TxnOperation{req.Success, req.Failure} {
+		for _, o := range branch {
+			if _, isGet := o.Op.(*pb.TxnOperation_GetKey); isGet || o.Op == nil { continue }
+			if err := s.checkWritable(ctx); err != nil { return nil, err }
+		}
+	}
+	resp, err := s.txn(req)
+	if err != nil || resp.Revision == 0 { return resp, err }
+	if _, err := s.awaitReplicas(ctx, resp.Revision, s.syncReplicas); err != nil { return nil, err }
+	return resp, nil
+}
+
+// txn: Cuerpo de Txn, con los candados tomados.
+func (s *Server) txn(req *pb.TxnRequest) (*pb.TxnResponse, error) {
+	var keys []string
+	for _, c := range req.Compare { keys = append(keys, c.Key) }
+	for _, branch := range [][]*pb.TxnOperation{req.Success, req.Failure} {
+		for i, o := range branch {
+			key, err := txnOperationKey(o)
+			if err != nil { return nil, status.Errorf(codes.InvalidArgument, "operación %d: %v", i, err) }
+			keys = append(keys, key)
+		}
+	}
+
+	unlock := s.kvStore.lockShards(keys)
+	defer unlock()
+
+	now := time.Now()
+	succeeded := true
+	for _, c := range req.Compare {
+		if !s.kvStore.evaluateCompareLocked(c, now.UnixNano()) {
+			succeeded = false
+			break
+		}
+	}
+	branch := req.Failure
+	if succeeded { branch = req.Success }
+
+	// Las operaciones se ejecutan en orden: un get posterior a un put de la misma transacción
+	// ve el valor nuevo. Las escrituras pendientes se guardan en 'pending' hasta el commit.
+	type pendingWrite struct {
+		entry   storeEntry
+		deleted bool
+	}
+	pending := make(map[string]pendingWrite)
+	var ops []walOp
+	results := make([]*pb.TxnOperationResult, len(branch))
+	var readsOfPending []int // Resultados que deben recibir la revisión del commit.
+	for i, o := range branch {
+		switch op := o.Op.(type) {
+		case *pb.TxnOperation_Put:
+			var expiresAt int64
+			if o.TtlSeconds > 0 {
+				expiresAt = now.Add(time.Duration(o.TtlSeconds) * time.Second).UnixNano()
+			}
+			ops = append(ops, walOp{op: opSet, key: op.Put.Key, value: op.Put.Value, expiresAt: expiresAt})
+			pending[op.Put.Key] = pendingWrite{entry: storeEntry{value: op.Put.Value, expiresAt: expiresAt}}
+			results[i] = &pb.TxnOperationResult{Key: op.Put.Key, Found: true}
+			readsOfPending = append(readsOfPending, i)
+		case *pb.TxnOperation_DeleteKey:
+			found := false
+			if w, ok := pending[op.DeleteKey]; ok {
+				found = !w.deleted
+			} else {
+				_, found = s.kvStore.live(op.DeleteKey, now.UnixNano())
+			}
+			ops = append(ops, walOp{op: opDelete, key: op.DeleteKey})
+			pending[op.DeleteKey] = pendingWrite{deleted: true}
+			results[i] = &pb.TxnOperationResult{Key: op.DeleteKey, Found: found}
+		case *pb.TxnOperation_GetKey:
+			result := &pb.TxnOperationResult{Key: op.GetKey}
+			if w, ok := pending[op.GetKey]; ok {
+				if !w.deleted {
+					result.Value, result.Found = w.entry.value, true
+					readsOfPending = append(readsOfPending, i)
+				}
+			} else if e, ok := s.kvStore.live(op.GetKey, now.UnixNano()); ok {
+				result.Value, result.Found, result.Version = e.value, true, e.version
+			}
+			results[i] = result
+		}
+	}
+
+	var revision uint64
+	if len(ops) > 0 {
+		var err error
+		if revision, err = s.kvStore.applyOpsLocked(ops); err != nil {
+			return nil, s.persistenceError("la transacción", err)
+		}
+		for _, i := range readsOfPending { results[i].Version = revision }
+	}
+
+	s.kvStore.stats.mu.Lock()
+	s.kvStore.stats.txnOperations++
+	s.kvStore.stats.mu.Unlock()
+	return &pb.TxnResponse{Succeeded: succeeded, Revision: revision, Results: results}, nil
+}
+
+// txnOperationKey: Valida una operación de transacción y devuelve la clave que toca.
+func txnOperationKey(o *pb.TxnOperation) (string, error) {
+	var key string
+	switch op := o.Op.(type) {
+	case *pb.TxnOperation_Put:
+		if op.Put == nil { return "", errors.New("put sin par clave-valor") }
+		key = op.Put.Key
+	case *pb.TxnOperation_DeleteKey:
+		key = op.DeleteKey
+	case *pb.TxnOperation_GetKey:
+		key = op.GetKey
+	default:
+		return "", errors.New("tipo de operación no especificado")
+	}
+	if len(key) > MaxKeySize {
+		return "", fmt.Errorf("el tamaño de la clave excede %d bytes", MaxKeySize)
+	}
+	return key, nil
+}
+
+// evaluateCompareLocked: Evalúa una comparación contra el estado actual de la clave.
+// Una clave inexistente (o expirada) tiene versión 0 y valor vacío.
+// El llamador debe tener tomado el candado del shard de la clave.
+func (s *ShardedStore) evaluateCompareLocked(c *pb.Compare, now int64) bool {
+	entry, _ := s.live(c.Key, now)
+	var cmp int
+	switch c.Target {
+	case pb.Compare_VERSION:
+		switch {
+		case entry.version < c.Version:
+			cmp = -1
+		case entry.version > c.Version:
+			cmp = 1
+		}
+	case pb.Compare_VALUE:
+		cmp = bytes.Compare(entry.value, c.Value)
+	}
+	switch c.Result {
+	case pb.Compare_EQUAL:
+		return cmp == 0
+	case pb.Compare_NOT_EQUAL:
+		return cmp != 0
+	case pb.Compare_GREATER:
+		return cmp > 0
+	case pb.Compare_LESS:
+		return cmp < 0
+	}
+	return false
+}
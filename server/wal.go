@@ -0,0 +1,593 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ---- Formato binario del WAL ---- //
+//
+// El archivo empieza con walMagic y sigue con una secuencia de registros:
+//
+//	[longitud uint32 LE][CRC32C uint32 LE][contenido]
+//
+// El contenido de un registro es:
+//
+//	tipo (1 byte) | secuencia (uvarint) | timestamp (varint) | nº de operaciones (uvarint)
+//	y por cada operación: op (1 byte) | expiración (varint) | clave (uvarint + bytes) | valor (uvarint + bytes)
+//
+// La secuencia es la revisión del almacén. Un batch o una transacción ocupan un único
+// registro, así que el CRC garantiza que se recuperan completos o no se recuperan.
+// En un clúster Raft el WAL es el log replicado: los registros llevan el bit recordTerm en el
+// tipo y, tras la secuencia, el mandato (uvarint) en el que se crearon (ver raft.go).
+// Si la cabecera indica compresión, en los registros con el bit recordCompressed en el tipo lo
+// que sigue al timestamp (nº de operaciones y operaciones) está comprimido con ese códec
+// (ver compression.go). Un registro solo se comprime si así ocupa menos.
+
+const (
+	// walMagic: Cabecera del archivo; distingue el formato binario del formato de texto antiguo.
+	// El byte walCodecOffset indica la compresión de los registros (0 = sin comprimir).
+	walMagic       = "KVWAL\x00\x01\n"
+	walCodecOffset = 5
+	// walFrameHeaderSize: Bytes de longitud y CRC que preceden al contenido de cada registro.
+	walFrameHeaderSize = 8
+	// maxWALRecordSize: Tamaño máximo aceptado para un registro. Una longitud mayor solo puede
+	// venir de un registro corrupto y evita reservar memoria a partir de basura.
+	maxWALRecordSize = 64 * 1024 * 1024
+)
+
+// Tipos de registro del WAL binario.
+const (
+	recordSet    byte = 1
+	recordDelete byte = 2
+	recordBatch  byte = 3 // Varias operaciones con la misma revisión (batch o transacción).
+	recordNoop   byte = 4 // Sin operaciones: la entrada con la que un líder Raft nuevo abre su mandato.
+	// recordTerm: Bit del tipo que indica que el registro lleva su mandato Raft.
+	recordTerm byte = 0x40
+	// recordCompressed: Bit del tipo que indica que el cuerpo del registro está comprimido.
+	recordCompressed byte = 0x80
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// errTornRecord: El registro está incompleto o su CRC no coincide (escritura interrumpida).
+var errTornRecord = errors.New("registro del WAL incompleto o corrupto")
+
+// errBadRecord: El registro tiene el CRC correcto pero su contenido no se puede interpretar.
+// No es una escritura interrumpida, así que nunca se descarta al recuperar.
+var errBadRecord = errors.New("registro del WAL ilegible")
+
+// walRecord: Un registro del WAL ya decodificado.
+type walRecord struct {
+	revision  uint64
+	term      uint64 // Mandato Raft en el que se creó; 0 fuera de un clúster.
+	timestamp int64
+	ops       []walOp
+}
+
+// encodeWALRecord: Serializa un registro con su cabecera de longitud y CRC32C.
+func encodeWALRecord(rec walRecord, codec compressionCodec) []byte {
+	return appendWALRecord(nil, rec.revision, rec.term, rec.timestamp, encodeWALBody(rec.ops, codec))
+}
+
+// encodeWALBody: Serializa el tipo y las operaciones de un registro, comprimidas con codec si
+// así ocupan menos. No depende de la revisión, así que se prepara antes de tomar el candado
+// del WAL. El primer byte es el tipo del registro.
+func encodeWALBody(ops []walOp, codec compressionCodec) []byte {
+	recordType := recordBatch
+	if len(ops) == 0 { recordType = recordNoop }
+	if len(ops) == 1 {
+		recordType = recordSet
+		if ops[0].op == opDelete { recordType = recordDelete }
+	}
+	body := append(make([]byte, 0, 32), recordType)
+	body = binary.AppendUvarint(body, uint64(len(ops)))
+	for _, o := range ops {
+		op := recordSet
+		if o.op == opDelete { op = recordDelete }
+		body = append(body, op)
+		body = binary.AppendVarint(body, o.expiresAt)
+		body = binary.AppendUvarint(body, uint64(len(o.key)))
+		body = append(body, o.key...)
+		body = binary.AppendUvarint(body, uint64(len(o.value)))
+		body = append(body, o.value...)
+	}
+	if codec == compressionNone { return body }
+	compressed := codec.compress([]byte{recordType | recordCompressed}, body[1:])
+	if len(compressed) >= len(body) { return body }
+	return compressed
+}
+
+// appendWALRecord: Añade a dst el registro formado por la revisión, el mandato (0 = sin
+// mandato), el timestamp y un cuerpo preparado con encodeWALBody.
+func appendWALRecord(dst []byte, revision, term uint64, timestamp int64, body []byte) []byte {
+	payload := make([]byte, 0, 1+3*binary.MaxVarintLen64+len(body))
+	if term == 0 {
+		payload = append(payload, body[0])
+		payload = binary.AppendUvarint(payload, revision)
+	} else {
+		payload = append(payload, body[0]|recordTerm)
+		payload = binary.AppendUvarint(payload, revision)
+		payload = binary.AppendUvarint(payload, term)
+	}
+	payload = binary.AppendVarint(payload, timestamp)
+	payload = append(payload, body[1:]...)
+	return appendFrame(dst, payload)
+}
+
+// appendFrame: Añade a dst el contenido precedido de su longitud y su CRC32C. Es el marco
+// común de los registros del WAL y de los bloques del snapshot.
+func appendFrame(dst, payload []byte) []byte {
+	var header [walFrameHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[4:8], crc32.Checksum(payload, crc32c))
+	dst = append(dst, header[:]...)
+	return append(dst, payload...)
+}
+
+// readFrame: Lee el siguiente marco y comprueba su CRC. Devuelve io.EOF si el archivo termina
+// justo en un límite de marco y errTornRecord si el marco está cortado o corrupto.
+func readFrame(r *bufio.Reader) ([]byte, int64, error) {
+	var header [walFrameHeaderSize]byte
+	n, err := io.ReadFull(r, header[:])
+	if err == io.EOF { return nil, 0, io.EOF }
+	if err != nil { return nil, int64(n), errTornRecord }
+	length := binary.LittleEndian.Uint32(header[0:4])
+	if length == 0 || length > maxWALRecordSize { return nil, walFrameHeaderSize, errTornRecord }
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil { return nil, walFrameHeaderSize, errTornRecord }
+	if crc32.Checksum(payload, crc32c) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, walFrameHeaderSize + int64(length), errTornRecord
+	}
+	return payload, walFrameHeaderSize + int64(length), nil
+}
+
+// payloadReader: Lee campos del contenido de un registro comprobando los límites.
+type payloadReader struct {
+	buf []byte
+	err error
+}
+
+func (p *payloadReader) byte() byte {
+	if p.err != nil || len(p.buf) < 1 {
+		p.err = errTornRecord
+		return 0
+	}
+	b := p.buf[0]
+	p.buf = p.buf[1:]
+	return b
+}
+
+func (p *payloadReader) uvarint() uint64 {
+	if p.err != nil { return 0 }
+	v, n := binary.Uvarint(p.buf)
+	if n <= 0 {
+		p.err = errTornRecord
+		return 0
+	}
+	p.buf = p.buf[n:]
+	return v
+}
+
+func (p *payloadReader) varint() int64 {
+	if p.err != nil { return 0 }
+	v, n := binary.Varint(p.buf)
+	if n <= 0 {
+		p.err = errTornRecord
+		return 0
+	}
+	p.buf = p.buf[n:]
+	return v
+}
+
+func (p *payloadReader) bytes() []byte {
+	n := p.uvarint()
+	if p.err != nil || uint64(len(p.buf)) < n {
+		p.err = errTornRecord
+		return nil
+	}
+	b := p.buf[:n:n]
+	p.buf = p.buf[n:]
+	return b
+}
+
+// decodeWALPayload: Interpreta el contenido de un registro cuyo CRC ya se comprobó, escrito
+// en un archivo con el códec indicado.
+func decodeWALPayload(payload []byte, codec compressionCodec) (walRecord, error) {
+	p := &payloadReader{buf: payload}
+	var rec walRecord
+	recordType := p.byte()
+	rec.revision = p.uvarint()
+	if recordType&recordTerm != 0 {
+		recordType &^= recordTerm
+		rec.term = p.uvarint()
+	}
+	rec.timestamp = p.varint()
+	if p.err != nil { return rec, p.err }
+	if recordType&recordCompressed != 0 {
+		recordType &^= recordCompressed
+		if codec == compressionNone { return rec, errBadCompressed }
+		body, err := codec.decompress(p.buf)
+		if err != nil { return rec, err }
+		p.buf = body
+	}
+	count := p.uvarint()
+	if p.err != nil { return rec, p.err }
+	if recordType == recordNoop {
+		if count != 0 || len(p.buf) != 0 { return rec, errTornRecord }
+		return rec, nil
+	}
+	if count == 0 || count > uint64(len(p.buf)) { return rec, errTornRecord }
+	for i := uint64(0); i < count; i++ {
+		var o walOp
+		switch p.byte() {
+		case recordSet:
+			o.op = opSet
+		case recordDelete:
+			o.op = opDelete
+		default:
+			return rec, fmt.Errorf("operación de WAL desconocida")
+		}
+		o.expiresAt = p.varint()
+		o.key = string(p.bytes())
+		if value := p.bytes(); len(value) > 0 { o.value = value }
+		rec.ops = append(rec.ops, o)
+	}
+	if p.err != nil { return rec, p.err }
+	if len(p.buf) != 0 { return rec, errTornRecord }
+	if (recordType == recordBatch) != (count > 1) { return rec, errTornRecord }
+	return rec, nil
+}
+
+// readWALRecord: Lee el siguiente registro. Devuelve io.EOF si el archivo termina justo en
+// un límite de registro, errTornRecord si el registro está cortado o su CRC no coincide,
+// errBadCompressed si su cuerpo no se puede descomprimir y errBadRecord si el CRC coincide
+// pero el contenido no se puede decodificar.
+func readWALRecord(r *bufio.Reader, codec compressionCodec) (walRecord, int64, error) {
+	payload, size, err := readFrame(r)
+	if err != nil { return walRecord{}, size, err }
+	rec, err := decodeWALPayload(payload, codec)
+	if errors.Is(err, errBadCompressed) { return rec, size, err }
+	if err != nil { return rec, size, fmt.Errorf("%w: %v", errBadRecord, err) }
+	return rec, size, nil
+}
+
+// replayWALFile: Recorre los registros de un WAL binario y llama a fn con cada uno.
+// Si encuentra un registro cortado o con el CRC incorrecto deja de leer y, con truncateTorn,
+// trunca el archivo en el último registro válido: una caída a mitad de una escritura solo
+// puede afectar a la cola del último segmento, y los registros siguientes no deben aplicarse
+// sin los anteriores. En cualquier otro segmento un registro dañado es un error, y también lo
+// es siempre un registro íntegro que no se puede decodificar o descomprimir (errBadRecord,
+// errBadCompressed): truncar ahí borraría datos confirmados.
+func replayWALFile(path string, truncateTorn bool, fn func(walRecord) error) error {
+	file, err := os.Open(path)
+	if err != nil { return err }
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil { return err }
+
+	r := bufio.NewReaderSize(file, 1<<20)
+	magic := make([]byte, len(walMagic))
+	n, err := io.ReadFull(r, magic)
+	// Una caída justo al crear el segmento puede dejar la cabecera a medio escribir; openWAL
+	// la reescribe al abrirlo.
+	if err != nil && truncateTorn && bytes.HasPrefix([]byte(walMagic), magic[:min(n, walCodecOffset)]) { return nil }
+	codec, ok := codecFromMagic(magic, walMagic, walCodecOffset)
+	if err != nil || !ok { return fmt.Errorf("%s: cabecera de WAL inválida", path) }
+	validEnd := int64(len(walMagic))
+	for {
+		rec, size, err := readWALRecord(r, codec)
+		if err == io.EOF { return nil }
+		if err != nil && (!truncateTorn || !errors.Is(err, errTornRecord)) { return fmt.Errorf("%s: %w en el byte %d", path, err, validEnd) }
+		if err != nil {
+			log.Printf("ADVERTENCIA: %v en el byte %d; se truncan los %d bytes finales del WAL.",
+				err, validEnd, info.Size()-validEnd)
+			file.Close()
+			return os.Truncate(path, validEnd)
+		}
+		if err := fn(rec); err != nil { return err }
+		validEnd += size
+	}
+}
+
+// walFormat: Formato del archivo WAL en disco.
+type walFormat int
+
+const (
+	walMissing walFormat = iota // No existe o está vacío.
+	walBinary
+	walLegacyText // Formato de texto "timestamp,op,versión,expiración,clave,valorBase64".
+)
+
+// detectWALFormat: Identifica el formato del WAL por su cabecera. Un archivo cuya cabecera
+// quedó a medio escribir se trunca y se trata como vacío.
+func detectWALFormat(path string) (walFormat, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) { return walMissing, nil }
+	if err != nil { return walMissing, err }
+	defer file.Close()
+	head := make([]byte, len(walMagic))
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF { return walMissing, err }
+	switch {
+	case n == 0:
+		return walMissing, nil
+	case n == len(walMagic) && string(head) == walMagic:
+		return walBinary, nil
+	case n < len(walMagic) && bytes.HasPrefix([]byte(walMagic), head[:n]):
+		return walMissing, os.Truncate(path, 0)
+	}
+	return walLegacyText, nil
+}
+
+// openWAL: Abre el WAL para añadir registros y escribe la cabecera, con el códec pedido, si
+// el archivo es nuevo o aún no tiene registros. Devuelve el códec con el que deben escribirse
+// sus registros, que en un archivo con registros es el de su cabecera.
+func openWAL(path string, codec compressionCodec) (*os.File, int64, compressionCodec, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil { return nil, 0, codec, err }
+	fail := func(err error) (*os.File, int64, compressionCodec, error) {
+		file.Close()
+		return nil, 0, codec, err
+	}
+	info, err := file.Stat()
+	if err != nil { return fail(err) }
+	size := info.Size()
+	if size >= int64(len(walMagic)) {
+		head := make([]byte, len(walMagic))
+		if _, err := file.ReadAt(head, 0); err != nil { return fail(err) }
+		fileCodec, ok := codecFromMagic(head, walMagic, walCodecOffset)
+		if !ok { return fail(fmt.Errorf("%s: cabecera de WAL inválida", path)) }
+		if fileCodec == codec || size > int64(len(walMagic)) { return file, size, fileCodec, nil }
+	}
+	if err := file.Truncate(0); err != nil { return fail(err) }
+	if _, err := file.WriteString(magicWithCodec(walMagic, walCodecOffset, codec)); err != nil { return fail(err) }
+	if err := file.Sync(); err != nil { return fail(err) }
+	return file, int64(len(walMagic)), codec, nil
+}
+
+// ---- Migración del WAL de texto ---- //
+
+// replayLegacyWAL: Lee un WAL en el formato de texto antiguo y entrega sus operaciones como
+// registros. Las operaciones de un batch se agrupan en un único registro y solo se entregan
+// si su COMMIT llegó al disco. Las líneas se leen sin límite de tamaño.
+func replayLegacyWAL(path string, nextRevision func() uint64, fn func(walRecord)) error {
+	file, err := os.Open(path)
+	if err != nil { return err }
+	defer file.Close()
+	r := bufio.NewReaderSize(file, 1<<20)
+
+	var batch walRecord
+	var batchCount int
+	inBatch := false
+	for {
+		line, readErr := r.ReadString('\n')
+		if readErr != nil && readErr != io.EOF { return readErr }
+		line = trimNewline(line)
+		if line != "" {
+			e, err := parseWALLine(line, nextRevision())
+			switch {
+			case err != nil:
+				log.Printf("ADVERTENCIA: %v, se ignora: %s", err, line)
+			case e.op == opBatchBegin:
+				if inBatch {
+					log.Printf("ADVERTENCIA: Batch de la revisión %d sin COMMIT, se descarta.", batch.revision)
+				}
+				batch, batchCount, inBatch = walRecord{revision: e.version, timestamp: e.timestamp}, e.count, true
+			case e.op == opBatchCommit:
+				if !inBatch || e.version != batch.revision || len(batch.ops) != batchCount {
+					log.Printf("ADVERTENCIA: COMMIT de batch inconsistente, se descarta: %s", line)
+				} else {
+					fn(batch)
+				}
+				inBatch = false
+			case inBatch && e.version == batch.revision:
+				batch.ops = append(batch.ops, walOp{op: e.op, key: e.key, value: e.value, expiresAt: e.expiresAt})
+			default:
+				// Una operación con otra revisión indica que el batch abierto quedó truncado y
+				// que el servidor siguió escribiendo después del reinicio.
+				if inBatch {
+					log.Printf("ADVERTENCIA: Batch de la revisión %d sin COMMIT, se descarta.", batch.revision)
+					inBatch = false
+				}
+				fn(walRecord{revision: e.version, timestamp: e.timestamp,
+					ops: []walOp{{op: e.op, key: e.key, value: e.value, expiresAt: e.expiresAt}}})
+			}
+		}
+		if readErr == io.EOF { break }
+	}
+	if inBatch {
+		log.Printf("ADVERTENCIA: El WAL termina en un batch incompleto (revisión %d), se descarta.", batch.revision)
+	}
+	return nil
+}
+
+func trimNewline(line string) string {
+	for len(line) > 0 && (line[len(line)-1] == '\n' || line[len(line)-1] == '\r') { line = line[:len(line)-1] }
+	return line
+}
+
+// migrateLegacyWAL: Reaplica un WAL de texto y lo reescribe en formato binario conservando
+// revisiones y timestamps. El archivo original se conserva como "<wal>.legacy".
+// La conversión se escribe en un temporal que se renombra al final, así una caída durante
+// la migración deja intacto el WAL antiguo y la migración se repite en el siguiente arranque.
+func migrateLegacyWAL(path string, nextRevision func() uint64, fn func(walRecord)) error {
+	log.Println("WAL en formato de texto detectado; migrando al formato binario...")
+	tempPath := path + ".migrate"
+	out, err := os.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil { return err }
+	defer os.Remove(tempPath)
+	w := bufio.NewWriterSize(out, 1<<20)
+	w.WriteString(walMagic)
+	records := 0
+	var writeErr error
+	err = replayLegacyWAL(path, nextRevision, func(rec walRecord) {
+		fn(rec)
+		records++
+		if writeErr == nil { _, writeErr = w.Write(encodeWALRecord(rec, compressionNone)) }
+	})
+	if err == nil { err = writeErr }
+	if err == nil { err = w.Flush() }
+	if err == nil { err = out.Sync() }
+	if closeErr := out.Close(); err == nil { err = closeErr }
+	if err != nil { return fmt.Errorf("migración del WAL fallida: %w", err) }
+
+	// El original se conserva con un enlace y luego el temporal lo reemplaza de forma atómica.
+	os.Remove(path + ".legacy")
+	if err := os.Link(path, path+".legacy"); err != nil { return err }
+	if err := os.Rename(tempPath, path); err != nil { return err }
+	log.Printf("Migración del WAL completada: %d registros convertidos; el original queda en %s.legacy.", records, path)
+	return nil
+}
+
+// ---- Segmentos del WAL ---- //
+//
+// El WAL se divide en segmentos dentro de data/wal/. Cada segmento se llama como la primera
+// LSN (revisión) que puede contener, con ceros a la izquierda para que el orden alfabético
+// coincida con el numérico. Solo se escribe en el último (el segmento activo). Un segmento
+// puede borrarse cuando un snapshot durable cubre todos sus registros, es decir, cuando el
+// segmento siguiente empieza en una LSN menor o igual que la del snapshot más uno.
+
+const (
+	walDirName = "wal"
+	// walSegmentSize: Tamaño a partir del cual el segmento activo se cierra y se abre otro.
+	walSegmentSize = 64 * 1024 * 1024
+)
+
+// walSegment: Un segmento del WAL en disco.
+type walSegment struct {
+	path  string
+	start uint64 // Primera LSN que puede contener.
+}
+
+func segmentFileName(start uint64) string {
+	return fmt.Sprintf("%020d.wal", start)
+}
+
+// listSegments: Devuelve los segmentos del directorio ordenados por LSN inicial.
+func listSegments(dir string) ([]walSegment, error) {
+	names, err := os.ReadDir(dir)
+	if os.IsNotExist(err) { return nil, nil }
+	if err != nil { return nil, err }
+	var segments []walSegment
+	for _, entry := range names {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".wal") { continue }
+		start, err := strconv.ParseUint(strings.TrimSuffix(name, ".wal"), 10, 64)
+		if err != nil { continue }
+		segments = append(segments, walSegment{path: filepath.Join(dir, name), start: start})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].start < segments[j].start })
+	return segments, nil
+}
+
+// coveredSegments: Segmentos cuyos registros tienen todos LSN <= lsn. El último segmento
+// nunca se incluye: es (o será) el activo.
+func coveredSegments(segments []walSegment, lsn uint64) []walSegment {
+	var covered []walSegment
+	for i := 0; i+1 < len(segments); i++ {
+		if segments[i+1].start > lsn+1 { break }
+		covered = append(covered, segments[i])
+	}
+	return covered
+}
+
+// syncDir: Hace durables las altas, bajas y renombrados de archivos de un directorio.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil { return err }
+	defer d.Close()
+	return d.Sync()
+}
+
+// openSegment: Abre (o crea) el segmento que empieza en start y lo deja como segmento activo.
+// El llamador debe tener tomado walSyncMutex (o estar en el arranque, sin escritores).
+func (s *ShardedStore) openSegment(start uint64) error {
+	if err := os.MkdirAll(s.walDir, 0755); err != nil { return err }
+	file, size, codec, err := openWAL(filepath.Join(s.walDir, segmentFileName(start)), s.compression)
+	if err != nil { return err }
+	if codec != s.compression {
+		// El segmento ya tiene registros con otra compresión (el servidor se reinició con otro
+		// -compression): se sigue en un segmento nuevo, así cada archivo usa un único códec.
+		file.Close()
+		return s.openSegment(s.walLastWritten + 1)
+	}
+	if err := syncDir(s.walDir); err != nil {
+		file.Close()
+		return err
+	}
+	s.walFile, s.segmentStart, s.segmentSize = file, start, size
+	return nil
+}
+
+// rotateSegmentLocked: Cierra el segmento activo, con fsync, y abre uno nuevo que empieza
+// en la LSN siguiente a la última escrita. No hace nada si el segmento activo está vacío.
+// El llamador debe tener tomado walSyncMutex.
+func (s *ShardedStore) rotateSegmentLocked() error {
+	next := s.walLastWritten + 1
+	if next == s.segmentStart { return nil }
+	if err := s.walFile.Sync(); err != nil { return err }
+	s.syncedLocked()
+	s.walIntervalDirty = false
+	s.walFile.Close()
+	return s.openSegment(next)
+}
+
+// truncateLog: Borra del WAL los registros desde la LSN from en adelante, que nunca se
+// confirmaron (Raft: entradas de un seguidor que contradicen las del nuevo líder). Los segmentos
+// posteriores se borran y el que contiene from se trunca justo antes de ese registro.
+func (s *ShardedStore) truncateLog(from uint64) error {
+	if _, err := s.waitWritten(s.lastRevision()); err != nil { return err }
+	s.walSyncMutex.Lock()
+	defer s.walSyncMutex.Unlock()
+	s.walMutex.Lock()
+	defer s.walMutex.Unlock()
+	if from > s.revision { return nil }
+
+	segments, err := listSegments(s.walDir)
+	if err != nil { return err }
+	i := sort.Search(len(segments), func(i int) bool { return segments[i].start > from }) - 1
+	if i < 0 { return fmt.Errorf("ningún segmento del WAL contiene la LSN %d", from) }
+	offset, err := walRecordOffset(segments[i].path, from)
+	if err != nil { return err }
+	s.walFile.Close()
+	for _, seg := range segments[i+1:] {
+		if err := os.Remove(seg.path); err != nil { return err }
+	}
+	if err := os.Truncate(segments[i].path, offset); err != nil { return err }
+	s.revision, s.walLastWritten = from-1, from-1
+	if err := s.openSegment(segments[i].start); err != nil { return err }
+	return s.walFile.Sync()
+}
+
+// walRecordOffset: Posición en el segmento del primer registro con LSN >= lsn (o su tamaño si
+// no hay ninguno).
+func walRecordOffset(path string, lsn uint64) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil { return 0, err }
+	defer file.Close()
+	r := bufio.NewReaderSize(file, 1<<20)
+	magic := make([]byte, len(walMagic))
+	if _, err := io.ReadFull(r, magic); err != nil { return 0, fmt.Errorf("%s: cabecera de WAL inválida", path) }
+	codec, ok := codecFromMagic(magic, walMagic, walCodecOffset)
+	if !ok { return 0, fmt.Errorf("%s: cabecera de WAL inválida", path) }
+	offset := int64(len(walMagic))
+	for {
+		rec, size, err := readWALRecord(r, codec)
+		if err == io.EOF { return offset, nil }
+		if err != nil { return 0, fmt.Errorf("%s: %w en el byte %d", path, err, offset) }
+		if rec.revision >= lsn { return offset, nil }
+		offset += size
+	}
+}
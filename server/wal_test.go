@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestWAL: Escribe un segmento con la cabecera del códec y los bytes dados, y devuelve su
+// ruta.
+func writeTestWAL(t *testing.T, codec compressionCodec, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), segmentFileName(1))
+	content := append([]byte(magicWithCodec(walMagic, walCodecOffset, codec)), data...)
+	if err := os.WriteFile(path, content, 0644); err != nil { t.Fatal(err) }
+	return path
+}
+
+func testRecord(revision uint64, key string) walRecord {
+	return walRecord{revision: revision, timestamp: int64(revision), ops: []walOp{{op: opSet, key: key, value: []byte("valor")}}}
+}
+
+// replayAll: Reaplica el archivo y devuelve las revisiones leídas.
+func replayAll(path string, truncateTorn bool) ([]uint64, error) {
+	var revisions []uint64
+	err := replayWALFile(path, truncateTorn, func(rec walRecord) error {
+		revisions = append(revisions, rec.revision)
+		return nil
+	})
+	return revisions, err
+}
+
+func TestReplayWALTruncatesTornTail(t *testing.T) {
+	valid := append(encodeWALRecord(testRecord(1, "a"), compressionNone), encodeWALRecord(testRecord(2, "b"), compressionNone)...)
+	third := encodeWALRecord(testRecord(3, "c"), compressionNone)
+	corrupt := append([]byte(nil), third...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	tails := map[string][]byte{
+		"cabecera cortada":  third[:3],
+		"contenido cortado": third[:len(third)-2],
+		"CRC incorrecto":    corrupt,
+	}
+	for name, tail := range tails {
+		t.Run(name, func(t *testing.T) {
+			path := writeTestWAL(t, compressionNone, append(append([]byte(nil), valid...), tail...))
+			if _, err := replayAll(path, false); !errors.Is(err, errTornRecord) {
+				t.Fatalf("sin truncar: %v, se esperaba errTornRecord", err)
+			}
+			revisions, err := replayAll(path, true)
+			if err != nil { t.Fatalf("replayWALFile: %v", err) }
+			if len(revisions) != 2 { t.Fatalf("se reaplicaron %v", revisions) }
+			info, err := os.Stat(path)
+			if err != nil { t.Fatal(err) }
+			if want := int64(len(walMagic) + len(valid)); info.Size() != want {
+				t.Errorf("tamaño tras truncar %d, se esperaba %d", info.Size(), want)
+			}
+		})
+	}
+}
+
+func TestReplayWALRejectsUndecodableRecords(t *testing.T) {
+	first := encodeWALRecord(testRecord(1, "a"), compressionNone)
+	// Un CRC correcto con una operación desconocida.
+	unknownOp := appendWALRecord(nil, 2, 0, 2, []byte{recordSet, 1, 9})
+	// Un cuerpo marcado como comprimido en un archivo sin compresión.
+	compressedInPlain := appendWALRecord(nil, 2, 0, 2, []byte{recordSet | recordCompressed, 1})
+	// Un cuerpo comprimido que no es zstd válido.
+	badZstd := appendWALRecord(nil, 2, 0, 2, []byte{recordSet | recordCompressed, 0x28, 0xb5, 0x2f, 0xfd, 0x00})
+	cases := []struct {
+		name  string
+		codec compressionCodec
+		bad   []byte
+		want  error
+	}{
+		{"operación desconocida", compressionNone, unknownOp, errBadRecord},
+		{"comprimido sin códec", compressionNone, compressedInPlain, errBadCompressed},
+		{"zstd ilegible", compressionZstd, badZstd, errBadCompressed},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data := append(append([]byte(nil), first...), c.bad...)
+			path := writeTestWAL(t, c.codec, data)
+			if _, err := replayAll(path, true); !errors.Is(err, c.want) {
+				t.Fatalf("replayWALFile: %v, se esperaba %v", err, c.want)
+			}
+			info, err := os.Stat(path)
+			if err != nil { t.Fatal(err) }
+			if want := int64(len(walMagic) + len(data)); info.Size() != want {
+				t.Errorf("el archivo se truncó a %d bytes, tenía %d", info.Size(), want)
+			}
+		})
+	}
+}
+
+// TestStoreRecoversAfterTornTail: Una escritura interrumpida al final del segmento activo se
+// descarta al arrancar; lo confirmado antes sigue ahí y el almacén acepta escrituras nuevas.
+func TestStoreRecoversAfterTornTail(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+	mustSet(t, s, "a", "1")
+	version := mustSet(t, s, "b", "2")
+
+	segments, err := listSegments(filepath.Join(dir, walDirName))
+	if err != nil || len(segments) == 0 { t.Fatalf("listSegments: %v %v", segments, err) }
+	path := segments[len(segments)-1].path
+	if err := s.kvStore.Close(); err != nil { t.Fatalf("Close: %v", err) }
+	torn := encodeWALRecord(testRecord(version+1, "c"), compressionNone)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil { t.Fatal(err) }
+	if _, err := f.Write(torn[:len(torn)-3]); err != nil { t.Fatal(err) }
+	f.Close()
+
+	s = newTestServer(t, dir)
+	if got := mustGet(t, s, "b"); string(got.Value) != "2" || got.Version != version {
+		t.Fatalf("b tras recuperar: %q versión %d", got.Value, got.Version)
+	}
+	if got := mustGet(t, s, "c"); got.Found { t.Fatalf("se recuperó el registro cortado") }
+	if next := mustSet(t, s, "c", "3"); next != version+1 { t.Errorf("la escritura siguiente recibe la versión %d, se esperaba %d", next, version+1) }
+	s = reopenTestServer(t, s)
+	if got := mustGet(t, s, "c"); string(got.Value) != "3" { t.Errorf("c tras el segundo arranque: %q", got.Value) }
+}
+
+// TestLegacyWALMigration: Un WAL de un solo archivo, binario o de texto, se reaplica al
+// arrancar. El almacén crea un punto de control, aparta el archivo como kvstore.wal.<unix>,
+// que la retención archiva al quedar cubierto, y sigue escribiendo en segmentos con el nombre
+// de su primera LSN.
+func TestLegacyWALMigration(t *testing.T) {
+	value := base64.StdEncoding.EncodeToString([]byte("valor"))
+	deleteA := walRecord{revision: 3, timestamp: 3, ops: []walOp{{op: opDelete, key: "a"}}}
+	formats := map[string]string{
+		"binario": walMagic + string(encodeWALRecord(testRecord(1, "a"), compressionNone)) +
+			string(encodeWALRecord(testRecord(2, "b"), compressionNone)) + string(encodeWALRecord(deleteA, compressionNone)),
+		"texto": fmt.Sprintf("1,%s,1,0,a,%s\n2,%s,2,0,b,%s\n3,%s,3,0,a,\n", opSet, value, opSet, value, opDelete),
+	}
+	for name, content := range formats {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			legacy := filepath.Join(dir, walFile)
+			if err := os.WriteFile(legacy, []byte(content), 0644); err != nil { t.Fatal(err) }
+			check := func(s *Server) {
+				t.Helper()
+				if got := mustGet(t, s, "b"); string(got.Value) != "valor" || got.Version != 2 { t.Errorf("b: %q versión %d", got.Value, got.Version) }
+				if got := mustGet(t, s, "a"); got.Found { t.Error("volvió una clave borrada en el WAL antiguo") }
+			}
+
+			opts := testStoreOptions(dir, "memory")
+			opts.retention.archiveDir = t.TempDir()
+			s := openTestServer(t, opts)
+			check(s)
+			if _, err := os.Stat(legacy); !os.IsNotExist(err) { t.Errorf("%s sigue en su sitio: %v", walFile, err) }
+			archived, err := filepath.Glob(filepath.Join(opts.retention.archiveDir, walFile+".*"))
+			if err != nil || len(archived) != 1 { t.Fatalf("copias archivadas del WAL antiguo: %v %v", archived, err) }
+			if revisions, err := replayAll(archived[0], false); err != nil || len(revisions) != 3 { t.Errorf("%s: revisiones %v %v", archived[0], revisions, err) }
+			if next := mustSet(t, s, "c", "3"); next != 4 { t.Errorf("la escritura siguiente recibe la versión %d, se esperaba la 4", next) }
+			segments, err := listSegments(s.kvStore.walDir)
+			if err != nil || len(segments) != 1 || filepath.Base(segments[0].path) != segmentFileName(4) { t.Fatalf("segmentos tras migrar: %v %v", segments, err) }
+
+			s = reopenTestServer(t, s)
+			check(s)
+			if got := mustGet(t, s, "c"); string(got.Value) != "3" || got.Version != 4 { t.Errorf("c tras reiniciar: %q versión %d", got.Value, got.Version) }
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	pb "asignacionservidor/proto/keyval"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// watchHistorySize: Número de eventos recientes que se conservan en memoria para que un
+	// watcher pueda reanudar desde una revisión anterior.
+	watchHistorySize = 10000
+	// watchBufferSize: Respuestas pendientes por watcher. Si se llena, el watcher va demasiado
+	// atrasado: se cancela y el cliente debe reanudar desde su última revisión recibida.
+	watchBufferSize = 1024
+)
+
+// ---- Watch: notificaciones de cambios ---- //
+
+// watchHub: Reparte los eventos del WAL entre los watchers suscritos y guarda un historial
+// acotado de eventos recientes para poder reanudar desde una revisión.
+type watchHub struct {
+	mu        sync.Mutex
+	history   []*pb.WatchEvent
+	// compacted: Revisión más alta que ya no está en el historial (o la revisión al arrancar).
+	compacted uint64
+	last      uint64 // Última revisión publicada.
+	watchers  map[*watcher]struct{}
+}
+
+type watcher struct {
+	prefix string
+	from   uint64 // Primera revisión que interesa al watcher.
+	events chan []*pb.WatchEvent
+	// lagged se cierra cuando el watcher se cancela por no consumir sus eventos a tiempo.
+	lagged chan struct{}
+}
+
+func newWatchHub(startRevision uint64) *watchHub {
+	return &watchHub{compacted: startRevision, last: startRevision, watchers: make(map[*watcher]struct{})}
+}
+
+// publish: Convierte las operaciones de un registro del WAL en eventos y los envía a los
+// watchers interesados sin bloquear. Solo la llama la rutina de escritura del WAL, una vez
+// escrito el registro y en orden de revisión (con Raft, la que aplica las entradas
+// confirmadas). El registro solo es durable si su grupo hizo fsync: en los modos 'interval' y
+// 'none', como la respuesta al cliente, el evento puede preceder al fsync y perderse en una
+// caída del sistema.
+func (h *watchHub) publish(revision uint64, ops []walOp) {
+	events := make([]*pb.WatchEvent, len(ops))
+	for i, o := range ops {
+		e := &pb.WatchEvent{Type: pb.WatchEvent_PUT, Pair: &pb.KeyValuePair{Key: o.key, Value: o.value}, Revision: revision}
+		if o.op == opDelete {
+			e.Type = pb.WatchEvent_DELETE
+			e.Pair.Value = nil
+		}
+		events[i] = e
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last = revision
+	h.history = append(h.history, events...)
+	if excess := len(h.history) - watchHistorySize; excess > 0 {
+		h.compacted = h.history[excess-1].Revision
+		h.history = append([]*pb.WatchEvent(nil), h.history[excess:]...)
+	}
+	for w := range h.watchers {
+		if revision < w.from { continue }
+		matching := filterEvents(events, w.prefix)
+		if len(matching) == 0 { continue }
+		select {
+		case w.events <- matching:
+		default:
+			delete(h.watchers, w)
+			close(w.lagged)
+		}
+	}
+}
+
+// subscribe: Registra un watcher y devuelve los eventos del historial desde startRevision
+// (0 = solo cambios nuevos). La lectura del historial y el registro se hacen bajo el mismo
+// candado, así no se pierde ni se duplica ningún evento entre ambos.
+func (h *watchHub) subscribe(prefix string, startRevision uint64) (*watcher, []*pb.WatchEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var backlog []*pb.WatchEvent
+	if startRevision == 0 {
+		startRevision = h.last + 1
+	} else {
+		if startRevision <= h.compacted {
+			return nil, nil, status.Errorf(codes.OutOfRange,
+				"la revisión %d ya no está en el historial; la más antigua disponible es %d", startRevision, h.compacted+1)
+		}
+		for _, e := range h.history {
+			if e.Revision >= startRevision && strings.HasPrefix(e.Pair.Key, prefix) {
+				backlog = append(backlog, e)
+			}
+		}
+	}
+	w := &watcher{prefix: prefix, from: startRevision, events: make(chan []*pb.WatchEvent, watchBufferSize), lagged: make(chan struct{})}
+	h.watchers[w] = struct{}{}
+	return w, backlog, nil
+}
+
+// reset: Vacía el historial cuando el almacén salta a otra revisión sin eventos intermedios
+// (al restaurar una copia de seguridad).
+func (h *watchHub) reset(revision uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.history = nil
+	h.compacted, h.last = revision, revision
+}
+
+func (h *watchHub) unsubscribe(w *watcher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.watchers, w)
+}
+
+func filterEvents(events []*pb.WatchEvent, prefix string) []*pb.WatchEvent {
+	if prefix == "" { return events }
+	var matching []*pb.WatchEvent
+	for _, e := range events {
+		if strings.HasPrefix(e.Pair.Key, prefix) { matching = append(matching, e) }
+	}
+	return matching
+}
+
+// Watch: Envía en streaming cada Set/Delete (incluidos batches y transacciones) sobre claves
+// con el prefijo pedido, en orden de commit. Con start_revision > 0 primero reenvía los eventos
+// del historial desde esa revisión. Si el cliente no consume a tiempo, el stream termina con
+// codes.ResourceExhausted indicando desde qué revisión reanudar.
+func (s *Server) Watch(req *pb.WatchRequest, stream pb.KeyValueService_WatchServer) error {
+	w, backlog, err := s.kvStore.watchers.subscribe(req.Prefix, req.StartRevision)
+	if err != nil { return err }
+	defer s.kvStore.watchers.unsubscribe(w)
+
+	nextRevision := w.from
+	send := func(events []*pb.WatchEvent) error {
+		// Los eventos de una misma revisión (un batch o una transacción) viajan juntos.
+		for len(events) > 0 {
+			n := 1
+			for n < len(events) && events[n].Revision == events[0].Revision { n++ }
+			if err := stream.Send(&pb.WatchResponse{Events: events[:n]}); err != nil { return err }
+			nextRevision = events[0].Revision + 1
+			events = events[n:]
+		}
+		return nil
+	}
+	if err := send(backlog); err != nil { return err }
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case events := <-w.events:
+			if err := send(events); err != nil { return err }
+		case <-w.lagged:
+			// Antes de cancelar se entregan los eventos que sí llegaron a encolarse.
+			for len(w.events) > 0 {
+				if err := send(<-w.events); err != nil { return err }
+			}
+			return status.Errorf(codes.ResourceExhausted,
+				"watcher demasiado atrasado; reanude con start_revision=%d", nextRevision)
+		}
+	}
+}
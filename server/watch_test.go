@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	pb "asignacionservidor/proto/keyval"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// watchStream: Stream de Watch en memoria. Cada Send espera a que la prueba lea la respuesta
+// de sent, así la prueba decide lo rápido que consume el watcher.
+type watchStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent chan *pb.WatchResponse
+}
+
+func (s *watchStream) Context() context.Context { return s.ctx }
+
+func (s *watchStream) Send(resp *pb.WatchResponse) error {
+	select {
+	case s.sent <- resp:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+// startWatch: Lanza Watch y espera a que quede suscrito. El error con el que termina llega por
+// el canal devuelto; el stream se cancela al terminar la prueba.
+func startWatch(t *testing.T, s *Server, req *pb.WatchRequest) (*watchStream, chan error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	stream := &watchStream{ctx: ctx, sent: make(chan *pb.WatchResponse)}
+	hub := s.kvStore.watchers
+	hub.mu.Lock()
+	before := len(hub.watchers)
+	hub.mu.Unlock()
+	done := make(chan error, 1)
+	go func() { done <- s.Watch(req, stream) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		hub.mu.Lock()
+		n := len(hub.watchers)
+		hub.mu.Unlock()
+		if n > before { return stream, done }
+		select {
+		case err := <-done:
+			t.Fatalf("Watch terminó al suscribirse: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) { t.Fatal("Watch no se suscribió") }
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// nextEvents: Eventos de la siguiente respuesta del stream.
+func nextEvents(t *testing.T, stream *watchStream) []*pb.WatchEvent {
+	t.Helper()
+	select {
+	case resp := <-stream.sent:
+		return resp.Events
+	case <-time.After(5 * time.Second):
+		t.Fatal("no llegó ningún evento")
+		return nil
+	}
+}
+
+func expectEvent(t *testing.T, events []*pb.WatchEvent, typ pb.WatchEvent_EventType, key string, revision uint64) {
+	t.Helper()
+	if len(events) != 1 || events[0].Type != typ || events[0].Pair.Key != key || events[0].Revision != revision {
+		t.Fatalf("eventos %v, se esperaba %v %s en la revisión %d", events, typ, key, revision)
+	}
+}
+
+// TestWatchResume: Con start_revision se reenvían primero los eventos del historial con el
+// prefijo pedido y luego siguen los nuevos.
+func TestWatchResume(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	first := mustSet(t, s, "a/1", "x")
+	mustSet(t, s, "b/1", "x")
+	second := mustSet(t, s, "a/2", "x")
+
+	stream, _ := startWatch(t, s, &pb.WatchRequest{Prefix: "a/", StartRevision: first})
+	expectEvent(t, nextEvents(t, stream), pb.WatchEvent_PUT, "a/1", first)
+	expectEvent(t, nextEvents(t, stream), pb.WatchEvent_PUT, "a/2", second)
+	third := mustSet(t, s, "a/3", "x")
+	mustSet(t, s, "b/2", "x")
+	if _, err := s.Delete(context.Background(), &pb.DeleteRequest{Key: "a/1"}); err != nil { t.Fatalf("Delete: %v", err) }
+	expectEvent(t, nextEvents(t, stream), pb.WatchEvent_PUT, "a/3", third)
+	expectEvent(t, nextEvents(t, stream), pb.WatchEvent_DELETE, "a/1", third+2)
+}
+
+// TestWatchCompactedHistory: Una revisión que ya salió del historial se rechaza con
+// OutOfRange; la primera que sigue en él se acepta.
+func TestWatchCompactedHistory(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	ops := make([]walOp, watchHistorySize)
+	for i := range ops { ops[i] = walOp{op: opSet, key: fmt.Sprintf("k%05d", i), value: []byte("v")} }
+	old, err := s.kvStore.applyBatch(ops)
+	if err != nil { t.Fatalf("applyBatch: %v", err) }
+	next := mustSet(t, s, "ultima", "v")
+
+	stream := &watchStream{ctx: context.Background(), sent: make(chan *pb.WatchResponse, 1)}
+	if err := s.Watch(&pb.WatchRequest{StartRevision: old}, stream); status.Code(err) != codes.OutOfRange {
+		t.Fatalf("Watch desde una revisión compactada: %v, se esperaba OutOfRange", err)
+	}
+	stream, _ = startWatch(t, s, &pb.WatchRequest{StartRevision: next})
+	expectEvent(t, nextEvents(t, stream), pb.WatchEvent_PUT, "ultima", next)
+}
+
+// TestWatchSlowWatcher: Un watcher que no consume sus eventos se cancela con
+// ResourceExhausted, tras recibir los que ya tenía encolados, e indica desde dónde reanudar.
+func TestWatchSlowWatcher(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	stream, done := startWatch(t, s, &pb.WatchRequest{})
+	hub := s.kvStore.watchers
+	hub.mu.Lock()
+	start := hub.last + 1
+	hub.mu.Unlock()
+	// Watch retiene un evento en Send; el resto llena el buffer y el último lo desborda.
+	for i := uint64(0); i < watchBufferSize+2; i++ {
+		hub.publish(start+i, []walOp{{op: opSet, key: "k", value: []byte("v")}})
+	}
+
+	var last uint64
+	for {
+		select {
+		case resp := <-stream.sent:
+			if want := last + 1; last > 0 && resp.Events[0].Revision != want {
+				t.Fatalf("revisión %d tras la %d", resp.Events[0].Revision, last)
+			}
+			last = resp.Events[0].Revision
+		case err := <-done:
+			if status.Code(err) != codes.ResourceExhausted { t.Fatalf("Watch: %v, se esperaba ResourceExhausted", err) }
+			if last >= start+watchBufferSize+1 { t.Fatalf("se entregaron todos los eventos (%d)", last) }
+			want := fmt.Sprintf("start_revision=%d", last+1)
+			if msg := status.Convert(err).Message(); !strings.Contains(msg, want) { t.Errorf("mensaje %q, se esperaba %s", msg, want) }
+			return
+		case <-time.After(5 * time.Second):
+			t.Fatal("Watch no terminó")
+		}
+	}
+}
+
+// TestWatchExpiredKey: Una clave que vence por TTL llega a los watchers como un DELETE con la
+// revisión del registro de la limpieza, también al reanudar.
+func TestWatchExpiredKey(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+	stream, _ := startWatch(t, s, &pb.WatchRequest{Prefix: "ttl/"})
+	put, err := s.kvStore.applyBatch([]walOp{{op: opSet, key: "ttl/a", value: []byte("v"), expiresAt: time.Now().Add(20 * time.Millisecond).UnixNano()}})
+	if err != nil { t.Fatalf("applyBatch: %v", err) }
+	expectEvent(t, nextEvents(t, stream), pb.WatchEvent_PUT, "ttl/a", put)
+
+	time.Sleep(30 * time.Millisecond)
+	if n, err := s.kvStore.reapExpired(); n != 1 || err != nil { t.Fatalf("reapExpired: %d %v", n, err) }
+	reaped := s.kvStore.lastRevision()
+	if reaped <= put { t.Fatalf("la limpieza no escribió ningún registro (revisión %d)", reaped) }
+	expectEvent(t, nextEvents(t, stream), pb.WatchEvent_DELETE, "ttl/a", reaped)
+
+	resumed, _ := startWatch(t, s, &pb.WatchRequest{Prefix: "ttl/", StartRevision: put})
+	expectEvent(t, nextEvents(t, resumed), pb.WatchEvent_PUT, "ttl/a", put)
+	expectEvent(t, nextEvents(t, resumed), pb.WatchEvent_DELETE, "ttl/a", reaped)
+
+	// Una clave vencida que se borra antes de la limpieza también deja su DELETE.
+	put, err = s.kvStore.applyBatch([]walOp{{op: opSet, key: "ttl/b", value: []byte("v"), expiresAt: time.Now().Add(time.Millisecond).UnixNano()}})
+	if err != nil { t.Fatalf("applyBatch: %v", err) }
+	expectEvent(t, nextEvents(t, stream), pb.WatchEvent_PUT, "ttl/b", put)
+	time.Sleep(5 * time.Millisecond)
+	resp, err := s.Delete(context.Background(), &pb.DeleteRequest{Key: "ttl/b"})
+	if err != nil || resp.Found { t.Fatalf("Delete de una clave vencida: %v %v", resp, err) }
+	expectEvent(t, nextEvents(t, stream), pb.WatchEvent_DELETE, "ttl/b", put+1)
+}